@@ -0,0 +1,27 @@
+package main
+
+import (
+	"net/http"
+
+	"shared/authz"
+)
+
+// --- Authorization ---
+//
+// Access tokens carry the caller's role in their tenant and every API route
+// requires a permission that role must grant; see shared/authz for the roles
+// and the middleware. This file holds the service's own route table.
+
+// routePermissions maps "METHOD /path/template" of every API route to the
+// permission it requires.
+var routePermissions = map[string]authz.Permission{
+	"GET /api/accounts":                     authz.AccountsRead,
+	"DELETE /api/accounts/{platformUserId}": authz.AccountsManage,
+}
+
+// authorize admits requests whose role grants the permission of the matched route.
+// It must run after authMiddleware.
+var authorize = authz.Authorize(routePermissions, func(r *http.Request) string {
+	role, _ := r.Context().Value(roleKey).(string)
+	return role
+})
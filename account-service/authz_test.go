@@ -0,0 +1,145 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/mux"
+	"shared/authz"
+	"shared/jwks"
+	"shared/revocation"
+)
+
+// testServer is the service's real router, trusting access tokens it signs.
+type testServer struct {
+	router *mux.Router
+	key    ed25519.PrivateKey
+}
+
+func newTestServer(t *testing.T) *testServer {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg = &Config{JWTIssuer: "test-issuer"}
+	keySet = jwks.StaticKeySet{"test": pub}
+	revokedTokens = revocation.NewList()
+	return &testServer{router: newRouter(), key: priv}
+}
+
+// do sends method path with an access token for role and amr.
+func (s *testServer) do(t *testing.T, method, path, role string, amr ...string) *httptest.ResponseRecorder {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, Claims{
+		UserID:   "user-1",
+		TenantID: "tenant-1",
+		Role:     role,
+		AMR:      amr,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    cfg.JWTIssuer,
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+		},
+	})
+	token.Header["kid"] = "test"
+	signed, err := token.SignedString(s.key)
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	req := httptest.NewRequest(method, path, nil)
+	req.Header.Set("Authorization", "Bearer "+signed)
+	rec := httptest.NewRecorder()
+	s.router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAPIRoutesMatchRoutePermissions(t *testing.T) {
+	s := newTestServer(t)
+	served := map[string]bool{}
+	err := s.router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		template, _ := route.GetPathTemplate()
+		methods, err := route.GetMethods()
+		if !strings.HasPrefix(template, "/api/") || err != nil {
+			return nil
+		}
+		for _, method := range methods {
+			served[method+" "+template] = true
+			if _, ok := routePermissions[method+" "+template]; !ok {
+				t.Errorf("%s %s has no entry in routePermissions", method, template)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Walk: %v", err)
+	}
+	for route := range routePermissions {
+		if !served[route] {
+			t.Errorf("routePermissions has %s, which the router does not serve", route)
+		}
+	}
+}
+
+func TestAccountRoutesByRole(t *testing.T) {
+	tests := []struct {
+		method, path, role string
+		allowed            bool
+	}{
+		{"GET", "/api/accounts", "owner", true},
+		{"GET", "/api/accounts", "admin", true},
+		{"GET", "/api/accounts", "editor", true},
+		{"GET", "/api/accounts", "viewer", true},
+		{"GET", "/api/accounts", "", false},
+		{"DELETE", "/api/accounts/123", "owner", true},
+		{"DELETE", "/api/accounts/123", "admin", true},
+		{"DELETE", "/api/accounts/123", "editor", false},
+		{"DELETE", "/api/accounts/123", "viewer", false},
+		{"DELETE", "/api/accounts/123", "", false},
+	}
+	s := newTestServer(t)
+	for _, tt := range tests {
+		var match mux.RouteMatch
+		if !s.router.Match(httptest.NewRequest(tt.method, tt.path, nil), &match) {
+			t.Fatalf("%s %s matches no route", tt.method, tt.path)
+		}
+		template, _ := match.Route.GetPathTemplate()
+		required := routePermissions[tt.method+" "+template]
+		if got := authz.RoleHas(tt.role, required); got != tt.allowed {
+			t.Errorf("%s %s as %q: allowed = %v; want %v", tt.method, tt.path, tt.role, got, tt.allowed)
+		}
+		if tt.allowed {
+			continue
+		}
+		rec := s.do(t, tt.method, tt.path, tt.role, "pwd", "otp", "mfa")
+		var body authz.ForbiddenResponse
+		json.NewDecoder(rec.Body).Decode(&body)
+		if rec.Code != http.StatusForbidden || body.Error != "forbidden" || body.RequiredPermission != required {
+			t.Errorf("%s %s as %q = %d %+v; want 403 requiring %q", tt.method, tt.path, tt.role, rec.Code, body, required)
+		}
+	}
+}
+
+func TestDisconnectRequiresMFA(t *testing.T) {
+	s := newTestServer(t)
+	rec := s.do(t, "DELETE", "/api/accounts/123", "owner", "pwd")
+	var body authz.ForbiddenResponse
+	json.NewDecoder(rec.Body).Decode(&body)
+	if rec.Code != http.StatusForbidden || body.Error != "mfa_required" {
+		t.Errorf("DELETE as owner without MFA = %d %+v; want 403 mfa_required", rec.Code, body)
+	}
+}
+
+func TestAPIRequiresToken(t *testing.T) {
+	s := newTestServer(t)
+	rec := httptest.NewRecorder()
+	s.router.ServeHTTP(rec, httptest.NewRequest("GET", "/api/accounts", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("GET /api/accounts without a token = %d; want 401", rec.Code)
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"time"
+
+	"shared/config"
+)
+
+// --- Configuration ---
+//
+// Settings come from, in increasing precedence: the `default` tags below, the
+// YAML file named by CONFIG_FILE (optional), and environment variables.
+
+// Config holds every setting of the Account Service.
+type Config struct {
+	Port              int    `yaml:"port" env:"PORT" default:"8082"`
+	DatabaseURL       string `yaml:"database_url" env:"DATABASE_URL" required:"true" secret:"true"`
+	AutoMigrate       bool   `yaml:"auto_migrate" env:"AUTO_MIGRATE" default:"true"`
+	CORSAllowedOrigin string `yaml:"cors_allowed_origin" env:"CORS_ALLOWED_ORIGIN" default:"http://localhost:3000"`
+	JWTIssuer         string `yaml:"jwt_issuer" env:"JWT_ISSUER" default:"social-media-platform"`
+	InternalAPISecret string `yaml:"internal_api_secret" env:"INTERNAL_API_SECRET" required:"true" secret:"true"`
+
+	JWKS       JWKSConfig       `yaml:"jwks" env:"JWKS"`
+	Revocation RevocationConfig `yaml:"revocation" env:"REVOCATION"`
+
+	TokenEncryption TokenEncryptionConfig `yaml:"token_encryption" env:"TOKEN_ENCRYPTION"`
+	TokenRotation   TokenRotationConfig   `yaml:"token_rotation" env:"TOKEN_ROTATION"`
+
+	Meta     PlatformClientConfig `yaml:"meta" env:"META"`
+	TikTok   PlatformClientConfig `yaml:"tiktok" env:"TIKTOK"`
+	Snapchat PlatformClientConfig `yaml:"snapchat" env:"SNAPCHAT"`
+}
+
+// TokenEncryptionConfig locates the key-encryption keys; see crypto.go for the format.
+type TokenEncryptionConfig struct {
+	Keys      string `yaml:"keys" env:"KEYS" secret:"true"`
+	KeysFile  string `yaml:"keys_file" env:"KEYS_FILE"`
+	ActiveKey int    `yaml:"active_key" env:"ACTIVE_KEY"`
+}
+
+// TokenRotationConfig controls the background refresh of expiring tokens.
+type TokenRotationConfig struct {
+	Interval time.Duration `yaml:"interval" env:"INTERVAL" default:"5m"`
+	Window   time.Duration `yaml:"window" env:"WINDOW" default:"30m"`
+}
+
+// PlatformClientConfig holds the app credentials used to refresh one platform's tokens.
+// TikTok calls its client ID a "client key"; TIKTOK_CLIENT_KEY is accepted too.
+type PlatformClientConfig struct {
+	ClientID     string `yaml:"client_id" env:"CLIENT_ID"`
+	ClientSecret string `yaml:"client_secret" env:"CLIENT_SECRET" secret:"true"`
+	OAuthBaseURL string `yaml:"oauth_base_url" env:"OAUTH_BASE_URL"`
+}
+
+// JWKSConfig locates the auth-service keys used to verify platform JWTs.
+type JWKSConfig struct {
+	URL             string        `yaml:"url" env:"URL" default:"http://localhost:8081/.well-known/jwks.json"`
+	RefreshInterval time.Duration `yaml:"refresh_interval" env:"REFRESH_INTERVAL" default:"5m"`
+}
+
+// RevocationConfig locates auth-service's list of revoked access tokens.
+type RevocationConfig struct {
+	URL          string        `yaml:"url" env:"URL" default:"http://localhost:8081/internal/revoked-tokens"`
+	PollInterval time.Duration `yaml:"poll_interval" env:"POLL_INTERVAL" default:"15s"`
+}
+
+var cfg *Config
+
+func initConfig() {
+	c := &Config{
+		Meta:     PlatformClientConfig{OAuthBaseURL: defaultMetaGraphBaseURL},
+		TikTok:   PlatformClientConfig{OAuthBaseURL: defaultTikTokBaseURL},
+		Snapchat: PlatformClientConfig{OAuthBaseURL: defaultSnapchatAuthURL},
+	}
+	if v := os.Getenv("TIKTOK_CLIENT_KEY"); v != "" && os.Getenv("TIKTOK_CLIENT_ID") == "" {
+		os.Setenv("TIKTOK_CLIENT_ID", v)
+	}
+	if err := config.Load(c); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	if err := c.validate(); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	config.Log(c)
+	cfg = c
+}
+
+func (c *Config) validate() error {
+	var errs []error
+	if _, err := url.ParseRequestURI(c.JWKS.URL); err != nil {
+		errs = append(errs, fmt.Errorf("jwks.url is not a valid URL: %q", c.JWKS.URL))
+	}
+	if c.JWKS.RefreshInterval <= 0 {
+		errs = append(errs, errors.New("jwks.refresh_interval must be positive"))
+	}
+	if _, err := url.ParseRequestURI(c.Revocation.URL); err != nil {
+		errs = append(errs, fmt.Errorf("revocation.url is not a valid URL: %q", c.Revocation.URL))
+	}
+	if c.Revocation.PollInterval <= 0 {
+		errs = append(errs, errors.New("revocation.poll_interval must be positive"))
+	}
+	if len(c.InternalAPISecret) < 32 {
+		errs = append(errs, errors.New("internal_api_secret must be at least 32 characters"))
+	}
+	if c.TokenEncryption.Keys == "" && c.TokenEncryption.KeysFile == "" {
+		errs = append(errs, errors.New("token_encryption.keys or token_encryption.keys_file is required"))
+	}
+	if c.TokenRotation.Interval <= 0 || c.TokenRotation.Window <= 0 {
+		errs = append(errs, errors.New("token_rotation.interval and token_rotation.window must be positive"))
+	}
+	for name, p := range map[string]PlatformClientConfig{"meta": c.Meta, "tiktok": c.TikTok, "snapchat": c.Snapchat} {
+		if _, err := url.ParseRequestURI(p.OAuthBaseURL); err != nil {
+			errs = append(errs, fmt.Errorf("%s.oauth_base_url is not a valid URL: %q", name, p.OAuthBaseURL))
+		}
+		if p.ClientID == "" {
+			log.Printf("Warning: %s.client_id is not set; %s tokens cannot be refreshed.", name, name)
+			continue
+		}
+		if p.ClientSecret == "" {
+			errs = append(errs, fmt.Errorf("%s.client_secret is required when %s.client_id is set", name, name))
+		}
+		if config.IsPlaceholder(p.ClientID) {
+			errs = append(errs, fmt.Errorf("%s.client_id is still a placeholder value", name))
+		}
+	}
+	return errors.Join(errs...)
+}
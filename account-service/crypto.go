@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// --- Token Encryption ---
+//
+// OAuth tokens are stored with envelope encryption. Every row gets its own random
+// data-encryption key (DEK) that seals access_token and refresh_token with AES-GCM.
+// The DEK is itself sealed with a key-encryption key (KEK) and stored in token_dek,
+// tagged with the KEK's version in token_key_version. Rotating the KEK therefore
+// only rewraps the small DEKs; token ciphertexts are left alone.
+//
+// KEKs are configured as "version:base64key" pairs, comma or newline separated,
+// in token_encryption.keys or in the file named by token_encryption.keys_file.
+// New rows use token_encryption.active_key, or the highest version if unset.
+// Version 0 marks a legacy plaintext row.
+
+const plaintextKeyVersion = 0
+
+var errUnknownKeyVersion = errors.New("unknown token encryption key version")
+
+// tokenKeys is the key ring used by the persistence layer.
+var tokenKeys *keyRing
+
+// keyRing holds every KEK that may still wrap a stored DEK.
+type keyRing struct {
+	keys   map[int]cipher.AEAD
+	active int
+}
+
+// sealedTokens is the at-rest form of an account's tokens.
+type sealedTokens struct {
+	AccessToken  string
+	RefreshToken sql.NullString
+	WrappedDEK   sql.NullString
+	KeyVersion   int
+}
+
+func initTokenKeys() {
+	ring, err := loadKeyRing()
+	if err != nil {
+		log.Fatalf("Failed to load token encryption keys: %v", err)
+	}
+	tokenKeys = ring
+	log.Printf("Token encryption enabled with %d key(s), active version %d", len(ring.keys), ring.active)
+}
+
+func loadKeyRing() (*keyRing, error) {
+	spec := cfg.TokenEncryption.Keys
+	if path := cfg.TokenEncryption.KeysFile; path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		spec = string(data)
+	}
+	ring := &keyRing{keys: make(map[int]cipher.AEAD)}
+	scanner := bufio.NewScanner(strings.NewReader(strings.ReplaceAll(spec, ",", "\n")))
+	for scanner.Scan() {
+		entry := strings.TrimSpace(scanner.Text())
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		versionStr, encoded, ok := strings.Cut(entry, ":")
+		if !ok {
+			return nil, fmt.Errorf("key entry %q is not in version:base64key form", entry)
+		}
+		version, err := strconv.Atoi(strings.TrimSpace(versionStr))
+		if err != nil || version <= plaintextKeyVersion {
+			return nil, fmt.Errorf("key version %q must be a positive integer", versionStr)
+		}
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return nil, fmt.Errorf("key version %d is not valid base64: %w", version, err)
+		}
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, fmt.Errorf("key version %d: %w", version, err)
+		}
+		if _, dup := ring.keys[version]; dup {
+			return nil, fmt.Errorf("key version %d is defined twice", version)
+		}
+		ring.keys[version] = aead
+		if version > ring.active {
+			ring.active = version
+		}
+	}
+	if len(ring.keys) == 0 {
+		return nil, errors.New("no keys configured")
+	}
+	if active := cfg.TokenEncryption.ActiveKey; active != 0 {
+		if _, ok := ring.keys[active]; !ok {
+			return nil, fmt.Errorf("active key version %d is not configured", active)
+		}
+		ring.active = active
+	}
+	return ring, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("key must be 32 bytes, got %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Seal encrypts an account's tokens under a fresh DEK wrapped by the active KEK.
+// The platform user ID is bound as additional data so ciphertexts cannot be
+// moved between rows.
+func (k *keyRing) Seal(platformUserID, accessToken, refreshToken string) (sealedTokens, error) {
+	dek := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, dek); err != nil {
+		return sealedTokens{}, fmt.Errorf("failed to generate data key: %w", err)
+	}
+	dekAEAD, err := newAEAD(dek)
+	if err != nil {
+		return sealedTokens{}, err
+	}
+	wrapped, err := k.wrap(platformUserID, dek, k.active)
+	if err != nil {
+		return sealedTokens{}, err
+	}
+	sealed := sealedTokens{
+		AccessToken: sealString(dekAEAD, accessToken, platformUserID+"/access_token"),
+		WrappedDEK:  sql.NullString{String: wrapped, Valid: true},
+		KeyVersion:  k.active,
+	}
+	if refreshToken != "" {
+		sealed.RefreshToken = sql.NullString{String: sealString(dekAEAD, refreshToken, platformUserID+"/refresh_token"), Valid: true}
+	}
+	return sealed, nil
+}
+
+// Open decrypts tokens produced by Seal. Legacy plaintext rows are returned as-is.
+func (k *keyRing) Open(platformUserID string, sealed sealedTokens) (accessToken, refreshToken string, err error) {
+	if sealed.KeyVersion == plaintextKeyVersion {
+		return sealed.AccessToken, sealed.RefreshToken.String, nil
+	}
+	dek, err := k.unwrap(platformUserID, sealed.WrappedDEK.String, sealed.KeyVersion)
+	if err != nil {
+		return "", "", err
+	}
+	dekAEAD, err := newAEAD(dek)
+	if err != nil {
+		return "", "", err
+	}
+	if accessToken, err = openString(dekAEAD, sealed.AccessToken, platformUserID+"/access_token"); err != nil {
+		return "", "", fmt.Errorf("failed to decrypt access token: %w", err)
+	}
+	if sealed.RefreshToken.Valid {
+		if refreshToken, err = openString(dekAEAD, sealed.RefreshToken.String, platformUserID+"/refresh_token"); err != nil {
+			return "", "", fmt.Errorf("failed to decrypt refresh token: %w", err)
+		}
+	}
+	return accessToken, refreshToken, nil
+}
+
+// Rewrap re-seals a DEK wrapped under version with the active KEK.
+func (k *keyRing) Rewrap(platformUserID, wrappedDEK string, version int) (string, error) {
+	dek, err := k.unwrap(platformUserID, wrappedDEK, version)
+	if err != nil {
+		return "", err
+	}
+	return k.wrap(platformUserID, dek, k.active)
+}
+
+func (k *keyRing) wrap(platformUserID string, dek []byte, version int) (string, error) {
+	kek, ok := k.keys[version]
+	if !ok {
+		return "", fmt.Errorf("%w %d", errUnknownKeyVersion, version)
+	}
+	return sealBytes(kek, dek, "dek/"+strconv.Itoa(version)+"/"+platformUserID), nil
+}
+
+func (k *keyRing) unwrap(platformUserID, wrappedDEK string, version int) ([]byte, error) {
+	kek, ok := k.keys[version]
+	if !ok {
+		return nil, fmt.Errorf("%w %d", errUnknownKeyVersion, version)
+	}
+	dek, err := openBytes(kek, wrappedDEK, "dek/"+strconv.Itoa(version)+"/"+platformUserID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key: %w", err)
+	}
+	return dek, nil
+}
+
+func sealString(aead cipher.AEAD, plaintext, aad string) string {
+	return sealBytes(aead, []byte(plaintext), aad)
+}
+
+func openString(aead cipher.AEAD, encoded, aad string) (string, error) {
+	plaintext, err := openBytes(aead, encoded, aad)
+	return string(plaintext), err
+}
+
+// sealBytes returns base64(nonce || ciphertext).
+func sealBytes(aead cipher.AEAD, plaintext []byte, aad string) string {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		panic(fmt.Sprintf("crypto/rand failed: %v", err))
+	}
+	return base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, plaintext, []byte(aad)))
+}
+
+func openBytes(aead cipher.AEAD, encoded, aad string) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	return aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], []byte(aad))
+}
+
+// --- Key Migration ---
+
+// encryptPlaintextAccounts seals every legacy plaintext row under the active key.
+// It is safe to run from several replicas at once: each row is locked and
+// re-checked before it is rewritten.
+func encryptPlaintextAccounts(ctx context.Context) (int, error) {
+	return forEachAccountWithKeyVersion(ctx, "token_key_version = $1", plaintextKeyVersion,
+		func(tx *sql.Tx, platformUserID string, sealed sealedTokens) error {
+			resealed, err := tokenKeys.Seal(platformUserID, sealed.AccessToken, sealed.RefreshToken.String)
+			if err != nil {
+				return err
+			}
+			_, err = tx.ExecContext(ctx,
+				"UPDATE social_accounts SET access_token = $1, refresh_token = $2, token_dek = $3, token_key_version = $4 WHERE platform_user_id = $5",
+				resealed.AccessToken, resealed.RefreshToken, resealed.WrappedDEK, resealed.KeyVersion, platformUserID,
+			)
+			return err
+		})
+}
+
+// rewrapAccountKeys moves every encrypted row onto the active key. Rows are
+// rewrapped one at a time while the service keeps running; readers can use
+// either key version throughout.
+func rewrapAccountKeys(ctx context.Context) (int, error) {
+	return forEachAccountWithKeyVersion(ctx, "token_key_version <> $1 AND token_key_version <> 0", tokenKeys.active,
+		func(tx *sql.Tx, platformUserID string, sealed sealedTokens) error {
+			wrapped, err := tokenKeys.Rewrap(platformUserID, sealed.WrappedDEK.String, sealed.KeyVersion)
+			if err != nil {
+				return err
+			}
+			_, err = tx.ExecContext(ctx,
+				"UPDATE social_accounts SET token_dek = $1, token_key_version = $2 WHERE platform_user_id = $3",
+				wrapped, tokenKeys.active, platformUserID,
+			)
+			return err
+		})
+}
+
+// forEachAccountWithKeyVersion runs fn in its own transaction for every row that
+// matches cond, locking the row and skipping it if it no longer matches. cond
+// may reference arg as $1.
+func forEachAccountWithKeyVersion(ctx context.Context, cond string, arg int, fn func(tx *sql.Tx, platformUserID string, sealed sealedTokens) error) (int, error) {
+	rows, err := db.QueryContext(ctx, "SELECT platform_user_id FROM social_accounts WHERE "+cond, arg)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list accounts: %w", err)
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan account: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	updated := 0
+	for _, id := range ids {
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return updated, fmt.Errorf("failed to begin transaction: %w", err)
+		}
+		var sealed sealedTokens
+		err = tx.QueryRowContext(ctx,
+			"SELECT access_token, refresh_token, token_dek, token_key_version FROM social_accounts WHERE "+cond+" AND platform_user_id = $2 FOR UPDATE",
+			arg, id,
+		).Scan(&sealed.AccessToken, &sealed.RefreshToken, &sealed.WrappedDEK, &sealed.KeyVersion)
+		if err == sql.ErrNoRows {
+			tx.Rollback()
+			continue
+		}
+		if err != nil {
+			tx.Rollback()
+			return updated, fmt.Errorf("failed to lock account %s: %w", id, err)
+		}
+		if err := fn(tx, id, sealed); err != nil {
+			tx.Rollback()
+			return updated, fmt.Errorf("failed to update account %s: %w", id, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return updated, fmt.Errorf("failed to commit account %s: %w", id, err)
+		}
+		updated++
+	}
+	return updated, nil
+}
+
+// runRotateKeysCommand implements `account-service rotate-keys`.
+func runRotateKeysCommand() {
+	n, err := rewrapAccountKeys(context.Background())
+	if err != nil {
+		log.Fatalf("Key rotation stopped after %d account(s): %v", n, err)
+	}
+	log.Printf("Rewrapped %d account(s) under key version %d", n, tokenKeys.active)
+}
@@ -3,7 +3,12 @@ module account-service
 go 1.22.3
 
 require (
-	github.com/golang-jwt/jwt/v5 v5.3.0 // indirect
-	github.com/gorilla/mux v1.8.1 // indirect
-	github.com/lib/pq v1.10.9 // indirect
+	github.com/golang-jwt/jwt/v5 v5.3.0
+	github.com/gorilla/mux v1.8.1
+	github.com/lib/pq v1.10.9
+	shared v0.0.0
 )
+
+require gopkg.in/yaml.v3 v3.0.1 // indirect
+
+replace shared => ../shared
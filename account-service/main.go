@@ -14,23 +14,17 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/gorilla/mux"
 	_ "github.com/lib/pq"
-)
-
-// --- Configuration ---
-const (
-	JWT_SECRET = "supersecretjwtkeythatshouldbeverylongandrandom"
+	"shared/authz"
+	"shared/jwks"
+	"shared/revocation"
 )
 
 // --- Database Connection ---
 var db *sql.DB
 
 func initDB() {
-	connStr := os.Getenv("DATABASE_URL")
-	if connStr == "" {
-		log.Fatal("DATABASE_URL environment variable is not set")
-	}
 	var err error
-	db, err = sql.Open("postgres", connStr)
+	db, err = sql.Open("postgres", cfg.DatabaseURL)
 	if err != nil {
 		log.Fatalf("Failed to open database connection: %v", err)
 	}
@@ -38,26 +32,6 @@ func initDB() {
 		log.Fatalf("Failed to ping database: %v", err)
 	}
 	log.Println("Account Service successfully connected to PostgreSQL!")
-	createTables()
-}
-
-func createTables() {
-	socialAccountTableSQL := `
-	CREATE TABLE IF NOT EXISTS social_accounts (
-		user_id TEXT,
-		tenant_id TEXT NOT NULL,
-		platform TEXT NOT NULL,
-		platform_user_id TEXT PRIMARY KEY,
-		access_token TEXT NOT NULL,
-		refresh_token TEXT,
-		expires_at TIMESTAMP WITH TIME ZONE,
-		username TEXT,
-		profile_pic TEXT
-	);`
-	if _, err := db.Exec(socialAccountTableSQL); err != nil {
-		log.Fatalf("Failed to create social_accounts table: %v", err)
-	}
-	log.Println("Account Service tables created successfully.")
 }
 
 // --- Models ---
@@ -71,19 +45,63 @@ type UserSocialAccount struct {
 	ExpiresAt      time.Time `json:"expiresAt"`
 	Username       string    `json:"username"`
 	ProfilePic     string    `json:"profilePic"`
+	Status         string    `json:"status"`
+	// RefreshFailures counts consecutive failed token refreshes.
+	RefreshFailures int `json:"-"`
+}
+
+// Connection states of a social account.
+const (
+	accountStatusActive      = "active"
+	accountStatusNeedsReauth = "needs_reauth"
+)
+
+// SocialAccountView is the browser-facing representation of a social account.
+// It deliberately omits the OAuth tokens, which only leave this service through
+// the internal token endpoint.
+type SocialAccountView struct {
+	UserID         string    `json:"userId"`
+	TenantID       string    `json:"tenantId"`
+	Platform       string    `json:"platform"`
+	PlatformUserID string    `json:"platformUserId"`
+	ExpiresAt      time.Time `json:"expiresAt"`
+	Username       string    `json:"username"`
+	ProfilePic     string    `json:"profilePic"`
+	Status         string    `json:"status"`
+}
+
+func (a UserSocialAccount) view() SocialAccountView {
+	return SocialAccountView{
+		UserID:         a.UserID,
+		TenantID:       a.TenantID,
+		Platform:       a.Platform,
+		PlatformUserID: a.PlatformUserID,
+		ExpiresAt:      a.ExpiresAt,
+		Username:       a.Username,
+		ProfilePic:     a.ProfilePic,
+		Status:         a.Status,
+	}
 }
 
 type Claims struct {
 	UserID   string `json:"user_id"`
 	TenantID string `json:"tenant_id"`
+	// Role is the user's role in the tenant; see authz.go for what it allows.
+	Role string `json:"role"`
+	// AMR lists how the session was authenticated; "mfa" means it passed a second factor.
+	AMR []string `json:"amr,omitempty"`
 	jwt.RegisteredClaims
 }
 
 // --- Database Operations ---
 func saveUserSocialAccount(account UserSocialAccount) error {
-	_, err := db.Exec(
-		"INSERT INTO social_accounts (user_id, tenant_id, platform, platform_user_id, access_token, refresh_token, expires_at, username, profile_pic) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) ON CONFLICT (platform_user_id) DO UPDATE SET user_id = $1, tenant_id = $2, access_token = $5, expires_at = $7, username = $8, profile_pic = $9",
-		account.UserID, account.TenantID, account.Platform, account.PlatformUserID, account.AccessToken, account.RefreshToken, account.ExpiresAt, account.Username, account.ProfilePic,
+	sealed, err := tokenKeys.Seal(account.PlatformUserID, account.AccessToken, account.RefreshToken)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt tokens: %w", err)
+	}
+	_, err = db.Exec(
+		"INSERT INTO social_accounts (user_id, tenant_id, platform, platform_user_id, access_token, refresh_token, expires_at, username, profile_pic, token_dek, token_key_version) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) ON CONFLICT (platform_user_id) DO UPDATE SET user_id = $1, tenant_id = $2, access_token = $5, refresh_token = $6, expires_at = $7, username = $8, profile_pic = $9, token_dek = $10, token_key_version = $11, status = 'active', refresh_failures = 0, last_refresh_error = NULL",
+		account.UserID, account.TenantID, account.Platform, account.PlatformUserID, sealed.AccessToken, sealed.RefreshToken, account.ExpiresAt, account.Username, account.ProfilePic, sealed.WrappedDEK, sealed.KeyVersion,
 	)
 	if err != nil {
 		return fmt.Errorf("failed to save social account: %w", err)
@@ -91,32 +109,104 @@ func saveUserSocialAccount(account UserSocialAccount) error {
 	return nil
 }
 
-func getSocialAccountsForUser(userID, tenantID string) ([]UserSocialAccount, error) {
-	rows, err := db.Query("SELECT user_id, tenant_id, platform, platform_user_id, access_token, refresh_token, expires_at, username, profile_pic FROM social_accounts WHERE user_id = $1 AND tenant_id = $2", userID, tenantID)
+// updateSocialAccountTokens stores fresh tokens for an account that is already
+// connected, leaving the tenant and user it belongs to alone. Accounts nobody
+// connected are ignored.
+func updateSocialAccountTokens(account UserSocialAccount) error {
+	sealed, err := tokenKeys.Seal(account.PlatformUserID, account.AccessToken, account.RefreshToken)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt tokens: %w", err)
+	}
+	_, err = db.Exec(
+		"UPDATE social_accounts SET access_token = $2, refresh_token = $3, expires_at = $4, token_dek = $5, token_key_version = $6, status = 'active', refresh_failures = 0, last_refresh_error = NULL WHERE platform_user_id = $1 AND platform = $7",
+		account.PlatformUserID, sealed.AccessToken, sealed.RefreshToken, account.ExpiresAt, sealed.WrappedDEK, sealed.KeyVersion, account.Platform,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update social account tokens: %w", err)
+	}
+	return nil
+}
+
+// getSocialAccountsForTenant returns the accounts connected to a tenant by any of its members.
+func getSocialAccountsForTenant(tenantID string) ([]UserSocialAccount, error) {
+	rows, err := db.Query("SELECT user_id, tenant_id, platform, platform_user_id, expires_at, username, profile_pic, status FROM social_accounts WHERE tenant_id = $1", tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get social accounts: %w", err)
+	}
+	return scanSocialAccounts(rows)
+}
+
+// getAllSocialAccounts lists the connected accounts of every tenant.
+func getAllSocialAccounts() ([]UserSocialAccount, error) {
+	rows, err := db.Query("SELECT user_id, tenant_id, platform, platform_user_id, expires_at, username, profile_pic, status FROM social_accounts ORDER BY tenant_id")
 	if err != nil {
 		return nil, fmt.Errorf("failed to get social accounts: %w", err)
 	}
+	return scanSocialAccounts(rows)
+}
+
+func scanSocialAccounts(rows *sql.Rows) ([]UserSocialAccount, error) {
 	defer rows.Close()
 
 	var accounts []UserSocialAccount
 	for rows.Next() {
 		var account UserSocialAccount
-		var refreshToken sql.NullString
-		if err := rows.Scan(&account.UserID, &account.TenantID, &account.Platform, &account.PlatformUserID, &account.AccessToken, &refreshToken, &account.ExpiresAt, &account.Username, &account.ProfilePic); err != nil {
+		if err := rows.Scan(&account.UserID, &account.TenantID, &account.Platform, &account.PlatformUserID, &account.ExpiresAt, &account.Username, &account.ProfilePic, &account.Status); err != nil {
 			return nil, fmt.Errorf("failed to scan social account row: %w", err)
 		}
-		if refreshToken.Valid {
-			account.RefreshToken = refreshToken.String
-		}
 		accounts = append(accounts, account)
 	}
-	return accounts, nil
+	return accounts, rows.Err()
+}
+
+// deleteSocialAccount removes a connected account of the tenant. It reports
+// whether the account existed.
+func deleteSocialAccount(tenantID, platformUserID string) (bool, error) {
+	res, err := db.Exec("DELETE FROM social_accounts WHERE platform_user_id = $1 AND tenant_id = $2", platformUserID, tenantID)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete social account: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to delete social account: %w", err)
+	}
+	return n > 0, nil
 }
 
 // --- Middleware ---
+
+// keySet verifies the platform JWTs presented to authMiddleware.
+var keySet jwks.KeySet
+
+func initKeySet() {
+	keySet = jwks.NewRemoteKeySet(cfg.JWKS.URL, cfg.JWKS.RefreshInterval)
+}
+
+func parseJWT(ctx context.Context, tokenString string, claims *Claims) (*jwt.Token, error) {
+	return jwks.Parse(ctx, keySet, cfg.JWTIssuer, tokenString, claims)
+}
+
+// revokedTokens holds the jtis of access tokens auth-service has revoked
+// before their expiry; authMiddleware rejects them.
+var revokedTokens *revocation.List
+
+func initRevocationList() {
+	revokedTokens = revocation.NewList()
+	poller := &revocation.Poller{
+		URL:      cfg.Revocation.URL,
+		Secret:   cfg.InternalAPISecret,
+		Interval: cfg.Revocation.PollInterval,
+		Client:   &http.Client{Timeout: 10 * time.Second},
+		List:     revokedTokens,
+	}
+	go poller.Run(context.Background())
+}
+
 type contextKey string
 const userIDKey contextKey = "userID"
 const tenantIDKey contextKey = "tenantID"
+const amrKey contextKey = "amr"
+const roleKey contextKey = "role"
 
 func authMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -127,19 +217,38 @@ func authMiddleware(next http.Handler) http.Handler {
 		}
 		tokenString := strings.Replace(authHeader, "Bearer ", "", 1)
 		claims := &Claims{}
-		token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-			return []byte(JWT_SECRET), nil
-		})
+		token, err := parseJWT(r.Context(), tokenString, claims)
 		if err != nil || !token.Valid {
 			http.Error(w, "Invalid or expired token", http.StatusUnauthorized)
 			return
 		}
+		if revokedTokens.Revoked(claims.ID) {
+			http.Error(w, "Token has been revoked", http.StatusUnauthorized)
+			return
+		}
 		ctx := context.WithValue(r.Context(), userIDKey, claims.UserID)
 		ctx = context.WithValue(ctx, tenantIDKey, claims.TenantID)
+		ctx = context.WithValue(ctx, amrKey, claims.AMR)
+		ctx = context.WithValue(ctx, roleKey, claims.Role)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+// requireMFA guards sensitive endpoints: it only admits access tokens from
+// sessions that passed two-factor authentication.
+func requireMFA(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		amr, _ := r.Context().Value(amrKey).([]string)
+		for _, method := range amr {
+			if method == "mfa" {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		authz.WriteForbidden(w, authz.ForbiddenResponse{Error: "mfa_required", Message: "This action requires a session signed in with two-factor authentication."})
+	})
+}
+
 func getUserIDAndTenantIDFromContext(ctx context.Context) (string, string, error) {
 	userID, ok := ctx.Value(userIDKey).(string)
 	if !ok {
@@ -159,6 +268,21 @@ func createAccountHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	if newAccount.TenantID == "" {
+		// A plain sign-in: refresh the tokens of an account some tenant already
+		// connected, but never move it or connect a new one.
+		if err := updateSocialAccountTokens(newAccount); err != nil {
+			log.Printf("Failed to update social account tokens: %v", err)
+			http.Error(w, "Failed to save social account", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if newAccount.UserID == "" {
+		http.Error(w, "userId is required with tenantId", http.StatusBadRequest)
+		return
+	}
 	if err := saveUserSocialAccount(newAccount); err != nil {
 		log.Printf("Failed to save social account: %v", err)
 		http.Error(w, "Failed to save social account", http.StatusInternalServerError)
@@ -166,34 +290,60 @@ func createAccountHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(newAccount)
+	json.NewEncoder(w).Encode(newAccount.view())
 }
 
 func getAccountsHandler(w http.ResponseWriter, r *http.Request) {
-	userID, tenantID, err := getUserIDAndTenantIDFromContext(r.Context())
+	_, tenantID, err := getUserIDAndTenantIDFromContext(r.Context())
 	if err != nil {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
-	accounts, err := getSocialAccountsForUser(userID, tenantID)
+	accounts, err := getSocialAccountsForTenant(tenantID)
 	if err != nil {
 		http.Error(w, "Failed to retrieve accounts", http.StatusInternalServerError)
 		return
 	}
+	views := make([]SocialAccountView, 0, len(accounts))
+	for _, account := range accounts {
+		views = append(views, account.view())
+	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(accounts)
+	json.NewEncoder(w).Encode(views)
 }
 
-// --- Main function ---
-func main() {
-	initDB()
-	defer db.Close()
+// disconnectAccountHandler removes a connected account and its stored tokens.
+func disconnectAccountHandler(w http.ResponseWriter, r *http.Request) {
+	userID, tenantID, err := getUserIDAndTenantIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	platformUserID := mux.Vars(r)["platformUserId"]
+	found, err := deleteSocialAccount(tenantID, platformUserID)
+	if err != nil {
+		log.Printf("Failed to disconnect account %s: %v", platformUserID, err)
+		http.Error(w, "Failed to disconnect account", http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		http.Error(w, "Account not found", http.StatusNotFound)
+		return
+	}
+	log.Printf("User %s disconnected account %s", userID, platformUserID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// --- Routes ---
 
+// newRouter builds the service's HTTP routes: the internal API for other
+// services, and the public API behind authMiddleware and authorize.
+func newRouter() *mux.Router {
 	router := mux.NewRouter()
 
 	router.Use(func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			w.Header().Set("Access-Control-Allow-Origin", "http://localhost:3000")
+			w.Header().Set("Access-Control-Allow-Origin", cfg.CORSAllowedOrigin)
 			w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE")
 			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Access-Control-Allow-Headers, Authorization, X-Requested-With")
 			w.Header().Set("Access-Control-Allow-Credentials", "true")
@@ -205,12 +355,50 @@ func main() {
 		})
 	})
 	
-	router.HandleFunc("/accounts", createAccountHandler).Methods("POST")
+	internalRouter := router.PathPrefix("/internal").Subrouter()
+	internalRouter.Use(internalAuthMiddleware)
+	internalRouter.HandleFunc("/accounts", createAccountHandler).Methods("POST")
+	internalRouter.HandleFunc("/tokens", getTokenHandler).Methods("GET")
+	internalRouter.HandleFunc("/accounts", listAccountsInternalHandler).Methods("GET")
 	
 	apiRouter := router.PathPrefix("/api").Subrouter()
 	apiRouter.Use(authMiddleware)
+	apiRouter.Use(authorize)
 	apiRouter.HandleFunc("/accounts", getAccountsHandler).Methods("GET")
-	
-	log.Println("Account Service is starting on port 8082...")
-	log.Fatal(http.ListenAndServe(":8082", router))
+	apiRouter.Handle("/accounts/{platformUserId}", requireMFA(http.HandlerFunc(disconnectAccountHandler))).Methods("DELETE")
+	return router
+}
+
+// --- Main function ---
+func main() {
+	initConfig()
+	initDB()
+	defer db.Close()
+
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		runMigrateCommand(os.Args[2:])
+		return
+	}
+	autoMigrate()
+	initTokenKeys()
+
+	if len(os.Args) > 1 && os.Args[1] == "rotate-keys" {
+		runRotateKeysCommand()
+		return
+	}
+	if n, err := encryptPlaintextAccounts(context.Background()); err != nil {
+		log.Fatalf("Failed to encrypt plaintext tokens: %v", err)
+	} else if n > 0 {
+		log.Printf("Encrypted tokens of %d legacy account(s)", n)
+	}
+
+	initTokenRefreshers()
+	initKeySet()
+	initRevocationList()
+	go newTokenRotator().Run(context.Background())
+
+	router := newRouter()
+
+	log.Printf("Account Service is starting on port %d...", cfg.Port)
+	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", cfg.Port), router))
 }
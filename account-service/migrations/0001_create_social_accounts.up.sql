@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS social_accounts (
+	user_id TEXT,
+	tenant_id TEXT NOT NULL,
+	platform TEXT NOT NULL,
+	platform_user_id TEXT PRIMARY KEY,
+	access_token TEXT NOT NULL,
+	refresh_token TEXT,
+	expires_at TIMESTAMP WITH TIME ZONE,
+	username TEXT,
+	profile_pic TEXT
+);
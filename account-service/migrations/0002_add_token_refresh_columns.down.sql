@@ -0,0 +1,4 @@
+ALTER TABLE social_accounts DROP COLUMN IF EXISTS last_refreshed_at;
+ALTER TABLE social_accounts DROP COLUMN IF EXISTS last_refresh_error;
+ALTER TABLE social_accounts DROP COLUMN IF EXISTS refresh_failures;
+ALTER TABLE social_accounts DROP COLUMN IF EXISTS status;
@@ -0,0 +1,5 @@
+-- Databases created before migrations existed may already have these columns.
+ALTER TABLE social_accounts ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'active';
+ALTER TABLE social_accounts ADD COLUMN IF NOT EXISTS refresh_failures INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE social_accounts ADD COLUMN IF NOT EXISTS last_refresh_error TEXT;
+ALTER TABLE social_accounts ADD COLUMN IF NOT EXISTS last_refreshed_at TIMESTAMP WITH TIME ZONE;
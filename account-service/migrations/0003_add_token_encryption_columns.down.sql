@@ -0,0 +1,9 @@
+DO $$
+BEGIN
+	IF EXISTS (SELECT 1 FROM social_accounts WHERE token_key_version <> 0) THEN
+		RAISE EXCEPTION 'social_accounts still holds encrypted tokens; they cannot be reverted to plaintext';
+	END IF;
+END
+$$;
+ALTER TABLE social_accounts DROP COLUMN IF EXISTS token_key_version;
+ALTER TABLE social_accounts DROP COLUMN IF EXISTS token_dek;
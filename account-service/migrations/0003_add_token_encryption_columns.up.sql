@@ -0,0 +1,3 @@
+-- Rows with token_key_version 0 hold plaintext tokens and are encrypted at startup.
+ALTER TABLE social_accounts ADD COLUMN IF NOT EXISTS token_dek TEXT;
+ALTER TABLE social_accounts ADD COLUMN IF NOT EXISTS token_key_version INTEGER NOT NULL DEFAULT 0;
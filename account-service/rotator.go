@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// --- Token Rotation Job ---
+const (
+	// maxRefreshFailures is how many consecutive refresh failures an account may
+	// have before it is marked needs_reauth and left alone until reconnected.
+	maxRefreshFailures = 3
+)
+
+// TokenRotator periodically refreshes every active account whose access token
+// expires within the window, so tokens are rotated ahead of publishing rather
+// than on demand. Failures surface on the account as needs_reauth.
+type TokenRotator struct {
+	interval time.Duration
+	window   time.Duration
+}
+
+func newTokenRotator() *TokenRotator {
+	return &TokenRotator{
+		interval: cfg.TokenRotation.Interval,
+		window:   cfg.TokenRotation.Window,
+	}
+}
+
+// Run rotates expiring tokens until ctx is cancelled.
+func (t *TokenRotator) Run(ctx context.Context) {
+	log.Printf("Token rotation job started (interval %s, window %s)", t.interval, t.window)
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+	for {
+		t.rotateExpiring(ctx)
+		select {
+		case <-ctx.Done():
+			log.Println("Token rotation job stopped.")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (t *TokenRotator) rotateExpiring(ctx context.Context) {
+	accounts, err := getAccountsExpiringBefore(ctx, time.Now().Add(t.window))
+	if err != nil {
+		log.Printf("Failed to list expiring accounts: %v", err)
+		return
+	}
+	for _, account := range accounts {
+		if _, err := refreshAccountToken(ctx, account.TenantID, account.PlatformUserID, t.window); err != nil {
+			log.Printf("Token rotation for %s account %s failed: %v", account.Platform, account.PlatformUserID, err)
+		}
+	}
+}
+
+// getAccountsExpiringBefore lists active accounts whose tokens expire before deadline.
+func getAccountsExpiringBefore(ctx context.Context, deadline time.Time) ([]UserSocialAccount, error) {
+	rows, err := db.QueryContext(ctx,
+		"SELECT tenant_id, platform, platform_user_id FROM social_accounts WHERE status = $1 AND expires_at IS NOT NULL AND expires_at < $2 ORDER BY expires_at",
+		accountStatusActive, deadline,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query expiring accounts: %w", err)
+	}
+	defer rows.Close()
+
+	var accounts []UserSocialAccount
+	for rows.Next() {
+		var account UserSocialAccount
+		if err := rows.Scan(&account.TenantID, &account.Platform, &account.PlatformUserID); err != nil {
+			return nil, fmt.Errorf("failed to scan expiring account: %w", err)
+		}
+		accounts = append(accounts, account)
+	}
+	return accounts, rows.Err()
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// --- Token Vending ---
+const (
+	// tokenRefreshSkew is how close to expires_at a token may get before it is
+	// refreshed instead of handed out.
+	tokenRefreshSkew = 10 * time.Minute
+
+	defaultMetaGraphBaseURL   = "https://graph.facebook.com/v19.0"
+	defaultTikTokBaseURL      = "https://open.tiktokapis.com"
+	defaultSnapchatAuthURL    = "https://accounts.snapchat.com"
+	internalTokenHeader       = "X-Internal-Token"
+	tokenRefreshClientTimeout = 30 * time.Second
+)
+
+var (
+	errAccountNotFound    = errors.New("social account not found")
+	errAccountNeedsReauth = errors.New("social account needs re-authorization")
+)
+
+// TokenSet is the result of a successful refresh. RefreshToken is empty when the
+// platform did not rotate it.
+type TokenSet struct {
+	AccessToken  string
+	RefreshToken string
+	ExpiresAt    time.Time
+}
+
+// TokenRefresher exchanges an account's current credentials for fresh ones.
+type TokenRefresher interface {
+	Refresh(ctx context.Context, account UserSocialAccount) (TokenSet, error)
+}
+
+// tokenRefreshers maps a lower-cased platform name to its refresher.
+var tokenRefreshers = map[string]TokenRefresher{}
+
+func initTokenRefreshers() {
+	client := &http.Client{Timeout: tokenRefreshClientTimeout}
+	tokenRefreshers["meta"] = &metaRefresher{
+		baseURL:      cfg.Meta.OAuthBaseURL,
+		clientID:     cfg.Meta.ClientID,
+		clientSecret: cfg.Meta.ClientSecret,
+		client:       client,
+	}
+	tokenRefreshers["tiktok"] = &tiktokRefresher{
+		baseURL:      cfg.TikTok.OAuthBaseURL,
+		clientKey:    cfg.TikTok.ClientID,
+		clientSecret: cfg.TikTok.ClientSecret,
+		client:       client,
+	}
+	tokenRefreshers["snapchat"] = &snapchatRefresher{
+		baseURL:      cfg.Snapchat.OAuthBaseURL,
+		clientID:     cfg.Snapchat.ClientID,
+		clientSecret: cfg.Snapchat.ClientSecret,
+		client:       client,
+	}
+}
+
+// needsRefresh reports whether the account's access token expires within skew.
+// Accounts without an expiry never need refreshing.
+func needsRefresh(account UserSocialAccount, skew time.Duration) bool {
+	return !account.ExpiresAt.IsZero() && time.Until(account.ExpiresAt) < skew
+}
+
+// getValidAccessToken returns a usable access token for the account, refreshing it
+// first if it is about to expire.
+func getValidAccessToken(ctx context.Context, tenantID, platformUserID string) (UserSocialAccount, error) {
+	return refreshAccountToken(ctx, tenantID, platformUserID, tokenRefreshSkew)
+}
+
+// refreshAccountToken refreshes the account's tokens if they expire within skew
+// and returns the current account. The row is locked for the duration so
+// concurrent callers (including other replicas) don't both spend a single-use
+// refresh token; whoever waits re-reads the rotated row and skips the refresh.
+// A failed refresh is counted, and after maxRefreshFailures the account is
+// marked needs_reauth.
+func refreshAccountToken(ctx context.Context, tenantID, platformUserID string, skew time.Duration) (UserSocialAccount, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return UserSocialAccount{}, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var account UserSocialAccount
+	var sealed sealedTokens
+	var expiresAt sql.NullTime
+	row := tx.QueryRowContext(ctx,
+		"SELECT user_id, tenant_id, platform, platform_user_id, access_token, refresh_token, token_dek, token_key_version, expires_at, status, refresh_failures FROM social_accounts WHERE tenant_id = $1 AND platform_user_id = $2 FOR UPDATE",
+		tenantID, platformUserID,
+	)
+	err = row.Scan(&account.UserID, &account.TenantID, &account.Platform, &account.PlatformUserID, &sealed.AccessToken, &sealed.RefreshToken, &sealed.WrappedDEK, &sealed.KeyVersion, &expiresAt, &account.Status, &account.RefreshFailures)
+	if err == sql.ErrNoRows {
+		return UserSocialAccount{}, errAccountNotFound
+	}
+	if err != nil {
+		return UserSocialAccount{}, fmt.Errorf("failed to load social account: %w", err)
+	}
+	if account.AccessToken, account.RefreshToken, err = tokenKeys.Open(account.PlatformUserID, sealed); err != nil {
+		return UserSocialAccount{}, fmt.Errorf("failed to decrypt tokens for account %s: %w", account.PlatformUserID, err)
+	}
+	account.ExpiresAt = expiresAt.Time
+
+	if account.Status == accountStatusNeedsReauth {
+		return UserSocialAccount{}, errAccountNeedsReauth
+	}
+	if !needsRefresh(account, skew) {
+		return account, nil
+	}
+	refresher, ok := tokenRefreshers[strings.ToLower(account.Platform)]
+	if !ok {
+		return UserSocialAccount{}, fmt.Errorf("no token refresher for platform %q", account.Platform)
+	}
+	tokens, refreshErr := refresher.Refresh(ctx, account)
+	if refreshErr != nil {
+		status := accountStatusActive
+		if account.RefreshFailures+1 >= maxRefreshFailures {
+			status = accountStatusNeedsReauth
+		}
+		if _, err := tx.ExecContext(ctx,
+			"UPDATE social_accounts SET refresh_failures = refresh_failures + 1, last_refresh_error = $1, status = $2 WHERE platform_user_id = $3",
+			refreshErr.Error(), status, account.PlatformUserID,
+		); err != nil {
+			return UserSocialAccount{}, fmt.Errorf("failed to record refresh failure: %w", err)
+		}
+		if err := tx.Commit(); err != nil {
+			return UserSocialAccount{}, fmt.Errorf("failed to commit refresh failure: %w", err)
+		}
+		if status == accountStatusNeedsReauth {
+			log.Printf("%s account %s needs re-authorization after %d failed refreshes", account.Platform, account.PlatformUserID, account.RefreshFailures+1)
+		}
+		return UserSocialAccount{}, fmt.Errorf("failed to refresh %s token: %w", account.Platform, refreshErr)
+	}
+	account.AccessToken = tokens.AccessToken
+	if tokens.RefreshToken != "" {
+		account.RefreshToken = tokens.RefreshToken
+	}
+	account.ExpiresAt = tokens.ExpiresAt
+	account.RefreshFailures = 0
+	resealed, err := tokenKeys.Seal(account.PlatformUserID, account.AccessToken, account.RefreshToken)
+	if err != nil {
+		return UserSocialAccount{}, fmt.Errorf("failed to encrypt refreshed tokens: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE social_accounts SET access_token = $1, refresh_token = $2, token_dek = $3, token_key_version = $4, expires_at = $5, refresh_failures = 0, last_refresh_error = NULL, last_refreshed_at = now() WHERE platform_user_id = $6",
+		resealed.AccessToken, resealed.RefreshToken, resealed.WrappedDEK, resealed.KeyVersion, account.ExpiresAt, account.PlatformUserID,
+	); err != nil {
+		return UserSocialAccount{}, fmt.Errorf("failed to store refreshed token: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return UserSocialAccount{}, fmt.Errorf("failed to commit refreshed token: %w", err)
+	}
+	log.Printf("Refreshed %s token for account %s", account.Platform, account.PlatformUserID)
+	return account, nil
+}
+
+// --- Platform Refreshers ---
+
+// metaRefresher renews a Facebook Page's access token. Meta does not issue
+// refresh tokens; instead the account keeps the long-lived user token it was
+// connected with in RefreshToken. That token is re-exchanged, which Meta
+// allows while it is still valid, and the Page token is read again with it.
+type metaRefresher struct {
+	baseURL, clientID, clientSecret string
+	client                          *http.Client
+}
+
+func (m *metaRefresher) Refresh(ctx context.Context, account UserSocialAccount) (TokenSet, error) {
+	if account.RefreshToken == "" {
+		return TokenSet{}, fmt.Errorf("account has no user token to renew its Page token with")
+	}
+	baseURL := strings.TrimRight(m.baseURL, "/")
+	q := url.Values{}
+	q.Set("grant_type", "fb_exchange_token")
+	q.Set("client_id", m.clientID)
+	q.Set("client_secret", m.clientSecret)
+	q.Set("fb_exchange_token", account.RefreshToken)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL+"/oauth/access_token?"+q.Encode(), nil)
+	if err != nil {
+		return TokenSet{}, err
+	}
+	user, err := doTokenRequest(m.client, "Meta", req)
+	if err != nil {
+		return TokenSet{}, err
+	}
+
+	q = url.Values{}
+	q.Set("fields", "access_token")
+	q.Set("access_token", user.AccessToken)
+	req, err = http.NewRequestWithContext(ctx, http.MethodGet, baseURL+"/"+url.PathEscape(account.PlatformUserID)+"?"+q.Encode(), nil)
+	if err != nil {
+		return TokenSet{}, err
+	}
+	page, err := doTokenRequest(m.client, "Meta", req)
+	if err != nil {
+		return TokenSet{}, err
+	}
+	return TokenSet{AccessToken: page.AccessToken, RefreshToken: user.AccessToken, ExpiresAt: user.ExpiresAt}, nil
+}
+
+// tiktokRefresher uses TikTok's refresh_token grant, which rotates the refresh token.
+type tiktokRefresher struct {
+	baseURL, clientKey, clientSecret string
+	client                           *http.Client
+}
+
+func (t *tiktokRefresher) Refresh(ctx context.Context, account UserSocialAccount) (TokenSet, error) {
+	if account.RefreshToken == "" {
+		return TokenSet{}, fmt.Errorf("account has no refresh token")
+	}
+	form := url.Values{}
+	form.Set("client_key", t.clientKey)
+	form.Set("client_secret", t.clientSecret)
+	form.Set("grant_type", "refresh_token")
+	form.Set("refresh_token", account.RefreshToken)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(t.baseURL, "/")+"/v2/oauth/token/", strings.NewReader(form.Encode()))
+	if err != nil {
+		return TokenSet{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return doTokenRequest(t.client, "TikTok", req)
+}
+
+// snapchatRefresher uses Snapchat's standard OAuth 2.0 refresh_token grant.
+type snapchatRefresher struct {
+	baseURL, clientID, clientSecret string
+	client                          *http.Client
+}
+
+func (s *snapchatRefresher) Refresh(ctx context.Context, account UserSocialAccount) (TokenSet, error) {
+	if account.RefreshToken == "" {
+		return TokenSet{}, fmt.Errorf("account has no refresh token")
+	}
+	form := url.Values{}
+	form.Set("grant_type", "refresh_token")
+	form.Set("refresh_token", account.RefreshToken)
+	form.Set("client_id", s.clientID)
+	form.Set("client_secret", s.clientSecret)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(s.baseURL, "/")+"/login/oauth2/access_token", strings.NewReader(form.Encode()))
+	if err != nil {
+		return TokenSet{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return doTokenRequest(s.client, "Snapchat", req)
+}
+
+// doTokenRequest executes an OAuth token request and parses the standard response fields.
+func doTokenRequest(client *http.Client, platform string, req *http.Request) (TokenSet, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return TokenSet{}, fmt.Errorf("failed to call %s token endpoint: %w", platform, err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode != http.StatusOK {
+		return TokenSet{}, fmt.Errorf("%s token endpoint returned status %d: %s", platform, resp.StatusCode, string(body))
+	}
+	var tokenData struct {
+		AccessToken  string  `json:"access_token"`
+		RefreshToken string  `json:"refresh_token"`
+		ExpiresIn    float64 `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &tokenData); err != nil {
+		return TokenSet{}, fmt.Errorf("failed to parse %s token response: %w", platform, err)
+	}
+	if tokenData.AccessToken == "" {
+		return TokenSet{}, fmt.Errorf("%s token response did not include an access token: %s", platform, string(body))
+	}
+	tokens := TokenSet{AccessToken: tokenData.AccessToken, RefreshToken: tokenData.RefreshToken}
+	if tokenData.ExpiresIn > 0 {
+		tokens.ExpiresAt = time.Now().Add(time.Duration(tokenData.ExpiresIn) * time.Second)
+	}
+	return tokens, nil
+}
+
+// --- Internal API ---
+
+// internalAuthMiddleware admits only callers presenting the internal API secret.
+func internalAuthMiddleware(next http.Handler) http.Handler {
+	secret := cfg.InternalAPISecret
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		presented := r.Header.Get(internalTokenHeader)
+		if subtle.ConstantTimeCompare([]byte(presented), []byte(secret)) != 1 {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// internalTokenResponse is returned to other services; it is never sent to browsers.
+type internalTokenResponse struct {
+	Platform       string    `json:"platform"`
+	PlatformUserID string    `json:"platformUserId"`
+	AccessToken    string    `json:"accessToken"`
+	ExpiresAt      time.Time `json:"expiresAt"`
+}
+
+func getTokenHandler(w http.ResponseWriter, r *http.Request) {
+	tenantID := r.URL.Query().Get("tenant_id")
+	platformUserID := r.URL.Query().Get("platform_user_id")
+	if tenantID == "" || platformUserID == "" {
+		http.Error(w, "tenant_id and platform_user_id are required", http.StatusBadRequest)
+		return
+	}
+	account, err := getValidAccessToken(r.Context(), tenantID, platformUserID)
+	if errors.Is(err, errAccountNotFound) {
+		http.Error(w, "Social account not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, errAccountNeedsReauth) {
+		http.Error(w, "Social account needs to be reconnected", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to vend token for account %s: %v", platformUserID, err)
+		http.Error(w, "Failed to obtain access token", http.StatusBadGateway)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(internalTokenResponse{
+		Platform:       account.Platform,
+		PlatformUserID: account.PlatformUserID,
+		AccessToken:    account.AccessToken,
+		ExpiresAt:      account.ExpiresAt,
+	})
+}
+
+// listAccountsInternalHandler lists a tenant's connected accounts, without tokens,
+// so other services can check which accounts a request may target. Without a
+// tenant_id it lists the accounts of every tenant, for background jobs that
+// work through all of them.
+func listAccountsInternalHandler(w http.ResponseWriter, r *http.Request) {
+	tenantID := r.URL.Query().Get("tenant_id")
+	var accounts []UserSocialAccount
+	var err error
+	if tenantID == "" {
+		accounts, err = getAllSocialAccounts()
+	} else {
+		accounts, err = getSocialAccountsForTenant(tenantID)
+	}
+	if err != nil {
+		log.Printf("Failed to list accounts of tenant %q: %v", tenantID, err)
+		http.Error(w, "Failed to retrieve accounts", http.StatusInternalServerError)
+		return
+	}
+	views := make([]SocialAccountView, 0, len(accounts))
+	for _, account := range accounts {
+		views = append(views, account.view())
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(views)
+}
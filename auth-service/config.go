@@ -0,0 +1,158 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/mail"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"shared/config"
+)
+
+// --- Configuration ---
+//
+// Settings come from, in increasing precedence: the `default` tags below, the
+// YAML file named by CONFIG_FILE (optional), and environment variables.
+
+// Config holds every setting of the Auth Service.
+type Config struct {
+	Port              int    `yaml:"port" env:"PORT" default:"8081"`
+	DatabaseURL       string `yaml:"database_url" env:"DATABASE_URL" required:"true" secret:"true"`
+	AutoMigrate       bool   `yaml:"auto_migrate" env:"AUTO_MIGRATE" default:"true"`
+	FrontendURL       string `yaml:"frontend_url" env:"FRONTEND_URL" default:"http://localhost:3000"`
+	PublicURL         string `yaml:"public_url" env:"PUBLIC_URL" default:"http://localhost:8081"`
+	CORSAllowedOrigin string `yaml:"cors_allowed_origin" env:"CORS_ALLOWED_ORIGIN" default:"http://localhost:3000"`
+	JWTIssuer         string `yaml:"jwt_issuer" env:"JWT_ISSUER" default:"social-media-platform"`
+	AccountServiceURL string `yaml:"account_service_url" env:"ACCOUNT_SERVICE_URL" default:"http://localhost:8082"`
+	InternalAPISecret string `yaml:"internal_api_secret" env:"INTERNAL_API_SECRET" required:"true" secret:"true"`
+
+	JWTSigning JWTSigningConfig `yaml:"jwt_signing" env:"JWT_SIGNING"`
+	Session    SessionConfig    `yaml:"session" env:"SESSION"`
+	Mail       MailConfig       `yaml:"mail" env:"MAIL"`
+
+	Meta     OAuthProviderConfig `yaml:"meta" env:"META"`
+	TikTok   OAuthProviderConfig `yaml:"tiktok" env:"TIKTOK"`
+	Snapchat OAuthProviderConfig `yaml:"snapchat" env:"SNAPCHAT"`
+}
+
+// JWTSigningConfig locates the JWT signing keys; see signing.go for the layout.
+type JWTSigningConfig struct {
+	KeysDir   string `yaml:"keys_dir" env:"KEYS_DIR" required:"true"`
+	ActiveKey string `yaml:"active_key" env:"ACTIVE_KEY"`
+}
+
+// SessionConfig controls token lifetimes; see sessions.go.
+type SessionConfig struct {
+	AccessTTL  time.Duration `yaml:"access_ttl" env:"ACCESS_TTL" default:"15m"`
+	RefreshTTL time.Duration `yaml:"refresh_ttl" env:"REFRESH_TTL" default:"720h"`
+	// CookieSecure marks the refresh cookie Secure; enable it whenever auth-service is served over HTTPS.
+	CookieSecure bool `yaml:"cookie_secure" env:"COOKIE_SECURE"`
+}
+
+// MailConfig selects how verification, reset and sign-in emails are sent; see mailer.go.
+type MailConfig struct {
+	// Mode is "smtp" to deliver through SMTP, or "log" to only log messages
+	// (and write them to Dir, if set) for local development.
+	Mode string     `yaml:"mode" env:"MODE" default:"log"`
+	From string     `yaml:"from" env:"FROM" default:"SMM Platform <no-reply@localhost>"`
+	Dir  string     `yaml:"dir" env:"DIR"`
+	SMTP SMTPConfig `yaml:"smtp" env:"SMTP"`
+}
+
+// SMTPConfig is the relay used when mail.mode is smtp.
+type SMTPConfig struct {
+	Host     string `yaml:"host" env:"HOST"`
+	Port     int    `yaml:"port" env:"PORT" default:"587"`
+	Username string `yaml:"username" env:"USERNAME"`
+	Password string `yaml:"password" env:"PASSWORD" secret:"true"`
+}
+
+// OAuthProviderConfig holds the app credentials registered with one platform.
+// TikTok calls its client ID a "client key"; TIKTOK_CLIENT_KEY is accepted too.
+type OAuthProviderConfig struct {
+	ClientID     string `yaml:"client_id" env:"CLIENT_ID"`
+	ClientSecret string `yaml:"client_secret" env:"CLIENT_SECRET" secret:"true"`
+	RedirectURI  string `yaml:"redirect_uri" env:"REDIRECT_URI"`
+}
+
+// Enabled reports whether the provider has been configured.
+func (p OAuthProviderConfig) Enabled() bool {
+	return p.ClientID != ""
+}
+
+var cfg *Config
+
+func initConfig() {
+	c := &Config{
+		Meta:     OAuthProviderConfig{RedirectURI: "http://localhost:8081/oauth/meta/callback"},
+		TikTok:   OAuthProviderConfig{RedirectURI: "http://localhost:8081/oauth/tiktok/callback"},
+		Snapchat: OAuthProviderConfig{RedirectURI: "http://localhost:8081/oauth/snapchat/callback"},
+	}
+	if v := os.Getenv("TIKTOK_CLIENT_KEY"); v != "" && os.Getenv("TIKTOK_CLIENT_ID") == "" {
+		os.Setenv("TIKTOK_CLIENT_ID", v)
+	}
+	if err := config.Load(c); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	if err := c.validate(); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	config.Log(c)
+	cfg = c
+}
+
+func (c *Config) validate() error {
+	var errs []error
+	if len(c.InternalAPISecret) < 32 {
+		errs = append(errs, errors.New("internal_api_secret must be at least 32 characters"))
+	}
+	if c.Session.AccessTTL <= 0 || c.Session.RefreshTTL <= c.Session.AccessTTL {
+		errs = append(errs, errors.New("session.access_ttl must be positive and shorter than session.refresh_ttl"))
+	}
+	if strings.HasPrefix(c.FrontendURL, "https://") && !c.Session.CookieSecure {
+		log.Println("Warning: frontend_url is HTTPS but session.cookie_secure is off.")
+	}
+	for _, u := range []struct{ name, value string }{
+		{"frontend_url", c.FrontendURL},
+		{"public_url", c.PublicURL},
+		{"account_service_url", c.AccountServiceURL},
+	} {
+		if _, err := url.ParseRequestURI(u.value); err != nil {
+			errs = append(errs, fmt.Errorf("%s is not a valid URL: %q", u.name, u.value))
+		}
+	}
+	switch c.Mail.Mode {
+	case "log":
+	case "smtp":
+		if c.Mail.SMTP.Host == "" {
+			errs = append(errs, errors.New("mail.smtp.host is required when mail.mode is smtp"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("mail.mode must be log or smtp, got %q", c.Mail.Mode))
+	}
+	if _, err := mail.ParseAddress(c.Mail.From); err != nil {
+		errs = append(errs, fmt.Errorf("mail.from is not a valid address: %q", c.Mail.From))
+	}
+	providers := map[string]OAuthProviderConfig{"meta": c.Meta, "tiktok": c.TikTok, "snapchat": c.Snapchat}
+	enabled := 0
+	for name, p := range providers {
+		if !p.Enabled() {
+			continue
+		}
+		enabled++
+		if p.ClientSecret == "" {
+			errs = append(errs, fmt.Errorf("%s.client_secret is required when %s.client_id is set", name, name))
+		}
+		if config.IsPlaceholder(p.ClientID) {
+			errs = append(errs, fmt.Errorf("%s.client_id is still a placeholder value", name))
+		}
+	}
+	if enabled == 0 {
+		log.Println("Warning: no OAuth provider is configured; social login is unavailable.")
+	}
+	return errors.Join(errs...)
+}
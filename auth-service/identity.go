@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// --- Identities ---
+//
+// A user is identified by the provider identities linked to them in
+// user_identities, not by any single platform ID. A login with a known identity
+// signs in its user; an unknown identity creates a new user and tenant, unless
+// the flow was started by a signed-in user ("connect another account"), in which
+// case the identity is attached to that user.
+
+var (
+	errIdentityLinkedElsewhere = errors.New("this account is already connected to a different user")
+	errNoPublishableAccounts   = errors.New("this account does not manage any Pages or profiles to publish to")
+)
+
+// oauthProfile is what a provider tells us about the person who signed in.
+type oauthProfile struct {
+	Provider string
+	Subject  string
+	Email    string
+	// EmailVerified is set for providers that only return verified addresses;
+	// only such emails are used to match an existing user.
+	EmailVerified bool
+	Name          string
+	PictureURL    string
+}
+
+// resolveIdentity returns the user behind profile, creating the user or the
+// identity as needed. A non-empty linkUserID attaches the identity to that user.
+func resolveIdentity(ctx context.Context, profile oauthProfile, linkUserID string) (InternalUser, error) {
+	if profile.Subject == "" {
+		return InternalUser{}, fmt.Errorf("%s profile has no user ID", profile.Provider)
+	}
+	profile.Email = strings.ToLower(strings.TrimSpace(profile.Email))
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return InternalUser{}, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var ownerID string
+	err = tx.QueryRowContext(ctx,
+		"SELECT user_id FROM user_identities WHERE provider = $1 AND subject = $2 FOR UPDATE",
+		profile.Provider, profile.Subject,
+	).Scan(&ownerID)
+	if err != nil && err != sql.ErrNoRows {
+		return InternalUser{}, fmt.Errorf("failed to look up identity: %w", err)
+	}
+	identityExists := err == nil
+
+	var userID string
+	switch {
+	case linkUserID != "":
+		if identityExists && ownerID != linkUserID {
+			return InternalUser{}, errIdentityLinkedElsewhere
+		}
+		userID = linkUserID
+	case identityExists:
+		userID = ownerID
+	case profile.EmailVerified && profile.Email != "":
+		// Only an account whose owner proved the address may be joined by email;
+		// anyone can register an unverified one in a victim's name beforehand.
+		err = tx.QueryRowContext(ctx,
+			"SELECT id FROM users WHERE email = $1 AND email_verified_at IS NOT NULL", profile.Email,
+		).Scan(&userID)
+		if err != nil && err != sql.ErrNoRows {
+			return InternalUser{}, fmt.Errorf("failed to get user by email: %w", err)
+		}
+		if userID == "" {
+			// The provider has proved the address, so an unverified account with it
+			// is claimed: it becomes verified and loses the password it was
+			// registered with, which the mailbox owner never chose.
+			err = tx.QueryRowContext(ctx,
+				"UPDATE users SET password_hash = NULL, email_verified_at = now() WHERE email = $1 AND email_verified_at IS NULL RETURNING id",
+				profile.Email,
+			).Scan(&userID)
+			if err != nil && err != sql.ErrNoRows {
+				return InternalUser{}, fmt.Errorf("failed to claim unverified user: %w", err)
+			}
+		}
+	}
+
+	if userID == "" {
+		user := InternalUser{ID: uuid.New().String(), TenantID: uuid.New().String(), Name: profile.Name, RegisteredAt: time.Now()}
+		if profile.EmailVerified {
+			user.Email = profile.Email
+		}
+		if _, err := tx.ExecContext(ctx,
+			"INSERT INTO users (id, tenant_id, email, name, registered_at, email_verified_at) VALUES ($1, $2, NULLIF($3, ''), $4, $5, CASE WHEN $3 <> '' THEN now() END)",
+			user.ID, user.TenantID, user.Email, user.Name, user.RegisteredAt,
+		); err != nil {
+			return InternalUser{}, fmt.Errorf("failed to create user: %w", err)
+		}
+		if err := createPersonalTenant(ctx, tx, user); err != nil {
+			return InternalUser{}, err
+		}
+		userID = user.ID
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO user_identities (provider, subject, user_id, email, display_name, last_login_at)
+		VALUES ($1, $2, $3, NULLIF($4, ''), $5, now())
+		ON CONFLICT (provider, subject) DO UPDATE SET email = EXCLUDED.email, display_name = EXCLUDED.display_name, last_login_at = now()`,
+		profile.Provider, profile.Subject, userID, profile.Email, profile.Name,
+	); err != nil {
+		return InternalUser{}, fmt.Errorf("failed to save identity: %w", err)
+	}
+
+	var user InternalUser
+	if err := tx.QueryRowContext(ctx,
+		"SELECT id, tenant_id, COALESCE(email, ''), COALESCE(name, ''), registered_at FROM users WHERE id = $1", userID,
+	).Scan(&user.ID, &user.TenantID, &user.Email, &user.Name, &user.RegisteredAt); err != nil {
+		return InternalUser{}, fmt.Errorf("failed to load user: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return InternalUser{}, fmt.Errorf("failed to commit identity: %w", err)
+	}
+	return user, nil
+}
+
+// finishOAuth hands the platform accounts the user can publish to (for Meta
+// their Pages, for Snapchat their Public Profiles) to the Account Service and
+// either signs them in (login) or returns to the app (connect). Only a connect
+// flow, which connectHandler admits for tenant admins, attaches the accounts
+// to a tenant; a login merely refreshes the tokens of accounts that are
+// already connected, wherever they are.
+func finishOAuth(w http.ResponseWriter, r *http.Request, callback oauthCallback, profile oauthProfile, accounts []UserSocialAccount) {
+	if callback.LinkUserID != "" && len(accounts) == 0 {
+		renderOAuthError(w, errNoPublishableAccounts)
+		return
+	}
+	user, err := resolveIdentity(r.Context(), profile, callback.LinkUserID)
+	if err != nil {
+		renderOAuthError(w, err)
+		return
+	}
+	for _, account := range accounts {
+		if callback.LinkTenantID != "" {
+			account.UserID = user.ID
+			account.TenantID = callback.LinkTenantID
+		}
+		if err := linkSocialAccount(account); err != nil {
+			renderOAuthError(w, err)
+			return
+		}
+	}
+	if callback.LinkUserID != "" {
+		http.Redirect(w, r, cfg.FrontendURL+"/?connected="+profile.Provider, http.StatusFound)
+		return
+	}
+	signInRedirect(w, r, user, amrFederated)
+}
+
+// linkSocialAccount hands the platform tokens to the Account Service's
+// internal API. An account without a TenantID only has its tokens refreshed.
+func linkSocialAccount(account UserSocialAccount) error {
+	jsonPayload, err := json.Marshal(account)
+	if err != nil {
+		return fmt.Errorf("failed to encode social account: %w", err)
+	}
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/internal/accounts", cfg.AccountServiceURL), bytes.NewBuffer(jsonPayload))
+	if err != nil {
+		return fmt.Errorf("failed to create account service request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(internalTokenHeader, cfg.InternalAPISecret)
+	resp, err := (&http.Client{Timeout: 30 * time.Second}).Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call account service: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusNoContent {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("account service returned status %d: %s", resp.StatusCode, body)
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// --- Mailer ---
+
+// Message is a plain-text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers transactional email.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// mailer is the Mailer used by the credential handlers.
+var mailer Mailer
+
+func initMailer() {
+	switch cfg.Mail.Mode {
+	case "smtp":
+		mailer = &smtpMailer{
+			addr:     net.JoinHostPort(cfg.Mail.SMTP.Host, strconv.Itoa(cfg.Mail.SMTP.Port)),
+			host:     cfg.Mail.SMTP.Host,
+			username: cfg.Mail.SMTP.Username,
+			password: cfg.Mail.SMTP.Password,
+			from:     cfg.Mail.From,
+		}
+	default:
+		log.Println("mail.mode is log: emails will be logged, not delivered.")
+		mailer = &logMailer{dir: cfg.Mail.Dir, from: cfg.Mail.From}
+	}
+}
+
+// headerLineBreaks removes line breaks, which would end a header field and let
+// the value add headers of its own or start the body.
+var headerLineBreaks = strings.NewReplacer("\r", "", "\n", "")
+
+// formatMessage renders msg as an RFC 5322 message. The subject may carry user
+// text such as tenant names, so it is MIME-encoded when it is not plain ASCII.
+func formatMessage(from string, msg Message) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", headerLineBreaks.Replace(from))
+	fmt.Fprintf(&b, "To: %s\r\n", headerLineBreaks.Replace(msg.To))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("UTF-8", headerLineBreaks.Replace(msg.Subject)))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return []byte(b.String())
+}
+
+// smtpMailer sends mail through an SMTP relay, using STARTTLS when offered.
+type smtpMailer struct {
+	addr     string
+	host     string
+	username string
+	password string
+	from     string
+}
+
+func (m *smtpMailer) Send(ctx context.Context, msg Message) error {
+	sender, err := mail.ParseAddress(m.from)
+	if err != nil {
+		return fmt.Errorf("invalid from address: %w", err)
+	}
+	var auth smtp.Auth
+	if m.username != "" {
+		auth = smtp.PlainAuth("", m.username, m.password, m.host)
+	}
+	if err := smtp.SendMail(m.addr, auth, sender.Address, []string{msg.To}, formatMessage(m.from, msg)); err != nil {
+		return fmt.Errorf("failed to send email: %w", err)
+	}
+	return nil
+}
+
+// logMailer logs every message and, if dir is set, also writes it there as an
+// .eml file. It is meant for local development and tests.
+type logMailer struct {
+	dir  string
+	from string
+
+	mu   sync.Mutex
+	sent []Message
+}
+
+func (m *logMailer) Send(ctx context.Context, msg Message) error {
+	m.mu.Lock()
+	m.sent = append(m.sent, msg)
+	m.mu.Unlock()
+	log.Printf("Email to %s: %s\n%s", msg.To, msg.Subject, msg.Body)
+	if m.dir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(m.dir, 0o700); err != nil {
+		return fmt.Errorf("failed to create mail directory: %w", err)
+	}
+	name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405.000000000Z"), strings.NewReplacer("@", "_at_", "/", "_").Replace(msg.To))
+	if err := os.WriteFile(filepath.Join(m.dir, name), formatMessage(m.from, msg), 0o600); err != nil {
+		return fmt.Errorf("failed to write email: %w", err)
+	}
+	return nil
+}
+
+// Sent returns a copy of every message sent so far.
+func (m *logMailer) Sent() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Message(nil), m.sent...)
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFormatMessageKeepsHeadersOnOneLine(t *testing.T) {
+	msg := formatMessage("Platform <noreply@example.com>", Message{
+		To:      "a@example.com\r\nBcc: victim@example.com",
+		Subject: "You're invited to Acme\r\nBcc: attacker@example.com\r\n\r\nFake body",
+		Body:    "Hi",
+	})
+	headers, body, ok := strings.Cut(string(msg), "\r\n\r\n")
+	if !ok {
+		t.Fatalf("message has no body:\n%s", msg)
+	}
+	for _, line := range strings.Split(headers, "\r\n") {
+		if strings.HasPrefix(line, "Bcc:") {
+			t.Errorf("injected header line %q", line)
+		}
+	}
+	if body != "Hi" {
+		t.Errorf("body = %q; want Hi", body)
+	}
+}
+
+func TestFormatMessageEncodesNonASCIISubject(t *testing.T) {
+	msg := string(formatMessage("noreply@example.com", Message{To: "a@example.com", Subject: "You're invited to Café", Body: "Hi"}))
+	if !strings.Contains(msg, "Subject: =?UTF-8?q?") {
+		t.Errorf("subject not MIME-encoded:\n%s", msg)
+	}
+}
@@ -1,11 +1,11 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
@@ -20,41 +20,13 @@ import (
 	_ "github.com/lib/pq"
 )
 
-// --- Configuration ---
-const (
-	// Replace with your actual Meta App ID and Secret obtained from Meta Developers.
-	META_CLIENT_ID     = "YOUR_META_APP_ID"
-	META_CLIENT_SECRET = "YOUR_META_APP_SECRET"
-	META_REDIRECT_URI  = "http://localhost:8081/oauth/meta/callback"
-	
-	// Replace with your actual TikTok App Key and Secret.
-	TIKTOK_CLIENT_KEY     = "YOUR_TIKTOK_CLIENT_KEY"
-	TIKTOK_CLIENT_SECRET  = "YOUR_TIKTOK_CLIENT_SECRET"
-	TIKTOK_REDIRECT_URI   = "http://localhost:8081/oauth/tiktok/callback"
-
-	// Replace with your actual Snapchat Client ID and Secret.
-	SNAPCHAT_CLIENT_ID     = "YOUR_SNAPCHAT_CLIENT_ID"
-	SNAPCHAT_CLIENT_SECRET = "YOUR_SNAPCHAT_CLIENT_SECRET"
-	SNAPCHAT_REDIRECT_URI  = "http://localhost:8081/oauth/snapchat/callback"
-	
-	// JWT Secret (generate a strong, random key in production)
-	JWT_SECRET = "supersecretjwtkeythatshouldbeverylongandrandom"
-	
-	// URL for the Account Service
-	ACCOUNT_SERVICE_URL = "http://localhost:8082"
-)
-
 // --- Database Connection ---
 var db *sql.DB
 
-// initDB connects to the PostgreSQL database and creates tables.
+// initDB connects to the PostgreSQL database.
 func initDB() {
-	connStr := os.Getenv("DATABASE_URL")
-	if connStr == "" {
-		log.Fatal("DATABASE_URL environment variable is not set")
-	}
 	var err error
-	db, err = sql.Open("postgres", connStr)
+	db, err = sql.Open("postgres", cfg.DatabaseURL)
 	if err != nil {
 		log.Fatalf("Failed to open database connection: %v", err)
 	}
@@ -62,23 +34,6 @@ func initDB() {
 		log.Fatalf("Failed to ping database: %v", err)
 	}
 	log.Println("Auth Service successfully connected to PostgreSQL!")
-	createTables()
-}
-
-// createTables runs SQL to set up the necessary database schema for the Auth service.
-func createTables() {
-	userTableSQL := `
-	CREATE TABLE IF NOT EXISTS users (
-		id TEXT PRIMARY KEY,
-		tenant_id TEXT NOT NULL,
-		email TEXT UNIQUE NOT NULL,
-		name TEXT,
-		registered_at TIMESTAMP WITH TIME ZONE
-	);`
-	if _, err := db.Exec(userTableSQL); err != nil {
-		log.Fatalf("Failed to create users table: %v", err)
-	}
-	log.Println("Auth Service tables created successfully.")
 }
 
 // --- Models ---
@@ -104,80 +59,74 @@ type UserSocialAccount struct {
 	ProfilePic     string    `json:"profilePic"`
 }
 
-// Claims for our JWT, including the TenantID.
+// Claims for our JWT, including the TenantID and the session that issued it.
 type Claims struct {
-	UserID   string `json:"user_id"`
-	TenantID string `json:"tenant_id"`
+	UserID    string `json:"user_id"`
+	TenantID  string `json:"tenant_id"`
+	SessionID string `json:"sid,omitempty"`
+	// Role is the user's role in the tenant: owner, admin, editor or viewer.
+	Role string `json:"role"`
+	// AMR lists how the session was authenticated (RFC 8176), e.g. ["pwd", "otp", "mfa"].
+	AMR []string `json:"amr,omitempty"`
 	jwt.RegisteredClaims
 }
 
-// --- Database Operations ---
-// saveInternalUser inserts or updates a user in the database.
-func saveInternalUser(user InternalUser) error {
-	_, err := db.Exec(
-		"INSERT INTO users (id, tenant_id, email, name, registered_at) VALUES ($1, $2, $3, $4, $5) ON CONFLICT (id) DO UPDATE SET tenant_id = $2, email = $3, name = $4",
-		user.ID, user.TenantID, user.Email, user.Name, user.RegisteredAt,
-	)
-	if err != nil {
-		return fmt.Errorf("failed to save user: %w", err)
-	}
-	return nil
-}
-
-// getInternalUserByEmail retrieves a user by email from the database.
-func getInternalUserByEmail(email string) (InternalUser, bool, error) {
-	var user InternalUser
-	row := db.QueryRow("SELECT id, tenant_id, email, name, registered_at FROM users WHERE email = $1", email)
-	err := row.Scan(&user.ID, &user.TenantID, &user.Email, &user.Name, &user.RegisteredAt)
-	if err == sql.ErrNoRows {
-		return user, false, nil
-	}
-	if err != nil {
-		return user, false, fmt.Errorf("failed to get user by email: %w", err)
-	}
-	return user, true, nil
-}
-
 // --- JWT Helper ---
-// generateJWT creates a new JWT with the UserID and TenantID claims.
-func generateJWT(userID, tenantID string) (string, error) {
-	expirationTime := time.Now().Add(24 * time.Hour)
+// generateJWT creates a short-lived access JWT with the UserID and TenantID
+// claims, signed with the active signing key. Its jti lets it be revoked.
+func generateJWT(userID, tenantID, sessionID, role string, amr []string) (accessToken, error) {
+	now := time.Now()
+	access := accessToken{JTI: uuid.New().String(), ExpiresAt: now.Add(cfg.Session.AccessTTL)}
 	claims := &Claims{
-		UserID:   userID,
-		TenantID: tenantID,
+		UserID:    userID,
+		TenantID:  tenantID,
+		SessionID: sessionID,
+		Role:      role,
+		AMR:       amr,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(expirationTime),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-			NotBefore: jwt.NewNumericDate(time.Now()),
+			ID:        access.JTI,
+			Issuer:    cfg.JWTIssuer,
+			ExpiresAt: jwt.NewNumericDate(access.ExpiresAt),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
 		},
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString([]byte(JWT_SECRET))
+	tokenString, err := signingKeys.sign(claims)
 	if err != nil {
-		return "", fmt.Errorf("could not sign token: %w", err)
+		return accessToken{}, fmt.Errorf("could not sign token: %w", err)
 	}
-	return tokenString, nil
+	access.Token = tokenString
+	return access, nil
 }
 
 // --- OAuth Handlers ---
 // handleMetaLogin redirects the user to the Meta authorization page.
 func handleMetaLogin(w http.ResponseWriter, r *http.Request) {
-	scope := "email,public_profile,pages_show_list,instagram_basic"
-	authURL := fmt.Sprintf(
-		"https://www.facebook.com/v19.0/dialog/oauth?client_id=%s&redirect_uri=%s&scope=%s&response_type=code",
-		META_CLIENT_ID, url.QueryEscape(META_REDIRECT_URI), url.QueryEscape(scope),
+	redirectToProvider(w, r, "meta")
+}
+
+// metaAuthorizeURL builds the Meta authorization URL.
+func metaAuthorizeURL(authz oauthAuthorization) string {
+	scope := "email,public_profile,pages_show_list,pages_manage_posts,pages_read_engagement,pages_read_user_content,pages_messaging,read_insights"
+	return fmt.Sprintf(
+		"https://www.facebook.com/v19.0/dialog/oauth?client_id=%s&redirect_uri=%s&scope=%s&response_type=code&state=%s&code_challenge=%s&code_challenge_method=S256",
+		cfg.Meta.ClientID, url.QueryEscape(cfg.Meta.RedirectURI), url.QueryEscape(scope), authz.State, authz.CodeChallenge,
 	)
-	http.Redirect(w, r, authURL, http.StatusFound)
 }
 
 // handleMetaCallback handles the redirect from Meta and completes the OAuth flow.
 func handleMetaCallback(w http.ResponseWriter, r *http.Request) {
+	callback, err := completeOAuth(w, r, "meta")
+	if err != nil {
+		renderOAuthError(w, err)
+		return
+	}
 	code := r.URL.Query().Get("code")
 	if code == "" {
 		http.Error(w, "Authorization code not found", http.StatusBadRequest)
 		return
 	}
-	tokenURL := fmt.Sprintf("https://graph.facebook.com/v19.0/oauth/access_token?client_id=%s&redirect_uri=%s&client_secret=%s&code=%s", META_CLIENT_ID, url.QueryEscape(META_REDIRECT_URI), META_CLIENT_SECRET, code)
+	tokenURL := fmt.Sprintf("https://graph.facebook.com/v19.0/oauth/access_token?client_id=%s&redirect_uri=%s&client_secret=%s&code=%s&code_verifier=%s", cfg.Meta.ClientID, url.QueryEscape(cfg.Meta.RedirectURI), cfg.Meta.ClientSecret, url.QueryEscape(code), callback.CodeVerifier)
 	tokenResp, err := http.Get(tokenURL)
 	if err != nil {
 		http.Error(w, "Failed to exchange token", http.StatusInternalServerError)
@@ -230,87 +179,151 @@ func handleMetaCallback(w http.ResponseWriter, r *http.Request) {
 	dataData, _ := pictureData["data"].(map[string]interface{})
 	profilePicURL, _ := dataData["url"].(string)
 
-	var currentUser InternalUser
-	user, found, err := getInternalUserByEmail(userEmail)
+	// Meta only returns email addresses its users have confirmed.
+	profile := oauthProfile{
+		Provider:      "meta",
+		Subject:       platformUserID,
+		Email:         userEmail,
+		EmailVerified: userEmail != "",
+		Name:          userName,
+		PictureURL:    profilePicURL,
+	}
+	userToken, expiresAt, err := exchangeMetaLongLivedToken(accessToken)
 	if err != nil {
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		log.Printf("Failed to exchange Meta token: %v", err)
+		http.Error(w, "Failed to exchange token", http.StatusInternalServerError)
 		return
 	}
-	if found {
-		currentUser = user
-	} else {
-		newTenantID := uuid.New().String()
-		currentUser = InternalUser{
-			ID:           platformUserID,
-			TenantID:     newTenantID,
-			Email:        userEmail,
-			Name:         userName,
-			RegisteredAt: time.Now(),
-		}
-		if err := saveInternalUser(currentUser); err != nil {
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
-			return
-		}
-	}
-	
-	linkedAccount := UserSocialAccount{
-		UserID:         currentUser.ID,
-		TenantID:       currentUser.TenantID,
-		Platform:       "Meta",
-		PlatformUserID: platformUserID,
-		AccessToken:    accessToken,
-		ExpiresAt:      time.Now().Add(60 * time.Days),
-		Username:       userName,
-		ProfilePic:     profilePicURL,
+	pages, err := fetchMetaPages(userToken, expiresAt)
+	if err != nil {
+		log.Printf("Failed to fetch Meta pages: %v", err)
+		http.Error(w, "Failed to fetch Facebook Pages", http.StatusInternalServerError)
+		return
 	}
+	finishOAuth(w, r, callback, profile, pages)
+}
 
-	jsonPayload, _ := json.Marshal(linkedAccount)
-	resp, err := http.Post(fmt.Sprintf("%s/accounts", ACCOUNT_SERVICE_URL), "application/json", bytes.NewBuffer(jsonPayload))
-	if err != nil || resp.StatusCode != http.StatusCreated {
-		log.Printf("Failed to call Account Service: %v", err)
-		http.Error(w, "Failed to link social account", http.StatusInternalServerError)
-		return
+// exchangeMetaLongLivedToken trades the short-lived token of the code exchange
+// for a long-lived one and returns it with its expiry, which is zero if Meta
+// does not report one. Page tokens obtained with a short-lived token expire
+// within hours.
+func exchangeMetaLongLivedToken(shortLived string) (string, time.Time, error) {
+	q := url.Values{}
+	q.Set("grant_type", "fb_exchange_token")
+	q.Set("client_id", cfg.Meta.ClientID)
+	q.Set("client_secret", cfg.Meta.ClientSecret)
+	q.Set("fb_exchange_token", shortLived)
+	req, err := http.NewRequest("GET", "https://graph.facebook.com/v19.0/oauth/access_token?"+q.Encode(), nil)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to create token exchange request: %w", err)
+	}
+	var body struct {
+		AccessToken string  `json:"access_token"`
+		ExpiresIn   float64 `json:"expires_in"`
+	}
+	if err := getProviderJSON(req, &body); err != nil {
+		return "", time.Time{}, err
+	}
+	if body.AccessToken == "" {
+		return "", time.Time{}, fmt.Errorf("token exchange returned no access token")
 	}
+	var expiresAt time.Time
+	if body.ExpiresIn > 0 {
+		expiresAt = time.Now().Add(time.Duration(body.ExpiresIn) * time.Second)
+	}
+	return body.AccessToken, expiresAt, nil
+}
 
-	jwtToken, err := generateJWT(currentUser.ID, currentUser.TenantID)
+// fetchMetaPages lists the Facebook Pages the user manages as social accounts.
+// Posts are published as a Page, so each account carries the Page's ID and
+// Page access token. The user token is kept as the refresh token because new
+// Page tokens are obtained with it; expiresAt is when it expires.
+func fetchMetaPages(userToken string, expiresAt time.Time) ([]UserSocialAccount, error) {
+	q := url.Values{}
+	q.Set("fields", "id,name,access_token,picture")
+	q.Set("limit", "100")
+	q.Set("access_token", userToken)
+	req, err := http.NewRequest("GET", "https://graph.facebook.com/v19.0/me/accounts?"+q.Encode(), nil)
 	if err != nil {
-		http.Error(w, "Failed to generate session token", http.StatusInternalServerError)
-		return
+		return nil, fmt.Errorf("failed to create pages request: %w", err)
+	}
+	var body struct {
+		Data []struct {
+			ID          string `json:"id"`
+			Name        string `json:"name"`
+			AccessToken string `json:"access_token"`
+			Picture     struct {
+				Data struct {
+					URL string `json:"url"`
+				} `json:"data"`
+			} `json:"picture"`
+		} `json:"data"`
+	}
+	if err := getProviderJSON(req, &body); err != nil {
+		return nil, err
+	}
+	var pages []UserSocialAccount
+	for _, page := range body.Data {
+		if page.AccessToken == "" {
+			// The user has no task on this Page that allows publishing.
+			continue
+		}
+		pages = append(pages, UserSocialAccount{
+			Platform:       "Meta",
+			PlatformUserID: page.ID,
+			AccessToken:    page.AccessToken,
+			RefreshToken:   userToken,
+			ExpiresAt:      expiresAt,
+			Username:       page.Name,
+			ProfilePic:     page.Picture.Data.URL,
+		})
 	}
-	http.Redirect(w, r, "http://localhost:3000/auth-success?token="+jwtToken, http.StatusFound)
+	return pages, nil
 }
 
 // handleTikTokLogin redirects the user to the TikTok authorization page.
 func handleTikTokLogin(w http.ResponseWriter, r *http.Request) {
+	redirectToProvider(w, r, "tiktok")
+}
+
+// tiktokAuthorizeURL builds the TikTok authorization URL. TikTok's web login
+// does not support PKCE, so only the state is sent.
+func tiktokAuthorizeURL(authz oauthAuthorization) string {
 	scope := "user.info.basic,video.list,video.upload"
-	authURL := fmt.Sprintf(
-		"https://www.tiktok.com/v2/auth/authorize?client_key=%s&redirect_uri=%s&scope=%s&response_type=code",
-		TIKTOK_CLIENT_KEY, url.QueryEscape(TIKTOK_REDIRECT_URI), url.QueryEscape(scope),
+	return fmt.Sprintf(
+		"https://www.tiktok.com/v2/auth/authorize?client_key=%s&redirect_uri=%s&scope=%s&response_type=code&state=%s",
+		cfg.TikTok.ClientID, url.QueryEscape(cfg.TikTok.RedirectURI), url.QueryEscape(scope), authz.State,
 	)
-	http.Redirect(w, r, authURL, http.StatusFound)
 }
 
 // handleTikTokCallback handles the redirect from TikTok and completes the OAuth flow.
 func handleTikTokCallback(w http.ResponseWriter, r *http.Request) {
+	callback, err := completeOAuth(w, r, "tiktok")
+	if err != nil {
+		renderOAuthError(w, err)
+		return
+	}
 	code := r.URL.Query().Get("code")
 	if code == "" {
 		http.Error(w, "Authorization code not found", http.StatusBadRequest)
 		return
 	}
 
-	tokenURL := "https://open-api.tiktok.com/oauth/access_token/"
-	payload := fmt.Sprintf(
-		`{"client_key": "%s", "client_secret": "%s", "code": "%s", "grant_type": "authorization_code"}`,
-		TIKTOK_CLIENT_KEY, TIKTOK_CLIENT_SECRET, code,
-	)
-	
-	req, err := http.NewRequest("POST", tokenURL, strings.NewReader(payload))
+	tokenURL := "https://open.tiktokapis.com/v2/oauth/token/"
+	data := url.Values{}
+	data.Set("client_key", cfg.TikTok.ClientID)
+	data.Set("client_secret", cfg.TikTok.ClientSecret)
+	data.Set("code", code)
+	data.Set("grant_type", "authorization_code")
+	data.Set("redirect_uri", cfg.TikTok.RedirectURI)
+
+	req, err := http.NewRequest("POST", tokenURL, strings.NewReader(data.Encode()))
 	if err != nil {
 		http.Error(w, "Failed to create token request", http.StatusInternalServerError)
 		return
 	}
-	req.Header.Set("Content-Type", "application/json")
-	
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
 	client := &http.Client{}
 	tokenResp, err := client.Do(req)
 	if err != nil {
@@ -335,76 +348,96 @@ func handleTikTokCallback(w http.ResponseWriter, r *http.Request) {
 	accessToken, _ := tokenData["access_token"].(string)
 	refreshToken, _ := tokenData["refresh_token"].(string)
 	expiresIn, _ := tokenData["expires_in"].(float64)
-	openID, _ := tokenData["open_id"].(string)
 	expiresAt := time.Now().Add(time.Duration(expiresIn) * time.Second)
-	
-	userName := "TikTokUser"
-	profilePicURL := "https://placehold.co/100x100/FF0050/FFFFFF?text=T"
-	
-	newTenantID := uuid.New().String()
-	currentUser := InternalUser{
-		ID: openID,
-		TenantID: newTenantID,
-		Email: openID + "@tiktok.com",
-		Name: "TikTok User",
-		RegisteredAt: time.Now(),
+	if accessToken == "" {
+		log.Printf("TikTok token exchange returned no access token: %v", tokenData)
+		http.Error(w, "Access token missing", http.StatusInternalServerError)
+		return
 	}
-	
-	linkedAccount := UserSocialAccount{
-		UserID:         currentUser.ID,
-		TenantID:       currentUser.TenantID,
+
+	profile, err := fetchTikTokProfile(accessToken)
+	if err != nil {
+		log.Printf("Failed to fetch TikTok profile: %v", err)
+		http.Error(w, "Failed to fetch user profile", http.StatusInternalServerError)
+		return
+	}
+	finishOAuth(w, r, callback, profile, []UserSocialAccount{{
 		Platform:       "TikTok",
-		PlatformUserID: openID,
+		PlatformUserID: profile.Subject,
 		AccessToken:    accessToken,
 		RefreshToken:   refreshToken,
 		ExpiresAt:      expiresAt,
-		Username:       userName,
-		ProfilePic:     profilePicURL,
-	}
+		Username:       profile.Name,
+		ProfilePic:     profile.PictureURL,
+	}})
+}
 
-	jsonPayload, _ := json.Marshal(linkedAccount)
-	resp, err := http.Post(fmt.Sprintf("%s/accounts", ACCOUNT_SERVICE_URL), "application/json", bytes.NewBuffer(jsonPayload))
-	if err != nil || resp.StatusCode != http.StatusCreated {
-		log.Printf("Failed to call Account Service: %v", err)
-		http.Error(w, "Failed to link social account", http.StatusInternalServerError)
-		return
-	}
-	
-	jwtToken, err := generateJWT(currentUser.ID, currentUser.TenantID)
+// fetchTikTokProfile reads the signed-in user's open_id, name and avatar.
+func fetchTikTokProfile(accessToken string) (oauthProfile, error) {
+	req, err := http.NewRequest("GET", "https://open.tiktokapis.com/v2/user/info/?fields=open_id,display_name,avatar_url", nil)
 	if err != nil {
-		http.Error(w, "Failed to generate session token", http.StatusInternalServerError)
-		return
-	}
-	
-	http.Redirect(w, r, "http://localhost:3000/auth-success?token="+jwtToken, http.StatusFound)
+		return oauthProfile{}, fmt.Errorf("failed to create profile request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	var body struct {
+		Data struct {
+			User struct {
+				OpenID      string `json:"open_id"`
+				DisplayName string `json:"display_name"`
+				AvatarURL   string `json:"avatar_url"`
+			} `json:"user"`
+		} `json:"data"`
+		Error struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := getProviderJSON(req, &body); err != nil {
+		return oauthProfile{}, err
+	}
+	if body.Error.Code != "" && body.Error.Code != "ok" {
+		return oauthProfile{}, fmt.Errorf("TikTok API error %s: %s", body.Error.Code, body.Error.Message)
+	}
+	user := body.Data.User
+	return oauthProfile{Provider: "tiktok", Subject: user.OpenID, Name: user.DisplayName, PictureURL: user.AvatarURL}, nil
 }
 
 // handleSnapchatLogin redirects the user to the Snapchat authorization page.
 func handleSnapchatLogin(w http.ResponseWriter, r *http.Request) {
-	scope := "snapchat-ads.manage,snapchat-creative-kit.creative-kit-token"
-	authURL := fmt.Sprintf(
-		"https://accounts.snapchat.com/login/oauth2/authorize?client_id=%s&redirect_uri=%s&scope=%s&response_type=code",
-		SNAPCHAT_CLIENT_ID, url.QueryEscape(SNAPCHAT_REDIRECT_URI), url.QueryEscape(scope),
+	redirectToProvider(w, r, "snapchat")
+}
+
+// snapchatAuthorizeURL builds the Snapchat authorization URL.
+func snapchatAuthorizeURL(authz oauthAuthorization) string {
+	scope := "snapchat-ads.manage,snapchat-profile-api"
+	return fmt.Sprintf(
+		"https://accounts.snapchat.com/login/oauth2/authorize?client_id=%s&redirect_uri=%s&scope=%s&response_type=code&state=%s&code_challenge=%s&code_challenge_method=S256",
+		cfg.Snapchat.ClientID, url.QueryEscape(cfg.Snapchat.RedirectURI), url.QueryEscape(scope), authz.State, authz.CodeChallenge,
 	)
-	http.Redirect(w, r, authURL, http.StatusFound)
 }
 
 // handleSnapchatCallback handles the redirect from Snapchat and completes the OAuth flow.
 func handleSnapchatCallback(w http.ResponseWriter, r *http.Request) {
+	callback, err := completeOAuth(w, r, "snapchat")
+	if err != nil {
+		renderOAuthError(w, err)
+		return
+	}
 	code := r.URL.Query().Get("code")
 	if code == "" {
 		http.Error(w, "Authorization code not found", http.StatusBadRequest)
 		return
 	}
-	
+
 	tokenURL := "https://accounts.snapchat.com/login/oauth2/access_token"
 	data := url.Values{}
 	data.Set("grant_type", "authorization_code")
 	data.Set("code", code)
-	data.Set("redirect_uri", SNAPCHAT_REDIRECT_URI)
-	data.Set("client_id", SNAPCHAT_CLIENT_ID)
-	data.Set("client_secret", SNAPCHAT_CLIENT_SECRET)
-	
+	data.Set("redirect_uri", cfg.Snapchat.RedirectURI)
+	data.Set("client_id", cfg.Snapchat.ClientID)
+	data.Set("client_secret", cfg.Snapchat.ClientSecret)
+	data.Set("code_verifier", callback.CodeVerifier)
+
 	req, err := http.NewRequest("POST", tokenURL, strings.NewReader(data.Encode()))
 	if err != nil {
 		http.Error(w, "Failed to create token request", http.StatusInternalServerError)
@@ -437,59 +470,132 @@ func handleSnapchatCallback(w http.ResponseWriter, r *http.Request) {
 	refreshToken, _ := tokenData["refresh_token"].(string)
 	expiresIn, _ := tokenData["expires_in"].(float64)
 	expiresAt := time.Now().Add(time.Duration(expiresIn) * time.Second)
-	
-	platformUserID := "SnapchatUser"
-	userName := "Snapchat User"
-	profilePicURL := "https://placehold.co/100x100/FFFC00/000000?text=S"
-	
-	newTenantID := uuid.New().String()
-	currentUser := InternalUser{
-		ID:           platformUserID,
-		TenantID:     newTenantID,
-		Email:        platformUserID + "@snapchat.com",
-		Name:         "Snapchat User",
-		RegisteredAt: time.Now(),
+	if accessToken == "" {
+		http.Error(w, "Access token missing", http.StatusInternalServerError)
+		return
 	}
-	
-	linkedAccount := UserSocialAccount{
-		UserID:         currentUser.ID,
-		TenantID:       currentUser.TenantID,
-		Platform:       "Snapchat",
-		PlatformUserID: platformUserID,
-		AccessToken:    accessToken,
-		RefreshToken:   refreshToken,
-		ExpiresAt:      expiresAt,
-		Username:       userName,
-		ProfilePic:     profilePicURL,
-	}
-	jsonPayload, _ := json.Marshal(linkedAccount)
-	resp, err := http.Post(fmt.Sprintf("%s/accounts", ACCOUNT_SERVICE_URL), "application/json", bytes.NewBuffer(jsonPayload))
-	if err != nil || resp.StatusCode != http.StatusCreated {
-		log.Printf("Failed to call Account Service: %v", err)
-		http.Error(w, "Failed to link social account", http.StatusInternalServerError)
+
+	profile, err := fetchSnapchatProfile(accessToken)
+	if err != nil {
+		log.Printf("Failed to fetch Snapchat profile: %v", err)
+		http.Error(w, "Failed to fetch user profile", http.StatusInternalServerError)
 		return
 	}
-	
-	jwtToken, err := generateJWT(currentUser.ID, currentUser.TenantID)
+	profiles, err := fetchSnapchatPublicProfiles(accessToken)
 	if err != nil {
-		http.Error(w, "Failed to generate session token", http.StatusInternalServerError)
+		log.Printf("Failed to fetch Snapchat public profiles: %v", err)
+		http.Error(w, "Failed to fetch Snapchat public profiles", http.StatusInternalServerError)
 		return
 	}
-	
-	http.Redirect(w, r, "http://localhost:3000/auth-success?token="+jwtToken, http.StatusFound)
+	for i := range profiles {
+		profiles[i].AccessToken = accessToken
+		profiles[i].RefreshToken = refreshToken
+		profiles[i].ExpiresAt = expiresAt
+	}
+	finishOAuth(w, r, callback, profile, profiles)
+}
+
+// fetchSnapchatPublicProfiles lists the Public Profiles the user manages as
+// social accounts. Stories are posted to a Public Profile, so each account
+// carries the profile ID rather than the Marketing API user ID.
+func fetchSnapchatPublicProfiles(accessToken string) ([]UserSocialAccount, error) {
+	req, err := http.NewRequest("GET", "https://businessapi.snapchat.com/v1/me/public_profiles", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create public profiles request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	var body struct {
+		PublicProfiles []struct {
+			PublicProfile struct {
+				ID          string `json:"id"`
+				DisplayName string `json:"display_name"`
+				LogoURL     string `json:"logo_url"`
+			} `json:"public_profile"`
+		} `json:"public_profiles"`
+	}
+	if err := getProviderJSON(req, &body); err != nil {
+		return nil, err
+	}
+	var profiles []UserSocialAccount
+	for _, p := range body.PublicProfiles {
+		profilePic := p.PublicProfile.LogoURL
+		if profilePic == "" {
+			profilePic = "https://placehold.co/100x100/FFFC00/000000?text=S"
+		}
+		profiles = append(profiles, UserSocialAccount{
+			Platform:       "Snapchat",
+			PlatformUserID: p.PublicProfile.ID,
+			Username:       p.PublicProfile.DisplayName,
+			ProfilePic:     profilePic,
+		})
+	}
+	return profiles, nil
+}
+
+// fetchSnapchatProfile reads the signed-in user from the Snapchat Marketing API.
+// Its email is the business contact address and is not treated as verified.
+func fetchSnapchatProfile(accessToken string) (oauthProfile, error) {
+	req, err := http.NewRequest("GET", "https://adsapi.snapchat.com/v1/me", nil)
+	if err != nil {
+		return oauthProfile{}, fmt.Errorf("failed to create profile request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	var body struct {
+		Me struct {
+			ID          string `json:"id"`
+			Email       string `json:"email"`
+			DisplayName string `json:"display_name"`
+		} `json:"me"`
+	}
+	if err := getProviderJSON(req, &body); err != nil {
+		return oauthProfile{}, err
+	}
+	return oauthProfile{Provider: "snapchat", Subject: body.Me.ID, Email: body.Me.Email, Name: body.Me.DisplayName}, nil
+}
+
+// getProviderJSON performs a request to a provider's API (a profile, token
+// exchange or account listing) and decodes the JSON response into out.
+func getProviderJSON(req *http.Request, out interface{}) error {
+	resp, err := (&http.Client{Timeout: 30 * time.Second}).Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call %s: %w", req.URL.Host, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("%s%s returned status %d: %s", req.URL.Host, req.URL.Path, resp.StatusCode, body)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to parse response of %s%s: %w", req.URL.Host, req.URL.Path, err)
+	}
+	return nil
 }
 
 // --- Main function ---
 
 func main() {
+	initConfig()
+	if len(os.Args) > 1 && os.Args[1] == "gen-signing-key" {
+		runGenSigningKeyCommand(os.Args[2:])
+		return
+	}
 	initDB()
 	defer db.Close()
 
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		runMigrateCommand(os.Args[2:])
+		return
+	}
+	autoMigrate()
+	initSigningKeys()
+	initMailer()
+	go pruneSessions(context.Background(), time.Hour)
+
 	router := mux.NewRouter()
 
 	router.Use(func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			w.Header().Set("Access-Control-Allow-Origin", "http://localhost:3000")
+			w.Header().Set("Access-Control-Allow-Origin", cfg.CORSAllowedOrigin)
 			w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE")
 			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Access-Control-Allow-Headers, Authorization, X-Requested-With")
 			w.Header().Set("Access-Control-Allow-Credentials", "true")
@@ -501,6 +607,39 @@ func main() {
 		})
 	})
 
+	router.HandleFunc("/.well-known/jwks.json", jwksHandler).Methods("GET")
+	router.HandleFunc("/auth/refresh", refreshHandler).Methods("POST")
+	router.HandleFunc("/auth/logout", logoutHandler).Methods("POST")
+	router.HandleFunc("/auth/register", registerHandler).Methods("POST")
+	router.HandleFunc("/auth/login", passwordLoginHandler).Methods("POST")
+	router.HandleFunc("/auth/verify-email", verifyEmailHandler).Methods("GET")
+	router.HandleFunc("/auth/verify-email/resend", resendVerificationHandler).Methods("POST")
+	router.HandleFunc("/auth/password/forgot", forgotPasswordHandler).Methods("POST")
+	router.HandleFunc("/auth/password/reset", resetPasswordHandler).Methods("POST")
+	router.HandleFunc("/auth/magic-link", magicLinkHandler).Methods("POST")
+	router.HandleFunc("/auth/magic-link/verify", magicLinkVerifyHandler).Methods("GET")
+	router.HandleFunc("/auth/mfa/verify", mfaVerifyHandler).Methods("POST")
+	router.Handle("/auth/mfa", authMiddleware(http.HandlerFunc(mfaStatusHandler))).Methods("GET")
+	router.Handle("/auth/mfa/totp", authMiddleware(http.HandlerFunc(totpEnrollHandler))).Methods("POST")
+	router.Handle("/auth/mfa/totp/confirm", authMiddleware(http.HandlerFunc(totpConfirmHandler))).Methods("POST")
+	router.Handle("/auth/mfa/totp", authMiddleware(requireMFA(http.HandlerFunc(totpDisableHandler)))).Methods("DELETE")
+	router.Handle("/auth/mfa/recovery-codes", authMiddleware(requireMFA(http.HandlerFunc(recoveryCodesHandler)))).Methods("POST")
+	router.Handle("/auth/tenants", authMiddleware(http.HandlerFunc(listTenantsHandler))).Methods("GET")
+	router.Handle("/auth/tenants", authMiddleware(http.HandlerFunc(createTenantHandler))).Methods("POST")
+	router.Handle("/auth/tenants/{tenantId}/switch", authMiddleware(http.HandlerFunc(switchTenantHandler))).Methods("POST")
+	router.Handle("/auth/tenants/{tenantId}/members", authMiddleware(http.HandlerFunc(listMembersHandler))).Methods("GET")
+	router.Handle("/auth/tenants/{tenantId}/members/{userId}", authMiddleware(http.HandlerFunc(updateMemberHandler))).Methods("PUT")
+	router.Handle("/auth/tenants/{tenantId}/members/{userId}", authMiddleware(http.HandlerFunc(removeMemberHandler))).Methods("DELETE")
+	router.Handle("/auth/tenants/{tenantId}/invitations", authMiddleware(http.HandlerFunc(listInvitationsHandler))).Methods("GET")
+	router.Handle("/auth/tenants/{tenantId}/invitations", authMiddleware(http.HandlerFunc(createInvitationHandler))).Methods("POST")
+	router.Handle("/auth/tenants/{tenantId}/invitations/{invitationId}", authMiddleware(http.HandlerFunc(revokeInvitationHandler))).Methods("DELETE")
+	router.Handle("/auth/invitations/accept", authMiddleware(http.HandlerFunc(acceptInvitationHandler))).Methods("POST")
+	router.Handle("/auth/connect/{provider}", authMiddleware(http.HandlerFunc(connectHandler))).Methods("POST", "OPTIONS")
+
+	internalRouter := router.PathPrefix("/internal").Subrouter()
+	internalRouter.Use(internalAuthMiddleware)
+	internalRouter.HandleFunc("/revoked-tokens", revokedTokensHandler).Methods("GET")
+
 	router.HandleFunc("/oauth/meta/login", handleMetaLogin).Methods("GET")
 	router.HandleFunc("/oauth/meta/callback", handleMetaCallback).Methods("GET")
 	router.HandleFunc("/oauth/tiktok/login", handleTikTokLogin).Methods("GET")
@@ -508,6 +647,6 @@ func main() {
 	router.HandleFunc("/oauth/snapchat/login", handleSnapchatLogin).Methods("GET")
 	router.HandleFunc("/oauth/snapchat/callback", handleSnapchatCallback).Methods("GET")
 	
-	log.Println("Auth Service is starting on port 8081...")
-	log.Fatal(http.ListenAndServe(":8081", router))
+	log.Printf("Auth Service is starting on port %d...", cfg.Port)
+	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", cfg.Port), router))
 }
@@ -0,0 +1,626 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"database/sql"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// --- Two-Factor Authentication ---
+//
+// Users may enroll a TOTP authenticator (RFC 6238: SHA-1, 6 digits, 30s steps).
+// Once enrolled, a first-factor login (password, magic link or OAuth) no longer
+// starts a session: it creates an mfa_challenges row, sets an HttpOnly
+// mfa_challenge cookie and sends the browser to the code prompt. Answering with
+// a TOTP or one-time recovery code starts the session. Invalid codes are
+// counted per user, so starting new challenges does not reset them, and too
+// many in a row lock the second factor for a growing time. The session's
+// authentication methods are carried in the access JWT's amr claim, which lets
+// the other services demand "mfa" for sensitive endpoints.
+
+// Authentication method references (RFC 8176) recorded in the amr claim.
+const (
+	amrPassword  = "pwd"
+	amrFederated = "fed"
+	amrEmailLink = "email"
+	amrOTP       = "otp"
+	amrMFA       = "mfa"
+)
+
+const (
+	totpIssuer  = "SMM Platform"
+	totpPeriod  = 30
+	totpDigits  = 6
+	totpSkew    = 1
+	totpKeySize = 20
+
+	recoveryCodeCount = 10
+
+	mfaChallengeCookie      = "mfa_challenge"
+	mfaChallengeTTL         = 5 * time.Minute
+	mfaChallengeMaxAttempts = 5
+
+	// A user may enter mfaFreeFailures invalid codes in a row, over any number
+	// of challenges; each further one locks the authenticator for twice as long
+	// as the one before, starting at mfaLockoutBase and up to mfaLockoutMax.
+	mfaFreeFailures = 5
+	mfaLockoutBase  = 30 * time.Second
+	mfaLockoutMax   = time.Hour
+)
+
+// mfaLockedError is returned while too many invalid codes lock a user's second
+// factor.
+type mfaLockedError struct {
+	Until time.Time
+}
+
+func (e *mfaLockedError) Error() string {
+	return fmt.Sprintf("too many invalid codes; locked until %s", e.Until.Format(time.RFC3339))
+}
+
+// mfaLockout returns how long the second factor is locked after failures
+// invalid codes in a row.
+func mfaLockout(failures int) time.Duration {
+	if failures < mfaFreeFailures {
+		return 0
+	}
+	lockout := mfaLockoutBase
+	for i := mfaFreeFailures; i < failures && lockout < mfaLockoutMax; i++ {
+		lockout *= 2
+	}
+	if lockout > mfaLockoutMax {
+		lockout = mfaLockoutMax
+	}
+	return lockout
+}
+
+var base32NoPadding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// --- TOTP ---
+
+func newTOTPSecret() (string, error) {
+	key := make([]byte, totpKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return "", fmt.Errorf("failed to generate TOTP secret: %w", err)
+	}
+	return base32NoPadding.EncodeToString(key), nil
+}
+
+// totpCode computes the code for time step counter (RFC 4226 section 5.3).
+func totpCode(key []byte, counter int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(counter))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+// verifyTOTP checks code against secret, allowing totpSkew steps of clock drift.
+// Steps at or before lastUsedStep are refused so a code cannot be replayed. It
+// returns the matched step.
+func verifyTOTP(secret, code string, lastUsedStep int64, now time.Time) (int64, bool) {
+	key, err := base32NoPadding.DecodeString(secret)
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= lastUsedStep {
+			continue
+		}
+		if hmac.Equal([]byte(totpCode(key, step)), []byte(code)) {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// totpProvisioningURI returns the otpauth:// URI that authenticator apps import,
+// usually by scanning it as a QR code.
+func totpProvisioningURI(secret, accountName string) string {
+	label := url.PathEscape(totpIssuer + ":" + accountName)
+	q := url.Values{
+		"secret":    {secret},
+		"issuer":    {totpIssuer},
+		"algorithm": {"SHA1"},
+		"digits":    {fmt.Sprint(totpDigits)},
+		"period":    {fmt.Sprint(totpPeriod)},
+	}
+	return "otpauth://totp/" + label + "?" + q.Encode()
+}
+
+// --- Recovery codes ---
+
+// newRecoveryCodes replaces userID's recovery codes and returns the new ones.
+// They are only ever shown once.
+func newRecoveryCodes(ctx context.Context, tx *sql.Tx, userID string) ([]string, error) {
+	if _, err := tx.ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_id = $1", userID); err != nil {
+		return nil, fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+	codes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		raw := make([]byte, 5)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, fmt.Errorf("failed to generate recovery code: %w", err)
+		}
+		code := strings.ToLower(base32NoPadding.EncodeToString(raw))
+		if _, err := tx.ExecContext(ctx,
+			"INSERT INTO recovery_codes (code_hash, user_id) VALUES ($1, $2)", hashToken(code), userID,
+		); err != nil {
+			return nil, fmt.Errorf("failed to store recovery code: %w", err)
+		}
+		codes = append(codes, code[:4]+"-"+code[4:])
+	}
+	return codes, nil
+}
+
+// normalizeRecoveryCode accepts codes typed with any case, spaces or dashes.
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
+
+// --- Second-factor checks ---
+
+// checkSecondFactor accepts either a current TOTP code or an unused recovery
+// code for userID, consuming it within tx. Invalid codes are counted against
+// the user, and while they lock the second factor it returns an
+// *mfaLockedError without looking at code. Callers commit tx either way.
+func checkSecondFactor(ctx context.Context, tx *sql.Tx, userID, code string) (bool, error) {
+	code = strings.TrimSpace(code)
+	var secret string
+	var lastUsedStep int64
+	var failures int
+	var lockedUntil sql.NullTime
+	err := tx.QueryRowContext(ctx,
+		"SELECT secret, last_used_step, failed_attempts, locked_until FROM user_totp WHERE user_id = $1 AND confirmed_at IS NOT NULL FOR UPDATE", userID,
+	).Scan(&secret, &lastUsedStep, &failures, &lockedUntil)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to load TOTP secret: %w", err)
+	}
+	now := time.Now()
+	if lockedUntil.Valid && lockedUntil.Time.After(now) {
+		return false, &mfaLockedError{Until: lockedUntil.Time}
+	}
+	if step, ok := verifyTOTP(secret, code, lastUsedStep, now); ok {
+		if _, err := tx.ExecContext(ctx,
+			"UPDATE user_totp SET last_used_step = $2, failed_attempts = 0, locked_until = NULL WHERE user_id = $1", userID, step,
+		); err != nil {
+			return false, fmt.Errorf("failed to record TOTP step: %w", err)
+		}
+		return true, nil
+	}
+	res, err := tx.ExecContext(ctx,
+		"UPDATE recovery_codes SET used_at = now() WHERE code_hash = $1 AND user_id = $2 AND used_at IS NULL",
+		hashToken(normalizeRecoveryCode(code)), userID,
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to redeem recovery code: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to redeem recovery code: %w", err)
+	}
+	if n == 1 {
+		log.Printf("User %s signed in with a recovery code", userID)
+		failures = 0
+	} else {
+		failures++
+	}
+	lockedUntil = sql.NullTime{}
+	if lockout := mfaLockout(failures); lockout > 0 {
+		lockedUntil = sql.NullTime{Time: now.Add(lockout), Valid: true}
+		log.Printf("Locked the second factor of user %s for %s after %d invalid codes", userID, lockout, failures)
+	}
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE user_totp SET failed_attempts = $2, locked_until = $3 WHERE user_id = $1", userID, failures, lockedUntil,
+	); err != nil {
+		return false, fmt.Errorf("failed to count invalid code: %w", err)
+	}
+	return n == 1, nil
+}
+
+// writeMFAError answers a request whose second-factor check failed with err.
+func writeMFAError(w http.ResponseWriter, action string, err error) {
+	var locked *mfaLockedError
+	if errors.As(err, &locked) {
+		retryAfter := int(time.Until(locked.Until)/time.Second) + 1
+		w.Header().Set("Retry-After", fmt.Sprint(retryAfter))
+		http.Error(w, fmt.Sprintf("Too many invalid codes; try again in %d seconds", retryAfter), http.StatusTooManyRequests)
+		return
+	}
+	log.Printf("%s failed: %v", action, err)
+	http.Error(w, "Internal server error", http.StatusInternalServerError)
+}
+
+// mfaEnabled reports whether userID has a confirmed authenticator.
+func mfaEnabled(ctx context.Context, userID string) (bool, error) {
+	var enabled bool
+	err := db.QueryRowContext(ctx,
+		"SELECT EXISTS (SELECT 1 FROM user_totp WHERE user_id = $1 AND confirmed_at IS NOT NULL)", userID,
+	).Scan(&enabled)
+	if err != nil {
+		return false, fmt.Errorf("failed to check two-factor enrollment: %w", err)
+	}
+	return enabled, nil
+}
+
+// signIn completes a first-factor login with method. Users without two-factor
+// authentication get a session straight away; for the others it starts an MFA
+// challenge and returns true, and the caller must send them to the code prompt.
+func signIn(w http.ResponseWriter, r *http.Request, user InternalUser, method string) (bool, error) {
+	enabled, err := mfaEnabled(r.Context(), user.ID)
+	if err != nil {
+		return false, err
+	}
+	if !enabled {
+		return false, startSession(w, r, user, []string{method})
+	}
+	token, err := newOpaqueToken()
+	if err != nil {
+		return false, err
+	}
+	if _, err := db.ExecContext(r.Context(),
+		"INSERT INTO mfa_challenges (token_hash, user_id, amr, expires_at) VALUES ($1, $2, $3, $4)",
+		hashToken(token), user.ID, pq.Array([]string{method}), time.Now().Add(mfaChallengeTTL),
+	); err != nil {
+		return false, fmt.Errorf("failed to store MFA challenge: %w", err)
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     mfaChallengeCookie,
+		Value:    token,
+		Path:     refreshCookiePath,
+		MaxAge:   int(mfaChallengeTTL / time.Second),
+		HttpOnly: true,
+		Secure:   cfg.Session.CookieSecure,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return true, nil
+}
+
+// signInRedirect finishes a browser-redirect login (OAuth or magic link).
+func signInRedirect(w http.ResponseWriter, r *http.Request, user InternalUser, method string) {
+	pending, err := signIn(w, r, user, method)
+	if err != nil {
+		renderOAuthError(w, err)
+		return
+	}
+	if pending {
+		http.Redirect(w, r, cfg.FrontendURL+"/login?mfa=required", http.StatusFound)
+		return
+	}
+	http.Redirect(w, r, cfg.FrontendURL+"/auth-success", http.StatusFound)
+}
+
+func clearMFAChallengeCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{Name: mfaChallengeCookie, Path: refreshCookiePath, MaxAge: -1, HttpOnly: true, Secure: cfg.Session.CookieSecure, SameSite: http.SameSiteLaxMode})
+}
+
+// hasAMR reports whether the request's access token was issued with method.
+func hasAMR(ctx context.Context, method string) bool {
+	amr, _ := ctx.Value(amrKey).([]string)
+	for _, m := range amr {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// requireMFA only admits access tokens from sessions that passed a second factor.
+func requireMFA(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !hasAMR(r.Context(), amrMFA) {
+			http.Error(w, "Two-factor authentication required", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// --- MFA Handlers ---
+
+// mfaVerifyHandler handles POST /auth/mfa/verify, the second step of a login.
+func mfaVerifyHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Code string `json:"code"`
+	}
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	cookie, err := r.Cookie(mfaChallengeCookie)
+	if err != nil || cookie.Value == "" {
+		http.Error(w, "No sign-in is waiting for a code; please sign in again", http.StatusUnauthorized)
+		return
+	}
+	ctx := r.Context()
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("MFA verification failed: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var userID string
+	var amr []string
+	var attempts int
+	err = tx.QueryRowContext(ctx,
+		"SELECT user_id, amr, attempts FROM mfa_challenges WHERE token_hash = $1 AND expires_at > now() FOR UPDATE",
+		hashToken(cookie.Value),
+	).Scan(&userID, pq.Array(&amr), &attempts)
+	if err == sql.ErrNoRows || (err == nil && attempts >= mfaChallengeMaxAttempts) {
+		clearMFAChallengeCookie(w)
+		http.Error(w, "The sign-in has expired; please sign in again", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		log.Printf("MFA verification failed: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	ok, err := checkSecondFactor(ctx, tx, userID, req.Code)
+	if err != nil {
+		writeMFAError(w, "MFA verification", err)
+		return
+	}
+	if !ok {
+		if _, err := tx.ExecContext(ctx, "UPDATE mfa_challenges SET attempts = attempts + 1 WHERE token_hash = $1", hashToken(cookie.Value)); err != nil {
+			log.Printf("Failed to count MFA attempt: %v", err)
+		} else if err := tx.Commit(); err != nil {
+			log.Printf("Failed to count MFA attempt: %v", err)
+		}
+		http.Error(w, "Invalid code", http.StatusUnauthorized)
+		return
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM mfa_challenges WHERE token_hash = $1", hashToken(cookie.Value)); err != nil {
+		log.Printf("MFA verification failed: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	user, err := getUserByID(ctx, tx, userID)
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err == nil {
+		clearMFAChallengeCookie(w)
+		err = startSession(w, r, user, append(amr, amrOTP, amrMFA))
+	}
+	if err != nil {
+		log.Printf("MFA verification failed: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// mfaStatusHandler handles GET /auth/mfa.
+func mfaStatusHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := r.Context().Value(userIDKey).(string)
+	var enabled bool
+	var remaining int
+	err := db.QueryRowContext(r.Context(), `
+		SELECT EXISTS (SELECT 1 FROM user_totp WHERE user_id = $1 AND confirmed_at IS NOT NULL),
+			(SELECT count(*) FROM recovery_codes WHERE user_id = $1 AND used_at IS NULL)`, userID,
+	).Scan(&enabled, &remaining)
+	if err != nil {
+		log.Printf("Failed to load two-factor status: %v", err)
+		http.Error(w, "Failed to load two-factor status", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"totpEnabled":            enabled,
+		"recoveryCodesRemaining": remaining,
+		"sessionVerified":        hasAMR(r.Context(), amrMFA),
+	})
+}
+
+// totpEnrollHandler handles POST /auth/mfa/totp. It creates a pending
+// authenticator secret, replacing any earlier unconfirmed one.
+func totpEnrollHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, _ := ctx.Value(userIDKey).(string)
+	secret, err := newTOTPSecret()
+	if err != nil {
+		log.Printf("TOTP enrollment failed: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	res, err := db.ExecContext(ctx, `
+		INSERT INTO user_totp (user_id, secret) VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET secret = EXCLUDED.secret, created_at = now(), last_used_step = 0
+		WHERE user_totp.confirmed_at IS NULL`, userID, secret)
+	var n int64
+	if err == nil {
+		n, err = res.RowsAffected()
+	}
+	if err != nil {
+		log.Printf("TOTP enrollment failed: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if n == 0 {
+		http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+		return
+	}
+	var accountName string
+	if err := db.QueryRowContext(ctx, "SELECT COALESCE(email, name, id) FROM users WHERE id = $1", userID).Scan(&accountName); err != nil {
+		log.Printf("TOTP enrollment failed: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]string{
+		"secret":          secret,
+		"provisioningUri": totpProvisioningURI(secret, accountName),
+	})
+}
+
+// totpConfirmHandler handles POST /auth/mfa/totp/confirm. A correct code from
+// the new authenticator enables it, returns fresh recovery codes and marks the
+// current session as having passed two factors.
+func totpConfirmHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Code string `json:"code"`
+	}
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	ctx := r.Context()
+	userID, _ := ctx.Value(userIDKey).(string)
+	sessionID, _ := ctx.Value(sessionIDKey).(string)
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("TOTP confirmation failed: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var secret string
+	err = tx.QueryRowContext(ctx,
+		"SELECT secret FROM user_totp WHERE user_id = $1 AND confirmed_at IS NULL FOR UPDATE", userID,
+	).Scan(&secret)
+	if err == sql.ErrNoRows {
+		http.Error(w, "No two-factor enrollment is pending", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("TOTP confirmation failed: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	step, ok := verifyTOTP(secret, strings.TrimSpace(req.Code), 0, time.Now())
+	if !ok {
+		http.Error(w, "Invalid code", http.StatusBadRequest)
+		return
+	}
+	_, err = tx.ExecContext(ctx, "UPDATE user_totp SET confirmed_at = now(), last_used_step = $2 WHERE user_id = $1", userID, step)
+	var codes []string
+	if err == nil {
+		codes, err = newRecoveryCodes(ctx, tx, userID)
+	}
+	if err == nil {
+		_, err = tx.ExecContext(ctx,
+			"UPDATE sessions SET amr = ARRAY(SELECT DISTINCT unnest(amr || $2::text[])) WHERE id = $1",
+			sessionID, pq.Array([]string{amrOTP, amrMFA}))
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Printf("TOTP confirmation failed: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]interface{}{"recoveryCodes": codes})
+}
+
+// totpDisableHandler handles DELETE /auth/mfa/totp. It removes the
+// authenticator and the recovery codes. The body must carry a fresh code, an
+// authenticator or a recovery code, so a stolen MFA session alone cannot turn
+// two-factor authentication off.
+func totpDisableHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Code string `json:"code"`
+	}
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	ctx := r.Context()
+	userID, _ := ctx.Value(userIDKey).(string)
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("Failed to disable two-factor authentication: %v", err)
+		http.Error(w, "Failed to disable two-factor authentication", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+	ok, err := checkSecondFactor(ctx, tx, userID, req.Code)
+	if err != nil {
+		writeMFAError(w, "Disabling two-factor authentication", err)
+		return
+	}
+	if !ok {
+		if err := tx.Commit(); err != nil {
+			log.Printf("Failed to count invalid code: %v", err)
+		}
+		http.Error(w, "Invalid code", http.StatusBadRequest)
+		return
+	}
+	_, err = tx.ExecContext(ctx, "DELETE FROM user_totp WHERE user_id = $1", userID)
+	if err == nil {
+		_, err = tx.ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_id = $1", userID)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Printf("Failed to disable two-factor authentication: %v", err)
+		http.Error(w, "Failed to disable two-factor authentication", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("User %s disabled two-factor authentication", userID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// recoveryCodesHandler handles POST /auth/mfa/recovery-codes, replacing every
+// recovery code of the user.
+func recoveryCodesHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, _ := ctx.Value(userIDKey).(string)
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("Failed to regenerate recovery codes: %v", err)
+		http.Error(w, "Failed to regenerate recovery codes", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+	var confirmed bool
+	err = tx.QueryRowContext(ctx,
+		"SELECT confirmed_at IS NOT NULL FROM user_totp WHERE user_id = $1 FOR UPDATE", userID,
+	).Scan(&confirmed)
+	if err == sql.ErrNoRows || (err == nil && !confirmed) {
+		http.Error(w, "Two-factor authentication is not enabled", http.StatusConflict)
+		return
+	}
+	var codes []string
+	if err == nil {
+		codes, err = newRecoveryCodes(ctx, tx, userID)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Printf("Failed to regenerate recovery codes: %v", err)
+		http.Error(w, "Failed to regenerate recovery codes", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]interface{}{"recoveryCodes": codes})
+}
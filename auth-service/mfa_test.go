@@ -0,0 +1,26 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMFALockout(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{mfaFreeFailures - 1, 0},
+		{mfaFreeFailures, 30 * time.Second},
+		{mfaFreeFailures + 1, time.Minute},
+		{mfaFreeFailures + 3, 4 * time.Minute},
+		{mfaFreeFailures + 7, time.Hour},
+		{1000, time.Hour},
+	}
+	for _, tt := range tests {
+		if got := mfaLockout(tt.failures); got != tt.want {
+			t.Errorf("mfaLockout(%d) = %s; want %s", tt.failures, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS users (
+	id TEXT PRIMARY KEY,
+	tenant_id TEXT NOT NULL,
+	email TEXT UNIQUE NOT NULL,
+	name TEXT,
+	registered_at TIMESTAMP WITH TIME ZONE
+);
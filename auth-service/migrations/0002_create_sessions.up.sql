@@ -0,0 +1,32 @@
+-- A session is one login; its refresh tokens form a rotation family.
+CREATE TABLE IF NOT EXISTS sessions (
+	id TEXT PRIMARY KEY,
+	user_id TEXT NOT NULL,
+	tenant_id TEXT NOT NULL,
+	user_agent TEXT,
+	ip_address TEXT,
+	created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+	last_refreshed_at TIMESTAMP WITH TIME ZONE,
+	revoked_at TIMESTAMP WITH TIME ZONE,
+	revoke_reason TEXT
+);
+CREATE INDEX IF NOT EXISTS sessions_user_idx ON sessions (user_id);
+
+-- Refresh tokens are stored as SHA-256 hashes and are single use.
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+	token_hash TEXT PRIMARY KEY,
+	session_id TEXT NOT NULL REFERENCES sessions (id) ON DELETE CASCADE,
+	created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+	expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+	used_at TIMESTAMP WITH TIME ZONE
+);
+CREATE INDEX IF NOT EXISTS refresh_tokens_session_idx ON refresh_tokens (session_id);
+
+-- Every issued access token, so revoking a session can deny its live JWTs by jti.
+CREATE TABLE IF NOT EXISTS access_tokens (
+	jti TEXT PRIMARY KEY,
+	session_id TEXT NOT NULL REFERENCES sessions (id) ON DELETE CASCADE,
+	expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+	revoked_at TIMESTAMP WITH TIME ZONE
+);
+CREATE INDEX IF NOT EXISTS access_tokens_revoked_idx ON access_tokens (expires_at) WHERE revoked_at IS NOT NULL;
@@ -0,0 +1,9 @@
+-- Pending OAuth authorizations. Rows are deleted when their callback arrives,
+-- which makes each state value single use.
+CREATE TABLE IF NOT EXISTS oauth_states (
+	state_hash TEXT PRIMARY KEY,
+	provider TEXT NOT NULL,
+	code_verifier TEXT NOT NULL,
+	created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+	expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
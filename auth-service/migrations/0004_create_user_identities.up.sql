@@ -0,0 +1,30 @@
+-- A user can sign in with several provider identities; each (provider, subject)
+-- pair belongs to exactly one user.
+CREATE TABLE IF NOT EXISTS user_identities (
+	provider TEXT NOT NULL,
+	subject TEXT NOT NULL,
+	user_id TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	email TEXT,
+	display_name TEXT,
+	created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+	last_login_at TIMESTAMP WITH TIME ZONE,
+	PRIMARY KEY (provider, subject)
+);
+CREATE INDEX IF NOT EXISTS user_identities_user_idx ON user_identities (user_id);
+
+-- Users used to be keyed by their Meta user ID and carried synthetic emails for
+-- TikTok and Snapchat. Record those IDs as identities and drop the fake emails.
+INSERT INTO user_identities (provider, subject, user_id, email, display_name)
+SELECT CASE
+		WHEN email LIKE '%@tiktok.com' THEN 'tiktok'
+		WHEN email LIKE '%@snapchat.com' THEN 'snapchat'
+		ELSE 'meta'
+	END, id, id, NULLIF(email, ''), name
+FROM users
+ON CONFLICT DO NOTHING;
+
+ALTER TABLE users ALTER COLUMN email DROP NOT NULL;
+UPDATE users SET email = NULL WHERE email = '' OR email LIKE '%@tiktok.com' OR email LIKE '%@snapchat.com';
+
+-- OAuth flows started by a signed-in user attach the identity to that user.
+ALTER TABLE oauth_states ADD COLUMN IF NOT EXISTS link_user_id TEXT;
@@ -0,0 +1,17 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS password_hash TEXT;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified_at TIMESTAMP WITH TIME ZONE;
+
+-- Every email stored so far came from Meta, which only returns confirmed addresses.
+UPDATE users SET email = lower(email), email_verified_at = COALESCE(registered_at, now())
+WHERE email IS NOT NULL AND email_verified_at IS NULL;
+
+-- Single-use tokens sent by email: verify_email, reset_password and magic_link.
+CREATE TABLE IF NOT EXISTS auth_tokens (
+	token_hash TEXT PRIMARY KEY,
+	purpose TEXT NOT NULL,
+	user_id TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+	expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+	used_at TIMESTAMP WITH TIME ZONE
+);
+CREATE INDEX IF NOT EXISTS auth_tokens_user_idx ON auth_tokens (user_id, purpose);
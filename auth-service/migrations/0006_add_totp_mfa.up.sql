@@ -0,0 +1,31 @@
+-- Authentication methods (RFC 8176 "amr" values) each session was established with.
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS amr TEXT[] NOT NULL DEFAULT '{}';
+
+-- A user's TOTP authenticator. Enrollment is pending until confirmed_at is set.
+-- last_used_step stops a code from being accepted twice.
+CREATE TABLE IF NOT EXISTS user_totp (
+	user_id TEXT PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+	secret TEXT NOT NULL,
+	created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+	confirmed_at TIMESTAMP WITH TIME ZONE,
+	last_used_step BIGINT NOT NULL DEFAULT 0
+);
+
+-- One-time recovery codes, stored as SHA-256 hashes.
+CREATE TABLE IF NOT EXISTS recovery_codes (
+	code_hash TEXT PRIMARY KEY,
+	user_id TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+	used_at TIMESTAMP WITH TIME ZONE
+);
+CREATE INDEX IF NOT EXISTS recovery_codes_user_idx ON recovery_codes (user_id);
+
+-- Logins that passed their first factor and wait for a TOTP or recovery code.
+CREATE TABLE IF NOT EXISTS mfa_challenges (
+	token_hash TEXT PRIMARY KEY,
+	user_id TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	amr TEXT[] NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+	expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
@@ -0,0 +1,4 @@
+ALTER TABLE oauth_states DROP COLUMN IF EXISTS link_tenant_id;
+DROP TABLE IF EXISTS tenant_invitations;
+DROP TABLE IF EXISTS tenant_members;
+DROP TABLE IF EXISTS tenants;
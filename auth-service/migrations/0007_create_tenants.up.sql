@@ -0,0 +1,47 @@
+-- A tenant is a workspace shared by its members. users.tenant_id is the tenant
+-- a user signs in to by default and always names one of their memberships.
+CREATE TABLE IF NOT EXISTS tenants (
+	id TEXT PRIMARY KEY,
+	name TEXT NOT NULL,
+	created_by TEXT REFERENCES users (id) ON DELETE SET NULL,
+	created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS tenant_members (
+	tenant_id TEXT NOT NULL REFERENCES tenants (id) ON DELETE CASCADE,
+	user_id TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	role TEXT NOT NULL CHECK (role IN ('owner', 'admin', 'editor', 'viewer')),
+	created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+	PRIMARY KEY (tenant_id, user_id)
+);
+CREATE INDEX IF NOT EXISTS tenant_members_user_idx ON tenant_members (user_id);
+
+-- Pending invitations. The emailed token is stored as a SHA-256 hash.
+CREATE TABLE IF NOT EXISTS tenant_invitations (
+	id TEXT PRIMARY KEY,
+	token_hash TEXT UNIQUE NOT NULL,
+	tenant_id TEXT NOT NULL REFERENCES tenants (id) ON DELETE CASCADE,
+	email TEXT NOT NULL,
+	role TEXT NOT NULL CHECK (role IN ('owner', 'admin', 'editor', 'viewer')),
+	invited_by TEXT REFERENCES users (id) ON DELETE SET NULL,
+	created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+	expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+	accepted_by TEXT REFERENCES users (id) ON DELETE SET NULL,
+	accepted_at TIMESTAMP WITH TIME ZONE,
+	revoked_at TIMESTAMP WITH TIME ZONE
+);
+CREATE INDEX IF NOT EXISTS tenant_invitations_tenant_idx ON tenant_invitations (tenant_id);
+
+-- Until now every user had a tenant of their own; make each an owned tenant.
+INSERT INTO tenants (id, name, created_by, created_at)
+SELECT DISTINCT ON (tenant_id) tenant_id, COALESCE(NULLIF(name, '') || '''s workspace', 'Personal workspace'), id, COALESCE(registered_at, now())
+FROM users
+ORDER BY tenant_id, registered_at
+ON CONFLICT DO NOTHING;
+
+INSERT INTO tenant_members (tenant_id, user_id, role, created_at)
+SELECT tenant_id, id, 'owner', COALESCE(registered_at, now()) FROM users
+ON CONFLICT DO NOTHING;
+
+-- Connect flows attach the new account to the tenant the user is working in.
+ALTER TABLE oauth_states ADD COLUMN IF NOT EXISTS link_tenant_id TEXT;
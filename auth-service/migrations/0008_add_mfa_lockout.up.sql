@@ -0,0 +1,4 @@
+-- Invalid second-factor codes a user entered in a row, across MFA challenges.
+-- Past a few of them the authenticator is locked until locked_until.
+ALTER TABLE user_totp ADD COLUMN IF NOT EXISTS failed_attempts INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE user_totp ADD COLUMN IF NOT EXISTS locked_until TIMESTAMP WITH TIME ZONE;
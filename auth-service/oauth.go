@@ -0,0 +1,210 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// --- OAuth State and PKCE ---
+//
+// Every login redirect carries a random state value. Its hash is stored in
+// oauth_states together with the PKCE code verifier, and the raw value is set in
+// an HttpOnly cookie. The callback must present the same state in the query and
+// the cookie (so a code started in another browser is refused) and consumes the
+// row (so it cannot be replayed). States expire after oauthStateTTL.
+//
+// A flow started through /auth/connect/{provider} also records the signed-in
+// user and their current tenant, so the callback attaches the new identity to
+// them, and the account to that tenant, instead of logging in.
+
+const (
+	oauthStateCookie = "oauth_state"
+	oauthStateTTL    = 10 * time.Minute
+)
+
+var (
+	errOAuthDenied        = errors.New("the authorization request was declined")
+	errOAuthStateMismatch = errors.New("the login response does not match a login started in this browser")
+	errOAuthStateInvalid  = errors.New("the login link has already been used or is unknown")
+	errOAuthStateExpired  = errors.New("the login link has expired")
+)
+
+// oauthAuthorization holds the per-request values to add to an authorize URL.
+type oauthAuthorization struct {
+	State         string
+	CodeChallenge string
+}
+
+// oauthCallback is what a validated callback carries over from beginOAuth.
+type oauthCallback struct {
+	CodeVerifier string
+	LinkUserID   string
+	LinkTenantID string
+}
+
+// oauthAuthorizeURLs builds each provider's authorization URL.
+var oauthAuthorizeURLs = map[string]func(oauthAuthorization) string{
+	"meta":     metaAuthorizeURL,
+	"tiktok":   tiktokAuthorizeURL,
+	"snapchat": snapchatAuthorizeURL,
+}
+
+// beginOAuth records a new authorization for provider and sets the state cookie.
+// linkUserID and linkTenantID are the signed-in user and their tenant for
+// connect flows, or empty for logins.
+func beginOAuth(w http.ResponseWriter, r *http.Request, provider, linkUserID, linkTenantID string) (oauthAuthorization, error) {
+	state, err := newOpaqueToken()
+	if err != nil {
+		return oauthAuthorization{}, err
+	}
+	verifier, err := newOpaqueToken()
+	if err != nil {
+		return oauthAuthorization{}, err
+	}
+	if _, err := db.ExecContext(r.Context(),
+		"INSERT INTO oauth_states (state_hash, provider, code_verifier, link_user_id, link_tenant_id, expires_at) VALUES ($1, $2, $3, NULLIF($4, ''), NULLIF($5, ''), $6)",
+		hashToken(state), provider, verifier, linkUserID, linkTenantID, time.Now().Add(oauthStateTTL),
+	); err != nil {
+		return oauthAuthorization{}, fmt.Errorf("failed to store OAuth state: %w", err)
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     oauthStateCookie,
+		Value:    state,
+		Path:     "/oauth",
+		MaxAge:   int(oauthStateTTL / time.Second),
+		HttpOnly: true,
+		Secure:   cfg.Session.CookieSecure,
+		// Lax so the cookie accompanies the top-level redirect back from the provider.
+		SameSite: http.SameSiteLaxMode,
+	})
+	challenge := sha256.Sum256([]byte(verifier))
+	return oauthAuthorization{State: state, CodeChallenge: base64.RawURLEncoding.EncodeToString(challenge[:])}, nil
+}
+
+// completeOAuth validates the callback's state against the cookie and consumes
+// it, returning the PKCE code verifier and, for connect flows, the user.
+func completeOAuth(w http.ResponseWriter, r *http.Request, provider string) (oauthCallback, error) {
+	query := r.URL.Query()
+	if query.Get("error") != "" {
+		log.Printf("%s authorization failed: %s %s", provider, query.Get("error"), query.Get("error_description"))
+		return oauthCallback{}, errOAuthDenied
+	}
+	state := query.Get("state")
+	cookie, err := r.Cookie(oauthStateCookie)
+	if state == "" || err != nil || subtle.ConstantTimeCompare([]byte(state), []byte(cookie.Value)) != 1 {
+		return oauthCallback{}, errOAuthStateMismatch
+	}
+	http.SetCookie(w, &http.Cookie{Name: oauthStateCookie, Path: "/oauth", MaxAge: -1, HttpOnly: true, Secure: cfg.Session.CookieSecure, SameSite: http.SameSiteLaxMode})
+
+	var callback oauthCallback
+	var linkUserID, linkTenantID sql.NullString
+	var expiresAt time.Time
+	err = db.QueryRowContext(r.Context(),
+		"DELETE FROM oauth_states WHERE state_hash = $1 AND provider = $2 RETURNING code_verifier, link_user_id, link_tenant_id, expires_at",
+		hashToken(state), provider,
+	).Scan(&callback.CodeVerifier, &linkUserID, &linkTenantID, &expiresAt)
+	if err == sql.ErrNoRows {
+		return oauthCallback{}, errOAuthStateInvalid
+	}
+	if err != nil {
+		return oauthCallback{}, fmt.Errorf("failed to consume OAuth state: %w", err)
+	}
+	if time.Now().After(expiresAt) {
+		return oauthCallback{}, errOAuthStateExpired
+	}
+	callback.LinkUserID = linkUserID.String
+	callback.LinkTenantID = linkTenantID.String
+	return callback, nil
+}
+
+// redirectToProvider starts a login with provider.
+func redirectToProvider(w http.ResponseWriter, r *http.Request, provider string) {
+	authz, err := beginOAuth(w, r, provider, "", "")
+	if err != nil {
+		log.Printf("Failed to start %s login: %v", provider, err)
+		http.Error(w, "Failed to start login", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, oauthAuthorizeURLs[provider](authz), http.StatusFound)
+}
+
+// connectHandler handles POST /auth/connect/{provider}. It starts an OAuth flow
+// that attaches the provider account to the signed-in user and returns the URL
+// the browser should navigate to. Connecting social accounts is managing the
+// tenant's accounts, so it takes at least the admin role.
+func connectHandler(w http.ResponseWriter, r *http.Request) {
+	provider := mux.Vars(r)["provider"]
+	authorizeURL, ok := oauthAuthorizeURLs[provider]
+	if !ok {
+		http.Error(w, "Unknown provider", http.StatusNotFound)
+		return
+	}
+	userID, _ := r.Context().Value(userIDKey).(string)
+	tenantID, _ := r.Context().Value(tenantIDKey).(string)
+	var role string
+	err := db.QueryRowContext(r.Context(),
+		"SELECT role FROM tenant_members WHERE tenant_id = $1 AND user_id = $2", tenantID, userID,
+	).Scan(&role)
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("Failed to check tenant membership: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if roleRanks[role] < roleRanks[roleAdmin] {
+		http.Error(w, "Insufficient role in this tenant", http.StatusForbidden)
+		return
+	}
+	authz, err := beginOAuth(w, r, provider, userID, tenantID)
+	if err != nil {
+		log.Printf("Failed to start %s connect flow: %v", provider, err)
+		http.Error(w, "Failed to start connect flow", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"authorizeUrl": authorizeURL(authz)})
+}
+
+var oauthErrorPage = template.Must(template.New("oauth-error").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Sign-in failed</title></head>
+<body style="font-family: sans-serif; max-width: 32rem; margin: 4rem auto; text-align: center;">
+<h1>Sign-in failed</h1>
+<p>{{.Message}}</p>
+<p><a href="{{.RetryURL}}">Return to the sign-in page and try again</a></p>
+</body>
+</html>`))
+
+// renderOAuthError shows a failed login callback or emailed link to the user. Errors other than
+// the OAuth sentinels are logged and reported generically.
+func renderOAuthError(w http.ResponseWriter, err error) {
+	status := http.StatusBadRequest
+	message := err.Error()
+	switch {
+	case errors.Is(err, errOAuthDenied), errors.Is(err, errOAuthStateMismatch),
+		errors.Is(err, errOAuthStateInvalid), errors.Is(err, errOAuthStateExpired),
+		errors.Is(err, errNoPublishableAccounts):
+		message = "Sign-in could not be completed: " + message + "."
+	case errors.Is(err, errIdentityLinkedElsewhere):
+		status = http.StatusConflict
+		message = "Sign-in could not be completed: " + message + "."
+	default:
+		log.Printf("OAuth callback failed: %v", err)
+		status = http.StatusInternalServerError
+		message = "Sign-in could not be completed because of a server error."
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	oauthErrorPage.Execute(w, struct{ Message, RetryURL string }{message, cfg.FrontendURL + "/login"})
+}
@@ -0,0 +1,454 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// --- Sessions ---
+//
+// A login creates a session. The browser holds an opaque refresh token in an
+// HttpOnly cookie and trades it at /auth/refresh for a short-lived access JWT.
+// Every refresh rotates the refresh token; presenting one that was already used
+// means it leaked, so the whole session (the token family) is revoked. Access
+// JWTs carry a jti, and revoking a session publishes the jtis of its unexpired
+// JWTs on /internal/revoked-tokens for the other services to deny.
+
+const (
+	refreshCookieName = "refresh_token"
+	refreshCookiePath = "/auth"
+	// refreshReuseGrace tolerates the same refresh token arriving twice in quick
+	// succession, e.g. from two tabs refreshing at once, without treating it as theft.
+	refreshReuseGrace = 10 * time.Second
+
+	internalTokenHeader = "X-Internal-Token"
+)
+
+var errSessionInvalid = errors.New("session is invalid or expired")
+
+// accessToken is a signed access JWT and the metadata recorded for it.
+type accessToken struct {
+	Token     string
+	JTI       string
+	ExpiresAt time.Time
+}
+
+// startSession creates a session for user and sets its refresh token cookie.
+// amr records the authentication methods the user has just passed.
+func startSession(w http.ResponseWriter, r *http.Request, user InternalUser, amr []string) error {
+	ctx := r.Context()
+	sessionID := uuid.New().String()
+	refresh, err := newOpaqueToken()
+	if err != nil {
+		return err
+	}
+	expiresAt := time.Now().Add(cfg.Session.RefreshTTL)
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx,
+		"INSERT INTO sessions (id, user_id, tenant_id, user_agent, ip_address, amr) VALUES ($1, $2, $3, $4, $5, $6)",
+		sessionID, user.ID, user.TenantID, r.UserAgent(), r.RemoteAddr, pq.Array(amr),
+	); err != nil {
+		return fmt.Errorf("failed to create session: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx,
+		"INSERT INTO refresh_tokens (token_hash, session_id, expires_at) VALUES ($1, $2, $3)",
+		hashToken(refresh), sessionID, expiresAt,
+	); err != nil {
+		return fmt.Errorf("failed to store refresh token: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit session: %w", err)
+	}
+	setRefreshCookie(w, refresh, expiresAt)
+	return nil
+}
+
+// rotateRefreshToken redeems a refresh token for a new access token and, unless
+// it was redeemed moments ago, a new refresh token. It returns errSessionInvalid
+// for unknown, expired or revoked tokens, and revokes the session on reuse.
+func rotateRefreshToken(ctx context.Context, presented string) (accessToken, string, time.Time, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return accessToken{}, "", time.Time{}, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var sessionID, userID, tenantID string
+	var amr []string
+	var expiresAt time.Time
+	var usedAt, revokedAt sql.NullTime
+	err = tx.QueryRowContext(ctx, `
+		SELECT rt.session_id, rt.expires_at, rt.used_at, s.user_id, s.tenant_id, s.amr, s.revoked_at
+		FROM refresh_tokens rt JOIN sessions s ON s.id = rt.session_id
+		WHERE rt.token_hash = $1
+		FOR UPDATE OF rt, s`, hashToken(presented),
+	).Scan(&sessionID, &expiresAt, &usedAt, &userID, &tenantID, pq.Array(&amr), &revokedAt)
+	if err == sql.ErrNoRows {
+		return accessToken{}, "", time.Time{}, errSessionInvalid
+	}
+	if err != nil {
+		return accessToken{}, "", time.Time{}, fmt.Errorf("failed to look up refresh token: %w", err)
+	}
+	if revokedAt.Valid || time.Now().After(expiresAt) {
+		return accessToken{}, "", time.Time{}, errSessionInvalid
+	}
+
+	if usedAt.Valid {
+		if time.Since(usedAt.Time) < refreshReuseGrace {
+			// A concurrent refresh already rotated the cookie; only mint an access token.
+			access, err := issueAccessToken(ctx, tx, sessionID, userID, tenantID, amr)
+			if err != nil {
+				return accessToken{}, "", time.Time{}, err
+			}
+			return access, "", time.Time{}, tx.Commit()
+		}
+		if err := revokeSession(ctx, tx, sessionID, "refresh token reuse"); err != nil {
+			return accessToken{}, "", time.Time{}, err
+		}
+		if err := tx.Commit(); err != nil {
+			return accessToken{}, "", time.Time{}, fmt.Errorf("failed to commit revocation: %w", err)
+		}
+		log.Printf("Refresh token reuse detected for session %s of user %s; session revoked", sessionID, userID)
+		return accessToken{}, "", time.Time{}, errSessionInvalid
+	}
+
+	refresh, err := newOpaqueToken()
+	if err != nil {
+		return accessToken{}, "", time.Time{}, err
+	}
+	refreshExpiresAt := time.Now().Add(cfg.Session.RefreshTTL)
+	if _, err := tx.ExecContext(ctx, "UPDATE refresh_tokens SET used_at = now() WHERE token_hash = $1", hashToken(presented)); err != nil {
+		return accessToken{}, "", time.Time{}, fmt.Errorf("failed to mark refresh token used: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx,
+		"INSERT INTO refresh_tokens (token_hash, session_id, expires_at) VALUES ($1, $2, $3)",
+		hashToken(refresh), sessionID, refreshExpiresAt,
+	); err != nil {
+		return accessToken{}, "", time.Time{}, fmt.Errorf("failed to store refresh token: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE sessions SET last_refreshed_at = now() WHERE id = $1", sessionID); err != nil {
+		return accessToken{}, "", time.Time{}, fmt.Errorf("failed to update session: %w", err)
+	}
+	access, err := issueAccessToken(ctx, tx, sessionID, userID, tenantID, amr)
+	if err != nil {
+		return accessToken{}, "", time.Time{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return accessToken{}, "", time.Time{}, fmt.Errorf("failed to commit refresh: %w", err)
+	}
+	return access, refresh, refreshExpiresAt, nil
+}
+
+// issueAccessToken signs an access JWT for the session and records its jti. The
+// token carries the user's current role in the tenant; it returns
+// errSessionInvalid if they are no longer a member.
+func issueAccessToken(ctx context.Context, tx *sql.Tx, sessionID, userID, tenantID string, amr []string) (accessToken, error) {
+	var role string
+	err := tx.QueryRowContext(ctx, "SELECT role FROM tenant_members WHERE tenant_id = $1 AND user_id = $2", tenantID, userID).Scan(&role)
+	if err == sql.ErrNoRows {
+		return accessToken{}, errSessionInvalid
+	}
+	if err != nil {
+		return accessToken{}, fmt.Errorf("failed to look up role: %w", err)
+	}
+	access, err := generateJWT(userID, tenantID, sessionID, role, amr)
+	if err != nil {
+		return accessToken{}, err
+	}
+	if _, err := tx.ExecContext(ctx,
+		"INSERT INTO access_tokens (jti, session_id, expires_at) VALUES ($1, $2, $3)",
+		access.JTI, sessionID, access.ExpiresAt,
+	); err != nil {
+		return accessToken{}, fmt.Errorf("failed to record access token: %w", err)
+	}
+	return access, nil
+}
+
+// revokeSession ends a session and denylists its unexpired access tokens.
+func revokeSession(ctx context.Context, tx *sql.Tx, sessionID, reason string) error {
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE sessions SET revoked_at = now(), revoke_reason = $2 WHERE id = $1 AND revoked_at IS NULL",
+		sessionID, reason,
+	); err != nil {
+		return fmt.Errorf("failed to revoke session: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE access_tokens SET revoked_at = now() WHERE session_id = $1 AND revoked_at IS NULL AND expires_at > now()",
+		sessionID,
+	); err != nil {
+		return fmt.Errorf("failed to revoke access tokens: %w", err)
+	}
+	return nil
+}
+
+// revokeUserSessions revokes every active session of userID.
+func revokeUserSessions(ctx context.Context, tx *sql.Tx, userID, reason string) error {
+	rows, err := tx.QueryContext(ctx, "SELECT id FROM sessions WHERE user_id = $1 AND revoked_at IS NULL", userID)
+	if err != nil {
+		return fmt.Errorf("failed to list sessions: %w", err)
+	}
+	var sessionIDs []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan session: %w", err)
+		}
+		sessionIDs = append(sessionIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to list sessions: %w", err)
+	}
+	for _, id := range sessionIDs {
+		if err := revokeSession(ctx, tx, id, reason); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// logoutSession revokes the session that issued the presented refresh token.
+func logoutSession(ctx context.Context, presented string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	var sessionID string
+	err = tx.QueryRowContext(ctx, "SELECT session_id FROM refresh_tokens WHERE token_hash = $1", hashToken(presented)).Scan(&sessionID)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to look up refresh token: %w", err)
+	}
+	if err := revokeSession(ctx, tx, sessionID, "logout"); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// --- Session helpers ---
+
+// newOpaqueToken returns 256 random bits, base64url encoded.
+func newOpaqueToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken is the form in which opaque tokens are stored.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func setRefreshCookie(w http.ResponseWriter, value string, expiresAt time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     refreshCookieName,
+		Value:    value,
+		Path:     refreshCookiePath,
+		Expires:  expiresAt,
+		HttpOnly: true,
+		Secure:   cfg.Session.CookieSecure,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func clearRefreshCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     refreshCookieName,
+		Path:     refreshCookiePath,
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   cfg.Session.CookieSecure,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// --- Session Handlers ---
+
+// refreshHandler handles POST /auth/refresh.
+func refreshHandler(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie(refreshCookieName)
+	if err != nil || cookie.Value == "" {
+		http.Error(w, "Not signed in", http.StatusUnauthorized)
+		return
+	}
+	access, refresh, refreshExpiresAt, err := rotateRefreshToken(r.Context(), cookie.Value)
+	if errors.Is(err, errSessionInvalid) {
+		clearRefreshCookie(w)
+		http.Error(w, "Session expired", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to refresh session: %v", err)
+		http.Error(w, "Failed to refresh session", http.StatusInternalServerError)
+		return
+	}
+	if refresh != "" {
+		setRefreshCookie(w, refresh, refreshExpiresAt)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"accessToken": access.Token,
+		"tokenType":   "Bearer",
+		"expiresAt":   access.ExpiresAt,
+	})
+}
+
+// logoutHandler handles POST /auth/logout.
+func logoutHandler(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie(refreshCookieName); err == nil && cookie.Value != "" {
+		if err := logoutSession(r.Context(), cookie.Value); err != nil {
+			log.Printf("Failed to revoke session on logout: %v", err)
+			http.Error(w, "Failed to log out", http.StatusInternalServerError)
+			return
+		}
+	}
+	clearRefreshCookie(w)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// revokedToken is one entry of the jti denylist.
+type revokedToken struct {
+	JTI       string    `json:"jti"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// revokedTokensHandler handles GET /internal/revoked-tokens: the jtis of every
+// revoked access token that has not yet expired.
+func revokedTokensHandler(w http.ResponseWriter, r *http.Request) {
+	rows, err := db.QueryContext(r.Context(), "SELECT jti, expires_at FROM access_tokens WHERE revoked_at IS NOT NULL AND expires_at > now()")
+	if err != nil {
+		log.Printf("Failed to list revoked tokens: %v", err)
+		http.Error(w, "Failed to list revoked tokens", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	revoked := []revokedToken{}
+	for rows.Next() {
+		var t revokedToken
+		if err := rows.Scan(&t.JTI, &t.ExpiresAt); err != nil {
+			log.Printf("Failed to scan revoked token: %v", err)
+			http.Error(w, "Failed to list revoked tokens", http.StatusInternalServerError)
+			return
+		}
+		revoked = append(revoked, t)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Failed to list revoked tokens: %v", err)
+		http.Error(w, "Failed to list revoked tokens", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"revoked": revoked})
+}
+
+type contextKey string
+
+const (
+	userIDKey    contextKey = "userID"
+	tenantIDKey  contextKey = "tenantID"
+	sessionIDKey contextKey = "sessionID"
+	amrKey       contextKey = "amr"
+)
+
+// authMiddleware admits requests bearing a valid, unrevoked access JWT.
+func authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokenString := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if tokenString == "" {
+			http.Error(w, "Authorization header required", http.StatusUnauthorized)
+			return
+		}
+		claims := &Claims{}
+		token, err := parseJWT(tokenString, claims)
+		if err != nil || !token.Valid {
+			http.Error(w, "Invalid or expired token", http.StatusUnauthorized)
+			return
+		}
+		var revoked bool
+		err = db.QueryRowContext(r.Context(), "SELECT revoked_at IS NOT NULL FROM access_tokens WHERE jti = $1", claims.ID).Scan(&revoked)
+		if err == sql.ErrNoRows || revoked {
+			http.Error(w, "Token has been revoked", http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to check token revocation: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		ctx := context.WithValue(r.Context(), userIDKey, claims.UserID)
+		ctx = context.WithValue(ctx, tenantIDKey, claims.TenantID)
+		ctx = context.WithValue(ctx, sessionIDKey, claims.SessionID)
+		ctx = context.WithValue(ctx, amrKey, claims.AMR)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// internalAuthMiddleware only admits requests carrying the shared internal API secret.
+func internalAuthMiddleware(next http.Handler) http.Handler {
+	secret := cfg.InternalAPISecret
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		presented := r.Header.Get(internalTokenHeader)
+		if subtle.ConstantTimeCompare([]byte(presented), []byte(secret)) != 1 {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// --- Session cleanup ---
+
+// pruneSessions periodically deletes expired tokens, abandoned OAuth states and
+// sessions with nothing left to redeem.
+func pruneSessions(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for _, stmt := range []string{
+			"DELETE FROM access_tokens WHERE expires_at < now()",
+			"DELETE FROM refresh_tokens WHERE expires_at < now()",
+			"DELETE FROM oauth_states WHERE expires_at < now()",
+			"DELETE FROM auth_tokens WHERE expires_at < now()",
+			"DELETE FROM mfa_challenges WHERE expires_at < now()",
+			"DELETE FROM sessions s WHERE NOT EXISTS (SELECT 1 FROM refresh_tokens rt WHERE rt.session_id = s.id) AND NOT EXISTS (SELECT 1 FROM access_tokens a WHERE a.session_id = s.id)",
+		} {
+			if _, err := db.ExecContext(ctx, stmt); err != nil {
+				log.Printf("Session cleanup failed: %v", err)
+				break
+			}
+		}
+	}
+}
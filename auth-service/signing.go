@@ -0,0 +1,235 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// --- JWT Signing Keys ---
+//
+// Platform JWTs are signed with an asymmetric key so the other services can
+// verify them from the published JWKS without being able to mint them.
+//
+// Private keys live in jwt_signing.keys_dir as PKCS#8 PEM files named
+// "<kid>.pem" (RSA or Ed25519). Every key in the directory is published at
+// /.well-known/jwks.json; new tokens are signed with jwt_signing.active_key, or
+// the greatest kid if unset. To rotate: add a new key and restart so verifiers
+// learn it, switch active_key to it, then delete the old file once the tokens
+// it signed have expired.
+
+const (
+	signingAlgRS256 = "RS256"
+	signingAlgEdDSA = "EdDSA"
+)
+
+// signingKeys is the key set used by generateJWT and the JWKS endpoint.
+var signingKeys *signingKeySet
+
+// signingKey is one private key and the JWT algorithm it signs with.
+type signingKey struct {
+	kid    string
+	alg    string
+	method jwt.SigningMethod
+	signer crypto.Signer
+}
+
+type signingKeySet struct {
+	keys   []*signingKey
+	active *signingKey
+}
+
+func initSigningKeys() {
+	set, err := loadSigningKeys(cfg.JWTSigning.KeysDir, cfg.JWTSigning.ActiveKey)
+	if err != nil {
+		log.Fatalf("Failed to load JWT signing keys: %v", err)
+	}
+	signingKeys = set
+	log.Printf("JWT signing enabled with %d key(s), active kid %s (%s)", len(set.keys), set.active.kid, set.active.alg)
+}
+
+func loadSigningKeys(dir, activeKID string) (*signingKeySet, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", dir, err)
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no signing keys in %s; create one with `auth-service gen-signing-key`", dir)
+	}
+	sort.Strings(paths)
+	set := &signingKeySet{}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		key, err := parseSigningKey(strings.TrimSuffix(filepath.Base(path), ".pem"), data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		set.keys = append(set.keys, key)
+	}
+	if activeKID == "" {
+		set.active = set.keys[len(set.keys)-1]
+		return set, nil
+	}
+	for _, key := range set.keys {
+		if key.kid == activeKID {
+			set.active = key
+			return set, nil
+		}
+	}
+	return nil, fmt.Errorf("active signing key %q not found in %s", activeKID, dir)
+}
+
+func parseSigningKey(kid string, data []byte) (*signingKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	var parsed interface{}
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %w", err)
+	}
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		if k.N.BitLen() < 2048 {
+			return nil, fmt.Errorf("RSA key is %d bits, need at least 2048", k.N.BitLen())
+		}
+		return &signingKey{kid: kid, alg: signingAlgRS256, method: jwt.SigningMethodRS256, signer: k}, nil
+	case ed25519.PrivateKey:
+		return &signingKey{kid: kid, alg: signingAlgEdDSA, method: jwt.SigningMethodEdDSA, signer: k}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", parsed)
+	}
+}
+
+// sign signs claims with the active key and stamps its kid in the header.
+func (s *signingKeySet) sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(s.active.method, claims)
+	token.Header["kid"] = s.active.kid
+	return token.SignedString(s.active.signer)
+}
+
+// parseJWT verifies a token signed with any configured key and decodes it into claims.
+func parseJWT(tokenString string, claims *Claims) (*jwt.Token, error) {
+	return jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		for _, key := range signingKeys.keys {
+			if key.kid == kid {
+				return key.signer.Public(), nil
+			}
+		}
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}, jwt.WithValidMethods([]string{signingAlgRS256, signingAlgEdDSA}), jwt.WithIssuer(cfg.JWTIssuer), jwt.WithExpirationRequired())
+}
+
+// --- JWKS ---
+
+// jsonWebKey is the public half of a signing key in RFC 7517 form.
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+func (k *signingKey) jwk() jsonWebKey {
+	jwk := jsonWebKey{Kid: k.kid, Use: "sig", Alg: k.alg}
+	switch pub := k.signer.Public().(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+	}
+	return jwk
+}
+
+// jwksHandler publishes every configured public key so verifiers accept tokens
+// signed by both the old and the new key during a rotation.
+func jwksHandler(w http.ResponseWriter, r *http.Request) {
+	keys := make([]jsonWebKey, 0, len(signingKeys.keys))
+	for _, key := range signingKeys.keys {
+		keys = append(keys, key.jwk())
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+}
+
+// --- gen-signing-key command ---
+
+// runGenSigningKeyCommand implements `auth-service gen-signing-key [ed25519|rsa]`.
+// It writes a new key to the keys directory, named after the current time so
+// that it sorts after every existing key.
+func runGenSigningKeyCommand(args []string) {
+	kind := "ed25519"
+	if len(args) > 0 {
+		kind = strings.ToLower(args[0])
+	}
+	var key interface{}
+	var err error
+	switch kind {
+	case "ed25519":
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	case "rsa":
+		key, err = rsa.GenerateKey(rand.Reader, 3072)
+	default:
+		log.Fatalf("Unknown key type %q (expected ed25519 or rsa)", kind)
+	}
+	if err != nil {
+		log.Fatalf("Failed to generate key: %v", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		log.Fatalf("Failed to encode key: %v", err)
+	}
+	if err := os.MkdirAll(cfg.JWTSigning.KeysDir, 0o700); err != nil {
+		log.Fatalf("Failed to create %s: %v", cfg.JWTSigning.KeysDir, err)
+	}
+	kid := time.Now().UTC().Format("20060102T150405Z") + "-" + kind
+	path := filepath.Join(cfg.JWTSigning.KeysDir, kid+".pem")
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		log.Fatalf("Failed to create %s: %v", path, err)
+	}
+	defer f.Close()
+	if err := pem.Encode(f, &pem.Block{Type: "PRIVATE KEY", Bytes: der}); err != nil {
+		log.Fatalf("Failed to write %s: %v", path, err)
+	}
+	log.Printf("Wrote signing key %s to %s", kid, path)
+}
@@ -0,0 +1,645 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// --- Tenants ---
+//
+// A tenant is a workspace that several users can share. Each membership has a
+// role: owners manage everything including other owners, admins manage members
+// and invitations, editors and viewers only work inside the tenant. New users
+// get a tenant of their own; others join through emailed invitations. An access
+// token is issued for one tenant at a time, the session's, and switching tenants
+// re-issues it for another membership.
+
+const (
+	roleOwner  = "owner"
+	roleAdmin  = "admin"
+	roleEditor = "editor"
+	roleViewer = "viewer"
+
+	invitationTTL = 7 * 24 * time.Hour
+)
+
+// roleRanks orders roles from least to most privileged.
+var roleRanks = map[string]int{roleViewer: 1, roleEditor: 2, roleAdmin: 3, roleOwner: 4}
+
+var (
+	errNotMember         = errors.New("not a member of this tenant")
+	errLastOwner         = errors.New("a tenant must keep at least one owner")
+	errInvitationInvalid = errors.New("the invitation is invalid, has already been used or has expired")
+)
+
+// Tenant is a tenant as seen by one of its members.
+type Tenant struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Role      string    `json:"role"`
+	Current   bool      `json:"current"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// TenantMember is one membership of a tenant.
+type TenantMember struct {
+	UserID   string    `json:"userId"`
+	Name     string    `json:"name"`
+	Email    string    `json:"email,omitempty"`
+	Role     string    `json:"role"`
+	JoinedAt time.Time `json:"joinedAt"`
+}
+
+// TenantInvitation is a pending invitation.
+type TenantInvitation struct {
+	ID        string    `json:"id"`
+	Email     string    `json:"email"`
+	Role      string    `json:"role"`
+	InvitedBy string    `json:"invitedBy,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+func personalTenantName(userName string) string {
+	if userName == "" {
+		return "Personal workspace"
+	}
+	return userName + "'s workspace"
+}
+
+// createTenant creates a tenant owned by userID.
+func createTenant(ctx context.Context, tx *sql.Tx, tenantID, name, userID string) error {
+	if _, err := tx.ExecContext(ctx,
+		"INSERT INTO tenants (id, name, created_by) VALUES ($1, $2, $3)", tenantID, name, userID,
+	); err != nil {
+		return fmt.Errorf("failed to create tenant: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx,
+		"INSERT INTO tenant_members (tenant_id, user_id, role) VALUES ($1, $2, $3)", tenantID, userID, roleOwner,
+	); err != nil {
+		return fmt.Errorf("failed to add tenant owner: %w", err)
+	}
+	return nil
+}
+
+// createPersonalTenant creates the tenant named by a new user's TenantID.
+func createPersonalTenant(ctx context.Context, tx *sql.Tx, user InternalUser) error {
+	return createTenant(ctx, tx, user.TenantID, personalTenantName(user.Name), user.ID)
+}
+
+// memberRole returns userID's role in tenantID, or errNotMember.
+func memberRole(ctx context.Context, tx *sql.Tx, tenantID, userID string) (string, error) {
+	var role string
+	err := tx.QueryRowContext(ctx,
+		"SELECT role FROM tenant_members WHERE tenant_id = $1 AND user_id = $2 FOR UPDATE", tenantID, userID,
+	).Scan(&role)
+	if err == sql.ErrNoRows {
+		return "", errNotMember
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to look up membership: %w", err)
+	}
+	return role, nil
+}
+
+// ensureOwnerRemains returns errLastOwner if tenantID would be left without owners.
+func ensureOwnerRemains(ctx context.Context, tx *sql.Tx, tenantID string) error {
+	var owners int
+	if err := tx.QueryRowContext(ctx,
+		"SELECT count(*) FROM tenant_members WHERE tenant_id = $1 AND role = $2", tenantID, roleOwner,
+	).Scan(&owners); err != nil {
+		return fmt.Errorf("failed to count owners: %w", err)
+	}
+	if owners == 0 {
+		return errLastOwner
+	}
+	return nil
+}
+
+// removeMember deletes a membership, ends the member's sessions in the tenant
+// and moves their default tenant elsewhere if it was this one.
+func removeMember(ctx context.Context, tx *sql.Tx, tenantID, userID string) error {
+	if _, err := tx.ExecContext(ctx, "DELETE FROM tenant_members WHERE tenant_id = $1 AND user_id = $2", tenantID, userID); err != nil {
+		return fmt.Errorf("failed to remove member: %w", err)
+	}
+	if err := ensureOwnerRemains(ctx, tx, tenantID); err != nil {
+		return err
+	}
+	rows, err := tx.QueryContext(ctx,
+		"SELECT id FROM sessions WHERE user_id = $1 AND tenant_id = $2 AND revoked_at IS NULL", userID, tenantID)
+	if err != nil {
+		return fmt.Errorf("failed to list sessions: %w", err)
+	}
+	var sessionIDs []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan session: %w", err)
+		}
+		sessionIDs = append(sessionIDs, id)
+	}
+	rows.Close()
+	for _, id := range sessionIDs {
+		if err := revokeSession(ctx, tx, id, "removed from tenant"); err != nil {
+			return err
+		}
+	}
+
+	var name string
+	var defaultTenantID string
+	if err := tx.QueryRowContext(ctx, "SELECT COALESCE(name, ''), tenant_id FROM users WHERE id = $1", userID).Scan(&name, &defaultTenantID); err != nil {
+		return fmt.Errorf("failed to load user: %w", err)
+	}
+	if defaultTenantID != tenantID {
+		return nil
+	}
+	var nextTenantID string
+	err = tx.QueryRowContext(ctx,
+		"SELECT tenant_id FROM tenant_members WHERE user_id = $1 ORDER BY created_at LIMIT 1", userID,
+	).Scan(&nextTenantID)
+	if err == sql.ErrNoRows {
+		nextTenantID = uuid.New().String()
+		err = createTenant(ctx, tx, nextTenantID, personalTenantName(name), userID)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to pick another tenant: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE users SET tenant_id = $2 WHERE id = $1", userID, nextTenantID); err != nil {
+		return fmt.Errorf("failed to update default tenant: %w", err)
+	}
+	return nil
+}
+
+// --- Tenant Handlers ---
+
+// tenantRequest opens a transaction for a request about the tenant in the URL
+// and checks that the signed-in user holds at least minRole there. It writes
+// the error response itself and returns a nil tx when the request must stop.
+func tenantRequest(w http.ResponseWriter, r *http.Request, minRole string) (*sql.Tx, string, string) {
+	ctx := r.Context()
+	userID, _ := ctx.Value(userIDKey).(string)
+	tenantID := mux.Vars(r)["tenantId"]
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("Failed to begin transaction: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return nil, "", ""
+	}
+	role, err := memberRole(ctx, tx, tenantID, userID)
+	if err != nil {
+		tx.Rollback()
+		if errors.Is(err, errNotMember) {
+			http.Error(w, "Tenant not found", http.StatusNotFound)
+		} else {
+			log.Printf("Failed to check tenant membership: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return nil, "", ""
+	}
+	if roleRanks[role] < roleRanks[minRole] {
+		tx.Rollback()
+		http.Error(w, "Insufficient role in this tenant", http.StatusForbidden)
+		return nil, "", ""
+	}
+	return tx, userID, role
+}
+
+func writeTenantError(w http.ResponseWriter, action string, err error) {
+	switch {
+	case errors.Is(err, errLastOwner):
+		http.Error(w, "A tenant must keep at least one owner", http.StatusConflict)
+	case errors.Is(err, errNotMember):
+		http.Error(w, "Member not found", http.StatusNotFound)
+	default:
+		log.Printf("Failed to %s: %v", action, err)
+		http.Error(w, "Failed to "+action, http.StatusInternalServerError)
+	}
+}
+
+// listTenantsHandler handles GET /auth/tenants: every tenant of the signed-in user.
+func listTenantsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, _ := ctx.Value(userIDKey).(string)
+	currentTenantID, _ := ctx.Value(tenantIDKey).(string)
+	rows, err := db.QueryContext(ctx, `
+		SELECT t.id, t.name, m.role, t.created_at
+		FROM tenant_members m JOIN tenants t ON t.id = m.tenant_id
+		WHERE m.user_id = $1 ORDER BY t.name`, userID)
+	if err != nil {
+		writeTenantError(w, "list tenants", err)
+		return
+	}
+	defer rows.Close()
+	tenants := []Tenant{}
+	for rows.Next() {
+		var t Tenant
+		if err := rows.Scan(&t.ID, &t.Name, &t.Role, &t.CreatedAt); err != nil {
+			writeTenantError(w, "list tenants", err)
+			return
+		}
+		t.Current = t.ID == currentTenantID
+		tenants = append(tenants, t)
+	}
+	if err := rows.Err(); err != nil {
+		writeTenantError(w, "list tenants", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tenants)
+}
+
+// createTenantHandler handles POST /auth/tenants. The creator becomes its owner.
+func createTenantHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name string `json:"name"`
+	}
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" || len(name) > 100 {
+		http.Error(w, "Name must be between 1 and 100 characters", http.StatusBadRequest)
+		return
+	}
+	// Tenant names appear in email subjects and elsewhere as a single line.
+	if strings.IndexFunc(name, unicode.IsControl) >= 0 {
+		http.Error(w, "Name must not contain control characters", http.StatusBadRequest)
+		return
+	}
+	ctx := r.Context()
+	userID, _ := ctx.Value(userIDKey).(string)
+	tenant := Tenant{ID: uuid.New().String(), Name: name, Role: roleOwner, CreatedAt: time.Now()}
+	tx, err := db.BeginTx(ctx, nil)
+	if err == nil {
+		defer tx.Rollback()
+		err = createTenant(ctx, tx, tenant.ID, tenant.Name, userID)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		writeTenantError(w, "create tenant", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(tenant)
+}
+
+// switchTenantHandler handles POST /auth/tenants/{tenantId}/switch. It moves the
+// session to another of the user's tenants and returns an access token for it;
+// tokens issued for the previous tenant are revoked.
+func switchTenantHandler(w http.ResponseWriter, r *http.Request) {
+	tx, userID, _ := tenantRequest(w, r, roleViewer)
+	if tx == nil {
+		return
+	}
+	defer tx.Rollback()
+	ctx := r.Context()
+	tenantID := mux.Vars(r)["tenantId"]
+	sessionID, _ := ctx.Value(sessionIDKey).(string)
+
+	var amr []string
+	err := tx.QueryRowContext(ctx,
+		"UPDATE sessions SET tenant_id = $2 WHERE id = $1 AND revoked_at IS NULL RETURNING amr", sessionID, tenantID,
+	).Scan(pq.Array(&amr))
+	if err == sql.ErrNoRows {
+		http.Error(w, "Session expired", http.StatusUnauthorized)
+		return
+	}
+	if err == nil {
+		_, err = tx.ExecContext(ctx,
+			"UPDATE access_tokens SET revoked_at = now() WHERE session_id = $1 AND revoked_at IS NULL AND expires_at > now()", sessionID)
+	}
+	if err == nil {
+		_, err = tx.ExecContext(ctx, "UPDATE users SET tenant_id = $2 WHERE id = $1", userID, tenantID)
+	}
+	var access accessToken
+	if err == nil {
+		access, err = issueAccessToken(ctx, tx, sessionID, userID, tenantID, amr)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		writeTenantError(w, "switch tenant", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"accessToken": access.Token,
+		"tokenType":   "Bearer",
+		"expiresAt":   access.ExpiresAt,
+	})
+}
+
+// listMembersHandler handles GET /auth/tenants/{tenantId}/members.
+func listMembersHandler(w http.ResponseWriter, r *http.Request) {
+	tx, _, _ := tenantRequest(w, r, roleViewer)
+	if tx == nil {
+		return
+	}
+	defer tx.Rollback()
+	rows, err := tx.QueryContext(r.Context(), `
+		SELECT u.id, COALESCE(u.name, ''), COALESCE(u.email, ''), m.role, m.created_at
+		FROM tenant_members m JOIN users u ON u.id = m.user_id
+		WHERE m.tenant_id = $1 ORDER BY m.created_at`, mux.Vars(r)["tenantId"])
+	if err != nil {
+		writeTenantError(w, "list members", err)
+		return
+	}
+	defer rows.Close()
+	members := []TenantMember{}
+	for rows.Next() {
+		var m TenantMember
+		if err := rows.Scan(&m.UserID, &m.Name, &m.Email, &m.Role, &m.JoinedAt); err != nil {
+			writeTenantError(w, "list members", err)
+			return
+		}
+		members = append(members, m)
+	}
+	if err := rows.Err(); err != nil {
+		writeTenantError(w, "list members", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(members)
+}
+
+// updateMemberHandler handles PUT /auth/tenants/{tenantId}/members/{userId},
+// changing a member's role. Only owners may grant or take away the owner role.
+func updateMemberHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Role string `json:"role"`
+	}
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	if _, ok := roleRanks[req.Role]; !ok {
+		http.Error(w, "Role must be owner, admin, editor or viewer", http.StatusBadRequest)
+		return
+	}
+	tx, _, actorRole := tenantRequest(w, r, roleAdmin)
+	if tx == nil {
+		return
+	}
+	defer tx.Rollback()
+	ctx := r.Context()
+	tenantID, memberID := mux.Vars(r)["tenantId"], mux.Vars(r)["userId"]
+	currentRole, err := memberRole(ctx, tx, tenantID, memberID)
+	if err != nil {
+		writeTenantError(w, "update member", err)
+		return
+	}
+	if (currentRole == roleOwner || req.Role == roleOwner) && actorRole != roleOwner {
+		http.Error(w, "Only owners can change who is an owner", http.StatusForbidden)
+		return
+	}
+	_, err = tx.ExecContext(ctx, "UPDATE tenant_members SET role = $3 WHERE tenant_id = $1 AND user_id = $2", tenantID, memberID, req.Role)
+	if err == nil {
+		err = ensureOwnerRemains(ctx, tx, tenantID)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		writeTenantError(w, "update member", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// removeMemberHandler handles DELETE /auth/tenants/{tenantId}/members/{userId}.
+// Admins may remove members other than owners; anyone may remove themselves.
+func removeMemberHandler(w http.ResponseWriter, r *http.Request) {
+	tenantID, memberID := mux.Vars(r)["tenantId"], mux.Vars(r)["userId"]
+	minRole := roleAdmin
+	if userID, _ := r.Context().Value(userIDKey).(string); userID == memberID {
+		minRole = roleViewer
+	}
+	tx, actorID, actorRole := tenantRequest(w, r, minRole)
+	if tx == nil {
+		return
+	}
+	defer tx.Rollback()
+	ctx := r.Context()
+	memberRoleName, err := memberRole(ctx, tx, tenantID, memberID)
+	if err != nil {
+		writeTenantError(w, "remove member", err)
+		return
+	}
+	if memberRoleName == roleOwner && actorRole != roleOwner {
+		http.Error(w, "Only owners can remove an owner", http.StatusForbidden)
+		return
+	}
+	err = removeMember(ctx, tx, tenantID, memberID)
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		writeTenantError(w, "remove member", err)
+		return
+	}
+	log.Printf("User %s removed user %s from tenant %s", actorID, memberID, tenantID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// --- Invitation Handlers ---
+
+// createInvitationHandler handles POST /auth/tenants/{tenantId}/invitations and
+// emails the invitation link.
+func createInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Email string `json:"email"`
+		Role  string `json:"role"`
+	}
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	email, err := normalizeEmail(req.Email)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, ok := roleRanks[req.Role]; !ok {
+		http.Error(w, "Role must be owner, admin, editor or viewer", http.StatusBadRequest)
+		return
+	}
+	tx, inviterID, inviterRole := tenantRequest(w, r, roleAdmin)
+	if tx == nil {
+		return
+	}
+	defer tx.Rollback()
+	if req.Role == roleOwner && inviterRole != roleOwner {
+		http.Error(w, "Only owners can invite owners", http.StatusForbidden)
+		return
+	}
+	ctx := r.Context()
+	tenantID := mux.Vars(r)["tenantId"]
+	token, err := newOpaqueToken()
+	if err != nil {
+		writeTenantError(w, "create invitation", err)
+		return
+	}
+	invitation := TenantInvitation{ID: uuid.New().String(), Email: email, Role: req.Role, InvitedBy: inviterID, CreatedAt: time.Now(), ExpiresAt: time.Now().Add(invitationTTL)}
+	var tenantName, inviterName string
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO tenant_invitations (id, token_hash, tenant_id, email, role, invited_by, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		invitation.ID, hashToken(token), tenantID, email, req.Role, inviterID, invitation.CreatedAt, invitation.ExpiresAt)
+	if err == nil {
+		err = tx.QueryRowContext(ctx, `
+			SELECT t.name, COALESCE(NULLIF(u.name, ''), u.email, 'A teammate')
+			FROM tenants t, users u WHERE t.id = $1 AND u.id = $2`, tenantID, inviterID,
+		).Scan(&tenantName, &inviterName)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		writeTenantError(w, "create invitation", err)
+		return
+	}
+	sendEmail(Message{To: email, Subject: fmt.Sprintf("You're invited to %s", tenantName), Body: fmt.Sprintf(
+		"Hi,\n\n%s invited you to join %s as %s %s. Accept the invitation here:\n\n%s/invitations/accept?%s\n\nThe invitation expires in 7 days.\n",
+		inviterName, tenantName, article(req.Role), req.Role, cfg.FrontendURL, url.Values{"token": {token}}.Encode())})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(invitation)
+}
+
+func article(word string) string {
+	if strings.ContainsRune("aeiou", rune(word[0])) {
+		return "an"
+	}
+	return "a"
+}
+
+// listInvitationsHandler handles GET /auth/tenants/{tenantId}/invitations.
+func listInvitationsHandler(w http.ResponseWriter, r *http.Request) {
+	tx, _, _ := tenantRequest(w, r, roleAdmin)
+	if tx == nil {
+		return
+	}
+	defer tx.Rollback()
+	rows, err := tx.QueryContext(r.Context(), `
+		SELECT id, email, role, COALESCE(invited_by, ''), created_at, expires_at FROM tenant_invitations
+		WHERE tenant_id = $1 AND accepted_at IS NULL AND revoked_at IS NULL AND expires_at > now()
+		ORDER BY created_at`, mux.Vars(r)["tenantId"])
+	if err != nil {
+		writeTenantError(w, "list invitations", err)
+		return
+	}
+	defer rows.Close()
+	invitations := []TenantInvitation{}
+	for rows.Next() {
+		var inv TenantInvitation
+		if err := rows.Scan(&inv.ID, &inv.Email, &inv.Role, &inv.InvitedBy, &inv.CreatedAt, &inv.ExpiresAt); err != nil {
+			writeTenantError(w, "list invitations", err)
+			return
+		}
+		invitations = append(invitations, inv)
+	}
+	if err := rows.Err(); err != nil {
+		writeTenantError(w, "list invitations", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(invitations)
+}
+
+// revokeInvitationHandler handles DELETE /auth/tenants/{tenantId}/invitations/{invitationId}.
+func revokeInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	tx, _, _ := tenantRequest(w, r, roleAdmin)
+	if tx == nil {
+		return
+	}
+	defer tx.Rollback()
+	res, err := tx.ExecContext(r.Context(),
+		"UPDATE tenant_invitations SET revoked_at = now() WHERE id = $1 AND tenant_id = $2 AND accepted_at IS NULL AND revoked_at IS NULL",
+		mux.Vars(r)["invitationId"], mux.Vars(r)["tenantId"])
+	var n int64
+	if err == nil {
+		n, err = res.RowsAffected()
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		writeTenantError(w, "revoke invitation", err)
+		return
+	}
+	if n == 0 {
+		http.Error(w, "Invitation not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// acceptInvitationHandler handles POST /auth/invitations/accept. The emailed
+// token is the proof of invitation, so any signed-in user presenting it joins
+// the tenant; an existing member keeps the higher of the two roles.
+func acceptInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Token string `json:"token"`
+	}
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	ctx := r.Context()
+	userID, _ := ctx.Value(userIDKey).(string)
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		writeTenantError(w, "accept invitation", err)
+		return
+	}
+	defer tx.Rollback()
+
+	var tenantID, role string
+	err = tx.QueryRowContext(ctx, `
+		UPDATE tenant_invitations SET accepted_at = now(), accepted_by = $2
+		WHERE token_hash = $1 AND accepted_at IS NULL AND revoked_at IS NULL AND expires_at > now()
+		RETURNING tenant_id, role`, hashToken(req.Token), userID,
+	).Scan(&tenantID, &role)
+	if err == sql.ErrNoRows {
+		http.Error(w, errInvitationInvalid.Error(), http.StatusBadRequest)
+		return
+	}
+	var existingRole string
+	if err == nil {
+		existingRole, err = memberRole(ctx, tx, tenantID, userID)
+	}
+	switch {
+	case errors.Is(err, errNotMember):
+		_, err = tx.ExecContext(ctx, "INSERT INTO tenant_members (tenant_id, user_id, role) VALUES ($1, $2, $3)", tenantID, userID, role)
+	case err == nil && roleRanks[role] > roleRanks[existingRole]:
+		_, err = tx.ExecContext(ctx, "UPDATE tenant_members SET role = $3 WHERE tenant_id = $1 AND user_id = $2", tenantID, userID, role)
+	case err == nil:
+		role = existingRole
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		writeTenantError(w, "accept invitation", err)
+		return
+	}
+	log.Printf("User %s joined tenant %s as %s", userID, tenantID, role)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"tenantId": tenantID, "role": role})
+}
@@ -1,5 +1,5 @@
 import React, { useState, useEffect } from 'react';
-import { LayoutDashboard, Calendar, BarChart2, MessageSquare, Plus, Loader2, Menu } from 'lucide-react';
+import { LayoutDashboard, Calendar, BarChart2, MessageSquare, Plus, Loader2, Menu, LogOut, ShieldCheck, Users } from 'lucide-react';
 import { LineChart, Line, XAxis, YAxis, CartesianGrid, Tooltip, ResponsiveContainer } from 'recharts';
 
 // The base URLs for our Go backend microservices.
@@ -8,7 +8,7 @@ const ACCOUNT_API_BASE_URL = 'http://localhost:8082';
 const POST_API_BASE_URL = 'http://localhost:8083';
 
 // Reusable component for displaying an account summary card.
-const AccountCard = ({ account }) => (
+const AccountCard = ({ account, onDisconnect }) => (
   <div className="bg-white p-6 rounded-xl shadow-lg transition-transform duration-300 hover:scale-105 transform hover:shadow-2xl flex flex-col items-center text-center">
     <div className="w-20 h-20 rounded-full overflow-hidden mb-4 border-4 border-gray-100">
       <img src={account.profilePic} alt={`${account.username}'s profile`} className="w-full h-full object-cover" />
@@ -17,6 +17,9 @@ const AccountCard = ({ account }) => (
     <p className="text-sm text-gray-500 mb-2">{account.platform}</p>
     <p className="text-2xl font-extrabold text-blue-600">{account.followers}</p>
     <p className="text-xs text-gray-400 uppercase tracking-wide">Followers</p>
+    <button onClick={() => onDisconnect(account)} className="mt-4 text-sm text-red-600 hover:underline">
+      Disconnect
+    </button>
   </div>
 );
 
@@ -25,6 +28,7 @@ const Dashboard = ({ token }) => {
   const [accounts, setAccounts] = useState([]);
   const [isLoading, setIsLoading] = useState(true);
   const [error, setError] = useState(null);
+  const [notice, setNotice] = useState(null);
   const [analyticsData, setAnalyticsData] = useState([]);
 
   useEffect(() => {
@@ -80,6 +84,46 @@ const Dashboard = ({ token }) => {
     }
   }, [token]);
 
+  // Attaches another platform account to the signed-in user rather than starting a new login.
+  const handleConnect = async (provider) => {
+    try {
+      const response = await fetch(`${AUTH_API_BASE_URL}/auth/connect/${provider}`, {
+        method: 'POST',
+        credentials: 'include',
+        headers: { 'Authorization': `Bearer ${token}` },
+      });
+      if (!response.ok) {
+        throw new Error('Failed to start connecting the account.');
+      }
+      const { authorizeUrl } = await response.json();
+      window.location.href = authorizeUrl;
+    } catch (e) {
+      console.error('Connect error:', e);
+      setError(e.message);
+    }
+  };
+
+  // Disconnecting is a sensitive action: the Account Service requires a session that passed two-factor authentication.
+  const handleDisconnect = async (account) => {
+    if (!window.confirm(`Disconnect ${account.username} (${account.platform})?`)) {
+      return;
+    }
+    setNotice(null);
+    try {
+      const response = await fetch(`${ACCOUNT_API_BASE_URL}/api/accounts/${encodeURIComponent(account.platformUserId)}`, {
+        method: 'DELETE',
+        headers: { 'Authorization': `Bearer ${token}` },
+      });
+      if (!response.ok) {
+        throw new Error(await errorMessage(response, 'Failed to disconnect the account.'));
+      }
+      setAccounts((current) => current.filter((a) => a.platformUserId !== account.platformUserId));
+    } catch (e) {
+      console.error('Disconnect error:', e);
+      setNotice(e.message);
+    }
+  };
+
   if (isLoading) {
     return (
       <div className="flex items-center justify-center h-full">
@@ -104,15 +148,28 @@ const Dashboard = ({ token }) => {
       {/* Account Overview Section */}
       <div className="bg-white p-6 rounded-xl shadow-lg">
         <h2 className="text-2xl font-semibold text-gray-800 mb-6">Connected Accounts</h2>
+        {notice && <p className="mb-6 p-3 rounded-lg bg-yellow-100 text-yellow-800 text-sm">{notice}</p>}
         <div className="grid grid-cols-1 sm:grid-cols-2 lg:grid-cols-3 gap-6">
           {accounts.length > 0 ? (
             accounts.map(account => (
-              <AccountCard key={account.platformUserId} account={account} />
+              <AccountCard key={account.platformUserId} account={account} onDisconnect={handleDisconnect} />
             ))
           ) : (
-            <p className="text-gray-500 text-center col-span-3">No accounts connected yet. Please go to your profile to connect a new one.</p>
+            <p className="text-gray-500 text-center col-span-3">No accounts connected yet.</p>
           )}
         </div>
+        <div className="flex flex-wrap gap-3 mt-6">
+          {[['meta', 'Meta'], ['tiktok', 'TikTok'], ['snapchat', 'Snapchat']].map(([provider, label]) => (
+            <button
+              key={provider}
+              onClick={() => handleConnect(provider)}
+              className="flex items-center py-2 px-4 rounded-lg border border-gray-300 text-gray-700 hover:bg-gray-100 transition-colors"
+            >
+              <Plus className="mr-2 h-4 w-4" />
+              Connect {label}
+            </button>
+          ))}
+        </div>
       </div>
       
       {/* Quick Analytics Section */}
@@ -134,17 +191,255 @@ const Dashboard = ({ token }) => {
   );
 };
 
-// Login component with OAuth buttons.
-const Login = () => {
+// Reads the message of a failed API response. 403s and validation failures (422)
+// carry a JSON body explaining them, other client errors a plain-text one.
+const errorMessage = async (response, fallback) => {
+  if ((response.headers.get('Content-Type') || '').includes('application/json')) {
+    const body = await response.json();
+    if (body.errors && body.errors.length > 0) {
+      return body.errors.map((e) => e.message).join(' ');
+    }
+    return body.message || fallback;
+  }
+  if (response.status >= 400 && response.status < 500) {
+    return (await response.text()).trim() || fallback;
+  }
+  return fallback;
+};
+
+// Files larger than this are sent as a resumable upload, in chunks of this size.
+const UPLOAD_CHUNK_SIZE = 8 * 1024 * 1024;
+
+// Adds a file to the media library and resolves to the media. Large files are
+// uploaded in chunks; after a failed chunk the upload resumes where the server
+// says it left off.
+const uploadMedia = async (token, file, onProgress) => {
+  const headers = { 'Authorization': `Bearer ${token}` };
+  if (file.size <= UPLOAD_CHUNK_SIZE) {
+    const form = new FormData();
+    form.append('file', file);
+    const response = await fetch(`${POST_API_BASE_URL}/api/media`, { method: 'POST', headers, body: form });
+    if (!response.ok) {
+      throw new Error(await errorMessage(response, 'Failed to upload the file.'));
+    }
+    onProgress(1);
+    return response.json();
+  }
+  const start = await fetch(`${POST_API_BASE_URL}/api/media/uploads`, {
+    method: 'POST',
+    headers: { ...headers, 'Content-Type': 'application/json' },
+    body: JSON.stringify({ filename: file.name, sizeBytes: file.size }),
+  });
+  if (!start.ok) {
+    throw new Error(await errorMessage(start, 'Failed to start the upload.'));
+  }
+  const upload = await start.json();
+  const uploadUrl = `${POST_API_BASE_URL}/api/media/uploads/${upload.id}`;
+  let offset = 0;
+  let failures = 0;
+  for (;;) {
+    const end = Math.min(offset + UPLOAD_CHUNK_SIZE, file.size);
+    let response;
+    try {
+      response = await fetch(uploadUrl, {
+        method: 'PUT',
+        headers: { ...headers, 'Content-Range': `bytes ${offset}-${end - 1}/${file.size}` },
+        body: file.slice(offset, end),
+      });
+    } catch (error) {
+      response = null;
+    }
+    if (response && (response.status === 200 || response.status === 201)) {
+      const body = await response.json();
+      if (body.sha256) {
+        onProgress(1);
+        return body;
+      }
+      offset = body.receivedBytes;
+      failures = 0;
+      onProgress(offset / file.size);
+      continue;
+    }
+    if (response && response.status !== 409 && response.status < 500) {
+      throw new Error(await errorMessage(response, 'Failed to upload the file.'));
+    }
+    failures += 1;
+    if (failures > 3) {
+      throw new Error('The upload keeps failing; check your connection and try again.');
+    }
+    const status = await fetch(uploadUrl, { headers });
+    if (!status.ok) {
+      throw new Error(await errorMessage(status, 'Failed to resume the upload.'));
+    }
+    offset = (await status.json()).receivedBytes;
+  }
+};
+
+// Posts a JSON body to the Auth Service and resolves to the response.
+const postAuth = (path, body) =>
+  fetch(`${AUTH_API_BASE_URL}${path}`, {
+    method: 'POST',
+    credentials: 'include',
+    headers: { 'Content-Type': 'application/json' },
+    body: JSON.stringify(body),
+  });
+
+// Login component with email/password, magic-link and OAuth sign-in.
+const Login = ({ onSignedIn }) => {
+  const [mode, setMode] = useState(new URLSearchParams(window.location.search).get('mfa') ? 'mfa' : 'signin');
+  const [name, setName] = useState('');
+  const [email, setEmail] = useState('');
+  const [password, setPassword] = useState('');
+  const [code, setCode] = useState('');
+  const [isSubmitting, setIsSubmitting] = useState(false);
+  const [error, setError] = useState(null);
+  const [notice, setNotice] = useState(
+    new URLSearchParams(window.location.search).get('emailVerified') ? 'Your email address is confirmed. You can sign in now.' : null
+  );
+
   const handleLogin = (platform) => {
     window.location.href = `${AUTH_API_BASE_URL}/oauth/${platform}/login`;
   };
 
+  const switchMode = (next) => {
+    setMode(next);
+    setError(null);
+    setNotice(null);
+  };
+
+  const handleSubmit = async (e) => {
+    e.preventDefault();
+    setIsSubmitting(true);
+    setError(null);
+    setNotice(null);
+    try {
+      let response;
+      switch (mode) {
+        case 'register':
+          response = await postAuth('/auth/register', { name, email, password });
+          if (response.ok) {
+            setNotice('Check your inbox for a link to confirm your email address.');
+          }
+          break;
+        case 'forgot':
+          response = await postAuth('/auth/password/forgot', { email });
+          if (response.ok) {
+            setNotice('If an account uses that address, we have emailed it a link to reset the password.');
+          }
+          break;
+        case 'mfa':
+          response = await postAuth('/auth/mfa/verify', { code });
+          if (response.ok) {
+            await onSignedIn();
+            return;
+          }
+          break;
+        case 'magic':
+          response = await postAuth('/auth/magic-link', { email });
+          if (response.ok) {
+            setNotice('If an account uses that address, we have emailed it a sign-in link.');
+          }
+          break;
+        default:
+          response = await postAuth('/auth/login', { email, password });
+          if (response.status === 200 && (await response.json()).mfaRequired) {
+            setMode('mfa');
+            return;
+          }
+          if (response.ok) {
+            await onSignedIn();
+            return;
+          }
+          if (response.status === 403) {
+            await postAuth('/auth/verify-email/resend', { email });
+            setError('Please confirm your email address first. We have sent you a new confirmation link.');
+            return;
+          }
+      }
+      if (!response.ok) {
+        setError((await response.text()).trim() || 'Something went wrong. Please try again.');
+      }
+    } catch (err) {
+      console.error('Authentication request failed:', err);
+      setError('Could not reach the server. Please try again.');
+    } finally {
+      setIsSubmitting(false);
+    }
+  };
+
+  const titles = { signin: 'Welcome', register: 'Create an account', forgot: 'Reset your password', magic: 'Email me a sign-in link', mfa: 'Two-factor authentication' };
+  const submitLabels = { signin: 'Sign in', register: 'Create account', forgot: 'Send reset link', magic: 'Send sign-in link', mfa: 'Verify' };
+  const needsPassword = mode === 'signin' || mode === 'register';
+
   return (
     <div className="min-h-screen flex items-center justify-center bg-gray-100 p-6">
       <div className="bg-white p-10 rounded-xl shadow-2xl text-center max-w-md w-full space-y-6">
-        <h1 className="text-4xl font-extrabold text-gray-900">Welcome</h1>
-        <p className="text-gray-600">Please connect your social media accounts to get started.</p>
+        <h1 className="text-4xl font-extrabold text-gray-900">{titles[mode]}</h1>
+        {notice && <p className="p-3 rounded-lg bg-green-100 text-green-800 text-sm">{notice}</p>}
+        {error && <p className="p-3 rounded-lg bg-red-100 text-red-800 text-sm">{error}</p>}
+        <form onSubmit={handleSubmit} className="space-y-4 text-left">
+          {mode === 'register' && (
+            <input
+              type="text"
+              value={name}
+              onChange={(e) => setName(e.target.value)}
+              placeholder="Name"
+              className="w-full p-3 border border-gray-300 rounded-lg focus:ring-blue-500 focus:border-blue-500"
+            />
+          )}
+          {mode === 'mfa' ? (
+            <>
+              <p className="text-gray-600 text-sm">Enter the 6-digit code from your authenticator app, or one of your recovery codes.</p>
+              <input
+                type="text"
+                value={code}
+                onChange={(e) => setCode(e.target.value)}
+                placeholder="123456"
+                autoComplete="one-time-code"
+                autoFocus
+                required
+                className="w-full p-3 border border-gray-300 rounded-lg focus:ring-blue-500 focus:border-blue-500 tracking-widest text-center"
+              />
+            </>
+          ) : (
+            <input
+              type="email"
+              value={email}
+              onChange={(e) => setEmail(e.target.value)}
+              placeholder="Email"
+              autoComplete="email"
+              required
+              className="w-full p-3 border border-gray-300 rounded-lg focus:ring-blue-500 focus:border-blue-500"
+            />
+          )}
+          {needsPassword && (
+            <input
+              type="password"
+              value={password}
+              onChange={(e) => setPassword(e.target.value)}
+              placeholder={mode === 'register' ? 'Password (at least 12 characters)' : 'Password'}
+              autoComplete={mode === 'register' ? 'new-password' : 'current-password'}
+              minLength={mode === 'register' ? 12 : undefined}
+              required
+              className="w-full p-3 border border-gray-300 rounded-lg focus:ring-blue-500 focus:border-blue-500"
+            />
+          )}
+          <button
+            type="submit"
+            disabled={isSubmitting}
+            className="w-full py-3 px-4 rounded-lg text-white font-semibold transition-colors bg-blue-600 hover:bg-blue-700 disabled:bg-gray-400 flex items-center justify-center"
+          >
+            {isSubmitting && <Loader2 className="animate-spin mr-2 h-5 w-5" />}
+            {submitLabels[mode]}
+          </button>
+        </form>
+        <div className="flex flex-wrap justify-center gap-x-4 gap-y-1 text-sm text-blue-600">
+          {mode !== 'signin' && <button onClick={() => switchMode('signin')} className="hover:underline">Sign in with a password</button>}
+          {mode !== 'register' && <button onClick={() => switchMode('register')} className="hover:underline">Create an account</button>}
+          {mode !== 'forgot' && <button onClick={() => switchMode('forgot')} className="hover:underline">Forgot password?</button>}
+          {mode !== 'magic' && <button onClick={() => switchMode('magic')} className="hover:underline">Email me a sign-in link</button>}
+        </div>
+        <p className="text-gray-600">Or continue with a social media account.</p>
         <button
           onClick={() => handleLogin('meta')}
           className="w-full py-3 px-4 rounded-lg text-white font-semibold transition-colors bg-blue-600 hover:bg-blue-700 focus:outline-none focus:ring-2 focus:ring-blue-500 flex items-center justify-center"
@@ -171,27 +466,514 @@ const Login = () => {
   );
 };
 
-// Component to handle the redirect from the OAuth callback.
-const AuthSuccess = ({ setToken }) => {
+// Security settings: TOTP two-factor enrollment and recovery codes.
+const Security = ({ token, onSessionUpgraded }) => {
+  const [status, setStatus] = useState(null);
+  const [enrollment, setEnrollment] = useState(null);
+  const [code, setCode] = useState('');
+  const [recoveryCodes, setRecoveryCodes] = useState(null);
+  const [error, setError] = useState(null);
+
+  const authFetch = (path, options = {}) =>
+    fetch(`${AUTH_API_BASE_URL}${path}`, {
+      ...options,
+      headers: { 'Authorization': `Bearer ${token}`, 'Content-Type': 'application/json', ...options.headers },
+    });
+
+  const loadStatus = async () => {
+    try {
+      const response = await authFetch('/auth/mfa');
+      if (!response.ok) {
+        throw new Error('Failed to load two-factor status.');
+      }
+      setStatus(await response.json());
+    } catch (e) {
+      console.error('Security error:', e);
+      setError(e.message);
+    }
+  };
+
   useEffect(() => {
-    const urlParams = new URLSearchParams(window.location.search);
-    const jwtToken = urlParams.get('token');
-    
-    if (jwtToken) {
-      localStorage.setItem('jwtToken', jwtToken);
-      setToken(jwtToken);
-      window.location.href = '/';
-    } else {
-      console.error('JWT token not found in URL.');
-      window.location.href = '/login';
+    loadStatus();
+    // eslint-disable-next-line react-hooks/exhaustive-deps
+  }, [token]);
+
+  const startEnrollment = async () => {
+    setError(null);
+    const response = await authFetch('/auth/mfa/totp', { method: 'POST' });
+    if (!response.ok) {
+      setError((await response.text()).trim());
+      return;
+    }
+    setEnrollment(await response.json());
+  };
+
+  const confirmEnrollment = async (e) => {
+    e.preventDefault();
+    setError(null);
+    const response = await authFetch('/auth/mfa/totp/confirm', { method: 'POST', body: JSON.stringify({ code }) });
+    if (!response.ok) {
+      setError((await response.text()).trim());
+      return;
+    }
+    const data = await response.json();
+    setRecoveryCodes(data.recoveryCodes);
+    setEnrollment(null);
+    setCode('');
+    await onSessionUpgraded();
+  };
+
+  const regenerateCodes = async () => {
+    setError(null);
+    const response = await authFetch('/auth/mfa/recovery-codes', { method: 'POST' });
+    if (!response.ok) {
+      setError((await response.text()).trim());
+      return;
+    }
+    setRecoveryCodes((await response.json()).recoveryCodes);
+    loadStatus();
+  };
+
+  const disable = async () => {
+    const disableCode = window.prompt('To turn off two-factor authentication, enter a code from your authenticator app or a recovery code.');
+    if (!disableCode) {
+      return;
+    }
+    setError(null);
+    const response = await authFetch('/auth/mfa/totp', { method: 'DELETE', body: JSON.stringify({ code: disableCode }) });
+    if (!response.ok) {
+      setError((await response.text()).trim());
+      return;
+    }
+    setRecoveryCodes(null);
+    loadStatus();
+  };
+
+  if (!status) {
+    return (
+      <div className="flex items-center justify-center h-full">
+        {error ? <p className="text-red-500 font-bold">{error}</p> : <Loader2 className="animate-spin text-blue-600 w-12 h-12" />}
+      </div>
+    );
+  }
+
+  return (
+    <div className="p-6 md:p-10 space-y-8">
+      <h1 className="text-4xl font-bold text-gray-900 mb-6">Security</h1>
+      <div className="bg-white p-6 rounded-xl shadow-lg space-y-4 max-w-2xl">
+        <h2 className="text-2xl font-semibold text-gray-800">Two-factor authentication</h2>
+        {error && <p className="p-3 rounded-lg bg-red-100 text-red-800 text-sm">{error}</p>}
+        {recoveryCodes && (
+          <div className="p-4 rounded-lg bg-yellow-50 border border-yellow-200">
+            <p className="font-semibold text-gray-800 mb-2">Save these recovery codes somewhere safe. Each works once, and they will not be shown again.</p>
+            <ul className="grid grid-cols-2 gap-1 font-mono text-sm">
+              {recoveryCodes.map((c) => <li key={c}>{c}</li>)}
+            </ul>
+          </div>
+        )}
+        {status.totpEnabled && !enrollment && (
+          <>
+            <p className="text-gray-600">Two-factor authentication is on. {status.recoveryCodesRemaining} recovery codes remain.</p>
+            {!status.sessionVerified && <p className="text-sm text-gray-500">Sign in again with your authenticator code to change these settings.</p>}
+            <div className="flex flex-wrap gap-3">
+              <button onClick={regenerateCodes} disabled={!status.sessionVerified} className="py-2 px-4 rounded-lg border border-gray-300 text-gray-700 hover:bg-gray-100 disabled:opacity-50">
+                New recovery codes
+              </button>
+              <button onClick={disable} disabled={!status.sessionVerified} className="py-2 px-4 rounded-lg border border-red-300 text-red-600 hover:bg-red-50 disabled:opacity-50">
+                Turn off
+              </button>
+            </div>
+          </>
+        )}
+        {!status.totpEnabled && !enrollment && (
+          <>
+            <p className="text-gray-600">Protect your account with a code from an authenticator app in addition to your usual sign-in.</p>
+            <button onClick={startEnrollment} className="py-2 px-4 rounded-lg bg-blue-600 text-white hover:bg-blue-700">
+              Set up authenticator app
+            </button>
+          </>
+        )}
+        {enrollment && (
+          <form onSubmit={confirmEnrollment} className="space-y-4">
+            <p className="text-gray-600">
+              Add this account to your authenticator app by opening the <a href={enrollment.provisioningUri} className="text-blue-600 hover:underline">setup link</a> on your phone, or by entering the key below. Then type the 6-digit code it shows.
+            </p>
+            <p className="font-mono text-sm bg-gray-100 p-3 rounded-lg break-all">{enrollment.secret}</p>
+            <input
+              type="text"
+              value={code}
+              onChange={(e) => setCode(e.target.value)}
+              placeholder="123456"
+              autoComplete="one-time-code"
+              required
+              className="w-full p-3 border border-gray-300 rounded-lg focus:ring-blue-500 focus:border-blue-500 tracking-widest text-center"
+            />
+            <button type="submit" className="py-2 px-4 rounded-lg bg-blue-600 text-white hover:bg-blue-700">
+              Turn on
+            </button>
+          </form>
+        )}
+      </div>
+    </div>
+  );
+};
+
+// Switches the session between the tenants (workspaces) the user belongs to.
+const TenantSwitcher = ({ token, onSwitched }) => {
+  const [tenants, setTenants] = useState([]);
+
+  useEffect(() => {
+    fetch(`${AUTH_API_BASE_URL}/auth/tenants`, { headers: { 'Authorization': `Bearer ${token}` } })
+      .then((response) => (response.ok ? response.json() : []))
+      .then(setTenants)
+      .catch((err) => console.error('Failed to load tenants:', err));
+  }, [token]);
+
+  const handleChange = async (e) => {
+    const response = await fetch(`${AUTH_API_BASE_URL}/auth/tenants/${e.target.value}/switch`, {
+      method: 'POST',
+      headers: { 'Authorization': `Bearer ${token}` },
+    });
+    if (response.ok) {
+      onSwitched(await response.json());
+    }
+  };
+
+  if (tenants.length < 2) {
+    return null;
+  }
+  const current = tenants.find((t) => t.current);
+  return (
+    <select
+      value={current ? current.id : ''}
+      onChange={handleChange}
+      className="w-full mb-6 p-2 rounded-lg bg-gray-800 text-gray-200 border border-gray-700"
+    >
+      {tenants.map((t) => <option key={t.id} value={t.id}>{t.name}</option>)}
+    </select>
+  );
+};
+
+// Team page: members, roles and invitations of the current tenant.
+const Team = ({ token }) => {
+  const [tenant, setTenant] = useState(null);
+  const [members, setMembers] = useState([]);
+  const [invitations, setInvitations] = useState([]);
+  const [inviteEmail, setInviteEmail] = useState('');
+  const [inviteRole, setInviteRole] = useState('editor');
+  const [newTenantName, setNewTenantName] = useState('');
+  const [requiredApprovals, setRequiredApprovals] = useState(0);
+  const [error, setError] = useState(null);
+  const [notice, setNotice] = useState(null);
+
+  const authFetch = (path, options = {}) =>
+    fetch(`${AUTH_API_BASE_URL}${path}`, {
+      ...options,
+      headers: { 'Authorization': `Bearer ${token}`, 'Content-Type': 'application/json', ...options.headers },
+    });
+
+  const load = async () => {
+    try {
+      const tenantsResponse = await authFetch('/auth/tenants');
+      if (!tenantsResponse.ok) {
+        throw new Error('Failed to load your workspaces.');
+      }
+      const current = (await tenantsResponse.json()).find((t) => t.current);
+      setTenant(current);
+      if (!current) {
+        return;
+      }
+      const membersResponse = await authFetch(`/auth/tenants/${current.id}/members`);
+      setMembers(membersResponse.ok ? await membersResponse.json() : []);
+      if (current.role === 'owner' || current.role === 'admin') {
+        const invitationsResponse = await authFetch(`/auth/tenants/${current.id}/invitations`);
+        setInvitations(invitationsResponse.ok ? await invitationsResponse.json() : []);
+      }
+      const settingsResponse = await fetch(`${POST_API_BASE_URL}/api/settings`, {
+        headers: { 'Authorization': `Bearer ${token}` },
+      });
+      if (settingsResponse.ok) {
+        setRequiredApprovals((await settingsResponse.json()).requiredApprovals);
+      }
+    } catch (e) {
+      console.error('Team error:', e);
+      setError(e.message);
+    }
+  };
+
+  useEffect(() => {
+    load();
+    // eslint-disable-next-line react-hooks/exhaustive-deps
+  }, [token]);
+
+  // Runs a mutating request and reloads the page data, surfacing the server's message on failure.
+  const mutate = async (path, options, successNotice) => {
+    setError(null);
+    setNotice(null);
+    const response = await authFetch(path, options);
+    if (!response.ok) {
+      setError((await response.text()).trim());
+      return false;
+    }
+    if (successNotice) {
+      setNotice(successNotice);
     }
-  }, [setToken]);
+    await load();
+    return true;
+  };
+
+  const handleInvite = async (e) => {
+    e.preventDefault();
+    if (await mutate(`/auth/tenants/${tenant.id}/invitations`, { method: 'POST', body: JSON.stringify({ email: inviteEmail, role: inviteRole }) }, `Invitation sent to ${inviteEmail}.`)) {
+      setInviteEmail('');
+    }
+  };
+
+  const handleSaveApprovals = async (e) => {
+    e.preventDefault();
+    setError(null);
+    setNotice(null);
+    const response = await fetch(`${POST_API_BASE_URL}/api/settings`, {
+      method: 'PUT',
+      headers: { 'Authorization': `Bearer ${token}`, 'Content-Type': 'application/json' },
+      body: JSON.stringify({ requiredApprovals: Number(requiredApprovals) }),
+    });
+    if (!response.ok) {
+      setError(await errorMessage(response, 'Failed to save the approval setting.'));
+      return;
+    }
+    setNotice('Saved. The new setting applies to posts submitted from now on.');
+  };
+
+  const handleCreateTenant = async (e) => {
+    e.preventDefault();
+    if (await mutate('/auth/tenants', { method: 'POST', body: JSON.stringify({ name: newTenantName }) }, `Created ${newTenantName}. Switch to it from the menu.`)) {
+      setNewTenantName('');
+    }
+  };
+
+  if (!tenant) {
+    return (
+      <div className="flex items-center justify-center h-full">
+        {error ? <p className="text-red-500 font-bold">{error}</p> : <Loader2 className="animate-spin text-blue-600 w-12 h-12" />}
+      </div>
+    );
+  }
+
+  const canManage = tenant.role === 'owner' || tenant.role === 'admin';
+  const roles = tenant.role === 'owner' ? ['owner', 'admin', 'editor', 'viewer'] : ['admin', 'editor', 'viewer'];
+
+  return (
+    <div className="p-6 md:p-10 space-y-8">
+      <h1 className="text-4xl font-bold text-gray-900 mb-6">{tenant.name}</h1>
+      {error && <p className="p-3 rounded-lg bg-red-100 text-red-800 text-sm">{error}</p>}
+      {notice && <p className="p-3 rounded-lg bg-green-100 text-green-800 text-sm">{notice}</p>}
+
+      <div className="bg-white p-6 rounded-xl shadow-lg">
+        <h2 className="text-2xl font-semibold text-gray-800 mb-6">Members</h2>
+        <ul className="divide-y divide-gray-200">
+          {members.map((m) => (
+            <li key={m.userId} className="py-3 flex flex-wrap items-center justify-between gap-3">
+              <div>
+                <p className="font-semibold text-gray-800">{m.name || m.email || m.userId}</p>
+                {m.email && <p className="text-sm text-gray-500">{m.email}</p>}
+              </div>
+              <div className="flex items-center gap-3">
+                {canManage && (tenant.role === 'owner' || m.role !== 'owner') ? (
+                  <select
+                    value={m.role}
+                    onChange={(e) => mutate(`/auth/tenants/${tenant.id}/members/${m.userId}`, { method: 'PUT', body: JSON.stringify({ role: e.target.value }) })}
+                    className="p-2 border border-gray-300 rounded-lg"
+                  >
+                    {roles.map((r) => <option key={r} value={r}>{r}</option>)}
+                  </select>
+                ) : (
+                  <span className="text-sm text-gray-600">{m.role}</span>
+                )}
+                {canManage && (tenant.role === 'owner' || m.role !== 'owner') && (
+                  <button
+                    onClick={() => window.confirm(`Remove ${m.name || m.email} from ${tenant.name}?`) && mutate(`/auth/tenants/${tenant.id}/members/${m.userId}`, { method: 'DELETE' })}
+                    className="text-sm text-red-600 hover:underline"
+                  >
+                    Remove
+                  </button>
+                )}
+              </div>
+            </li>
+          ))}
+        </ul>
+      </div>
+
+      {canManage && (
+        <div className="bg-white p-6 rounded-xl shadow-lg space-y-4">
+          <h2 className="text-2xl font-semibold text-gray-800">Invite people</h2>
+          <form onSubmit={handleInvite} className="flex flex-wrap gap-3">
+            <input
+              type="email"
+              value={inviteEmail}
+              onChange={(e) => setInviteEmail(e.target.value)}
+              placeholder="colleague@example.com"
+              required
+              className="flex-grow p-3 border border-gray-300 rounded-lg focus:ring-blue-500 focus:border-blue-500"
+            />
+            <select value={inviteRole} onChange={(e) => setInviteRole(e.target.value)} className="p-3 border border-gray-300 rounded-lg">
+              {roles.map((r) => <option key={r} value={r}>{r}</option>)}
+            </select>
+            <button type="submit" className="py-3 px-4 rounded-lg bg-blue-600 text-white hover:bg-blue-700">Send invitation</button>
+          </form>
+          {invitations.length > 0 && (
+            <ul className="divide-y divide-gray-200">
+              {invitations.map((inv) => (
+                <li key={inv.id} className="py-3 flex items-center justify-between">
+                  <span className="text-gray-700">{inv.email} <span className="text-sm text-gray-500">({inv.role}, expires {new Date(inv.expiresAt).toLocaleDateString()})</span></span>
+                  <button onClick={() => mutate(`/auth/tenants/${tenant.id}/invitations/${inv.id}`, { method: 'DELETE' })} className="text-sm text-red-600 hover:underline">
+                    Revoke
+                  </button>
+                </li>
+              ))}
+            </ul>
+          )}
+        </div>
+      )}
+
+      <div className="bg-white p-6 rounded-xl shadow-lg space-y-4">
+        <h2 className="text-2xl font-semibold text-gray-800">Post approvals</h2>
+        <p className="text-gray-600">
+          Posts are published only after this many members other than the author have approved them. With 0, submitted posts are scheduled right away.
+        </p>
+        <form onSubmit={handleSaveApprovals} className="flex flex-wrap items-center gap-3">
+          <input
+            type="number"
+            min="0"
+            max="10"
+            value={requiredApprovals}
+            onChange={(e) => setRequiredApprovals(e.target.value)}
+            disabled={!canManage}
+            className="w-24 p-3 border border-gray-300 rounded-lg focus:ring-blue-500 focus:border-blue-500"
+          />
+          {canManage && (
+            <button type="submit" className="py-3 px-4 rounded-lg bg-blue-600 text-white hover:bg-blue-700">Save</button>
+          )}
+        </form>
+      </div>
+
+      <div className="bg-white p-6 rounded-xl shadow-lg space-y-4">
+        <h2 className="text-2xl font-semibold text-gray-800">New workspace</h2>
+        <form onSubmit={handleCreateTenant} className="flex flex-wrap gap-3">
+          <input
+            type="text"
+            value={newTenantName}
+            onChange={(e) => setNewTenantName(e.target.value)}
+            placeholder="Workspace name"
+            required
+            className="flex-grow p-3 border border-gray-300 rounded-lg focus:ring-blue-500 focus:border-blue-500"
+          />
+          <button type="submit" className="py-3 px-4 rounded-lg border border-gray-300 text-gray-700 hover:bg-gray-100">Create</button>
+        </form>
+      </div>
+    </div>
+  );
+};
+
+// Page behind the link in the password reset email.
+const ResetPassword = () => {
+  const token = new URLSearchParams(window.location.search).get('token') || '';
+  const [password, setPassword] = useState('');
+  const [confirmation, setConfirmation] = useState('');
+  const [isSubmitting, setIsSubmitting] = useState(false);
+  const [isDone, setIsDone] = useState(false);
+  const [error, setError] = useState(null);
+
+  const handleSubmit = async (e) => {
+    e.preventDefault();
+    if (password !== confirmation) {
+      setError('The passwords do not match.');
+      return;
+    }
+    setIsSubmitting(true);
+    setError(null);
+    try {
+      const response = await postAuth('/auth/password/reset', { token, password });
+      if (!response.ok) {
+        setError((await response.text()).trim() || 'Failed to reset the password.');
+        return;
+      }
+      setIsDone(true);
+    } catch (err) {
+      console.error('Password reset failed:', err);
+      setError('Could not reach the server. Please try again.');
+    } finally {
+      setIsSubmitting(false);
+    }
+  };
 
+  return (
+    <div className="min-h-screen flex items-center justify-center bg-gray-100 p-6">
+      <div className="bg-white p-10 rounded-xl shadow-2xl text-center max-w-md w-full space-y-6">
+        <h1 className="text-4xl font-extrabold text-gray-900">Choose a new password</h1>
+        {isDone ? (
+          <>
+            <p className="p-3 rounded-lg bg-green-100 text-green-800 text-sm">Your password has been changed and every other session has been signed out.</p>
+            <a href="/login" className="text-blue-600 hover:underline">Continue to sign in</a>
+          </>
+        ) : (
+          <form onSubmit={handleSubmit} className="space-y-4 text-left">
+            {error && <p className="p-3 rounded-lg bg-red-100 text-red-800 text-sm">{error}</p>}
+            <input
+              type="password"
+              value={password}
+              onChange={(e) => setPassword(e.target.value)}
+              placeholder="New password (at least 12 characters)"
+              autoComplete="new-password"
+              minLength={12}
+              required
+              className="w-full p-3 border border-gray-300 rounded-lg focus:ring-blue-500 focus:border-blue-500"
+            />
+            <input
+              type="password"
+              value={confirmation}
+              onChange={(e) => setConfirmation(e.target.value)}
+              placeholder="Repeat the new password"
+              autoComplete="new-password"
+              required
+              className="w-full p-3 border border-gray-300 rounded-lg focus:ring-blue-500 focus:border-blue-500"
+            />
+            <button
+              type="submit"
+              disabled={isSubmitting || !token}
+              className="w-full py-3 px-4 rounded-lg text-white font-semibold transition-colors bg-blue-600 hover:bg-blue-700 disabled:bg-gray-400 flex items-center justify-center"
+            >
+              {isSubmitting && <Loader2 className="animate-spin mr-2 h-5 w-5" />}
+              Set password
+            </button>
+          </form>
+        )}
+      </div>
+    </div>
+  );
+};
+
+// Trades the HttpOnly refresh cookie for a short-lived access token.
+// Resolves to { accessToken, expiresAt } or null when there is no valid session.
+const refreshSession = async () => {
+  const response = await fetch(`${AUTH_API_BASE_URL}/auth/refresh`, {
+    method: 'POST',
+    credentials: 'include',
+  });
+  if (!response.ok) {
+    return null;
+  }
+  return response.json();
+};
+
+// Shown while the session is restored, including on the redirect from the OAuth callback.
+const SessionLoader = () => {
+  const isAuthRedirect = window.location.pathname === '/auth-success';
   return (
     <div className="flex items-center justify-center min-h-screen bg-gray-100">
       <div className="text-center p-10">
-        <h1 className="text-3xl font-bold">Authentication Successful!</h1>
-        <p className="mt-4 text-gray-600">Redirecting to your dashboard...</p>
+        <h1 className="text-3xl font-bold">{isAuthRedirect ? 'Authentication Successful!' : 'Signing you in'}</h1>
+        <p className="mt-4 text-gray-600">{isAuthRedirect ? 'Redirecting to your dashboard...' : 'Restoring your session...'}</p>
         <Loader2 className="animate-spin text-blue-600 w-12 h-12 mx-auto mt-6" />
       </div>
     </div>
@@ -199,10 +981,18 @@ const AuthSuccess = ({ setToken }) => {
 };
 
 // New Post Creator component.
+// Selecting several accounts creates a campaign with one post per account.
 const PostCreator = ({ token, onPostCreated }) => {
   const [content, setContent] = useState('');
-  const [platform, setPlatform] = useState('');
+  const [selected, setSelected] = useState([]);
+  const [captions, setCaptions] = useState({});
+  const [campaignName, setCampaignName] = useState('');
+  const [hashtags, setHashtags] = useState('');
+  const [media, setMedia] = useState(null);
+  const [uploadProgress, setUploadProgress] = useState(null);
+  const [renditions, setRenditions] = useState([]);
   const [scheduledAt, setScheduledAt] = useState('');
+  const [feedback, setFeedback] = useState([]);
   const [isSubmitting, setIsSubmitting] = useState(false);
   const [accounts, setAccounts] = useState([]);
 
@@ -214,10 +1004,7 @@ const PostCreator = ({ token, onPostCreated }) => {
           headers: { 'Authorization': `Bearer ${token}` },
         });
         const data = await response.json();
-        setAccounts(data);
-        if (data.length > 0) {
-          setPlatform(data[0].platform);
-        }
+        setAccounts(data || []);
       } catch (error) {
         console.error('Failed to fetch accounts:', error);
       }
@@ -225,8 +1012,96 @@ const PostCreator = ({ token, onPostCreated }) => {
     fetchAccounts();
   }, [token]);
 
-  const handleSubmit = async (e) => {
+  // Checks the draft against each selected account's platform rules as it is typed.
+  useEffect(() => {
+    if (selected.length === 0) {
+      setFeedback([]);
+      return undefined;
+    }
+    const timer = setTimeout(async () => {
+      const results = await Promise.all(selected.map(async (id) => {
+        const account = accounts.find((a) => a.platformUserId === id);
+        const tags = selected.length > 1 ? hashtags.split(/[\s,]+/).filter(Boolean).map((t) => `#${t.replace(/^#+/, '')}`) : [];
+        const caption = (selected.length > 1 && captions[id]) || content;
+        const response = await fetch(`${POST_API_BASE_URL}/api/posts/validate`, {
+          method: 'POST',
+          headers: { 'Content-Type': 'application/json', 'Authorization': `Bearer ${token}` },
+          body: JSON.stringify({
+            platform: account.platform,
+            platformUserId: id,
+            content: tags.length > 0 ? `${caption}\n\n${tags.join(' ')}` : caption,
+            mediaId: media ? media.id : undefined,
+            scheduledAt: scheduledAt ? new Date(scheduledAt).toISOString() : undefined,
+          }),
+        });
+        if (!response.ok) {
+          return [];
+        }
+        const result = await response.json();
+        return [...result.errors, ...result.warnings.map((w) => ({ ...w, warning: true }))]
+          .filter((f) => f.field !== 'scheduledAt')
+          .map((f) => ({ ...f, account: `${account.platform} (${account.username})` }));
+      }));
+      setFeedback(results.flat());
+    }, 400);
+    return () => clearTimeout(timer);
+  }, [token, accounts, selected, content, captions, hashtags, media, scheduledAt]);
+
+  // Follows the jobs that make the uploaded media's platform renditions.
+  useEffect(() => {
+    setRenditions([]);
+    if (!media) return undefined;
+    let timer;
+    const poll = async () => {
+      try {
+        const response = await fetch(`${POST_API_BASE_URL}/api/media/${media.id}/renditions`, {
+          headers: { 'Authorization': `Bearer ${token}` },
+        });
+        if (!response.ok) return;
+        const jobs = await response.json();
+        setRenditions(jobs);
+        if (jobs.some((job) => job.status === 'queued' || job.status === 'processing')) {
+          timer = setTimeout(poll, 2000);
+        }
+      } catch (error) {
+        console.error('Failed to fetch renditions:', error);
+      }
+    };
+    poll();
+    return () => clearTimeout(timer);
+  }, [token, media]);
+
+  const handleFile = async (e) => {
+    const file = e.target.files[0];
+    setMedia(null);
+    if (!file) return;
+    setUploadProgress(0);
+    try {
+      setMedia(await uploadMedia(token, file, setUploadProgress));
+    } catch (error) {
+      console.error('Error uploading media:', error);
+      alert(error.message);
+      e.target.value = '';
+    } finally {
+      setUploadProgress(null);
+    }
+  };
+
+  // Creates the post as a draft and, unless saveOnly, submits it for approval.
+  const handleSubmit = async (e, saveOnly = false) => {
     e.preventDefault();
+    if (saveOnly && !e.currentTarget.form.reportValidity()) {
+      return;
+    }
+    if (selected.length === 0) {
+      alert('Select at least one account.');
+      return;
+    }
+    if (selected.length > 1) {
+      createCampaign(saveOnly);
+      return;
+    }
+    const account = accounts.find(acc => acc.platformUserId === selected[0]);
     setIsSubmitting(true);
     try {
       const response = await fetch(`${POST_API_BASE_URL}/api/posts`, {
@@ -236,49 +1111,109 @@ const PostCreator = ({ token, onPostCreated }) => {
           'Authorization': `Bearer ${token}`,
         },
         body: JSON.stringify({
-          platform: platform,
-          content: content,
+          platform: account.platform,
+          platformUserId: account.platformUserId,
+          content: content,
+          mediaId: media ? media.id : undefined,
+          scheduledAt: new Date(scheduledAt).toISOString(),
+        }),
+      });
+
+      if (!response.ok) {
+        throw new Error(await errorMessage(response, 'Failed to create post.'));
+      }
+
+      let newPost = await response.json();
+      if (!saveOnly) {
+        const submitResponse = await fetch(`${POST_API_BASE_URL}/api/posts/${newPost.id}/submit`, {
+          method: 'POST',
+          headers: { 'Authorization': `Bearer ${token}` },
+        });
+        if (!submitResponse.ok) {
+          throw new Error(await errorMessage(submitResponse, 'The post was saved as a draft but could not be submitted.'));
+        }
+        newPost = await submitResponse.json();
+      }
+      console.log('Post created:', newPost);
+      alert(newPost.status === 'scheduled' ? 'Post scheduled successfully!'
+        : newPost.status === 'pending_approval' ? 'Post submitted for approval.'
+        : 'Draft saved.');
+      if (onPostCreated) {
+        onPostCreated();
+      }
+    } catch (error) {
+      console.error('Error creating post:', error);
+      alert(error.message || 'Failed to schedule post.');
+    } finally {
+      setIsSubmitting(false);
+    }
+  };
+
+  const createCampaign = async (saveOnly) => {
+    setIsSubmitting(true);
+    try {
+      const response = await fetch(`${POST_API_BASE_URL}/api/campaigns`, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json', 'Authorization': `Bearer ${token}` },
+        body: JSON.stringify({
+          name: campaignName,
+          content,
+          mediaId: media ? media.id : undefined,
+          hashtags: hashtags.split(/[\s,]+/).filter(Boolean),
           scheduledAt: new Date(scheduledAt).toISOString(),
+          targets: selected.map((id) => ({ platformUserId: id, caption: captions[id] || undefined })),
+          submit: !saveOnly,
         }),
       });
-
       if (!response.ok) {
-        throw new Error('Failed to create post.');
+        throw new Error(await errorMessage(response, 'Failed to create campaign.'));
       }
-
-      const newPost = await response.json();
-      console.log('Post created:', newPost);
-      alert('Post scheduled successfully!');
+      const campaign = await response.json();
+      alert(saveOnly ? `Campaign saved with ${campaign.posts.length} draft posts.` : `Campaign created with ${campaign.posts.length} posts.`);
       if (onPostCreated) {
         onPostCreated();
       }
     } catch (error) {
-      console.error('Error creating post:', error);
-      alert('Failed to schedule post.');
+      console.error('Error creating campaign:', error);
+      alert(error.message);
     } finally {
       setIsSubmitting(false);
     }
   };
 
+  const toggleAccount = (id) =>
+    setSelected(selected.includes(id) ? selected.filter((s) => s !== id) : [...selected, id]);
+
   return (
     <div className="p-6 md:p-10">
       <h1 className="text-4xl font-bold text-gray-900 mb-6">Create New Post</h1>
       <div className="bg-white p-6 rounded-xl shadow-lg max-w-2xl mx-auto">
         <form onSubmit={handleSubmit} className="space-y-6">
           <div>
-            <label className="block text-gray-700 font-semibold mb-2">Platform</label>
-            <select
-              value={platform}
-              onChange={(e) => setPlatform(e.target.value)}
-              className="w-full p-3 border border-gray-300 rounded-lg focus:ring-2 focus:ring-blue-500 focus:border-blue-500"
-              required
-            >
-              <option value="">Select a platform</option>
+            <label className="block text-gray-700 font-semibold mb-2">Accounts</label>
+            <div className="space-y-2">
               {accounts.map(acc => (
-                <option key={acc.platform} value={acc.platform}>{acc.platform} ({acc.username})</option>
+                <label key={acc.platformUserId} className="flex items-center gap-2 text-gray-700">
+                  <input type="checkbox" checked={selected.includes(acc.platformUserId)} onChange={() => toggleAccount(acc.platformUserId)} />
+                  {acc.platform} ({acc.username})
+                </label>
               ))}
-            </select>
+              {accounts.length === 0 && <p className="text-gray-500">Connect an account on the dashboard first.</p>}
+            </div>
           </div>
+          {selected.length > 1 && (
+            <div>
+              <label className="block text-gray-700 font-semibold mb-2">Campaign Name</label>
+              <input
+                type="text"
+                value={campaignName}
+                onChange={(e) => setCampaignName(e.target.value)}
+                className="w-full p-3 border border-gray-300 rounded-lg focus:ring-2 focus:ring-blue-500 focus:border-blue-500"
+                placeholder="Spring launch"
+                required
+              />
+            </div>
+          )}
           <div>
             <label className="block text-gray-700 font-semibold mb-2">Content</label>
             <textarea
@@ -290,6 +1225,60 @@ const PostCreator = ({ token, onPostCreated }) => {
               required
             ></textarea>
           </div>
+          <div>
+            <label className="block text-gray-700 font-semibold mb-2">Media</label>
+            <input
+              type="file"
+              accept="image/jpeg,image/png,image/gif,image/webp,video/mp4,video/quicktime,video/webm"
+              onChange={handleFile}
+              className="w-full p-3 border border-gray-300 rounded-lg"
+              disabled={uploadProgress !== null}
+            />
+            {uploadProgress !== null && <p className="text-sm text-gray-500 mt-1">Uploading... {Math.round(uploadProgress * 100)}%</p>}
+            {media && (
+              <p className="text-sm text-gray-500 mt-1">
+                {media.filename}: {media.kind}{media.width ? `, ${media.width}x${media.height}` : ''}{media.durationSeconds ? `, ${media.durationSeconds.toFixed(1)}s` : ''}
+              </p>
+            )}
+            {renditions.length > 0 && (
+              <ul className="text-sm text-gray-500 mt-1">
+                {renditions.filter((job) => job.preset !== 'thumbnail').map((job) => (
+                  <li key={job.id} className={job.status === 'failed' ? 'text-red-600' : ''}>
+                    {job.preset} version: {job.status === 'processing' ? `${Math.round(job.progress * 100)}%` : job.status}{job.error ? ` (${job.error})` : ''}
+                  </li>
+                ))}
+              </ul>
+            )}
+          </div>
+          {selected.length > 1 && (
+            <>
+              <div>
+                <label className="block text-gray-700 font-semibold mb-2">Hashtags</label>
+                <input
+                  type="text"
+                  value={hashtags}
+                  onChange={(e) => setHashtags(e.target.value)}
+                  className="w-full p-3 border border-gray-300 rounded-lg focus:ring-2 focus:ring-blue-500 focus:border-blue-500"
+                  placeholder="#launch #spring"
+                />
+              </div>
+              {selected.map((id) => {
+                const acc = accounts.find((a) => a.platformUserId === id);
+                return (
+                  <div key={id}>
+                    <label className="block text-gray-700 font-semibold mb-2">Caption for {acc.platform} ({acc.username}), optional</label>
+                    <textarea
+                      value={captions[id] || ''}
+                      onChange={(e) => setCaptions({ ...captions, [id]: e.target.value })}
+                      className="w-full p-3 border border-gray-300 rounded-lg focus:ring-2 focus:ring-blue-500 focus:border-blue-500"
+                      rows="2"
+                      placeholder="Leave empty to use the content above"
+                    ></textarea>
+                  </div>
+                );
+              })}
+            </>
+          )}
           <div>
             <label className="block text-gray-700 font-semibold mb-2">Schedule Time</label>
             <input
@@ -300,20 +1289,39 @@ const PostCreator = ({ token, onPostCreated }) => {
               required
             />
           </div>
-          <button
-            type="submit"
-            className="w-full py-3 px-4 rounded-lg bg-blue-600 text-white font-semibold hover:bg-blue-700 transition-colors flex items-center justify-center disabled:opacity-50"
-            disabled={isSubmitting}
-          >
-            {isSubmitting ? (
-              <>
-                <Loader2 className="animate-spin mr-2 h-5 w-5" />
-                Scheduling...
-              </>
-            ) : (
-              'Schedule Post'
-            )}
-          </button>
+          {feedback.length > 0 && (
+            <ul className="space-y-1 text-sm">
+              {feedback.map((f, i) => (
+                <li key={i} className={f.warning ? 'text-yellow-700' : 'text-red-600'}>
+                  {f.account}: {f.message}
+                </li>
+              ))}
+            </ul>
+          )}
+          <div className="flex gap-3">
+            <button
+              type="button"
+              onClick={(e) => handleSubmit(e, true)}
+              className="py-3 px-4 rounded-lg border border-gray-300 text-gray-700 font-semibold hover:bg-gray-100 transition-colors disabled:opacity-50"
+              disabled={isSubmitting || uploadProgress !== null}
+            >
+              Save Draft
+            </button>
+            <button
+              type="submit"
+              className="flex-grow py-3 px-4 rounded-lg bg-blue-600 text-white font-semibold hover:bg-blue-700 transition-colors flex items-center justify-center disabled:opacity-50"
+              disabled={isSubmitting || uploadProgress !== null}
+            >
+              {isSubmitting ? (
+                <>
+                  <Loader2 className="animate-spin mr-2 h-5 w-5" />
+                  Submitting...
+                </>
+              ) : (
+                'Submit Post'
+              )}
+            </button>
+          </div>
         </form>
       </div>
     </div>
@@ -324,27 +1332,87 @@ const PostCreator = ({ token, onPostCreated }) => {
 const Scheduler = ({ token }) => {
   const [posts, setPosts] = useState([]);
   const [isLoading, setIsLoading] = useState(true);
+  const [editing, setEditing] = useState(null);
+  const [campaigns, setCampaigns] = useState([]);
 
-  useEffect(() => {
-    const fetchPosts = async () => {
-      try {
-        const response = await fetch(`${POST_API_BASE_URL}/api/posts`, {
-          headers: { 'Authorization': `Bearer ${token}` },
-        });
-        const data = await response.json();
-        setPosts(data);
-      } catch (error) {
-        console.error('Failed to fetch posts:', error);
-      } finally {
-        setIsLoading(false);
-      }
-    };
+  const fetchPosts = async () => {
+    try {
+      const response = await fetch(`${POST_API_BASE_URL}/api/posts`, {
+        headers: { 'Authorization': `Bearer ${token}` },
+      });
+      const data = await response.json();
+      setPosts(data || []);
+      const campaignsResponse = await fetch(`${POST_API_BASE_URL}/api/campaigns`, {
+        headers: { 'Authorization': `Bearer ${token}` },
+      });
+      setCampaigns(campaignsResponse.ok ? await campaignsResponse.json() : []);
+    } catch (error) {
+      console.error('Failed to fetch posts:', error);
+    } finally {
+      setIsLoading(false);
+    }
+  };
 
+  useEffect(() => {
     if (token) {
       fetchPosts();
     }
+    // eslint-disable-next-line react-hooks/exhaustive-deps
   }, [token]);
 
+  // Runs a review action (submit, approve or reject) on a post, or on every
+  // post of a campaign when given one.
+  const review = async (post, action, campaign) => {
+    let comment = '';
+    if (action === 'reject') {
+      comment = window.prompt('Why is this post rejected?');
+      if (!comment) {
+        return;
+      }
+    }
+    const path = campaign ? `campaigns/${campaign.id}` : `posts/${post.id}`;
+    const headers = { 'Authorization': `Bearer ${token}`, 'Content-Type': 'application/json' };
+    if (post) {
+      headers['If-Match'] = `"${post.version}"`;
+    }
+    const response = await fetch(`${POST_API_BASE_URL}/api/${path}/${action}`, {
+      method: 'POST',
+      headers,
+      body: JSON.stringify({ comment }),
+    });
+    if (!response.ok) {
+      alert(await errorMessage(response, `Failed to ${action} the post.`));
+    }
+    fetchPosts();
+  };
+
+  // Changes a post, sending the version it was loaded at so concurrent edits are refused.
+  const change = async (post, method, body, action) => {
+    const response = await fetch(`${POST_API_BASE_URL}/api/posts/${post.id}`, {
+      method,
+      headers: { 'Authorization': `Bearer ${token}`, 'Content-Type': 'application/json', 'If-Match': `"${post.version}"` },
+      body: body && JSON.stringify(body),
+    });
+    if (!response.ok) {
+      alert(await errorMessage(response, `Failed to ${action} the post.`));
+    }
+    setEditing(null);
+    fetchPosts();
+  };
+
+  const handleSaveEdit = (e, post) => {
+    e.preventDefault();
+    change(post, 'PATCH', { content: editing.content, scheduledAt: new Date(editing.scheduledAt).toISOString() }, 'edit');
+  };
+
+  // Formats a date for a datetime-local input.
+  const toLocalInput = (value) => {
+    const date = new Date(value);
+    return new Date(date.getTime() - date.getTimezoneOffset() * 60000).toISOString().slice(0, 16);
+  };
+
+  const isLocked = (post) => ['publishing', 'published'].includes(post.status);
+
   if (isLoading) {
     return (
       <div className="flex items-center justify-center h-full">
@@ -356,6 +1424,42 @@ const Scheduler = ({ token }) => {
   return (
     <div className="p-6 md:p-10">
       <h1 className="text-4xl font-bold text-gray-900 mb-6">Post Scheduler</h1>
+      {campaigns.length > 0 && (
+        <div className="bg-white p-6 rounded-xl shadow-lg mb-8">
+          <h2 className="text-2xl font-semibold text-gray-800 mb-4">Campaigns</h2>
+          <ul className="divide-y divide-gray-200">
+            {campaigns.map((c) => (
+              <li key={c.id} className="py-3">
+                <div className="flex flex-wrap items-center justify-between gap-3">
+                  <div>
+                    <p className="font-semibold text-gray-800">{c.name}</p>
+                    <p className="text-sm text-gray-500">
+                      {new Date(c.scheduledAt).toLocaleString()} · {c.status.replace('_', ' ')} ·{' '}
+                      {Object.entries(c.statusCounts).map(([status, n]) => `${n} ${status.replace('_', ' ')}`).join(', ')}
+                    </p>
+                  </div>
+                  <div className="flex gap-3">
+                    {(c.statusCounts.draft > 0 || c.statusCounts.rejected > 0) && (
+                      <button onClick={() => review(null, 'submit', c)} className="text-sm text-blue-600 hover:text-blue-800">Submit all</button>
+                    )}
+                    {c.statusCounts.pending_approval > 0 && (
+                      <>
+                        <button onClick={() => review(null, 'approve', c)} className="text-sm text-green-600 hover:text-green-800">Approve all</button>
+                        <button onClick={() => review(null, 'reject', c)} className="text-sm text-red-600 hover:text-red-800">Reject all</button>
+                      </>
+                    )}
+                  </div>
+                </div>
+                {c.failures.length > 0 && (
+                  <ul className="mt-2 text-sm text-red-700">
+                    {c.failures.map((f) => <li key={f.postId}>{f.platform} ({f.platformUserId}): {f.error}</li>)}
+                  </ul>
+                )}
+              </li>
+            ))}
+          </ul>
+        </div>
+      )}
       <div className="bg-white p-6 rounded-xl shadow-lg">
         <h2 className="text-2xl font-semibold text-gray-800 mb-4">Upcoming Posts</h2>
         <div className="space-y-4">
@@ -365,11 +1469,69 @@ const Scheduler = ({ token }) => {
                 <span className="text-gray-500 mr-4">
                   {post.platform === 'Meta' ? '🌐' : post.platform === 'TikTok' ? '🎵' : '👻'}
                 </span>
-                <div className="flex-grow">
-                  <p className="font-medium text-gray-700">{post.content}</p>
-                  <p className="text-sm text-gray-400">{new Date(post.scheduledAt).toLocaleString()}</p>
+                {editing && editing.id === post.id ? (
+                  <form onSubmit={(e) => handleSaveEdit(e, post)} className="flex-grow space-y-2">
+                    <textarea
+                      value={editing.content}
+                      onChange={(e) => setEditing({ ...editing, content: e.target.value })}
+                      className="w-full p-2 border border-gray-300 rounded-lg"
+                      rows="3"
+                      required
+                    ></textarea>
+                    <div className="flex flex-wrap items-center gap-3">
+                      <input
+                        type="datetime-local"
+                        value={editing.scheduledAt}
+                        onChange={(e) => setEditing({ ...editing, scheduledAt: e.target.value })}
+                        className="p-2 border border-gray-300 rounded-lg"
+                        required
+                      />
+                      <button type="submit" className="text-sm text-blue-600 hover:text-blue-800">Save</button>
+                      <button type="button" onClick={() => setEditing(null)} className="text-sm text-gray-500 hover:text-gray-700">Discard</button>
+                    </div>
+                    {post.status !== 'draft' && post.status !== 'rejected' && (
+                      <p className="text-xs text-gray-500">Changing the content sends the post back to draft for another approval.</p>
+                    )}
+                  </form>
+                ) : (
+                  <div className="flex-grow">
+                    <p className="font-medium text-gray-700">{post.content}</p>
+                    <p className="text-sm text-gray-400">
+                      {new Date(post.scheduledAt).toLocaleString()} · {post.status.replace('_', ' ')}
+                      {post.status === 'pending_approval' && ` (${post.approvals} of ${post.requiredApprovals} approvals)`}
+                    </p>
+                  </div>
+                )}
+                <div className="flex gap-3 ml-4">
+                  {(post.status === 'draft' || post.status === 'rejected') && (
+                    <button onClick={() => review(post, 'submit')} className="text-sm text-blue-600 hover:text-blue-800 transition-colors">Submit</button>
+                  )}
+                  {post.status === 'pending_approval' && (
+                    <>
+                      <button onClick={() => review(post, 'approve')} className="text-sm text-green-600 hover:text-green-800 transition-colors">Approve</button>
+                      <button onClick={() => review(post, 'reject')} className="text-sm text-red-600 hover:text-red-800 transition-colors">Reject</button>
+                    </>
+                  )}
+                  {!isLocked(post) && !editing && (
+                    <>
+                      <button
+                        onClick={() => setEditing({ id: post.id, content: post.content, scheduledAt: toLocalInput(post.scheduledAt) })}
+                        className="text-sm text-blue-600 hover:text-blue-800 transition-colors"
+                      >
+                        Edit
+                      </button>
+                      {!['cancelled', 'failed'].includes(post.status) && (
+                        <button onClick={() => change(post, 'PATCH', { status: 'cancelled' }, 'cancel')} className="text-sm text-gray-600 hover:text-gray-800 transition-colors">Cancel</button>
+                      )}
+                      <button
+                        onClick={() => window.confirm('Delete this post?') && change(post, 'DELETE', null, 'delete')}
+                        className="text-sm text-red-600 hover:text-red-800 transition-colors"
+                      >
+                        Delete
+                      </button>
+                    </>
+                  )}
                 </div>
-                <button className="text-sm text-blue-600 hover:text-blue-800 transition-colors">Edit</button>
               </div>
             ))
           ) : (
@@ -381,21 +1543,31 @@ const Scheduler = ({ token }) => {
   );
 };
 
-// Analytics component (updated to fetch data).
+// Analytics component: daily series of one metric, per platform or per account.
 const Analytics = ({ token }) => {
   const [analyticsData, setAnalyticsData] = useState([]);
   const [isLoading, setIsLoading] = useState(true);
+  const [error, setError] = useState(null);
+  const [filters, setFilters] = useState({ from: '', to: '', metric: 'engagement', platform: '', groupBy: 'platform' });
 
   useEffect(() => {
     const fetchAnalytics = async () => {
+      const params = new URLSearchParams();
+      Object.entries(filters).forEach(([key, value]) => {
+        if (value) params.set(key, value);
+      });
       try {
-        const response = await fetch(`${POST_API_BASE_URL}/api/analytics`, {
+        const response = await fetch(`${POST_API_BASE_URL}/api/analytics?${params}`, {
           headers: { 'Authorization': `Bearer ${token}` },
         });
-        const data = await response.json();
-        setAnalyticsData(data);
-      } catch (error) {
-        console.error('Failed to fetch analytics:', error);
+        if (!response.ok) {
+          throw new Error(await errorMessage(response, 'Failed to fetch analytics.'));
+        }
+        setAnalyticsData(await response.json());
+        setError(null);
+      } catch (e) {
+        console.error('Failed to fetch analytics:', e);
+        setError(e.message);
       } finally {
         setIsLoading(false);
       }
@@ -404,8 +1576,63 @@ const Analytics = ({ token }) => {
     if (token) {
       fetchAnalytics();
     }
+  }, [token, filters]);
+
+  // Reports are rendered in the background; the list is polled until none is pending.
+  const [reports, setReports] = useState([]);
+  const [reportFormat, setReportFormat] = useState('pdf');
+  const pendingReports = reports.some((report) => report.status === 'queued' || report.status === 'processing');
+
+  const fetchReports = async () => {
+    try {
+      const response = await fetch(`${POST_API_BASE_URL}/api/reports`, {
+        headers: { 'Authorization': `Bearer ${token}` },
+      });
+      if (response.ok) {
+        setReports(await response.json());
+      }
+    } catch (e) {
+      console.error('Failed to fetch reports:', e);
+    }
+  };
+
+  useEffect(() => {
+    if (token) {
+      fetchReports();
+    }
   }, [token]);
 
+  useEffect(() => {
+    if (!pendingReports) return undefined;
+    const timer = setTimeout(fetchReports, 3000);
+    return () => clearTimeout(timer);
+  }, [reports, pendingReports]);
+
+  const handleExport = async () => {
+    const day = (date) => date.toISOString().slice(0, 10);
+    const to = filters.to || day(new Date());
+    const from = filters.from || day(new Date(Date.parse(to) - 29 * 24 * 60 * 60 * 1000));
+    try {
+      const response = await fetch(`${POST_API_BASE_URL}/api/reports`, {
+        method: 'POST',
+        headers: { 'Authorization': `Bearer ${token}`, 'Content-Type': 'application/json' },
+        body: JSON.stringify({ format: reportFormat, from, to }),
+      });
+      if (!response.ok) {
+        throw new Error(await errorMessage(response, 'Failed to request the report.'));
+      }
+      const report = await response.json();
+      setReports((current) => [report, ...current]);
+    } catch (e) {
+      console.error('Failed to request report:', e);
+      setError(e.message);
+    }
+  };
+
+  const setFilter = (key) => (e) => setFilters((current) => ({ ...current, [key]: e.target.value }));
+  const colors = ['#4c51bf', '#06b6d4', '#ef4444', '#10b981', '#f59e0b', '#8b5cf6'];
+  const series = analyticsData.length > 0 ? Object.keys(analyticsData[0]).filter((key) => key !== 'name') : [];
+
   if (isLoading) {
     return (
       <div className="flex items-center justify-center h-full">
@@ -418,38 +1645,420 @@ const Analytics = ({ token }) => {
     <div className="p-6 md:p-10">
       <h1 className="text-4xl font-bold text-gray-900 mb-6">Analytics & Reporting</h1>
       <div className="bg-white p-6 rounded-xl shadow-lg">
-        <h2 className="text-2xl font-semibold text-gray-800 mb-6">Engagement Over Time</h2>
+        <h2 className="text-2xl font-semibold text-gray-800 mb-6">Performance Over Time</h2>
+        <div className="flex flex-wrap gap-3 mb-6 text-sm">
+          <input type="date" value={filters.from} onChange={setFilter('from')} className="p-2 border border-gray-300 rounded-lg" />
+          <input type="date" value={filters.to} onChange={setFilter('to')} className="p-2 border border-gray-300 rounded-lg" />
+          <select value={filters.metric} onChange={setFilter('metric')} className="p-2 border border-gray-300 rounded-lg">
+            {['engagement', 'impressions', 'reach', 'likes', 'comments', 'shares', 'followers'].map((metric) => (
+              <option key={metric} value={metric}>{metric}</option>
+            ))}
+          </select>
+          <select value={filters.platform} onChange={setFilter('platform')} className="p-2 border border-gray-300 rounded-lg">
+            <option value="">All platforms</option>
+            {['Meta', 'TikTok', 'Snapchat'].map((platform) => (
+              <option key={platform} value={platform}>{platform}</option>
+            ))}
+          </select>
+          <select value={filters.groupBy} onChange={setFilter('groupBy')} className="p-2 border border-gray-300 rounded-lg">
+            <option value="platform">By platform</option>
+            <option value="account">By account</option>
+          </select>
+        </div>
+        {error && <p className="mb-4 p-3 rounded-lg bg-red-100 text-red-800 text-sm">{error}</p>}
         <ResponsiveContainer width="100%" height={300}>
           <LineChart data={analyticsData}>
             <CartesianGrid strokeDasharray="3 3" stroke="#e5e7eb" />
             <XAxis dataKey="name" stroke="#6b7280" />
             <YAxis stroke="#6b7280" />
             <Tooltip />
-            <Line type="monotone" dataKey="Meta" stroke="#4c51bf" strokeWidth={2} activeDot={{ r: 8 }} />
-            <Line type="monotone" dataKey="TikTok" stroke="#06b6d4" strokeWidth={2} activeDot={{ r: 8 }} />
-            <Line type="monotone" dataKey="Snapchat" stroke="#ef4444" strokeWidth={2} activeDot={{ r: 8 }} />
+            {series.map((key, i) => (
+              <Line key={key} type="monotone" dataKey={key} stroke={colors[i % colors.length]} strokeWidth={2} activeDot={{ r: 8 }} />
+            ))}
           </LineChart>
         </ResponsiveContainer>
       </div>
+      <div className="bg-white p-6 rounded-xl shadow-lg mt-8">
+        <h2 className="text-2xl font-semibold text-gray-800 mb-4">Reports</h2>
+        <p className="text-sm text-gray-500 mb-4">Exports totals, follower growth and top posts for the selected dates (the last 30 days if none are set).</p>
+        <div className="flex flex-wrap gap-3 mb-6 text-sm">
+          <select value={reportFormat} onChange={(e) => setReportFormat(e.target.value)} className="p-2 border border-gray-300 rounded-lg">
+            <option value="pdf">PDF</option>
+            <option value="xlsx">Excel (XLSX)</option>
+            <option value="csv">CSV</option>
+          </select>
+          <button onClick={handleExport} className="py-2 px-4 rounded-lg bg-blue-600 text-white hover:bg-blue-700 transition-colors">
+            Export report
+          </button>
+        </div>
+        <ul className="space-y-2 text-sm">
+          {reports.map((report) => (
+            <li key={report.id} className="flex items-center justify-between p-3 bg-gray-50 rounded-lg">
+              <span>{report.from} to {report.to} ({report.format.toUpperCase()})</span>
+              {report.status === 'ready' ? (
+                <a href={report.downloadUrl} className="text-blue-600 hover:underline">Download</a>
+              ) : (
+                <span className={report.status === 'failed' ? 'text-red-600' : 'text-gray-500'}>
+                  {report.status}{report.error ? `: ${report.error}` : ''}
+                </span>
+              )}
+            </li>
+          ))}
+        </ul>
+      </div>
+      <ScheduledReports token={token} />
+    </div>
+  );
+};
+
+// Scheduled reports: subscriptions that render a report on a cron schedule and
+// deliver it by email, webhook or file, with each subscription's delivery history.
+const ScheduledReports = ({ token }) => {
+  const emptyForm = {
+    name: '', schedule: '0 8 * * 1', timezone: Intl.DateTimeFormat().resolvedOptions().timeZone || 'UTC',
+    format: 'pdf', rangeDays: 7, method: 'email', recipients: '', webhookUrl: '',
+  };
+  const [subscriptions, setSubscriptions] = useState([]);
+  const [form, setForm] = useState(emptyForm);
+  const [deliveries, setDeliveries] = useState({});
+  const [message, setMessage] = useState(null);
+  const [error, setError] = useState(null);
+
+  const request = async (path, options, fallback) => {
+    const response = await fetch(`${POST_API_BASE_URL}/api/report-subscriptions${path}`, {
+      ...options,
+      headers: { 'Authorization': `Bearer ${token}`, 'Content-Type': 'application/json' },
+    });
+    if (!response.ok) {
+      throw new Error(await errorMessage(response, fallback));
+    }
+    return response.status === 204 ? null : response.json();
+  };
+
+  const fetchSubscriptions = async () => {
+    try {
+      setSubscriptions(await request('', {}, 'Failed to fetch scheduled reports.'));
+    } catch (e) {
+      console.error('Failed to fetch scheduled reports:', e);
+      setError(e.message);
+    }
+  };
+
+  useEffect(() => {
+    if (token) {
+      fetchSubscriptions();
+    }
+  }, [token]);
+
+  const fetchDeliveries = async (id) => {
+    try {
+      const list = await request(`/${id}/deliveries`, {}, 'Failed to fetch deliveries.');
+      setDeliveries((current) => ({ ...current, [id]: list }));
+    } catch (e) {
+      setError(e.message);
+    }
+  };
+
+  const toggleDeliveries = (id) => {
+    if (deliveries[id]) {
+      setDeliveries(({ [id]: _, ...rest }) => rest);
+    } else {
+      fetchDeliveries(id);
+    }
+  };
+
+  const handleCreate = async (e) => {
+    e.preventDefault();
+    setError(null);
+    setMessage(null);
+    try {
+      const created = await request('', {
+        method: 'POST',
+        body: JSON.stringify({
+          ...form,
+          rangeDays: Number(form.rangeDays),
+          recipients: form.recipients.split(',').map((r) => r.trim()).filter(Boolean),
+        }),
+      }, 'Failed to create the scheduled report.');
+      setSubscriptions((current) => [...current, created].sort((a, b) => a.name.localeCompare(b.name)));
+      setForm(emptyForm);
+      setMessage(created.webhookSecret
+        ? `Scheduled "${created.name}". Webhooks are signed with this secret, which is only shown once: ${created.webhookSecret}`
+        : `Scheduled "${created.name}".`);
+    } catch (e) {
+      setError(e.message);
+    }
+  };
+
+  const handleDelete = async (subscription) => {
+    if (!window.confirm(`Stop sending "${subscription.name}"?`)) return;
+    try {
+      await request(`/${subscription.id}`, { method: 'DELETE' }, 'Failed to delete the scheduled report.');
+      setSubscriptions((current) => current.filter((s) => s.id !== subscription.id));
+    } catch (e) {
+      setError(e.message);
+    }
+  };
+
+  const handleRetry = async (subscriptionId, deliveryId) => {
+    try {
+      await request(`/${subscriptionId}/deliveries/${deliveryId}/retry`, { method: 'POST' }, 'Failed to retry the delivery.');
+      fetchDeliveries(subscriptionId);
+    } catch (e) {
+      setError(e.message);
+    }
+  };
+
+  const setField = (key) => (e) => setForm((current) => ({ ...current, [key]: e.target.value }));
+  const inputClass = 'p-2 border border-gray-300 rounded-lg';
+
+  return (
+    <div className="bg-white p-6 rounded-xl shadow-lg mt-8">
+      <h2 className="text-2xl font-semibold text-gray-800 mb-4">Scheduled Reports</h2>
+      <p className="text-sm text-gray-500 mb-4">Each run covers the given number of days before the day it runs on. The schedule is a cron expression (minute hour day month weekday) in the chosen timezone.</p>
+      {message && <p className="mb-4 p-3 rounded-lg bg-green-100 text-green-800 text-sm break-all">{message}</p>}
+      {error && <p className="mb-4 p-3 rounded-lg bg-red-100 text-red-800 text-sm">{error}</p>}
+      <form onSubmit={handleCreate} className="flex flex-wrap gap-3 mb-6 text-sm">
+        <input value={form.name} onChange={setField('name')} placeholder="Name" required className={inputClass} />
+        <input value={form.schedule} onChange={setField('schedule')} placeholder="0 8 * * 1" required className={`${inputClass} font-mono w-32`} />
+        <input value={form.timezone} onChange={setField('timezone')} placeholder="Timezone" className={inputClass} />
+        <select value={form.format} onChange={setField('format')} className={inputClass}>
+          <option value="pdf">PDF</option>
+          <option value="xlsx">Excel (XLSX)</option>
+          <option value="csv">CSV</option>
+        </select>
+        <label className="flex items-center gap-2 text-gray-600">
+          Days
+          <input type="number" min="1" max="366" value={form.rangeDays} onChange={setField('rangeDays')} className={`${inputClass} w-20`} />
+        </label>
+        <select value={form.method} onChange={setField('method')} className={inputClass}>
+          <option value="email">Email</option>
+          <option value="webhook">Webhook</option>
+          <option value="file">File</option>
+        </select>
+        {form.method === 'email' && (
+          <input value={form.recipients} onChange={setField('recipients')} placeholder="Recipients, comma separated" required className={`${inputClass} flex-1`} />
+        )}
+        {form.method === 'webhook' && (
+          <input type="url" value={form.webhookUrl} onChange={setField('webhookUrl')} placeholder="https://example.com/hooks/reports" required className={`${inputClass} flex-1`} />
+        )}
+        <button type="submit" className="py-2 px-4 rounded-lg bg-blue-600 text-white hover:bg-blue-700 transition-colors">
+          Schedule
+        </button>
+      </form>
+      <ul className="space-y-2 text-sm">
+        {subscriptions.map((subscription) => (
+          <li key={subscription.id} className="p-3 bg-gray-50 rounded-lg">
+            <div className="flex flex-wrap items-center justify-between gap-2">
+              <span>
+                <span className="font-medium text-gray-800">{subscription.name}</span>{' '}
+                <span className="text-gray-500">
+                  <code>{subscription.schedule}</code> {subscription.timezone}, last {subscription.rangeDays} days as {subscription.format.toUpperCase()} by {subscription.method}
+                  {subscription.method === 'email' && ` to ${subscription.recipients.join(', ')}`}
+                  {subscription.enabled ? `; next ${new Date(subscription.nextRunAt).toLocaleString()}` : '; paused'}
+                </span>
+              </span>
+              <span className="flex gap-3">
+                <button onClick={() => toggleDeliveries(subscription.id)} className="text-blue-600 hover:underline">
+                  {deliveries[subscription.id] ? 'Hide history' : 'History'}
+                </button>
+                <button onClick={() => handleDelete(subscription)} className="text-red-600 hover:underline">Delete</button>
+              </span>
+            </div>
+            {deliveries[subscription.id] && (
+              <ul className="mt-2 space-y-1 text-gray-600">
+                {deliveries[subscription.id].length === 0 && <li>No deliveries yet.</li>}
+                {deliveries[subscription.id].map((delivery) => (
+                  <li key={delivery.id} className="flex items-center justify-between">
+                    <span>
+                      {delivery.from} to {delivery.to}:{' '}
+                      <span className={delivery.status === 'failed' ? 'text-red-600' : delivery.status === 'delivered' ? 'text-green-700' : ''}>
+                        {delivery.status}
+                      </span>
+                      {delivery.attempts > 0 && ` after ${delivery.attempts} attempt(s)`}
+                      {delivery.lastError && ` (${delivery.lastError})`}
+                    </span>
+                    {delivery.status === 'failed' && delivery.reportId && (
+                      <button onClick={() => handleRetry(subscription.id, delivery.id)} className="text-blue-600 hover:underline">Retry</button>
+                    )}
+                  </li>
+                ))}
+              </ul>
+            )}
+          </li>
+        ))}
+      </ul>
     </div>
   );
 };
 
-// Engagement component (unified inbox).
-const Engagement = () => {
+// Engagement component: the unified inbox of comments, mentions and direct
+// messages from every connected account.
+const Engagement = ({ token }) => {
+  const [conversations, setConversations] = useState([]);
+  const [nextCursor, setNextCursor] = useState(null);
+  const [filters, setFilters] = useState({ status: '', kind: '', platform: '', q: '' });
+  const [selected, setSelected] = useState(null);
+  const [messages, setMessages] = useState([]);
+  const [messagesCursor, setMessagesCursor] = useState(null);
+  const [isLoading, setIsLoading] = useState(true);
+  const [error, setError] = useState(null);
+
+  const request = async (path, options = {}) => {
+    const response = await fetch(`${POST_API_BASE_URL}/api/inbox${path}`, {
+      ...options,
+      headers: { 'Authorization': `Bearer ${token}` },
+    });
+    if (!response.ok) {
+      throw new Error(await errorMessage(response, 'Failed to load the inbox.'));
+    }
+    return response.json();
+  };
+
+  const fetchConversations = async (cursor) => {
+    const params = new URLSearchParams();
+    Object.entries(filters).forEach(([key, value]) => {
+      if (value) params.set(key, value);
+    });
+    if (cursor) params.set('cursor', cursor);
+    try {
+      const page = await request(`/conversations?${params}`);
+      setConversations((current) => (cursor ? [...current, ...page.conversations] : page.conversations));
+      setNextCursor(page.nextCursor || null);
+      setError(null);
+    } catch (e) {
+      console.error('Failed to fetch conversations:', e);
+      setError(e.message);
+    } finally {
+      setIsLoading(false);
+    }
+  };
+
+  // The first page is refreshed every 30 seconds to pick up new activity.
+  useEffect(() => {
+    if (!token) return undefined;
+    fetchConversations();
+    const timer = setInterval(() => fetchConversations(), 30000);
+    return () => clearInterval(timer);
+  }, [token, filters]);
+
+  const replaceConversation = (conversation) => {
+    setConversations((current) => current.map((c) => (c.id === conversation.id ? conversation : c)));
+    setSelected((current) => (current && current.id === conversation.id ? conversation : current));
+  };
+
+  const fetchMessages = async (conversation, cursor) => {
+    try {
+      const page = await request(`/conversations/${conversation.id}/messages${cursor ? `?cursor=${encodeURIComponent(cursor)}` : ''}`);
+      setMessages((current) => (cursor ? [...current, ...page.messages] : page.messages));
+      setMessagesCursor(page.nextCursor || null);
+    } catch (e) {
+      setError(e.message);
+    }
+  };
+
+  const openConversation = async (conversation) => {
+    setSelected(conversation);
+    setMessages([]);
+    await fetchMessages(conversation);
+    if (conversation.unreadCount > 0) {
+      markConversation(conversation, true);
+    }
+  };
+
+  const markConversation = async (conversation, read) => {
+    try {
+      replaceConversation(await request(`/conversations/${conversation.id}/${read ? 'read' : 'unread'}`, { method: 'POST' }));
+    } catch (e) {
+      setError(e.message);
+    }
+  };
+
+  const setFilter = (key) => (e) => setFilters((current) => ({ ...current, [key]: e.target.value }));
+  const kindLabels = { comment: 'Comments', mention: 'Mention', message: 'Direct message' };
+  const title = (c) => c.participantName || (c.kind === 'comment' ? `Comments on ${c.postId ? 'your post' : c.externalId}` : c.externalId);
+  const inputClass = 'p-2 border border-gray-300 rounded-lg';
+
+  if (isLoading) {
+    return (
+      <div className="flex items-center justify-center h-full">
+        <Loader2 className="animate-spin text-blue-600 w-12 h-12" />
+      </div>
+    );
+  }
+
   return (
     <div className="p-6 md:p-10">
       <h1 className="text-4xl font-bold text-gray-900 mb-6">Engagement Management</h1>
-      <div className="bg-white p-6 rounded-xl shadow-lg">
-        <h2 className="text-2xl font-semibold text-gray-800 mb-4">Unified Inbox</h2>
-        <p className="text-gray-600">This area would function as a unified inbox, fetching comments and direct messages from all connected social media accounts. Users can view and respond to interactions from a single, centralized location, with notifications for new activity.</p>
-        <div className="mt-4 p-4 bg-gray-50 rounded-lg">
-          <p className="font-medium text-gray-700">Mock Comment from TikTok:</p>
-          <p className="text-sm text-gray-500">"Love this video! 😍"</p>
+      <div className="flex flex-wrap gap-3 mb-6 text-sm">
+        <select value={filters.status} onChange={setFilter('status')} className={inputClass}>
+          <option value="">All</option>
+          <option value="unread">Unread</option>
+          <option value="read">Read</option>
+        </select>
+        <select value={filters.kind} onChange={setFilter('kind')} className={inputClass}>
+          <option value="">Comments, mentions and messages</option>
+          <option value="comment">Comments</option>
+          <option value="mention">Mentions</option>
+          <option value="message">Direct messages</option>
+        </select>
+        <select value={filters.platform} onChange={setFilter('platform')} className={inputClass}>
+          <option value="">All platforms</option>
+          {['Meta', 'TikTok', 'Snapchat'].map((platform) => (
+            <option key={platform} value={platform}>{platform}</option>
+          ))}
+        </select>
+        <input value={filters.q} onChange={setFilter('q')} placeholder="Search" className={`${inputClass} flex-1`} />
+      </div>
+      {error && <p className="mb-4 p-3 rounded-lg bg-red-100 text-red-800 text-sm">{error}</p>}
+      <div className="grid grid-cols-1 lg:grid-cols-3 gap-6">
+        <div className="bg-white rounded-xl shadow-lg overflow-hidden">
+          {conversations.length === 0 && <p className="p-6 text-gray-500">Nothing here yet.</p>}
+          <ul className="divide-y divide-gray-100">
+            {conversations.map((c) => (
+              <li key={c.id}>
+                <button
+                  onClick={() => openConversation(c)}
+                  className={`w-full text-left p-4 hover:bg-gray-50 ${selected && selected.id === c.id ? 'bg-blue-50' : ''}`}
+                >
+                  <div className="flex justify-between text-sm">
+                    <span className={c.unreadCount > 0 ? 'font-semibold text-gray-900' : 'text-gray-700'}>{title(c)}</span>
+                    {c.unreadCount > 0 && <span className="px-2 rounded-full bg-blue-600 text-white text-xs">{c.unreadCount}</span>}
+                  </div>
+                  <p className="text-xs text-gray-500">{c.platform} · {kindLabels[c.kind]} · {new Date(c.lastMessageAt).toLocaleString()}</p>
+                  <p className="text-sm text-gray-600 truncate">{c.preview}</p>
+                </button>
+              </li>
+            ))}
+          </ul>
+          {nextCursor && (
+            <button onClick={() => fetchConversations(nextCursor)} className="w-full p-3 text-sm text-blue-600 hover:bg-gray-50">Load more</button>
+          )}
         </div>
-        <div className="mt-2 p-4 bg-gray-50 rounded-lg">
-          <p className="font-medium text-gray-700">Mock Message from Meta:</p>
-          <p className="text-sm text-gray-500">"Hi, is this product available?"</p>
+        <div className="bg-white p-6 rounded-xl shadow-lg lg:col-span-2">
+          {!selected ? (
+            <p className="text-gray-500">Select a conversation to read it.</p>
+          ) : (
+            <>
+              <div className="flex justify-between items-center mb-4">
+                <h2 className="text-xl font-semibold text-gray-800">{title(selected)}</h2>
+                <button onClick={() => markConversation(selected, false)} className="text-sm text-blue-600 hover:underline">Mark unread</button>
+              </div>
+              {messagesCursor && (
+                <button onClick={() => fetchMessages(selected, messagesCursor)} className="mb-3 text-sm text-blue-600 hover:underline">Show older</button>
+              )}
+              <ul className="space-y-3">
+                {[...messages].reverse().map((m) => (
+                  <li key={m.id} className={`p-3 rounded-lg max-w-xl ${m.outbound ? 'ml-auto bg-blue-600 text-white' : 'bg-gray-100 text-gray-800'}`}>
+                    <p className={`text-xs mb-1 ${m.outbound ? 'text-blue-100' : 'text-gray-500'}`}>
+                      {m.outbound ? 'You' : m.authorName || m.authorId} · {new Date(m.sentAt).toLocaleString()}
+                    </p>
+                    <p className="whitespace-pre-wrap">{m.body}</p>
+                  </li>
+                ))}
+              </ul>
+            </>
+          )}
         </div>
       </div>
     </div>
@@ -458,15 +2067,111 @@ const Engagement = () => {
 
 const App = () => {
   const [currentPage, setCurrentPage] = useState('dashboard');
-  const [token, setToken] = useState(localStorage.getItem('jwtToken'));
+  const [token, setToken] = useState(null);
+  const [tokenExpiresAt, setTokenExpiresAt] = useState(null);
+  const [isRestoring, setIsRestoring] = useState(true);
   const [isSidebarOpen, setIsSidebarOpen] = useState(false);
 
+  // Access tokens live only in memory; the refresh cookie restores them on load.
+  const applySession = (session) => {
+    setToken(session.accessToken);
+    setTokenExpiresAt(new Date(session.expiresAt));
+  };
+
+  const restoreSession = async () => {
+    const session = await refreshSession();
+    if (session) {
+      applySession(session);
+    }
+    if (session && window.location.pathname !== '/') {
+      window.history.replaceState(null, '', '/');
+    }
+  };
+
+  useEffect(() => {
+    localStorage.removeItem('jwtToken');
+    // Invitation links may arrive before the user signs in; keep the token until they have.
+    if (window.location.pathname === '/invitations/accept') {
+      const invitation = new URLSearchParams(window.location.search).get('token');
+      if (invitation) {
+        sessionStorage.setItem('pendingInvitation', invitation);
+      }
+    }
+    if (window.location.pathname === '/reset-password') {
+      setIsRestoring(false);
+      return;
+    }
+    restoreSession()
+      .catch((err) => console.error('Failed to restore session:', err))
+      .finally(() => setIsRestoring(false));
+  }, []);
+
+  // Accept a pending invitation once signed in, then switch to the tenant it joined.
+  useEffect(() => {
+    const invitation = sessionStorage.getItem('pendingInvitation');
+    if (!token || !invitation) return;
+    sessionStorage.removeItem('pendingInvitation');
+    (async () => {
+      try {
+        const response = await fetch(`${AUTH_API_BASE_URL}/auth/invitations/accept`, {
+          method: 'POST',
+          headers: { 'Authorization': `Bearer ${token}`, 'Content-Type': 'application/json' },
+          body: JSON.stringify({ token: invitation }),
+        });
+        if (!response.ok) {
+          window.alert((await response.text()).trim());
+          return;
+        }
+        const { tenantId } = await response.json();
+        const switched = await fetch(`${AUTH_API_BASE_URL}/auth/tenants/${tenantId}/switch`, {
+          method: 'POST',
+          headers: { 'Authorization': `Bearer ${token}` },
+        });
+        if (switched.ok) {
+          applySession(await switched.json());
+          setCurrentPage('team');
+        }
+      } catch (err) {
+        console.error('Failed to accept invitation:', err);
+      }
+    })();
+  }, [token]);
+
+  // Renew the access token a minute before it expires.
+  useEffect(() => {
+    if (!tokenExpiresAt) return;
+    const delay = Math.max(tokenExpiresAt.getTime() - Date.now() - 60 * 1000, 0);
+    const timer = setTimeout(async () => {
+      try {
+        const session = await refreshSession();
+        setToken(session ? session.accessToken : null);
+        setTokenExpiresAt(session ? new Date(session.expiresAt) : null);
+      } catch (err) {
+        console.error('Failed to refresh session:', err);
+      }
+    }, delay);
+    return () => clearTimeout(timer);
+  }, [tokenExpiresAt]);
+
+  const handleLogout = async () => {
+    try {
+      await fetch(`${AUTH_API_BASE_URL}/auth/logout`, { method: 'POST', credentials: 'include' });
+    } catch (err) {
+      console.error('Failed to log out:', err);
+    }
+    setToken(null);
+    setTokenExpiresAt(null);
+  };
+
   const renderContent = () => {
+    if (window.location.pathname === '/reset-password') {
+      return <ResetPassword />;
+    }
+    if (isRestoring) {
+      return <SessionLoader />;
+    }
     if (!token) {
-      if (window.location.pathname === '/auth-success') {
-        return <AuthSuccess setToken={setToken} />;
-      }
-      return <Login />;
+      return <Login onSignedIn={restoreSession} />;
     }
 
     switch (currentPage) {
@@ -477,7 +2182,11 @@ const App = () => {
       case 'analytics':
         return <Analytics token={token} />;
       case 'engagement':
-        return <Engagement />;
+        return <Engagement token={token} />;
+      case 'team':
+        return <Team token={token} />;
+      case 'security':
+        return <Security token={token} onSessionUpgraded={restoreSession} />;
       case 'new-post':
         return <PostCreator token={token} onPostCreated={() => setCurrentPage('scheduler')} />;
       default:
@@ -503,6 +2212,7 @@ const App = () => {
               <Menu className="h-6 w-6 text-gray-400" />
             </button>
           </div>
+          <TenantSwitcher token={token} onSwitched={applySession} />
           <nav className="flex-grow">
             <ul className="space-y-2">
               <li>
@@ -541,6 +2251,24 @@ const App = () => {
                   <span>Engagement</span>
                 </button>
               </li>
+              <li>
+                <button
+                  onClick={() => { setCurrentPage('team'); setIsSidebarOpen(false); }}
+                  className={`flex items-center w-full p-3 rounded-lg transition-colors duration-200 ${currentPage === 'team' ? 'bg-blue-600 text-white' : 'hover:bg-gray-800 text-gray-300'}`}
+                >
+                  <Users className="mr-3 h-5 w-5" />
+                  <span>Team</span>
+                </button>
+              </li>
+              <li>
+                <button
+                  onClick={() => { setCurrentPage('security'); setIsSidebarOpen(false); }}
+                  className={`flex items-center w-full p-3 rounded-lg transition-colors duration-200 ${currentPage === 'security' ? 'bg-blue-600 text-white' : 'hover:bg-gray-800 text-gray-300'}`}
+                >
+                  <ShieldCheck className="mr-3 h-5 w-5" />
+                  <span>Security</span>
+                </button>
+              </li>
             </ul>
           </nav>
           <div className="mt-auto">
@@ -551,6 +2279,13 @@ const App = () => {
               <Plus className="mr-2 h-5 w-5" />
               <span>New Post</span>
             </button>
+            <button
+              onClick={handleLogout}
+              className="flex items-center w-full p-3 mt-2 rounded-lg text-gray-300 hover:bg-gray-800 transition-colors"
+            >
+              <LogOut className="mr-2 h-5 w-5" />
+              <span>Log out</span>
+            </button>
           </div>
         </aside>
       )}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// --- Account Service Client ---
+const internalTokenHeader = "X-Internal-Token"
+
+// TokenSource hands out platform access tokens for a tenant's connected accounts.
+type TokenSource interface {
+	AccessToken(ctx context.Context, tenantID, platformUserID string) (string, error)
+}
+
+// ConnectedAccount is a social account connected to a tenant.
+type ConnectedAccount struct {
+	TenantID       string `json:"tenantId"`
+	Platform       string `json:"platform"`
+	PlatformUserID string `json:"platformUserId"`
+	Username       string `json:"username"`
+	Status         string `json:"status"`
+}
+
+// AccountDirectory lists the social accounts connected to a tenant.
+type AccountDirectory interface {
+	Accounts(ctx context.Context, tenantID string) ([]ConnectedAccount, error)
+}
+
+// AccountCatalog lists the social accounts connected to any tenant.
+type AccountCatalog interface {
+	AllAccounts(ctx context.Context) ([]ConnectedAccount, error)
+}
+
+// accountDirectory is used to check the targets of new campaigns.
+var accountDirectory AccountDirectory
+
+// accountServiceClient fetches access tokens and account lists from
+// account-service's internal endpoints, authenticating with the shared internal
+// API secret.
+type accountServiceClient struct {
+	baseURL string
+	secret  string
+	client  *http.Client
+}
+
+func newAccountServiceClientFromConfig() *accountServiceClient {
+	return &accountServiceClient{
+		baseURL: strings.TrimRight(cfg.AccountServiceURL, "/"),
+		secret:  cfg.InternalAPISecret,
+		client:  &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (c *accountServiceClient) AccessToken(ctx context.Context, tenantID, platformUserID string) (string, error) {
+	q := url.Values{}
+	q.Set("tenant_id", tenantID)
+	q.Set("platform_user_id", platformUserID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/internal/tokens?"+q.Encode(), nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create token request: %w", err)
+	}
+	req.Header.Set(internalTokenHeader, c.secret)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to call account service: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return "", fmt.Errorf("account service returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	var token struct {
+		AccessToken string `json:"accessToken"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("failed to parse token response: %w", err)
+	}
+	return token.AccessToken, nil
+}
+
+func (c *accountServiceClient) Accounts(ctx context.Context, tenantID string) ([]ConnectedAccount, error) {
+	q := url.Values{}
+	q.Set("tenant_id", tenantID)
+	return c.listAccounts(ctx, q)
+}
+
+func (c *accountServiceClient) AllAccounts(ctx context.Context) ([]ConnectedAccount, error) {
+	return c.listAccounts(ctx, url.Values{})
+}
+
+func (c *accountServiceClient) listAccounts(ctx context.Context, q url.Values) ([]ConnectedAccount, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/internal/accounts?"+q.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create accounts request: %w", err)
+	}
+	req.Header.Set(internalTokenHeader, c.secret)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call account service: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf("account service returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	var accounts []ConnectedAccount
+	if err := json.NewDecoder(resp.Body).Decode(&accounts); err != nil {
+		return nil, fmt.Errorf("failed to parse accounts response: %w", err)
+	}
+	return accounts, nil
+}
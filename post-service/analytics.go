@@ -0,0 +1,561 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// --- Analytics ---
+//
+// The metrics collector periodically asks the platforms how published posts
+// and connected accounts are doing and stores the answers as time series: one
+// row per post (or account) and UTC day in post_metrics and account_metrics,
+// replaced by later collections on the same day. The platforms report
+// lifetime totals, so a post's value for a day is what it gained since the
+// previous day it was collected on. Posts are collected until they are
+// cfg.Analytics.Lookback old; the collector claims them with FOR UPDATE SKIP
+// LOCKED, so every replica can run one.
+//
+// GET /api/analytics aggregates the series of the caller's tenant into one
+// point per day, with a value per platform or per account.
+
+// PostMetrics are the lifetime totals of a published post. Metrics a platform
+// does not report stay zero.
+type PostMetrics struct {
+	Impressions int64 `json:"impressions"`
+	Reach       int64 `json:"reach"`
+	Likes       int64 `json:"likes"`
+	Comments    int64 `json:"comments"`
+	Shares      int64 `json:"shares"`
+}
+
+// AccountMetrics describe a connected account.
+type AccountMetrics struct {
+	Followers int64 `json:"followers"`
+}
+
+// MetricsSource is implemented by the publishers of platforms that report metrics.
+type MetricsSource interface {
+	// PostMetrics fetches the totals of the post the platform knows as externalID.
+	PostMetrics(ctx context.Context, account PlatformAccount, externalID string) (PostMetrics, error)
+	// AccountMetrics fetches the account's follower count.
+	AccountMetrics(ctx context.Context, account PlatformAccount) (AccountMetrics, error)
+}
+
+// errMetricsUnavailable is returned while a platform has no metrics for a post yet.
+var errMetricsUnavailable = errors.New("metrics are not available yet")
+
+const (
+	metricsBatchSize = 50
+	// metricsCallTimeout bounds the platform calls made for one post or account.
+	metricsCallTimeout = time.Minute
+	// maxAnalyticsDays bounds the date range of one analytics query.
+	maxAnalyticsDays = 366
+)
+
+// analyticsPlatforms are the platforms analytics are reported for, as they are named in responses.
+var analyticsPlatforms = []string{"Meta", "TikTok", "Snapchat"}
+
+// MetricsCollector periodically records the metrics of published posts and
+// of the accounts of tenants that publish.
+type MetricsCollector struct {
+	publishers *PublisherRegistry
+	tokens     TokenSource
+	accounts   AccountDirectory
+	interval   time.Duration
+	lookback   time.Duration
+	batchSize  int
+}
+
+func newMetricsCollector(publishers *PublisherRegistry, tokens TokenSource, accounts AccountDirectory) *MetricsCollector {
+	return &MetricsCollector{
+		publishers: publishers,
+		tokens:     tokens,
+		accounts:   accounts,
+		interval:   cfg.Analytics.CollectInterval,
+		lookback:   cfg.Analytics.Lookback,
+		batchSize:  metricsBatchSize,
+	}
+}
+
+// Run collects metrics every interval until ctx is cancelled.
+func (c *MetricsCollector) Run(ctx context.Context) {
+	log.Printf("Metrics collector started (interval %s, lookback %s)", c.interval, c.lookback)
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		c.tick(ctx)
+		select {
+		case <-ctx.Done():
+			log.Println("Metrics collector stopped.")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *MetricsCollector) tick(ctx context.Context) {
+	collected := 0
+	for ctx.Err() == nil {
+		posts, err := claimPostsForMetrics(ctx, c.interval, c.lookback, c.batchSize)
+		if err != nil {
+			log.Printf("Failed to claim posts for metrics: %v", err)
+			break
+		}
+		for _, post := range posts {
+			if c.collectPost(ctx, post) {
+				collected++
+			}
+		}
+		if len(posts) < c.batchSize {
+			break
+		}
+	}
+	if collected > 0 {
+		log.Printf("Collected metrics of %d post(s)", collected)
+	}
+	c.collectAccounts(ctx)
+}
+
+// source returns the metrics source of platform, if it has one.
+func (c *MetricsCollector) source(platform string) (MetricsSource, bool) {
+	publisher, ok := c.publishers.Get(platform)
+	if !ok {
+		return nil, false
+	}
+	source, ok := publisher.(MetricsSource)
+	return source, ok
+}
+
+// collectPost records the current totals of a published post and reports whether it could.
+func (c *MetricsCollector) collectPost(ctx context.Context, post Post) bool {
+	source, ok := c.source(post.Platform)
+	if !ok || post.PlatformUserID == "" {
+		return false
+	}
+	callCtx, cancel := context.WithTimeout(ctx, metricsCallTimeout)
+	defer cancel()
+	accessToken, err := c.tokens.AccessToken(callCtx, post.TenantID, post.PlatformUserID)
+	if err != nil {
+		log.Printf("Failed to obtain access token for metrics of post %s: %v", post.ID, err)
+		return false
+	}
+	metrics, err := source.PostMetrics(callCtx, PlatformAccount{PlatformUserID: post.PlatformUserID, AccessToken: accessToken}, post.ExternalID)
+	if errors.Is(err, errMetricsUnavailable) || errors.Is(err, errPublisherUnsupported) {
+		return false
+	}
+	if err != nil {
+		log.Printf("Failed to fetch metrics of post %s from %s: %v", post.ID, post.Platform, err)
+		return false
+	}
+	if err := savePostMetrics(ctx, post, metrics); err != nil {
+		log.Print(err)
+		return false
+	}
+	return true
+}
+
+// collectAccounts records the follower counts of the active accounts of every
+// tenant that has published posts, unless they were recorded within the interval.
+func (c *MetricsCollector) collectAccounts(ctx context.Context) {
+	tenants, err := publishingTenants(ctx)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	for _, tenantID := range tenants {
+		if ctx.Err() != nil {
+			return
+		}
+		recent, err := recentlyCollectedAccounts(ctx, tenantID, time.Now().Add(-c.interval*9/10))
+		if err != nil {
+			log.Print(err)
+			continue
+		}
+		accounts, err := c.accounts.Accounts(ctx, tenantID)
+		if err != nil {
+			log.Printf("Failed to list connected accounts of tenant %s: %v", tenantID, err)
+			continue
+		}
+		for _, account := range accounts {
+			if account.Status != "active" || recent[account.PlatformUserID] {
+				continue
+			}
+			c.collectAccount(ctx, tenantID, account)
+		}
+	}
+}
+
+func (c *MetricsCollector) collectAccount(ctx context.Context, tenantID string, account ConnectedAccount) {
+	source, ok := c.source(account.Platform)
+	if !ok {
+		return
+	}
+	callCtx, cancel := context.WithTimeout(ctx, metricsCallTimeout)
+	defer cancel()
+	accessToken, err := c.tokens.AccessToken(callCtx, tenantID, account.PlatformUserID)
+	if err != nil {
+		log.Printf("Failed to obtain access token for metrics of account %s: %v", account.PlatformUserID, err)
+		return
+	}
+	metrics, err := source.AccountMetrics(callCtx, PlatformAccount{PlatformUserID: account.PlatformUserID, AccessToken: accessToken})
+	if errors.Is(err, errMetricsUnavailable) || errors.Is(err, errPublisherUnsupported) {
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to fetch metrics of account %s from %s: %v", account.PlatformUserID, account.Platform, err)
+		return
+	}
+	if err := saveAccountMetrics(ctx, tenantID, account, metrics); err != nil {
+		log.Print(err)
+	}
+}
+
+// --- Database Operations ---
+
+// claimPostsForMetrics marks up to limit published posts as collected and
+// returns them. A post is due when it was last claimed more than nine tenths
+// of interval ago, so it is collected on every tick despite timer jitter.
+func claimPostsForMetrics(ctx context.Context, interval, lookback time.Duration, limit int) ([]Post, error) {
+	now := time.Now()
+	rows, err := db.QueryContext(ctx, `
+		UPDATE posts SET metrics_collected_at = now()
+		WHERE id IN (
+			SELECT id FROM posts
+			WHERE status = $1 AND external_id IS NOT NULL AND posted_at > $2
+				AND (metrics_collected_at IS NULL OR metrics_collected_at < $3)
+			ORDER BY metrics_collected_at NULLS FIRST
+			LIMIT $4
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, tenant_id, platform, COALESCE(platform_user_id, ''), external_id`,
+		statusPublished, now.Add(-lookback), now.Add(-interval*9/10), limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim posts for metrics: %w", err)
+	}
+	defer rows.Close()
+
+	var posts []Post
+	for rows.Next() {
+		var post Post
+		if err := rows.Scan(&post.ID, &post.TenantID, &post.Platform, &post.PlatformUserID, &post.ExternalID); err != nil {
+			return nil, fmt.Errorf("failed to scan post claimed for metrics: %w", err)
+		}
+		posts = append(posts, post)
+	}
+	return posts, rows.Err()
+}
+
+func savePostMetrics(ctx context.Context, post Post, m PostMetrics) error {
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO post_metrics (post_id, day, tenant_id, platform, platform_user_id, impressions, reach, likes, comments, shares)
+		VALUES ($1, (now() AT TIME ZONE 'UTC')::date, $2, $3, $4, $5, $6, $7, $8, $9)
+		ON CONFLICT (post_id, day) DO UPDATE SET impressions = EXCLUDED.impressions, reach = EXCLUDED.reach, likes = EXCLUDED.likes,
+			comments = EXCLUDED.comments, shares = EXCLUDED.shares, collected_at = now()`,
+		post.ID, post.TenantID, post.Platform, post.PlatformUserID, m.Impressions, m.Reach, m.Likes, m.Comments, m.Shares,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save metrics of post %s: %w", post.ID, err)
+	}
+	return nil
+}
+
+func saveAccountMetrics(ctx context.Context, tenantID string, account ConnectedAccount, m AccountMetrics) error {
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO account_metrics (tenant_id, platform_user_id, day, platform, followers)
+		VALUES ($1, $2, (now() AT TIME ZONE 'UTC')::date, $3, $4)
+		ON CONFLICT (tenant_id, platform_user_id, day) DO UPDATE SET platform = EXCLUDED.platform, followers = EXCLUDED.followers, collected_at = now()`,
+		tenantID, account.PlatformUserID, account.Platform, m.Followers,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save metrics of account %s: %w", account.PlatformUserID, err)
+	}
+	return nil
+}
+
+// publishingTenants lists the tenants that have published posts.
+func publishingTenants(ctx context.Context) ([]string, error) {
+	rows, err := db.QueryContext(ctx, "SELECT DISTINCT tenant_id FROM posts WHERE status = $1", statusPublished)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list publishing tenants: %w", err)
+	}
+	defer rows.Close()
+	var tenants []string
+	for rows.Next() {
+		var tenantID string
+		if err := rows.Scan(&tenantID); err != nil {
+			return nil, fmt.Errorf("failed to scan tenant: %w", err)
+		}
+		tenants = append(tenants, tenantID)
+	}
+	return tenants, rows.Err()
+}
+
+// recentlyCollectedAccounts returns the accounts of a tenant whose metrics were recorded after since.
+func recentlyCollectedAccounts(ctx context.Context, tenantID string, since time.Time) (map[string]bool, error) {
+	rows, err := db.QueryContext(ctx, "SELECT platform_user_id FROM account_metrics WHERE tenant_id = $1 AND collected_at > $2", tenantID, since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list collected accounts: %w", err)
+	}
+	defer rows.Close()
+	recent := make(map[string]bool)
+	for rows.Next() {
+		var platformUserID string
+		if err := rows.Scan(&platformUserID); err != nil {
+			return nil, fmt.Errorf("failed to scan collected account: %w", err)
+		}
+		recent[platformUserID] = true
+	}
+	return recent, rows.Err()
+}
+
+// --- Queries ---
+
+// postMetricExprs maps the metrics of GET /api/analytics that come from posts
+// to their expression over post_metrics. The followers metric comes from
+// account_metrics.
+var postMetricExprs = map[string]string{
+	"impressions": "impressions",
+	"reach":       "reach",
+	"likes":       "likes",
+	"comments":    "comments",
+	"shares":      "shares",
+	"engagement":  "likes + comments + shares",
+}
+
+// AnalyticsQuery selects the series of GET /api/analytics. From and To are
+// UTC days, both included.
+type AnalyticsQuery struct {
+	From     time.Time
+	To       time.Time
+	Metric   string
+	Platform string
+	Account  string
+	// GroupBy is "platform" for a series per platform or "account" for one per account.
+	GroupBy string
+}
+
+// parseAnalyticsQuery reads the from, to, metric, platform, account and
+// groupBy parameters. The default is the engagement of the last 30 days per platform.
+func parseAnalyticsQuery(r *http.Request) (AnalyticsQuery, error) {
+	params := r.URL.Query()
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	q := AnalyticsQuery{
+		From:     today.AddDate(0, 0, -29),
+		To:       today,
+		Metric:   "engagement",
+		Platform: params.Get("platform"),
+		Account:  params.Get("account"),
+		GroupBy:  "platform",
+	}
+	for _, p := range []struct {
+		name   string
+		target *time.Time
+	}{{"from", &q.From}, {"to", &q.To}} {
+		if value := params.Get(p.name); value != "" {
+			day, err := time.Parse("2006-01-02", value)
+			if err != nil {
+				return q, fmt.Errorf("%s must be a date like 2006-01-02", p.name)
+			}
+			*p.target = day
+		}
+	}
+	if q.To.Before(q.From) {
+		return q, errors.New("from must not be after to")
+	}
+	if days := int(q.To.Sub(q.From).Hours()/24) + 1; days > maxAnalyticsDays {
+		return q, fmt.Errorf("the date range may span at most %d days", maxAnalyticsDays)
+	}
+	if metric := params.Get("metric"); metric != "" {
+		if _, ok := postMetricExprs[metric]; !ok && metric != "followers" {
+			return q, fmt.Errorf("unknown metric %q", metric)
+		}
+		q.Metric = metric
+	}
+	if groupBy := params.Get("groupBy"); groupBy != "" {
+		if groupBy != "platform" && groupBy != "account" {
+			return q, errors.New("groupBy must be \"platform\" or \"account\"")
+		}
+		q.GroupBy = groupBy
+	}
+	return q, nil
+}
+
+// analyticsSeries returns the tenant's values of q.Metric by day and series.
+// Post metrics are summed daily gains; followers are summed daily counts.
+func analyticsSeries(ctx context.Context, tenantID string, q AnalyticsQuery) (map[string]map[string]int64, error) {
+	group := "lower(platform)"
+	if q.GroupBy == "account" {
+		group = "platform_user_id"
+	}
+	var query string
+	if q.Metric == "followers" {
+		query = `SELECT day, ` + group + `, sum(followers)
+			FROM account_metrics
+			WHERE tenant_id = $1 AND day BETWEEN $2 AND $3 AND ($4 = '' OR lower(platform) = lower($4)) AND ($5 = '' OR platform_user_id = $5)
+			GROUP BY 1, 2`
+	} else {
+		// The previous day of each post is looked up before the range is
+		// applied, so the first day of the range also shows a gain.
+		query = `WITH gains AS (
+				SELECT day, platform, platform_user_id,
+					(` + postMetricExprs[q.Metric] + `) - COALESCE(LAG(` + postMetricExprs[q.Metric] + `) OVER (PARTITION BY post_id ORDER BY day), 0) AS gain
+				FROM post_metrics
+				WHERE tenant_id = $1 AND day <= $3 AND ($4 = '' OR lower(platform) = lower($4)) AND ($5 = '' OR platform_user_id = $5)
+			)
+			SELECT day, ` + group + `, sum(gain) FROM gains WHERE day >= $2 GROUP BY 1, 2`
+	}
+	rows, err := db.QueryContext(ctx, query, tenantID, q.From.Format("2006-01-02"), q.To.Format("2006-01-02"), q.Platform, q.Account)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query analytics: %w", err)
+	}
+	defer rows.Close()
+	series := make(map[string]map[string]int64)
+	for rows.Next() {
+		var day time.Time
+		var name string
+		var value int64
+		if err := rows.Scan(&day, &name, &value); err != nil {
+			return nil, fmt.Errorf("failed to scan analytics row: %w", err)
+		}
+		if q.GroupBy == "platform" {
+			name = platformLabel(name)
+		}
+		if series[name] == nil {
+			series[name] = make(map[string]int64)
+		}
+		series[name][day.Format("2006-01-02")] = value
+	}
+	return series, rows.Err()
+}
+
+// platformLabel returns how analytics name a platform stored in any case.
+func platformLabel(platform string) string {
+	for _, label := range analyticsPlatforms {
+		if strings.EqualFold(label, platform) {
+			return label
+		}
+	}
+	return platform
+}
+
+// analyticsPoints turns series into one point per day of q, named after the
+// day, with a value per series. When grouping by platform every platform has
+// a series. Days without a follower count repeat the previous one; other
+// metrics gained nothing on days without data.
+func analyticsPoints(q AnalyticsQuery, series map[string]map[string]int64) []map[string]interface{} {
+	var names []string
+	if q.GroupBy == "platform" {
+		for _, platform := range analyticsPlatforms {
+			if q.Platform == "" || strings.EqualFold(platform, q.Platform) {
+				names = append(names, platform)
+			}
+		}
+	}
+	var others []string
+	for name := range series {
+		if !slices.Contains(names, name) {
+			others = append(others, name)
+		}
+	}
+	sort.Strings(others)
+	names = append(names, others...)
+
+	points := []map[string]interface{}{}
+	last := make(map[string]int64)
+	for day := q.From; !day.After(q.To); day = day.AddDate(0, 0, 1) {
+		name := day.Format("2006-01-02")
+		point := map[string]interface{}{"name": name}
+		for _, s := range names {
+			value, ok := series[s][name]
+			if !ok && q.Metric == "followers" {
+				value = last[s]
+			}
+			last[s] = value
+			point[s] = value
+		}
+		points = append(points, point)
+	}
+	return points
+}
+
+// --- Handlers ---
+
+// getAnalyticsHandler handles GET /api/analytics; see parseAnalyticsQuery for its parameters.
+func getAnalyticsHandler(w http.ResponseWriter, r *http.Request) {
+	_, tenantID, err := getUserIDAndTenantIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	q, err := parseAnalyticsQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	series, err := analyticsSeries(r.Context(), tenantID, q)
+	if err != nil {
+		log.Printf("Failed to get analytics: %v", err)
+		http.Error(w, "Failed to get analytics", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(analyticsPoints(q, series))
+}
+
+// PostMetricsSample is a post's totals as collected on one day.
+type PostMetricsSample struct {
+	Day string `json:"day"`
+	PostMetrics
+	CollectedAt time.Time `json:"collectedAt"`
+}
+
+// listPostMetricsHandler handles GET /api/posts/{postId}/metrics: the post's
+// totals on each day they were collected.
+func listPostMetricsHandler(w http.ResponseWriter, r *http.Request) {
+	_, tenantID, err := getUserIDAndTenantIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	rows, err := db.QueryContext(r.Context(), `
+		SELECT day, impressions, reach, likes, comments, shares, collected_at
+		FROM post_metrics
+		WHERE post_id = $1 AND tenant_id = $2
+		ORDER BY day`,
+		mux.Vars(r)["postId"], tenantID,
+	)
+	if err != nil {
+		writePostError(w, "list metrics", err)
+		return
+	}
+	defer rows.Close()
+	samples := []PostMetricsSample{}
+	for rows.Next() {
+		var s PostMetricsSample
+		var day time.Time
+		if err := rows.Scan(&day, &s.Impressions, &s.Reach, &s.Likes, &s.Comments, &s.Shares, &s.CollectedAt); err != nil {
+			writePostError(w, "list metrics", err)
+			return
+		}
+		s.Day = day.Format("2006-01-02")
+		samples = append(samples, s)
+	}
+	if err := rows.Err(); err != nil {
+		writePostError(w, "list metrics", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(samples)
+}
@@ -0,0 +1,461 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"shared/authz"
+)
+
+// --- Approval Workflow ---
+//
+// A post is created as a draft. Submitting it starts a review round: the post
+// waits in pending_approval until as many members as the tenant requires have
+// approved it, then it becomes approved and is scheduled for the publishing
+// worker, which only ever claims approved posts. One rejection ends the round;
+// a rejected post can be submitted again for a new round. The number of
+// approvals is a tenant setting, read when the post is submitted. Authors
+// cannot approve their own posts, and neither can whoever last edited a post or
+// submitted its current round. Tenants that require no approvals have their
+// posts approved on submission.
+//
+// Every status change, including the publishing worker's, is recorded in
+// post_transitions with who made it and when.
+
+const maxRequiredApprovals = 10
+
+// postTransitions lists the statuses a post may move to from each status. Edits
+// send a submitted post back to draft (see posts.go), and posts can be cancelled
+// or deleted until the publishing worker claims them.
+var postTransitions = map[string][]string{
+	statusDraft:           {statusPendingApproval, statusCancelled, statusDeleted},
+	statusPendingApproval: {statusApproved, statusRejected, statusDraft, statusCancelled, statusDeleted},
+	statusRejected:        {statusPendingApproval, statusCancelled, statusDeleted},
+	statusApproved:        {statusScheduled, statusDraft, statusCancelled, statusDeleted},
+	statusScheduled:       {statusPublishing, statusDraft, statusCancelled, statusDeleted},
+	statusPublishing:      {statusPublished, statusFailed},
+	statusFailed:          {statusDraft, statusDeleted},
+	statusCancelled:       {statusDraft, statusDeleted},
+}
+
+var (
+	errPostNotFound      = errors.New("post not found")
+	errInvalidTransition = errors.New("invalid status transition")
+	errAlreadyApproved   = errors.New("already approved by this user")
+	errSelfApproval      = errors.New("posts cannot be approved by their author, last editor or submitter")
+	errRejectionComment  = errors.New("a comment explaining the rejection is required")
+)
+
+// PostTransition is one recorded status change of a post.
+type PostTransition struct {
+	ID         int64     `json:"id"`
+	FromStatus string    `json:"fromStatus"`
+	ToStatus   string    `json:"toStatus"`
+	ActorID    string    `json:"actorId,omitempty"`
+	Comment    string    `json:"comment,omitempty"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+// PostApproval is one member's sign-off in a review round.
+type PostApproval struct {
+	Round     int       `json:"round"`
+	UserID    string    `json:"userId"`
+	Comment   string    `json:"comment,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// TenantSettings are a tenant's post-service preferences.
+type TenantSettings struct {
+	RequiredApprovals int `json:"requiredApprovals"`
+}
+
+func canTransition(from, to string) bool {
+	for _, allowed := range postTransitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+func transitionError(from string) error {
+	return fmt.Errorf("%w: the post is %s", errInvalidTransition, strings.ReplaceAll(from, "_", " "))
+}
+
+// getPostForUpdate loads a tenant's post and locks it for the transaction.
+func getPostForUpdate(ctx context.Context, tx *sql.Tx, tenantID, postID string) (Post, error) {
+	row := tx.QueryRowContext(ctx, "SELECT "+postColumns+" FROM posts p WHERE p.id = $1 AND p.tenant_id = $2 AND p.status <> $3 FOR UPDATE", postID, tenantID, statusDeleted)
+	post, err := scanPost(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Post{}, errPostNotFound
+	}
+	if err != nil {
+		return Post{}, fmt.Errorf("failed to load post: %w", err)
+	}
+	return post, nil
+}
+
+// transitionPost moves post to status and records who did it. A post sent back
+// to draft loses its approval.
+func transitionPost(ctx context.Context, tx *sql.Tx, post *Post, status, actorID, comment string) error {
+	if !canTransition(post.Status, status) {
+		return transitionError(post.Status)
+	}
+	err := tx.QueryRowContext(ctx, `
+		UPDATE posts SET status = $2, version = version + 1, updated_at = now(),
+			approved_at = CASE WHEN $2 = 'approved' THEN now() WHEN $2 = 'draft' THEN NULL ELSE approved_at END
+		WHERE id = $1
+		RETURNING version, updated_at`,
+		post.ID, status,
+	).Scan(&post.Version, &post.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to update post status: %w", err)
+	}
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO post_transitions (post_id, from_status, to_status, actor_id, comment) VALUES ($1, $2, $3, $4, $5)",
+		post.ID, post.Status, status, actorID, sql.NullString{String: comment, Valid: comment != ""},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record post transition: %w", err)
+	}
+	post.Status = status
+	return nil
+}
+
+// approveAndSchedule ends a review round successfully.
+func approveAndSchedule(ctx context.Context, tx *sql.Tx, post *Post, actorID, comment string) error {
+	if err := transitionPost(ctx, tx, post, statusApproved, actorID, comment); err != nil {
+		return err
+	}
+	return transitionPost(ctx, tx, post, statusScheduled, actorID, "")
+}
+
+// submitPost starts a new review round for post that needs required approvals.
+func submitPost(ctx context.Context, tx *sql.Tx, post *Post, actorID, comment string, required int) error {
+	if err := transitionPost(ctx, tx, post, statusPendingApproval, actorID, comment); err != nil {
+		return err
+	}
+	err := tx.QueryRowContext(ctx,
+		"UPDATE posts SET approval_round = approval_round + 1, required_approvals = $2, submitted_by = $3 WHERE id = $1 RETURNING approval_round",
+		post.ID, required, actorID,
+	).Scan(&post.ApprovalRound)
+	if err != nil {
+		return fmt.Errorf("failed to start review round: %w", err)
+	}
+	post.RequiredApprovals, post.Approvals = required, 0
+	if required == 0 {
+		return approveAndSchedule(ctx, tx, post, actorID, "No approval required")
+	}
+	return nil
+}
+
+// approvePost records actorID's approval of post in its current round and
+// schedules the post once enough members have approved it. The post's author,
+// last editor and the member who submitted the round cannot approve it.
+func approvePost(ctx context.Context, tx *sql.Tx, post *Post, actorID, comment string) error {
+	if post.Status != statusPendingApproval {
+		return transitionError(post.Status)
+	}
+	if post.UserID == actorID {
+		return errSelfApproval
+	}
+	var editorID, submitterID string
+	err := tx.QueryRowContext(ctx,
+		"SELECT COALESCE(last_edited_by, ''), COALESCE(submitted_by, '') FROM posts WHERE id = $1", post.ID,
+	).Scan(&editorID, &submitterID)
+	if err != nil {
+		return fmt.Errorf("failed to load post editors: %w", err)
+	}
+	if editorID == actorID || submitterID == actorID {
+		return errSelfApproval
+	}
+	res, err := tx.ExecContext(ctx,
+		"INSERT INTO post_approvals (post_id, round, user_id, comment) VALUES ($1, $2, $3, $4) ON CONFLICT DO NOTHING",
+		post.ID, post.ApprovalRound, actorID, sql.NullString{String: comment, Valid: comment != ""},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record approval: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errAlreadyApproved
+	}
+	post.Approvals++
+	if post.Approvals >= post.RequiredApprovals {
+		return approveAndSchedule(ctx, tx, post, actorID, comment)
+	}
+	return nil
+}
+
+// getRequiredApprovals returns the tenant's approval setting; tenants that
+// never changed it require none.
+func getRequiredApprovals(ctx context.Context, tx *sql.Tx, tenantID string) (int, error) {
+	var required int
+	err := tx.QueryRowContext(ctx, "SELECT required_approvals FROM tenant_settings WHERE tenant_id = $1", tenantID).Scan(&required)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to get tenant settings: %w", err)
+	}
+	return required, nil
+}
+
+// postRequest starts a transaction for an action on the post named in the URL,
+// honouring an If-Match header if the client sent one. On failure it writes the
+// response and returns a nil transaction.
+func postRequest(w http.ResponseWriter, r *http.Request, action string) (*sql.Tx, Post, string) {
+	userID, tenantID, err := getUserIDAndTenantIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil, Post{}, ""
+	}
+	tx, err := db.BeginTx(r.Context(), nil)
+	if err != nil {
+		writePostError(w, action, err)
+		return nil, Post{}, ""
+	}
+	post, err := getPostForUpdate(r.Context(), tx, tenantID, mux.Vars(r)["postId"])
+	if err != nil {
+		tx.Rollback()
+		writePostError(w, action, err)
+		return nil, Post{}, ""
+	}
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" && !etagMatches(ifMatch, post) {
+		tx.Rollback()
+		writePostError(w, action, errPostChanged)
+		return nil, Post{}, ""
+	}
+	return tx, post, userID
+}
+
+func writePostError(w http.ResponseWriter, action string, err error) {
+	switch {
+	case errors.Is(err, errPostNotFound):
+		http.Error(w, "Post not found", http.StatusNotFound)
+	case errors.Is(err, errInvalidTransition):
+		http.Error(w, fmt.Sprintf("Cannot %s: %v", action, err), http.StatusConflict)
+	case errors.Is(err, errRejectionComment):
+		http.Error(w, "A comment explaining the rejection is required", http.StatusBadRequest)
+	case errors.Is(err, errSelfApproval):
+		authz.WriteForbidden(w, authz.ForbiddenResponse{Error: "forbidden", Message: "Posts cannot be approved by their author, last editor or submitter."})
+	case errors.Is(err, errAlreadyApproved):
+		http.Error(w, "You have already approved this post", http.StatusConflict)
+	case errors.Is(err, errPostLocked):
+		http.Error(w, "Posts cannot be changed once publishing has started", http.StatusConflict)
+	case errors.Is(err, errPostChanged):
+		http.Error(w, "The post was changed by someone else; reload it and try again", http.StatusPreconditionFailed)
+	default:
+		log.Printf("Failed to %s: %v", action, err)
+		http.Error(w, "Failed to "+action, http.StatusInternalServerError)
+	}
+}
+
+func writePost(w http.ResponseWriter, post Post) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", postETag(post))
+	json.NewEncoder(w).Encode(post)
+}
+
+// decodeComment reads the optional {"comment": "..."} body of a review action.
+func decodeComment(w http.ResponseWriter, r *http.Request) (string, bool) {
+	var req struct {
+		Comment string `json:"comment"`
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, 1<<16)
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return "", false
+	}
+	return strings.TrimSpace(req.Comment), true
+}
+
+// --- Handlers ---
+
+func submitPostHandler(w http.ResponseWriter, r *http.Request) {
+	comment, ok := decodeComment(w, r)
+	if !ok {
+		return
+	}
+	tx, post, userID := postRequest(w, r, "submit post")
+	if tx == nil {
+		return
+	}
+	defer tx.Rollback()
+	ctx := r.Context()
+	required, err := getRequiredApprovals(ctx, tx, post.TenantID)
+	if err == nil {
+		err = submitPost(ctx, tx, &post, userID, comment, required)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		writePostError(w, "submit post", err)
+		return
+	}
+	writePost(w, post)
+}
+
+func approvePostHandler(w http.ResponseWriter, r *http.Request) {
+	comment, ok := decodeComment(w, r)
+	if !ok {
+		return
+	}
+	tx, post, userID := postRequest(w, r, "approve post")
+	if tx == nil {
+		return
+	}
+	defer tx.Rollback()
+	err := approvePost(r.Context(), tx, &post, userID, comment)
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		writePostError(w, "approve post", err)
+		return
+	}
+	writePost(w, post)
+}
+
+func rejectPostHandler(w http.ResponseWriter, r *http.Request) {
+	comment, ok := decodeComment(w, r)
+	if !ok {
+		return
+	}
+	if comment == "" {
+		writePostError(w, "reject post", errRejectionComment)
+		return
+	}
+	tx, post, userID := postRequest(w, r, "reject post")
+	if tx == nil {
+		return
+	}
+	defer tx.Rollback()
+	err := transitionPost(r.Context(), tx, &post, statusRejected, userID, comment)
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		writePostError(w, "reject post", err)
+		return
+	}
+	writePost(w, post)
+}
+
+func listApprovalsHandler(w http.ResponseWriter, r *http.Request) {
+	_, tenantID, err := getUserIDAndTenantIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	rows, err := db.QueryContext(r.Context(), `
+		SELECT a.round, a.user_id, COALESCE(a.comment, ''), a.created_at
+		FROM post_approvals a JOIN posts p ON p.id = a.post_id
+		WHERE a.post_id = $1 AND p.tenant_id = $2
+		ORDER BY a.created_at`,
+		mux.Vars(r)["postId"], tenantID,
+	)
+	if err != nil {
+		writePostError(w, "list approvals", err)
+		return
+	}
+	defer rows.Close()
+	approvals := []PostApproval{}
+	for rows.Next() {
+		var a PostApproval
+		if err := rows.Scan(&a.Round, &a.UserID, &a.Comment, &a.CreatedAt); err != nil {
+			writePostError(w, "list approvals", err)
+			return
+		}
+		approvals = append(approvals, a)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(approvals)
+}
+
+func listTransitionsHandler(w http.ResponseWriter, r *http.Request) {
+	_, tenantID, err := getUserIDAndTenantIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	rows, err := db.QueryContext(r.Context(), `
+		SELECT t.id, t.from_status, t.to_status, COALESCE(t.actor_id, ''), COALESCE(t.comment, ''), t.created_at
+		FROM post_transitions t JOIN posts p ON p.id = t.post_id
+		WHERE t.post_id = $1 AND p.tenant_id = $2
+		ORDER BY t.id`,
+		mux.Vars(r)["postId"], tenantID,
+	)
+	if err != nil {
+		writePostError(w, "list transitions", err)
+		return
+	}
+	defer rows.Close()
+	transitions := []PostTransition{}
+	for rows.Next() {
+		var t PostTransition
+		if err := rows.Scan(&t.ID, &t.FromStatus, &t.ToStatus, &t.ActorID, &t.Comment, &t.CreatedAt); err != nil {
+			writePostError(w, "list transitions", err)
+			return
+		}
+		transitions = append(transitions, t)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(transitions)
+}
+
+func getTenantSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	_, tenantID, err := getUserIDAndTenantIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var settings TenantSettings
+	err = db.QueryRowContext(r.Context(), "SELECT required_approvals FROM tenant_settings WHERE tenant_id = $1", tenantID).Scan(&settings.RequiredApprovals)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		writePostError(w, "get settings", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(settings)
+}
+
+// updateTenantSettingsHandler changes the tenant's settings. A new approval
+// count applies to posts submitted afterwards.
+func updateTenantSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, tenantID, err := getUserIDAndTenantIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var settings TenantSettings
+	r.Body = http.MaxBytesReader(w, r.Body, 1<<16)
+	if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if settings.RequiredApprovals < 0 || settings.RequiredApprovals > maxRequiredApprovals {
+		http.Error(w, fmt.Sprintf("requiredApprovals must be between 0 and %d", maxRequiredApprovals), http.StatusBadRequest)
+		return
+	}
+	_, err = db.ExecContext(r.Context(), `
+		INSERT INTO tenant_settings (tenant_id, required_approvals, updated_by, updated_at) VALUES ($1, $2, $3, now())
+		ON CONFLICT (tenant_id) DO UPDATE SET required_approvals = EXCLUDED.required_approvals, updated_by = EXCLUDED.updated_by, updated_at = now()`,
+		tenantID, settings.RequiredApprovals, userID,
+	)
+	if err != nil {
+		writePostError(w, "update settings", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(settings)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/http"
+
+	"shared/authz"
+)
+
+// --- Authorization ---
+//
+// Access tokens carry the caller's role in their tenant and every API route
+// requires a permission that role must grant; see shared/authz for the roles
+// and the middleware. This file holds the service's own route table.
+
+// routePermissions maps "METHOD /path/template" of every API route to the
+// permission it requires.
+var routePermissions = map[string]authz.Permission{
+	"GET /api/posts":                           authz.PostsRead,
+	"POST /api/posts":                          authz.PostsWrite,
+	"POST /api/posts/validate":                 authz.PostsWrite,
+	"GET /api/posts/{postId}":                  authz.PostsRead,
+	"PUT /api/posts/{postId}":                  authz.PostsWrite,
+	"PATCH /api/posts/{postId}":                authz.PostsWrite,
+	"DELETE /api/posts/{postId}":               authz.PostsWrite,
+	"POST /api/posts/{postId}/submit":          authz.PostsWrite,
+	"POST /api/posts/{postId}/approve":         authz.PostsApprove,
+	"POST /api/posts/{postId}/reject":          authz.PostsApprove,
+	"GET /api/posts/{postId}/approvals":        authz.PostsRead,
+	"GET /api/posts/{postId}/transitions":      authz.PostsRead,
+	"GET /api/posts/{postId}/metrics":          authz.AnalyticsRead,
+	"GET /api/campaigns":                       authz.PostsRead,
+	"POST /api/campaigns":                      authz.PostsWrite,
+	"GET /api/campaigns/{campaignId}":          authz.PostsRead,
+	"POST /api/campaigns/{campaignId}/submit":  authz.PostsWrite,
+	"POST /api/campaigns/{campaignId}/approve": authz.PostsApprove,
+	"POST /api/campaigns/{campaignId}/reject":  authz.PostsApprove,
+	"GET /api/media":                           authz.MediaRead,
+	"POST /api/media":                          authz.MediaWrite,
+	"POST /api/media/uploads":                  authz.MediaWrite,
+	"GET /api/media/uploads/{uploadId}":        authz.MediaWrite,
+	"PUT /api/media/uploads/{uploadId}":        authz.MediaWrite,
+	"DELETE /api/media/uploads/{uploadId}":     authz.MediaWrite,
+	"GET /api/media/{mediaId}":                 authz.MediaRead,
+	"GET /api/media/{mediaId}/renditions":      authz.MediaRead,
+	"POST /api/media/{mediaId}/renditions":     authz.MediaWrite,
+	"DELETE /api/media/{mediaId}":              authz.MediaWrite,
+	"GET /api/analytics":                       authz.AnalyticsRead,
+	"GET /api/reports":                         authz.AnalyticsRead,
+	"POST /api/reports":                        authz.ReportsManage,
+	"GET /api/reports/{reportId}":              authz.AnalyticsRead,
+	"GET /api/settings":                        authz.PostsRead,
+	"PUT /api/settings":                        authz.SettingsManage,
+
+	// Scheduled reports.
+	"GET /api/report-subscriptions":                                                 authz.AnalyticsRead,
+	"POST /api/report-subscriptions":                                                authz.ReportsManage,
+	"GET /api/report-subscriptions/{subscriptionId}":                                authz.AnalyticsRead,
+	"PUT /api/report-subscriptions/{subscriptionId}":                                authz.ReportsManage,
+	"DELETE /api/report-subscriptions/{subscriptionId}":                             authz.ReportsManage,
+	"GET /api/report-subscriptions/{subscriptionId}/deliveries":                     authz.AnalyticsRead,
+	"POST /api/report-subscriptions/{subscriptionId}/deliveries/{deliveryId}/retry": authz.ReportsManage,
+
+	// Engagement inbox.
+	"GET /api/inbox/conversations":                           authz.InboxRead,
+	"GET /api/inbox/conversations/{conversationId}":          authz.InboxRead,
+	"GET /api/inbox/conversations/{conversationId}/messages": authz.InboxRead,
+	"POST /api/inbox/conversations/{conversationId}/read":    authz.InboxManage,
+	"POST /api/inbox/conversations/{conversationId}/unread":  authz.InboxManage,
+}
+
+// authorize admits requests whose role grants the permission of the matched route.
+// It must run after authMiddleware.
+var authorize = authz.Authorize(routePermissions, func(r *http.Request) string {
+	role, _ := r.Context().Value(roleKey).(string)
+	return role
+})
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/mux"
+	"shared/authz"
+	"shared/jwks"
+	"shared/revocation"
+)
+
+// Who may call each API route, written out by hand rather than derived from
+// routePermissions, so a change to either shows up here.
+const (
+	everyone = "owner admin editor viewer"
+	editors  = "owner admin editor"
+	admins   = "owner admin"
+)
+
+var expectedRouteRoles = map[string]string{
+	"GET /api/posts":                           everyone,
+	"POST /api/posts":                          editors,
+	"POST /api/posts/validate":                 editors,
+	"GET /api/posts/{postId}":                  everyone,
+	"PUT /api/posts/{postId}":                  editors,
+	"PATCH /api/posts/{postId}":                editors,
+	"DELETE /api/posts/{postId}":               editors,
+	"POST /api/posts/{postId}/submit":          editors,
+	"POST /api/posts/{postId}/approve":         admins,
+	"POST /api/posts/{postId}/reject":          admins,
+	"GET /api/posts/{postId}/approvals":        everyone,
+	"GET /api/posts/{postId}/transitions":      everyone,
+	"GET /api/posts/{postId}/metrics":          everyone,
+	"GET /api/campaigns":                       everyone,
+	"POST /api/campaigns":                      editors,
+	"GET /api/campaigns/{campaignId}":          everyone,
+	"POST /api/campaigns/{campaignId}/submit":  editors,
+	"POST /api/campaigns/{campaignId}/approve": admins,
+	"POST /api/campaigns/{campaignId}/reject":  admins,
+	"GET /api/media":                           everyone,
+	"POST /api/media":                          editors,
+	"POST /api/media/uploads":                  editors,
+	"GET /api/media/uploads/{uploadId}":        editors,
+	"PUT /api/media/uploads/{uploadId}":        editors,
+	"DELETE /api/media/uploads/{uploadId}":     editors,
+	"GET /api/media/{mediaId}":                 everyone,
+	"DELETE /api/media/{mediaId}":              editors,
+	"GET /api/media/{mediaId}/renditions":      everyone,
+	"POST /api/media/{mediaId}/renditions":     editors,
+	"GET /api/analytics":                       everyone,
+	"GET /api/reports":                         everyone,
+	"POST /api/reports":                        editors,
+	"GET /api/reports/{reportId}":              everyone,
+	"GET /api/settings":                        everyone,
+	"PUT /api/settings":                        admins,
+
+	"GET /api/report-subscriptions":                                                 everyone,
+	"POST /api/report-subscriptions":                                                editors,
+	"GET /api/report-subscriptions/{subscriptionId}":                                everyone,
+	"PUT /api/report-subscriptions/{subscriptionId}":                                editors,
+	"DELETE /api/report-subscriptions/{subscriptionId}":                             editors,
+	"GET /api/report-subscriptions/{subscriptionId}/deliveries":                     everyone,
+	"POST /api/report-subscriptions/{subscriptionId}/deliveries/{deliveryId}/retry": editors,
+
+	"GET /api/inbox/conversations":                           everyone,
+	"GET /api/inbox/conversations/{conversationId}":          everyone,
+	"GET /api/inbox/conversations/{conversationId}/messages": everyone,
+	"POST /api/inbox/conversations/{conversationId}/read":    editors,
+	"POST /api/inbox/conversations/{conversationId}/unread":  editors,
+}
+
+// expectedToAllow reports whether expectedRouteRoles lets role call route.
+func expectedToAllow(route, role string) bool {
+	for _, r := range strings.Fields(expectedRouteRoles[route]) {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+var pathVarPattern = regexp.MustCompile(`\{[^}]+\}`)
+
+// apiRoutes walks router and returns every "METHOD /path/template" under /api.
+func apiRoutes(t *testing.T, router *mux.Router) []string {
+	t.Helper()
+	var routes []string
+	err := router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		template, err := route.GetPathTemplate()
+		if err != nil || !strings.HasPrefix(template, "/api/") {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			t.Errorf("route %s accepts every method", template)
+			return nil
+		}
+		for _, method := range methods {
+			routes = append(routes, method+" "+template)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Walk: %v", err)
+	}
+	sort.Strings(routes)
+	return routes
+}
+
+// newTestRouter returns the service's router with authMiddleware trusting
+// tokens from the returned signer.
+func newTestRouter(t *testing.T) (*mux.Router, func(role string) string) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg = &Config{JWTIssuer: "test-issuer"}
+	keySet = jwks.StaticKeySet{"test": pub}
+	revokedTokens = revocation.NewList()
+	sign := func(role string) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, Claims{
+			UserID:   "user-1",
+			TenantID: "tenant-1",
+			Role:     role,
+			RegisteredClaims: jwt.RegisteredClaims{
+				Issuer:    cfg.JWTIssuer,
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+			},
+		})
+		token.Header["kid"] = "test"
+		signed, err := token.SignedString(priv)
+		if err != nil {
+			t.Fatalf("sign: %v", err)
+		}
+		return signed
+	}
+	return newRouter(newPublisherRegistry()), sign
+}
+
+func TestEveryAPIRouteHasAPermission(t *testing.T) {
+	router, _ := newTestRouter(t)
+	served := map[string]bool{}
+	for _, route := range apiRoutes(t, router) {
+		served[route] = true
+		if _, ok := routePermissions[route]; !ok {
+			t.Errorf("%s has no entry in routePermissions", route)
+		}
+		if _, ok := expectedRouteRoles[route]; !ok {
+			t.Errorf("%s has no entry in expectedRouteRoles", route)
+		}
+	}
+	for route := range routePermissions {
+		if !served[route] {
+			t.Errorf("routePermissions has %s, which the router does not serve", route)
+		}
+	}
+}
+
+func TestRolesMatchExpectedMatrix(t *testing.T) {
+	for route := range expectedRouteRoles {
+		for role := range authz.RolePermissions {
+			want := expectedToAllow(route, role)
+			if got := authz.RoleHas(role, routePermissions[route]); got != want {
+				t.Errorf("%s as %s: allowed = %v; want %v", route, role, got, want)
+			}
+		}
+	}
+}
+
+func TestRouterRefusesRolesWithoutPermission(t *testing.T) {
+	router, sign := newTestRouter(t)
+	for _, route := range apiRoutes(t, router) {
+		method, template, _ := strings.Cut(route, " ")
+		path := pathVarPattern.ReplaceAllString(template, "1")
+		for _, role := range []string{"owner", "admin", "editor", "viewer", "", "unknown"} {
+			if expectedToAllow(route, role) {
+				continue
+			}
+			req := httptest.NewRequest(method, path, nil)
+			req.Header.Set("Authorization", "Bearer "+sign(role))
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			if rec.Code != http.StatusForbidden {
+				t.Errorf("%s as %q = %d; want 403", route, role, rec.Code)
+				continue
+			}
+			var body authz.ForbiddenResponse
+			if err := json.NewDecoder(rec.Body).Decode(&body); err != nil || body.RequiredPermission != routePermissions[route] {
+				t.Errorf("%s as %q: 403 body = %+v, %v; want requiredPermission %q", route, role, body, err, routePermissions[route])
+			}
+		}
+	}
+}
+
+func TestRouterRequiresToken(t *testing.T) {
+	router, _ := newTestRouter(t)
+	for _, route := range apiRoutes(t, router) {
+		method, template, _ := strings.Cut(route, " ")
+		req := httptest.NewRequest(method, pathVarPattern.ReplaceAllString(template, "1"), nil)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("%s without a token = %d; want 401", route, rec.Code)
+		}
+	}
+}
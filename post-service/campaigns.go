@@ -0,0 +1,588 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// --- Campaigns ---
+//
+// A campaign is one composed post sent to several connected accounts. It holds
+// the shared content, media and hashtags, and each target account may override
+// the caption, media or hashtags. Creating a campaign expands it into one draft
+// post per target; those posts then go through approval and publishing like any
+// other and can be edited one by one. A campaign has no status of its own: it
+// is rolled up from its posts, and posts that failed to publish are listed so
+// a partial failure can be followed up.
+
+// Rolled-up campaign statuses besides the post statuses.
+const (
+	campaignStatusInProgress      = "in_progress"
+	campaignStatusPartiallyFailed = "partially_failed"
+)
+
+var errCampaignNotFound = errors.New("campaign not found")
+
+// Campaign is a campaign with the rolled-up state of its posts.
+type Campaign struct {
+	ID           string            `json:"id"`
+	TenantID     string            `json:"tenantId"`
+	UserID       string            `json:"userId"`
+	Name         string            `json:"name"`
+	Content      string            `json:"content"`
+	MediaID      string            `json:"mediaId,omitempty"`
+	Hashtags     []string          `json:"hashtags"`
+	ScheduledAt  time.Time         `json:"scheduledAt"`
+	CreatedAt    time.Time         `json:"createdAt"`
+	Status       string            `json:"status"`
+	StatusCounts map[string]int    `json:"statusCounts"`
+	Failures     []CampaignFailure `json:"failures"`
+	// Targets and Posts are only filled in for a single campaign.
+	Targets []CampaignTarget `json:"targets,omitempty"`
+	Posts   []Post           `json:"posts,omitempty"`
+}
+
+// CampaignTarget is one account a campaign is sent to. Nil overrides use the
+// campaign's value; an empty Hashtags list means no hashtags.
+type CampaignTarget struct {
+	PlatformUserID string  `json:"platformUserId"`
+	Platform       string  `json:"platform"`
+	Caption        *string `json:"caption,omitempty"`
+	// MediaID names library media to send instead of the campaign's.
+	MediaID  *string  `json:"mediaId,omitempty"`
+	Hashtags []string `json:"hashtags,omitempty"`
+	PostID   string   `json:"postId"`
+}
+
+// CampaignFailure reports a campaign post that failed to publish.
+type CampaignFailure struct {
+	PostID         string `json:"postId"`
+	Platform       string `json:"platform"`
+	PlatformUserID string `json:"platformUserId"`
+	Error          string `json:"error"`
+}
+
+// rollupStatus summarises the statuses of a campaign's posts.
+func rollupStatus(counts map[string]int) string {
+	total := 0
+	for _, n := range counts {
+		total += n
+	}
+	switch {
+	case total == 0:
+		return statusCancelled
+	case counts[statusPublishing] > 0:
+		return statusPublishing
+	case len(counts) == 1:
+		for status := range counts {
+			return status
+		}
+	}
+	if counts[statusPublished]+counts[statusFailed]+counts[statusCancelled] == total {
+		switch {
+		case counts[statusFailed] > 0 && counts[statusPublished] > 0:
+			return campaignStatusPartiallyFailed
+		case counts[statusFailed] > 0:
+			return statusFailed
+		case counts[statusPublished] > 0:
+			return statusPublished
+		}
+	}
+	return campaignStatusInProgress
+}
+
+// addPost counts a post towards the campaign's rolled-up state.
+func (c *Campaign) addPost(post Post) {
+	c.StatusCounts[post.Status]++
+	if post.Status == statusFailed {
+		c.Failures = append(c.Failures, CampaignFailure{PostID: post.ID, Platform: post.Platform, PlatformUserID: post.PlatformUserID, Error: post.LastError})
+	}
+}
+
+// normalizeHashtags strips leading '#' and drops empty tags. nil stays nil so
+// a target without hashtags keeps inheriting the campaign's.
+func normalizeHashtags(tags []string) ([]string, error) {
+	if tags == nil {
+		return nil, nil
+	}
+	normalized := []string{}
+	for _, tag := range tags {
+		tag = strings.TrimLeft(strings.TrimSpace(tag), "#")
+		if tag == "" {
+			continue
+		}
+		if strings.ContainsAny(tag, " \t\n#") {
+			return nil, fmt.Errorf("hashtag %q may not contain spaces or '#'", tag)
+		}
+		normalized = append(normalized, tag)
+	}
+	return normalized, nil
+}
+
+// composeContent appends hashtags to a caption.
+func composeContent(caption string, hashtags []string) string {
+	if len(hashtags) == 0 {
+		return caption
+	}
+	return strings.TrimSpace(caption) + "\n\n#" + strings.Join(hashtags, " #")
+}
+
+// expand builds the post for target, applying its overrides to the campaign's content.
+func (c Campaign) expand(target CampaignTarget) Post {
+	caption, mediaID, hashtags := c.Content, c.MediaID, c.Hashtags
+	if target.Caption != nil {
+		caption = *target.Caption
+	}
+	if target.MediaID != nil {
+		mediaID = *target.MediaID
+	}
+	if target.Hashtags != nil {
+		hashtags = target.Hashtags
+	}
+	return Post{
+		UserID:         c.UserID,
+		TenantID:       c.TenantID,
+		Platform:       target.Platform,
+		PlatformUserID: target.PlatformUserID,
+		Content:        composeContent(caption, hashtags),
+		MediaID:        mediaID,
+		ScheduledAt:    c.ScheduledAt,
+		Status:         statusDraft,
+		CampaignID:     c.ID,
+		Version:        1,
+	}
+}
+
+// --- Database Operations ---
+
+const campaignColumns = "id, tenant_id, user_id, name, content, COALESCE(media_id, ''), hashtags, scheduled_at, created_at"
+
+func scanCampaign(row interface{ Scan(...interface{}) error }) (Campaign, error) {
+	c := Campaign{StatusCounts: map[string]int{}, Failures: []CampaignFailure{}}
+	err := row.Scan(&c.ID, &c.TenantID, &c.UserID, &c.Name, &c.Content, &c.MediaID, (*pq.StringArray)(&c.Hashtags), &c.ScheduledAt, &c.CreatedAt)
+	return c, err
+}
+
+// getCampaignsForTenant returns a tenant's campaigns, newest first, with their
+// rolled-up status.
+func getCampaignsForTenant(ctx context.Context, tenantID string) ([]Campaign, error) {
+	rows, err := db.QueryContext(ctx, "SELECT "+campaignColumns+" FROM campaigns WHERE tenant_id = $1 ORDER BY created_at DESC", tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get campaigns: %w", err)
+	}
+	defer rows.Close()
+	campaigns := []Campaign{}
+	byID := make(map[string]*Campaign)
+	for rows.Next() {
+		c, err := scanCampaign(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan campaign row: %w", err)
+		}
+		campaigns = append(campaigns, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get campaigns: %w", err)
+	}
+	for i := range campaigns {
+		byID[campaigns[i].ID] = &campaigns[i]
+	}
+
+	postRows, err := db.QueryContext(ctx,
+		"SELECT "+postColumns+" FROM posts p WHERE p.tenant_id = $1 AND p.campaign_id IS NOT NULL AND p.status <> $2 ORDER BY p.id",
+		tenantID, statusDeleted,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get campaign posts: %w", err)
+	}
+	defer postRows.Close()
+	for postRows.Next() {
+		post, err := scanPost(postRows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan post row: %w", err)
+		}
+		if c, ok := byID[post.CampaignID]; ok {
+			c.addPost(post)
+		}
+	}
+	for i := range campaigns {
+		campaigns[i].Status = rollupStatus(campaigns[i].StatusCounts)
+	}
+	return campaigns, postRows.Err()
+}
+
+// getCampaign returns one campaign with its targets and posts.
+func getCampaign(ctx context.Context, tenantID, campaignID string) (Campaign, error) {
+	c, err := scanCampaign(db.QueryRowContext(ctx, "SELECT "+campaignColumns+" FROM campaigns WHERE id = $1 AND tenant_id = $2", campaignID, tenantID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Campaign{}, errCampaignNotFound
+	}
+	if err != nil {
+		return Campaign{}, fmt.Errorf("failed to get campaign: %w", err)
+	}
+
+	rows, err := db.QueryContext(ctx,
+		"SELECT platform_user_id, platform, caption, media_id, hashtags, post_id FROM campaign_targets WHERE campaign_id = $1 ORDER BY platform, platform_user_id",
+		campaignID,
+	)
+	if err != nil {
+		return Campaign{}, fmt.Errorf("failed to get campaign targets: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t CampaignTarget
+		var caption, mediaID sql.NullString
+		if err := rows.Scan(&t.PlatformUserID, &t.Platform, &caption, &mediaID, (*pq.StringArray)(&t.Hashtags), &t.PostID); err != nil {
+			return Campaign{}, fmt.Errorf("failed to scan campaign target: %w", err)
+		}
+		if caption.Valid {
+			t.Caption = &caption.String
+		}
+		if mediaID.Valid {
+			t.MediaID = &mediaID.String
+		}
+		c.Targets = append(c.Targets, t)
+	}
+	if err := rows.Err(); err != nil {
+		return Campaign{}, fmt.Errorf("failed to get campaign targets: %w", err)
+	}
+
+	postRows, err := db.QueryContext(ctx,
+		"SELECT "+postColumns+" FROM posts p WHERE p.campaign_id = $1 AND p.status <> $2 ORDER BY p.platform, p.platform_user_id",
+		campaignID, statusDeleted,
+	)
+	if err != nil {
+		return Campaign{}, fmt.Errorf("failed to get campaign posts: %w", err)
+	}
+	defer postRows.Close()
+	for postRows.Next() {
+		post, err := scanPost(postRows)
+		if err != nil {
+			return Campaign{}, fmt.Errorf("failed to scan post row: %w", err)
+		}
+		c.Posts = append(c.Posts, post)
+		c.addPost(post)
+	}
+	c.Status = rollupStatus(c.StatusCounts)
+	return c, postRows.Err()
+}
+
+// saveCampaign stores a campaign and its targets and creates their posts.
+func saveCampaign(ctx context.Context, tx *sql.Tx, c *Campaign) ([]Post, error) {
+	_, err := tx.ExecContext(ctx,
+		"INSERT INTO campaigns (id, tenant_id, user_id, name, content, media_id, hashtags, scheduled_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)",
+		c.ID, c.TenantID, c.UserID, c.Name, c.Content, sql.NullString{String: c.MediaID, Valid: c.MediaID != ""}, pq.StringArray(c.Hashtags), c.ScheduledAt,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save campaign: %w", err)
+	}
+	posts := make([]Post, 0, len(c.Targets))
+	for i := range c.Targets {
+		t := &c.Targets[i]
+		post := c.expand(*t)
+		post.ID = fmt.Sprintf("post-%d-%d", time.Now().UnixNano(), i)
+		if err := savePost(ctx, tx, post); err != nil {
+			return nil, err
+		}
+		t.PostID = post.ID
+		_, err := tx.ExecContext(ctx,
+			"INSERT INTO campaign_targets (campaign_id, platform_user_id, platform, caption, media_id, hashtags, post_id) VALUES ($1, $2, $3, $4, $5, $6, $7)",
+			c.ID, t.PlatformUserID, t.Platform, t.Caption, t.MediaID, pq.StringArray(t.Hashtags), post.ID,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to save campaign target: %w", err)
+		}
+		posts = append(posts, post)
+	}
+	return posts, nil
+}
+
+// lockCampaignPosts loads and locks the campaign's posts that are in one of statuses.
+func lockCampaignPosts(ctx context.Context, tx *sql.Tx, tenantID, campaignID string, statuses []string) ([]Post, error) {
+	var exists bool
+	err := tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM campaigns WHERE id = $1 AND tenant_id = $2)", campaignID, tenantID).Scan(&exists)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get campaign: %w", err)
+	}
+	if !exists {
+		return nil, errCampaignNotFound
+	}
+	rows, err := tx.QueryContext(ctx,
+		"SELECT "+postColumns+" FROM posts p WHERE p.campaign_id = $1 AND p.status = ANY($2) ORDER BY p.id FOR UPDATE",
+		campaignID, pq.StringArray(statuses),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock campaign posts: %w", err)
+	}
+	defer rows.Close()
+	var posts []Post
+	for rows.Next() {
+		post, err := scanPost(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan post row: %w", err)
+		}
+		posts = append(posts, post)
+	}
+	return posts, rows.Err()
+}
+
+// --- Handlers ---
+
+func writeCampaignError(w http.ResponseWriter, action string, err error) {
+	if errors.Is(err, errCampaignNotFound) {
+		http.Error(w, "Campaign not found", http.StatusNotFound)
+		return
+	}
+	writePostError(w, action, err)
+}
+
+func writeCampaign(w http.ResponseWriter, status int, c Campaign) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(c)
+}
+
+func getCampaignsHandler(w http.ResponseWriter, r *http.Request) {
+	_, tenantID, err := getUserIDAndTenantIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	campaigns, err := getCampaignsForTenant(r.Context(), tenantID)
+	if err != nil {
+		writeCampaignError(w, "list campaigns", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(campaigns)
+}
+
+func getCampaignHandler(w http.ResponseWriter, r *http.Request) {
+	_, tenantID, err := getUserIDAndTenantIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	c, err := getCampaign(r.Context(), tenantID, mux.Vars(r)["campaignId"])
+	if err != nil {
+		writeCampaignError(w, "get campaign", err)
+		return
+	}
+	writeCampaign(w, http.StatusOK, c)
+}
+
+// createCampaignHandler creates a campaign and its posts, and submits them for
+// approval straight away if the request says so.
+func createCampaignHandler(w http.ResponseWriter, r *http.Request) {
+	userID, tenantID, err := getUserIDAndTenantIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var req struct {
+		Name        string           `json:"name"`
+		Content     string           `json:"content"`
+		MediaID     string           `json:"mediaId"`
+		Hashtags    []string         `json:"hashtags"`
+		ScheduledAt time.Time        `json:"scheduledAt"`
+		Targets     []CampaignTarget `json:"targets"`
+		Submit      bool             `json:"submit"`
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, 1<<20)
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || req.ScheduledAt.IsZero() || len(req.Targets) == 0 {
+		http.Error(w, "name, scheduledAt and at least one target are required", http.StatusBadRequest)
+		return
+	}
+	hashtags, err := normalizeHashtags(req.Hashtags)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if hashtags == nil {
+		hashtags = []string{}
+	}
+
+	connected, err := accountDirectory.Accounts(r.Context(), tenantID)
+	if err != nil {
+		log.Printf("Failed to list connected accounts of tenant %s: %v", tenantID, err)
+		http.Error(w, "Failed to look up connected accounts", http.StatusBadGateway)
+		return
+	}
+	platforms := make(map[string]string, len(connected))
+	for _, account := range connected {
+		platforms[account.PlatformUserID] = account.Platform
+	}
+	seen := make(map[string]bool)
+	for i := range req.Targets {
+		t := &req.Targets[i]
+		platform, ok := platforms[t.PlatformUserID]
+		if !ok {
+			http.Error(w, fmt.Sprintf("Account %q is not connected to this workspace", t.PlatformUserID), http.StatusBadRequest)
+			return
+		}
+		if seen[t.PlatformUserID] {
+			http.Error(w, fmt.Sprintf("Account %q is targeted twice", t.PlatformUserID), http.StatusBadRequest)
+			return
+		}
+		seen[t.PlatformUserID] = true
+		t.Platform = platform
+		if t.MediaID != nil && *t.MediaID == "" {
+			t.MediaID = nil
+		}
+		if t.Hashtags, err = normalizeHashtags(t.Hashtags); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	c := Campaign{
+		ID:          fmt.Sprintf("campaign-%d", time.Now().UnixNano()),
+		TenantID:    tenantID,
+		UserID:      userID,
+		Name:        req.Name,
+		Content:     req.Content,
+		MediaID:     req.MediaID,
+		Hashtags:    hashtags,
+		ScheduledAt: req.ScheduledAt,
+		Targets:     req.Targets,
+	}
+	// Each target's post must meet its platform's rules.
+	result := ValidationResult{Valid: true, Errors: []FieldError{}, Warnings: []FieldError{}}
+	for i, t := range c.Targets {
+		post := c.expand(t)
+		if err := attachMedia(r.Context(), &post); err != nil {
+			writeCampaignError(w, "create campaign", err)
+			return
+		}
+		v := validatePost(post).prefix(fmt.Sprintf("targets[%d].", i))
+		result.Errors = append(result.Errors, v.Errors...)
+		result.Warnings = append(result.Warnings, v.Warnings...)
+	}
+	if len(result.Errors) > 0 {
+		result.Valid = false
+		writeValidationError(w, result)
+		return
+	}
+	ctx := r.Context()
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		writeCampaignError(w, "create campaign", err)
+		return
+	}
+	defer tx.Rollback()
+	posts, err := saveCampaign(ctx, tx, &c)
+	if err == nil && req.Submit {
+		var required int
+		required, err = getRequiredApprovals(ctx, tx, tenantID)
+		for i := 0; err == nil && i < len(posts); i++ {
+			err = submitPost(ctx, tx, &posts[i], userID, "", required)
+		}
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		writeCampaignError(w, "create campaign", err)
+		return
+	}
+	if c, err = getCampaign(ctx, tenantID, c.ID); err != nil {
+		writeCampaignError(w, "get campaign", err)
+		return
+	}
+	writeCampaign(w, http.StatusCreated, c)
+}
+
+// campaignActionHandler applies a review action to every post of a campaign
+// whose status is in from, and answers with the updated campaign. Posts the
+// action does not apply to, such as those the caller already approved, are
+// left alone; the request fails only if it applied to none.
+func campaignActionHandler(action string, from []string, apply func(ctx context.Context, tx *sql.Tx, post *Post, actorID, comment string) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, tenantID, err := getUserIDAndTenantIDFromContext(r.Context())
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		comment, ok := decodeComment(w, r)
+		if !ok {
+			return
+		}
+		ctx := r.Context()
+		campaignID := mux.Vars(r)["campaignId"]
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			writeCampaignError(w, action, err)
+			return
+		}
+		defer tx.Rollback()
+		posts, err := lockCampaignPosts(ctx, tx, tenantID, campaignID, from)
+		if err != nil {
+			writeCampaignError(w, action, err)
+			return
+		}
+		applied := 0
+		var skipped error
+		for i := range posts {
+			err := apply(ctx, tx, &posts[i], userID, comment)
+			switch {
+			case err == nil:
+				applied++
+			case errors.Is(err, errAlreadyApproved), errors.Is(err, errSelfApproval):
+				skipped = err
+			default:
+				writeCampaignError(w, action, err)
+				return
+			}
+		}
+		if applied == 0 {
+			if skipped == nil {
+				skipped = fmt.Errorf("%w: no post of the campaign is %s", errInvalidTransition, strings.ReplaceAll(strings.Join(from, " or "), "_", " "))
+			}
+			writeCampaignError(w, action, skipped)
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			writeCampaignError(w, action, err)
+			return
+		}
+		c, err := getCampaign(ctx, tenantID, campaignID)
+		if err != nil {
+			writeCampaignError(w, "get campaign", err)
+			return
+		}
+		writeCampaign(w, http.StatusOK, c)
+	}
+}
+
+var submitCampaignHandler = campaignActionHandler("submit campaign", []string{statusDraft, statusRejected},
+	func(ctx context.Context, tx *sql.Tx, post *Post, actorID, comment string) error {
+		required, err := getRequiredApprovals(ctx, tx, post.TenantID)
+		if err != nil {
+			return err
+		}
+		return submitPost(ctx, tx, post, actorID, comment, required)
+	})
+
+var approveCampaignHandler = campaignActionHandler("approve campaign", []string{statusPendingApproval}, approvePost)
+
+var rejectCampaignHandler = campaignActionHandler("reject campaign", []string{statusPendingApproval},
+	func(ctx context.Context, tx *sql.Tx, post *Post, actorID, comment string) error {
+		if comment == "" {
+			return errRejectionComment
+		}
+		return transitionPost(ctx, tx, post, statusRejected, actorID, comment)
+	})
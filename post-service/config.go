@@ -0,0 +1,238 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/mail"
+	"net/url"
+	"time"
+
+	"shared/config"
+)
+
+// --- Configuration ---
+//
+// Settings come from, in increasing precedence: the `default` tags below, the
+// YAML file named by CONFIG_FILE (optional), and environment variables.
+
+// Config holds every setting of the Post Service.
+type Config struct {
+	Port              int    `yaml:"port" env:"PORT" default:"8083"`
+	DatabaseURL       string `yaml:"database_url" env:"DATABASE_URL" required:"true" secret:"true"`
+	AutoMigrate       bool   `yaml:"auto_migrate" env:"AUTO_MIGRATE" default:"true"`
+	CORSAllowedOrigin string `yaml:"cors_allowed_origin" env:"CORS_ALLOWED_ORIGIN" default:"http://localhost:3000"`
+	JWTIssuer         string `yaml:"jwt_issuer" env:"JWT_ISSUER" default:"social-media-platform"`
+	InternalAPISecret string `yaml:"internal_api_secret" env:"INTERNAL_API_SECRET" required:"true" secret:"true"`
+	AccountServiceURL string `yaml:"account_service_url" env:"ACCOUNT_SERVICE_URL" default:"http://localhost:8082"`
+
+	JWKS       JWKSConfig       `yaml:"jwks" env:"JWKS"`
+	Revocation RevocationConfig `yaml:"revocation" env:"REVOCATION"`
+
+	// PublisherMode is "live" to call the platforms or "fake" to only record posts.
+	PublisherMode string            `yaml:"publisher_mode" env:"PUBLISHER_MODE" default:"live"`
+	Meta          PlatformAPIConfig `yaml:"meta" env:"META"`
+	TikTok        PlatformAPIConfig `yaml:"tiktok" env:"TIKTOK"`
+	Snapchat      PlatformAPIConfig `yaml:"snapchat" env:"SNAPCHAT"`
+
+	Media      MediaConfig     `yaml:"media" env:"MEDIA"`
+	Renditions RenditionConfig `yaml:"renditions" env:"RENDITIONS"`
+	Analytics  AnalyticsConfig `yaml:"analytics" env:"ANALYTICS"`
+	Reports    ReportConfig    `yaml:"reports" env:"REPORTS"`
+	Inbox      InboxConfig     `yaml:"inbox" env:"INBOX"`
+}
+
+// PlatformAPIConfig locates one platform's publishing API.
+type PlatformAPIConfig struct {
+	APIBaseURL string `yaml:"api_base_url" env:"API_BASE_URL"`
+}
+
+// JWKSConfig locates the auth-service keys used to verify platform JWTs.
+type JWKSConfig struct {
+	URL             string        `yaml:"url" env:"URL" default:"http://localhost:8081/.well-known/jwks.json"`
+	RefreshInterval time.Duration `yaml:"refresh_interval" env:"REFRESH_INTERVAL" default:"5m"`
+}
+
+// RevocationConfig locates auth-service's list of revoked access tokens.
+type RevocationConfig struct {
+	URL          string        `yaml:"url" env:"URL" default:"http://localhost:8081/internal/revoked-tokens"`
+	PollInterval time.Duration `yaml:"poll_interval" env:"POLL_INTERVAL" default:"15s"`
+}
+
+// MediaConfig says where the media library keeps its files; see media.go.
+type MediaConfig struct {
+	// Storage is "local" to keep files in LocalDir or "s3" for an S3-compatible bucket.
+	Storage  string `yaml:"storage" env:"STORAGE" default:"local"`
+	LocalDir string `yaml:"local_dir" env:"LOCAL_DIR" default:"./data/media"`
+	// UploadDir stages uploads until they are complete. Replicas must share it.
+	UploadDir string `yaml:"upload_dir" env:"UPLOAD_DIR" default:"./data/uploads"`
+	// PublicURL is where the platforms reach this service to fetch locally stored media.
+	PublicURL string `yaml:"public_url" env:"PUBLIC_URL" default:"http://localhost:8083"`
+	// URLTTL is how long the media links handed to publishers stay valid.
+	URLTTL         time.Duration `yaml:"url_ttl" env:"URL_TTL" default:"24h"`
+	MaxUploadBytes int           `yaml:"max_upload_bytes" env:"MAX_UPLOAD_BYTES" default:"1073741824"`
+	S3             S3Config      `yaml:"s3" env:"S3"`
+}
+
+// S3Config locates the bucket used when media.storage is "s3".
+type S3Config struct {
+	Endpoint        string `yaml:"endpoint" env:"ENDPOINT"`
+	Region          string `yaml:"region" env:"REGION" default:"us-east-1"`
+	Bucket          string `yaml:"bucket" env:"BUCKET"`
+	AccessKeyID     string `yaml:"access_key_id" env:"ACCESS_KEY_ID"`
+	SecretAccessKey string `yaml:"secret_access_key" env:"SECRET_ACCESS_KEY" secret:"true"`
+	// PathStyle addresses the bucket as endpoint/bucket, as MinIO expects.
+	PathStyle bool `yaml:"path_style" env:"PATH_STYLE" default:"true"`
+}
+
+// RenditionConfig controls the worker that makes platform renditions of media; see renditions.go.
+type RenditionConfig struct {
+	Enabled    bool   `yaml:"enabled" env:"ENABLED" default:"true"`
+	FFmpegPath string `yaml:"ffmpeg_path" env:"FFMPEG_PATH" default:"ffmpeg"`
+	// Workers is how many renditions one replica encodes at a time.
+	Workers      int           `yaml:"workers" env:"WORKERS" default:"1"`
+	PollInterval time.Duration `yaml:"poll_interval" env:"POLL_INTERVAL" default:"5s"`
+	// JobTimeout bounds a single encode; a job claimed more than a few minutes
+	// longer ago than this, by a replica that has since died, is queued again.
+	JobTimeout time.Duration `yaml:"job_timeout" env:"JOB_TIMEOUT" default:"30m"`
+}
+
+// AnalyticsConfig controls the metrics collector; see analytics.go.
+type AnalyticsConfig struct {
+	Enabled         bool          `yaml:"enabled" env:"ENABLED" default:"true"`
+	CollectInterval time.Duration `yaml:"collect_interval" env:"COLLECT_INTERVAL" default:"1h"`
+	// Lookback is how long after publishing a post's metrics are still collected.
+	Lookback time.Duration `yaml:"lookback" env:"LOOKBACK" default:"720h"`
+}
+
+// ReportConfig controls the worker that renders reports; see reports.go.
+type ReportConfig struct {
+	// Workers is how many reports one replica renders at a time.
+	Workers      int           `yaml:"workers" env:"WORKERS" default:"1"`
+	PollInterval time.Duration `yaml:"poll_interval" env:"POLL_INTERVAL" default:"5s"`
+	JobTimeout   time.Duration `yaml:"job_timeout" env:"JOB_TIMEOUT" default:"10m"`
+	// Retention is how long finished reports can be downloaded.
+	Retention time.Duration  `yaml:"retention" env:"RETENTION" default:"720h"`
+	Delivery  DeliveryConfig `yaml:"delivery" env:"DELIVERY"`
+}
+
+// DeliveryConfig configures how scheduled reports are delivered; see delivery.go.
+// Webhook delivery is always available, email only with an SMTP host and file
+// delivery only with a directory.
+type DeliveryConfig struct {
+	SMTP SMTPConfig `yaml:"smtp" env:"SMTP"`
+	// FileDir is where file deliveries are written, for development and tests.
+	FileDir string `yaml:"file_dir" env:"FILE_DIR"`
+}
+
+// SMTPConfig is the mail server email deliveries are sent through.
+type SMTPConfig struct {
+	Host     string `yaml:"host" env:"HOST"`
+	Port     int    `yaml:"port" env:"PORT" default:"587"`
+	Username string `yaml:"username" env:"USERNAME"`
+	Password string `yaml:"password" env:"PASSWORD" secret:"true"`
+	From     string `yaml:"from" env:"FROM"`
+}
+
+// InboxConfig controls the engagement inbox; see inbox.go.
+type InboxConfig struct {
+	Enabled      bool          `yaml:"enabled" env:"ENABLED" default:"true"`
+	PollInterval time.Duration `yaml:"poll_interval" env:"POLL_INTERVAL" default:"5m"`
+	// Lookback is how far back the first sync of an account reaches.
+	Lookback time.Duration `yaml:"lookback" env:"LOOKBACK" default:"168h"`
+	// MetaAppSecret checks the signatures of Meta webhooks, and Meta's
+	// subscription check must present MetaVerifyToken. Meta webhooks are
+	// refused while they are unset.
+	MetaAppSecret   string `yaml:"meta_app_secret" env:"META_APP_SECRET" secret:"true"`
+	MetaVerifyToken string `yaml:"meta_verify_token" env:"META_VERIFY_TOKEN" secret:"true"`
+}
+
+var cfg *Config
+
+func initConfig() {
+	c := &Config{
+		Meta:     PlatformAPIConfig{APIBaseURL: defaultMetaGraphBaseURL},
+		TikTok:   PlatformAPIConfig{APIBaseURL: defaultTikTokBaseURL},
+		Snapchat: PlatformAPIConfig{APIBaseURL: defaultSnapchatBaseURL},
+	}
+	if err := config.Load(c); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	if err := c.validate(); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	config.Log(c)
+	cfg = c
+}
+
+func (c *Config) validate() error {
+	var errs []error
+	if _, err := url.ParseRequestURI(c.JWKS.URL); err != nil {
+		errs = append(errs, fmt.Errorf("jwks.url is not a valid URL: %q", c.JWKS.URL))
+	}
+	if c.JWKS.RefreshInterval <= 0 {
+		errs = append(errs, errors.New("jwks.refresh_interval must be positive"))
+	}
+	if _, err := url.ParseRequestURI(c.Revocation.URL); err != nil {
+		errs = append(errs, fmt.Errorf("revocation.url is not a valid URL: %q", c.Revocation.URL))
+	}
+	if c.Revocation.PollInterval <= 0 {
+		errs = append(errs, errors.New("revocation.poll_interval must be positive"))
+	}
+	if len(c.InternalAPISecret) < 32 {
+		errs = append(errs, errors.New("internal_api_secret must be at least 32 characters"))
+	}
+	if c.PublisherMode != "live" && c.PublisherMode != "fake" {
+		errs = append(errs, fmt.Errorf("publisher_mode must be \"live\" or \"fake\", got %q", c.PublisherMode))
+	}
+	switch c.Media.Storage {
+	case "local":
+	case "s3":
+		if _, err := url.ParseRequestURI(c.Media.S3.Endpoint); err != nil {
+			errs = append(errs, fmt.Errorf("media.s3.endpoint is not a valid URL: %q", c.Media.S3.Endpoint))
+		}
+		if c.Media.S3.Bucket == "" || c.Media.S3.AccessKeyID == "" || c.Media.S3.SecretAccessKey == "" {
+			errs = append(errs, errors.New("media.s3.bucket, media.s3.access_key_id and media.s3.secret_access_key are required for S3 storage"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("media.storage must be \"local\" or \"s3\", got %q", c.Media.Storage))
+	}
+	if c.Media.URLTTL <= 0 {
+		errs = append(errs, errors.New("media.url_ttl must be positive"))
+	}
+	if c.Media.MaxUploadBytes <= 0 {
+		errs = append(errs, errors.New("media.max_upload_bytes must be positive"))
+	}
+	if c.Renditions.Workers <= 0 || c.Renditions.PollInterval <= 0 || c.Renditions.JobTimeout <= 0 {
+		errs = append(errs, errors.New("renditions.workers, renditions.poll_interval and renditions.job_timeout must be positive"))
+	}
+	if c.Analytics.CollectInterval <= 0 || c.Analytics.Lookback <= 0 {
+		errs = append(errs, errors.New("analytics.collect_interval and analytics.lookback must be positive"))
+	}
+	if c.Reports.Workers <= 0 || c.Reports.PollInterval <= 0 || c.Reports.JobTimeout <= 0 || c.Reports.Retention <= 0 {
+		errs = append(errs, errors.New("reports.workers, reports.poll_interval, reports.job_timeout and reports.retention must be positive"))
+	}
+	if c.Inbox.PollInterval <= 0 || c.Inbox.Lookback <= 0 {
+		errs = append(errs, errors.New("inbox.poll_interval and inbox.lookback must be positive"))
+	}
+	if c.Reports.Delivery.SMTP.Host != "" {
+		if _, err := mail.ParseAddress(c.Reports.Delivery.SMTP.From); err != nil {
+			errs = append(errs, fmt.Errorf("reports.delivery.smtp.from is not a valid address: %q", c.Reports.Delivery.SMTP.From))
+		}
+		if c.Reports.Delivery.SMTP.Port <= 0 {
+			errs = append(errs, errors.New("reports.delivery.smtp.port must be positive"))
+		}
+	}
+	for _, u := range []struct{ name, value string }{
+		{"account_service_url", c.AccountServiceURL},
+		{"media.public_url", c.Media.PublicURL},
+		{"meta.api_base_url", c.Meta.APIBaseURL},
+		{"tiktok.api_base_url", c.TikTok.APIBaseURL},
+		{"snapchat.api_base_url", c.Snapchat.APIBaseURL},
+	} {
+		if _, err := url.ParseRequestURI(u.value); err != nil {
+			errs = append(errs, fmt.Errorf("%s is not a valid URL: %q", u.name, u.value))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- Report Delivery ---
+//
+// Scheduled reports reach their recipients through a Deliverer, chosen by the
+// subscription's delivery method: "email" sends the report as an attachment
+// through the configured SMTP server, "webhook" posts a signed JSON
+// notification with a download link, and "file" writes the report to a local
+// directory, which is meant for development and tests. Email and file delivery
+// are only offered when they are configured.
+
+// ReportDelivery is a rendered report on its way to a subscription's recipients.
+type ReportDelivery struct {
+	DeliveryID   string
+	Subscription ReportSubscription
+	Report       Report
+	Filename     string
+	ContentType  string
+	Content      []byte
+	// DownloadURL links to the report for deliveries that do not carry it.
+	DownloadURL string
+	ExpiresAt   time.Time
+}
+
+// Deliverer sends reports by one delivery method.
+type Deliverer interface {
+	Deliver(ctx context.Context, d ReportDelivery) error
+}
+
+// deliverers maps the available delivery methods to their Deliverer.
+var deliverers = map[string]Deliverer{}
+
+func initReportDelivery() {
+	c := cfg.Reports.Delivery
+	deliverers["webhook"] = &webhookDeliverer{client: newWebhookClient()}
+	if c.SMTP.Host != "" {
+		deliverers["email"] = &smtpDeliverer{config: c.SMTP}
+	}
+	if c.FileDir != "" {
+		deliverers["file"] = &fileDeliverer{dir: c.FileDir}
+	}
+	methods := make([]string, 0, len(deliverers))
+	for method := range deliverers {
+		methods = append(methods, method)
+	}
+	log.Printf("Report delivery methods: %s", strings.Join(methods, ", "))
+}
+
+// deliverySubject is the subject line of a delivery.
+func deliverySubject(d ReportDelivery) string {
+	return fmt.Sprintf("%s: analytics report %s to %s", d.Subscription.Name, d.Report.From, d.Report.To)
+}
+
+// --- Email ---
+
+// emailAttachmentLimit is the largest report sent as an attachment; larger
+// ones are sent as a download link.
+const emailAttachmentLimit = 10 << 20
+
+// smtpDeliverer mails reports through an SMTP server, upgrading the
+// connection with STARTTLS when the server offers it.
+type smtpDeliverer struct {
+	config SMTPConfig
+}
+
+func (s *smtpDeliverer) Deliver(ctx context.Context, d ReportDelivery) error {
+	message, err := s.message(d)
+	if err != nil {
+		return err
+	}
+	addr := net.JoinHostPort(s.config.Host, strconv.Itoa(s.config.Port))
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to connect to SMTP server: %w", err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	client, err := smtp.NewClient(conn, s.config.Host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to greet SMTP server: %w", err)
+	}
+	defer client.Close()
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: s.config.Host}); err != nil {
+			return fmt.Errorf("failed to start TLS: %w", err)
+		}
+	}
+	if s.config.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", s.config.Username, s.config.Password, s.config.Host)); err != nil {
+			return fmt.Errorf("failed to authenticate with SMTP server: %w", err)
+		}
+	}
+	if err := client.Mail(s.config.From); err != nil {
+		return fmt.Errorf("SMTP server refused sender: %w", err)
+	}
+	for _, rcpt := range d.Subscription.Recipients {
+		if err := client.Rcpt(rcpt); err != nil {
+			return fmt.Errorf("SMTP server refused recipient %s: %w", rcpt, err)
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	if _, err := w.Write(message); err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	return client.Quit()
+}
+
+// message builds a MIME message with a short note and the report attached.
+func (s *smtpDeliverer) message(d ReportDelivery) ([]byte, error) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	fmt.Fprintf(&buf, "From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\nMIME-Version: 1.0\r\nContent-Type: multipart/mixed; boundary=%q\r\n\r\n",
+		s.config.From, strings.Join(d.Subscription.Recipients, ", "), mime.QEncoding.Encode("utf-8", deliverySubject(d)),
+		time.Now().Format(time.RFC1123Z), mw.Boundary())
+
+	text, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/plain; charset=utf-8"},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	qp := quotedprintable.NewWriter(text)
+	fmt.Fprintf(qp, "Here is the %s analytics report for %s to %s.\r\n", d.Subscription.Name, d.Report.From, d.Report.To)
+	attach := len(d.Content) <= emailAttachmentLimit
+	if !attach {
+		fmt.Fprintf(qp, "\r\nIt is too large to attach; download it until %s from:\r\n%s\r\n", d.ExpiresAt.Format(time.RFC1123), d.DownloadURL)
+	}
+	if err := qp.Close(); err != nil {
+		return nil, err
+	}
+
+	if attach {
+		file, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {mime.FormatMediaType(d.ContentType, map[string]string{"name": d.Filename})},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": d.Filename})},
+			"Content-Transfer-Encoding": {"base64"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		encoded := base64.StdEncoding.EncodeToString(d.Content)
+		for len(encoded) > 76 {
+			io.WriteString(file, encoded[:76]+"\r\n")
+			encoded = encoded[76:]
+		}
+		io.WriteString(file, encoded+"\r\n")
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// --- Webhook ---
+
+// Headers of webhook deliveries. The signature is the hex HMAC-SHA256 of the
+// timestamp, a dot and the body, keyed with the subscription's webhook secret.
+const (
+	webhookSignatureHeader = "X-Report-Signature"
+	webhookTimestampHeader = "X-Report-Timestamp"
+	webhookDeliveryHeader  = "X-Report-Delivery"
+)
+
+// errWebhookAddressNotAllowed refuses webhook hosts inside the network the
+// service runs in. Tenants choose the URL, so without this check a webhook
+// could be pointed at loopback, cloud metadata or other internal services.
+var errWebhookAddressNotAllowed = errors.New("webhook host must resolve to a public address")
+
+// sharedAddressSpace is the carrier-grade NAT range (RFC 6598), which
+// net.IP.IsPrivate does not cover.
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// publicWebhookIP reports whether a webhook may connect to ip: not loopback,
+// private, link-local, unspecified, multicast or broadcast.
+func publicWebhookIP(ip net.IP) bool {
+	return ip.IsGlobalUnicast() && !ip.IsPrivate() && !sharedAddressSpace.Contains(ip)
+}
+
+// resolveWebhookHost returns the addresses of host, or errWebhookAddressNotAllowed
+// if any of them is not public.
+func resolveWebhookHost(ctx context.Context, host string) ([]net.IP, error) {
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve webhook host: %w", err)
+	}
+	ips := make([]net.IP, 0, len(addrs))
+	for _, addr := range addrs {
+		if !publicWebhookIP(addr.IP) {
+			return nil, errWebhookAddressNotAllowed
+		}
+		ips = append(ips, addr.IP)
+	}
+	return ips, nil
+}
+
+// newWebhookClient returns a client that only connects to public addresses. The
+// host is resolved once per connection and the checked address is dialed
+// directly, so a DNS answer that changes between the check and the dial cannot
+// slip through, and every redirect is checked the same way.
+func newWebhookClient() *http.Client {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// A proxy would make the connection on our behalf, unchecked.
+	transport.Proxy = nil
+	transport.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+		ips, err := resolveWebhookHost(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		var conn net.Conn
+		for _, ip := range ips {
+			if conn, err = dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port)); err == nil {
+				return conn, nil
+			}
+		}
+		return nil, err
+	}
+	return &http.Client{Timeout: 30 * time.Second, Transport: transport}
+}
+
+// webhookDeliverer posts a notification with a download link to the subscription's URL.
+type webhookDeliverer struct {
+	client *http.Client
+}
+
+func (h *webhookDeliverer) Deliver(ctx context.Context, d ReportDelivery) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"deliveryId":     d.DeliveryID,
+		"subscriptionId": d.Subscription.ID,
+		"name":           d.Subscription.Name,
+		"reportId":       d.Report.ID,
+		"format":         d.Report.Format,
+		"from":           d.Report.From,
+		"to":             d.Report.To,
+		"filename":       d.Filename,
+		"contentType":    d.ContentType,
+		"sizeBytes":      len(d.Content),
+		"downloadUrl":    d.DownloadURL,
+		"expiresAt":      d.ExpiresAt,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode webhook: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.Subscription.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create webhook request: %w", err)
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(d.Subscription.WebhookSecret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhookTimestampHeader, timestamp)
+	req.Header.Set(webhookSignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	req.Header.Set(webhookDeliveryHeader, d.DeliveryID)
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		// The body is not part of the error: delivery errors are shown to the
+		// tenant, and must not become a way to read responses.
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// --- File ---
+
+// fileDeliverer writes reports below a directory, one folder per subscription.
+type fileDeliverer struct {
+	dir string
+}
+
+func (f *fileDeliverer) Deliver(ctx context.Context, d ReportDelivery) error {
+	dir := filepath.Join(f.dir, d.Report.tenantID, d.Subscription.ID)
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return fmt.Errorf("failed to create delivery directory: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, d.DeliveryID+"-"+d.Filename), d.Content, 0o640); err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	return nil
+}
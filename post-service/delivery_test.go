@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPublicWebhookIP(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"93.184.215.14", true},
+		{"2606:2800:21f:cb07:6820:80da:af6b:8b2c", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"100.64.0.1", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"fd00::1", false},
+		{"0.0.0.0", false},
+		{"::", false},
+		{"224.0.0.1", false},
+		{"255.255.255.255", false},
+		{"::ffff:127.0.0.1", false},
+	}
+	for _, tt := range tests {
+		if got := publicWebhookIP(net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("publicWebhookIP(%s) = %v; want %v", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestWebhookClientRefusesInternalAddresses(t *testing.T) {
+	called := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { called = true }))
+	defer srv.Close()
+
+	webhook := &webhookDeliverer{client: newWebhookClient()}
+	err := webhook.Deliver(context.Background(), ReportDelivery{Subscription: ReportSubscription{WebhookURL: srv.URL}})
+	if !errors.Is(err, errWebhookAddressNotAllowed) {
+		t.Errorf("Deliver to %s = %v; want errWebhookAddressNotAllowed", srv.URL, err)
+	}
+	if called {
+		t.Error("the loopback server was called")
+	}
+}
+
+func TestWebhookErrorOmitsResponseBody(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "internal-only details", http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	webhook := &webhookDeliverer{client: srv.Client()}
+	err := webhook.Deliver(context.Background(), ReportDelivery{Subscription: ReportSubscription{WebhookURL: srv.URL}})
+	if err == nil || !strings.Contains(err.Error(), "500") {
+		t.Fatalf("Deliver = %v; want the status in the error", err)
+	}
+	if strings.Contains(err.Error(), "internal-only") {
+		t.Errorf("Deliver error %q echoes the response body", err)
+	}
+}
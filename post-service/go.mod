@@ -6,4 +6,10 @@ require (
 	github.com/golang-jwt/jwt/v5 v5.3.0
 	github.com/gorilla/mux v1.8.1
 	github.com/lib/pq v1.10.9
+	github.com/robfig/cron/v3 v3.0.1
+	shared v0.0.0
 )
+
+require gopkg.in/yaml.v3 v3.0.1 // indirect
+
+replace shared => ../shared
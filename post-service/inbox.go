@@ -0,0 +1,787 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// --- Engagement Inbox ---
+//
+// The inbox gathers the comments, mentions and direct messages of every
+// connected account into conversations per tenant: the comments on one post,
+// one post mentioning the account, or the direct messages with one person.
+// The inbox syncer keeps inbox_accounts in step with account-service and
+// polls each account every cfg.Inbox.PollInterval, claiming accounts with FOR
+// UPDATE SKIP LOCKED so every replica can run one. Platforms that push
+// activity deliver it to /webhooks/{platform}, which stores it for every
+// tenant that connected the account. Items are deduplicated by their platform
+// ID, so polling and webhooks can both see the same message.
+//
+// Read state is shared by the tenant's members: a message is unread until
+// someone reads its conversation. Messages the account sent itself are stored
+// read.
+
+// Inbox item kinds.
+const (
+	inboxComment = "comment"
+	inboxMention = "mention"
+	inboxMessage = "message"
+)
+
+const (
+	inboxBatchSize = 50
+	// inboxCallTimeout bounds the platform calls made to sync one account.
+	inboxCallTimeout = 2 * time.Minute
+	// inboxSyncOverlap is how far before the end of the previous sync the next
+	// one starts, so items the platform makes visible late are not missed.
+	inboxSyncOverlap = 10 * time.Minute
+	// inboxPreviewLength is how many characters of the latest message a
+	// conversation shows.
+	inboxPreviewLength   = 140
+	defaultInboxPageSize = 50
+	maxInboxPageSize     = 100
+)
+
+var (
+	errConversationNotFound = errors.New("conversation not found")
+	// errWebhookUnverified is returned for webhook requests that are not signed
+	// by the platform, or while the webhook secret is not configured.
+	errWebhookUnverified = errors.New("webhook could not be verified")
+)
+
+// InboxItem is a comment, mention or direct message as a platform reports it.
+type InboxItem struct {
+	Kind string
+	// ThreadID identifies the conversation on the platform: the commented
+	// post, the mentioning post or the other party of direct messages.
+	ThreadID        string
+	ParticipantID   string
+	ParticipantName string
+	ExternalID      string
+	AuthorID        string
+	AuthorName      string
+	Text            string
+	SentAt          time.Time
+	// Outbound is set for messages the account sent itself.
+	Outbound bool
+}
+
+// InboxSource is implemented by the publishers of platforms whose comments,
+// mentions or direct messages can be fetched.
+type InboxSource interface {
+	// Inbox fetches the items of the account that are newer than since.
+	Inbox(ctx context.Context, account PlatformAccount, since time.Time) ([]InboxItem, error)
+}
+
+// InboxWebhook is implemented by the publishers of platforms that push inbox
+// activity to a webhook.
+type InboxWebhook interface {
+	// WebhookChallenge answers the platform's check of a webhook subscription.
+	WebhookChallenge(q url.Values) (string, error)
+	// ParseWebhook verifies a delivery and returns its items by the
+	// PlatformUserID of the account they belong to.
+	ParseWebhook(header http.Header, body []byte) (map[string][]InboxItem, error)
+}
+
+// Conversation is a thread of the inbox.
+type Conversation struct {
+	ID              string    `json:"id"`
+	Platform        string    `json:"platform"`
+	PlatformUserID  string    `json:"platformUserId"`
+	Kind            string    `json:"kind"`
+	ExternalID      string    `json:"externalId"`
+	PostID          string    `json:"postId,omitempty"`
+	ParticipantID   string    `json:"participantId,omitempty"`
+	ParticipantName string    `json:"participantName,omitempty"`
+	Preview         string    `json:"preview"`
+	LastMessageAt   time.Time `json:"lastMessageAt"`
+	UnreadCount     int       `json:"unreadCount"`
+	CreatedAt       time.Time `json:"createdAt"`
+}
+
+// InboxMessage is one comment, mention or direct message of a conversation.
+type InboxMessage struct {
+	ID             string    `json:"id"`
+	ConversationID string    `json:"conversationId"`
+	ExternalID     string    `json:"externalId"`
+	AuthorID       string    `json:"authorId,omitempty"`
+	AuthorName     string    `json:"authorName,omitempty"`
+	Body           string    `json:"body"`
+	Outbound       bool      `json:"outbound"`
+	SentAt         time.Time `json:"sentAt"`
+	Read           bool      `json:"read"`
+}
+
+// inboxAccount is an account the syncer polls.
+type inboxAccount struct {
+	TenantID       string
+	Platform       string
+	PlatformUserID string
+	SyncedUntil    sql.NullTime
+}
+
+// InboxSyncer periodically fetches the inbox items of every connected account
+// whose platform has an inbox.
+type InboxSyncer struct {
+	publishers *PublisherRegistry
+	tokens     TokenSource
+	catalog    AccountCatalog
+	interval   time.Duration
+	lookback   time.Duration
+	batchSize  int
+}
+
+func newInboxSyncer(publishers *PublisherRegistry, tokens TokenSource, catalog AccountCatalog) *InboxSyncer {
+	return &InboxSyncer{
+		publishers: publishers,
+		tokens:     tokens,
+		catalog:    catalog,
+		interval:   cfg.Inbox.PollInterval,
+		lookback:   cfg.Inbox.Lookback,
+		batchSize:  inboxBatchSize,
+	}
+}
+
+// Run syncs the inbox every interval until ctx is cancelled.
+func (s *InboxSyncer) Run(ctx context.Context) {
+	log.Printf("Inbox syncer started (interval %s, lookback %s)", s.interval, s.lookback)
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		s.tick(ctx)
+		select {
+		case <-ctx.Done():
+			log.Println("Inbox syncer stopped.")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *InboxSyncer) tick(ctx context.Context) {
+	if err := s.registerAccounts(ctx); err != nil {
+		log.Print(err)
+	}
+	received := 0
+	for ctx.Err() == nil {
+		accounts, err := claimInboxAccounts(ctx, s.interval, s.batchSize)
+		if err != nil {
+			log.Print(err)
+			break
+		}
+		for _, account := range accounts {
+			received += s.syncAccount(ctx, account)
+		}
+		if len(accounts) < s.batchSize {
+			break
+		}
+	}
+	if received > 0 {
+		log.Printf("Received %d inbox item(s)", received)
+	}
+}
+
+// hasInbox reports whether the inbox is synced for accounts of platform.
+func (s *InboxSyncer) hasInbox(platform string) bool {
+	publisher, ok := s.publishers.Get(platform)
+	if !ok {
+		return false
+	}
+	_, polled := publisher.(InboxSource)
+	_, pushed := publisher.(InboxWebhook)
+	return polled || pushed
+}
+
+// registerAccounts makes inbox_accounts the active connected accounts of
+// platforms with an inbox.
+func (s *InboxSyncer) registerAccounts(ctx context.Context) error {
+	accounts, err := s.catalog.AllAccounts(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list connected accounts for the inbox: %w", err)
+	}
+	var tenants, platforms, users []string
+	for _, account := range accounts {
+		if account.Status != "active" || !s.hasInbox(account.Platform) {
+			continue
+		}
+		tenants = append(tenants, account.TenantID)
+		platforms = append(platforms, platformLabel(account.Platform))
+		users = append(users, account.PlatformUserID)
+	}
+	return syncInboxAccounts(ctx, tenants, platforms, users)
+}
+
+// syncAccount stores the new inbox items of an account and returns how many it received.
+func (s *InboxSyncer) syncAccount(ctx context.Context, account inboxAccount) int {
+	publisher, _ := s.publishers.Get(account.Platform)
+	source, ok := publisher.(InboxSource)
+	if !ok {
+		return 0
+	}
+	started := time.Now()
+	since := started.Add(-s.lookback)
+	if account.SyncedUntil.Valid {
+		since = account.SyncedUntil.Time.Add(-inboxSyncOverlap)
+	}
+	callCtx, cancel := context.WithTimeout(ctx, inboxCallTimeout)
+	defer cancel()
+	accessToken, err := s.tokens.AccessToken(callCtx, account.TenantID, account.PlatformUserID)
+	if err != nil {
+		log.Printf("Failed to obtain access token for the inbox of account %s: %v", account.PlatformUserID, err)
+		recordInboxSync(ctx, account, time.Time{}, err)
+		return 0
+	}
+	items, err := source.Inbox(callCtx, PlatformAccount{PlatformUserID: account.PlatformUserID, AccessToken: accessToken}, since)
+	if errors.Is(err, errPublisherUnsupported) {
+		return 0
+	}
+	if err != nil {
+		log.Printf("Failed to fetch the inbox of account %s from %s: %v", account.PlatformUserID, account.Platform, err)
+		recordInboxSync(ctx, account, time.Time{}, err)
+		return 0
+	}
+	received, err := saveInboxItems(ctx, account.TenantID, account.Platform, account.PlatformUserID, items)
+	if err != nil {
+		log.Print(err)
+		recordInboxSync(ctx, account, time.Time{}, err)
+		return 0
+	}
+	recordInboxSync(ctx, account, started, nil)
+	return received
+}
+
+// --- Database Operations ---
+
+// syncInboxAccounts adds the given accounts to inbox_accounts and removes the
+// ones that are no longer connected. The slices hold one account per index.
+func syncInboxAccounts(ctx context.Context, tenants, platforms, users []string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO inbox_accounts (tenant_id, platform, platform_user_id)
+		SELECT * FROM unnest($1::text[], $2::text[], $3::text[])
+		ON CONFLICT DO NOTHING`,
+		pq.StringArray(tenants), pq.StringArray(platforms), pq.StringArray(users),
+	); err != nil {
+		return fmt.Errorf("failed to register inbox accounts: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `
+		DELETE FROM inbox_accounts
+		WHERE (tenant_id, platform, platform_user_id) NOT IN (SELECT * FROM unnest($1::text[], $2::text[], $3::text[]))`,
+		pq.StringArray(tenants), pq.StringArray(platforms), pq.StringArray(users),
+	); err != nil {
+		return fmt.Errorf("failed to remove disconnected inbox accounts: %w", err)
+	}
+	return tx.Commit()
+}
+
+// claimInboxAccounts marks up to limit accounts as synced and returns them. An
+// account is due when it was last claimed more than nine tenths of interval
+// ago, so it is synced on every tick despite timer jitter.
+func claimInboxAccounts(ctx context.Context, interval time.Duration, limit int) ([]inboxAccount, error) {
+	rows, err := db.QueryContext(ctx, `
+		UPDATE inbox_accounts SET claimed_at = now()
+		WHERE (tenant_id, platform, platform_user_id) IN (
+			SELECT tenant_id, platform, platform_user_id FROM inbox_accounts
+			WHERE claimed_at IS NULL OR claimed_at < $1
+			ORDER BY claimed_at NULLS FIRST
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING tenant_id, platform, platform_user_id, synced_until`,
+		time.Now().Add(-interval*9/10), limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim inbox accounts: %w", err)
+	}
+	defer rows.Close()
+	var accounts []inboxAccount
+	for rows.Next() {
+		var a inboxAccount
+		if err := rows.Scan(&a.TenantID, &a.Platform, &a.PlatformUserID, &a.SyncedUntil); err != nil {
+			return nil, fmt.Errorf("failed to scan inbox account: %w", err)
+		}
+		accounts = append(accounts, a)
+	}
+	return accounts, rows.Err()
+}
+
+// recordInboxSync records the outcome of a sync: how far it got, or why it failed.
+func recordInboxSync(ctx context.Context, account inboxAccount, syncedUntil time.Time, cause error) {
+	var err error
+	if cause != nil {
+		_, err = db.ExecContext(ctx, "UPDATE inbox_accounts SET last_error = $4, updated_at = now() WHERE tenant_id = $1 AND platform = $2 AND platform_user_id = $3",
+			account.TenantID, account.Platform, account.PlatformUserID, cause.Error())
+	} else {
+		_, err = db.ExecContext(ctx, "UPDATE inbox_accounts SET synced_until = $4, last_error = NULL, updated_at = now() WHERE tenant_id = $1 AND platform = $2 AND platform_user_id = $3",
+			account.TenantID, account.Platform, account.PlatformUserID, syncedUntil)
+	}
+	if err != nil {
+		log.Printf("Failed to record inbox sync of account %s: %v", account.PlatformUserID, err)
+	}
+}
+
+// inboxTenants lists the tenants that connected an account.
+func inboxTenants(ctx context.Context, platform, platformUserID string) ([]string, error) {
+	rows, err := db.QueryContext(ctx, "SELECT tenant_id FROM inbox_accounts WHERE platform = $1 AND platform_user_id = $2", platformLabel(platform), platformUserID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find the tenants of account %s: %w", platformUserID, err)
+	}
+	defer rows.Close()
+	var tenants []string
+	for rows.Next() {
+		var tenantID string
+		if err := rows.Scan(&tenantID); err != nil {
+			return nil, fmt.Errorf("failed to scan tenant: %w", err)
+		}
+		tenants = append(tenants, tenantID)
+	}
+	return tenants, rows.Err()
+}
+
+// saveInboxItems stores items of a tenant's account in their conversations
+// and returns how many were new or changed. Comments are linked to the
+// tenant's post they were made on.
+func saveInboxItems(ctx context.Context, tenantID, platform, platformUserID string, items []InboxItem) (int, error) {
+	if len(items) == 0 {
+		return 0, nil
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	platform = platformLabel(platform)
+	now := time.Now()
+	saved := 0
+	touched := make(map[string]bool)
+	for i, item := range items {
+		if item.ThreadID == "" || item.ExternalID == "" {
+			continue
+		}
+		var conversationID string
+		err := tx.QueryRowContext(ctx, `
+			INSERT INTO conversations (id, tenant_id, platform, platform_user_id, kind, external_id, post_id, participant_id, participant_name, last_message_at)
+			VALUES ($1, $2, $3, $4, $5, $6,
+				CASE WHEN $5 = 'comment' THEN (SELECT id FROM posts WHERE tenant_id = $2 AND external_id = $6 LIMIT 1) END,
+				$7, $8, $9)
+			ON CONFLICT (tenant_id, platform, platform_user_id, kind, external_id) DO UPDATE
+			SET participant_name = CASE WHEN EXCLUDED.participant_name <> '' THEN EXCLUDED.participant_name ELSE conversations.participant_name END
+			RETURNING id`,
+			fmt.Sprintf("conversation-%d-%d", now.UnixNano(), i), tenantID, platform, platformUserID, item.Kind, item.ThreadID,
+			item.ParticipantID, item.ParticipantName, item.SentAt,
+		).Scan(&conversationID)
+		if err != nil {
+			return 0, fmt.Errorf("failed to save conversation: %w", err)
+		}
+		res, err := tx.ExecContext(ctx, `
+			INSERT INTO inbox_messages (id, conversation_id, external_id, author_id, author_name, body, outbound, sent_at, read_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, CASE WHEN $7 THEN now() END)
+			ON CONFLICT (conversation_id, external_id) DO UPDATE SET body = EXCLUDED.body
+			WHERE inbox_messages.body <> EXCLUDED.body`,
+			fmt.Sprintf("message-%d-%d", now.UnixNano(), i), conversationID, item.ExternalID, item.AuthorID, item.AuthorName,
+			item.Text, item.Outbound, item.SentAt,
+		)
+		if err != nil {
+			return 0, fmt.Errorf("failed to save inbox message: %w", err)
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			saved++
+			touched[conversationID] = true
+		}
+	}
+	for conversationID := range touched {
+		if err := refreshConversation(ctx, tx, conversationID); err != nil {
+			return 0, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit inbox items: %w", err)
+	}
+	return saved, nil
+}
+
+// refreshConversation updates the preview, time and unread count of a
+// conversation from its messages.
+func refreshConversation(ctx context.Context, tx *sql.Tx, conversationID string) error {
+	_, err := tx.ExecContext(ctx, `
+		UPDATE conversations c
+		SET last_message_at = m.sent_at, preview = left(m.body, $2), updated_at = now(),
+			unread_count = (SELECT count(*) FROM inbox_messages WHERE conversation_id = c.id AND read_at IS NULL)
+		FROM (SELECT sent_at, body FROM inbox_messages WHERE conversation_id = $1 ORDER BY sent_at DESC, id DESC LIMIT 1) m
+		WHERE c.id = $1`,
+		conversationID, inboxPreviewLength,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update conversation: %w", err)
+	}
+	return nil
+}
+
+const conversationColumns = "c.id, c.platform, c.platform_user_id, c.kind, c.external_id, COALESCE(c.post_id, ''), c.participant_id, c.participant_name, c.preview, c.last_message_at, c.unread_count, c.created_at"
+
+func scanConversation(row interface{ Scan(...interface{}) error }) (Conversation, error) {
+	var c Conversation
+	err := row.Scan(&c.ID, &c.Platform, &c.PlatformUserID, &c.Kind, &c.ExternalID, &c.PostID, &c.ParticipantID, &c.ParticipantName,
+		&c.Preview, &c.LastMessageAt, &c.UnreadCount, &c.CreatedAt)
+	return c, err
+}
+
+func getConversation(ctx context.Context, q queryRower, tenantID, id string) (Conversation, error) {
+	c, err := scanConversation(q.QueryRowContext(ctx, "SELECT "+conversationColumns+" FROM conversations c WHERE c.id = $1 AND c.tenant_id = $2", id, tenantID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return c, errConversationNotFound
+	}
+	if err != nil {
+		return c, fmt.Errorf("failed to get conversation: %w", err)
+	}
+	return c, nil
+}
+
+// --- Pagination ---
+
+// inboxCursor is a position in a list ordered newest first: the time and ID
+// of the last item of the previous page.
+type inboxCursor struct {
+	At time.Time
+	ID string
+}
+
+func (c inboxCursor) String() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(c.At.UTC().Format(time.RFC3339Nano) + "|" + c.ID))
+}
+
+func parseInboxCursor(s string) (*inboxCursor, error) {
+	if s == "" {
+		return nil, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	at, id, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return nil, errors.New("invalid cursor")
+	}
+	t, err := time.Parse(time.RFC3339Nano, at)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	return &inboxCursor{At: t, ID: id}, nil
+}
+
+// parseInboxPage reads the limit and cursor query parameters.
+func parseInboxPage(q url.Values) (int, *inboxCursor, error) {
+	limit := defaultInboxPageSize
+	if s := q.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxInboxPageSize {
+			return 0, nil, fmt.Errorf("limit must be between 1 and %d", maxInboxPageSize)
+		}
+		limit = n
+	}
+	cursor, err := parseInboxCursor(q.Get("cursor"))
+	return limit, cursor, err
+}
+
+// --- Handlers ---
+
+func writeInboxError(w http.ResponseWriter, action string, err error) {
+	if errors.Is(err, errConversationNotFound) {
+		http.Error(w, "Conversation not found", http.StatusNotFound)
+		return
+	}
+	log.Printf("Failed to %s: %v", action, err)
+	http.Error(w, "Failed to "+action, http.StatusInternalServerError)
+}
+
+// listConversationsHandler handles GET /api/inbox/conversations, newest
+// activity first. Filters: kind (comment, mention, message), platform,
+// account (a PlatformUserID), postId, status (unread or read) and q, which
+// searches participants and messages. Pages hold limit conversations; pass
+// nextCursor as cursor for the next one.
+func listConversationsHandler(w http.ResponseWriter, r *http.Request) {
+	_, tenantID, err := getUserIDAndTenantIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	q := r.URL.Query()
+	limit, cursor, err := parseInboxPage(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	where := []string{"c.tenant_id = $1"}
+	args := []interface{}{tenantID}
+	add := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		where = append(where, strings.ReplaceAll(cond, "?", fmt.Sprintf("$%d", len(args))))
+	}
+	if kind := q.Get("kind"); kind != "" {
+		if kind != inboxComment && kind != inboxMention && kind != inboxMessage {
+			http.Error(w, "kind must be \"comment\", \"mention\" or \"message\"", http.StatusBadRequest)
+			return
+		}
+		add("c.kind = ?", kind)
+	}
+	if platform := q.Get("platform"); platform != "" {
+		add("c.platform = ?", platformLabel(platform))
+	}
+	if account := q.Get("account"); account != "" {
+		add("c.platform_user_id = ?", account)
+	}
+	if postID := q.Get("postId"); postID != "" {
+		add("c.post_id = ?", postID)
+	}
+	switch q.Get("status") {
+	case "":
+	case "unread":
+		where = append(where, "c.unread_count > 0")
+	case "read":
+		where = append(where, "c.unread_count = 0")
+	default:
+		http.Error(w, "status must be \"unread\" or \"read\"", http.StatusBadRequest)
+		return
+	}
+	if search := strings.TrimSpace(q.Get("q")); search != "" {
+		pattern := "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(search) + "%"
+		add("(c.participant_name ILIKE ? OR EXISTS (SELECT 1 FROM inbox_messages m WHERE m.conversation_id = c.id AND (m.body ILIKE ? OR m.author_name ILIKE ?)))", pattern)
+	}
+	if cursor != nil {
+		args = append(args, cursor.At, cursor.ID)
+		where = append(where, fmt.Sprintf("(c.last_message_at, c.id) < ($%d, $%d)", len(args)-1, len(args)))
+	}
+	args = append(args, limit+1)
+	query := fmt.Sprintf("SELECT %s FROM conversations c WHERE %s ORDER BY c.last_message_at DESC, c.id DESC LIMIT $%d",
+		conversationColumns, strings.Join(where, " AND "), len(args))
+
+	rows, err := db.QueryContext(r.Context(), query, args...)
+	if err != nil {
+		writeInboxError(w, "list conversations", err)
+		return
+	}
+	defer rows.Close()
+	conversations := []Conversation{}
+	for rows.Next() {
+		c, err := scanConversation(rows)
+		if err != nil {
+			writeInboxError(w, "list conversations", err)
+			return
+		}
+		conversations = append(conversations, c)
+	}
+	if err := rows.Err(); err != nil {
+		writeInboxError(w, "list conversations", err)
+		return
+	}
+	page := struct {
+		Conversations []Conversation `json:"conversations"`
+		NextCursor    string         `json:"nextCursor,omitempty"`
+	}{Conversations: conversations}
+	if len(conversations) > limit {
+		page.Conversations = conversations[:limit]
+		last := page.Conversations[limit-1]
+		page.NextCursor = inboxCursor{At: last.LastMessageAt, ID: last.ID}.String()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+func getConversationHandler(w http.ResponseWriter, r *http.Request) {
+	_, tenantID, err := getUserIDAndTenantIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	c, err := getConversation(r.Context(), db, tenantID, mux.Vars(r)["conversationId"])
+	if err != nil {
+		writeInboxError(w, "get conversation", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c)
+}
+
+// listInboxMessagesHandler handles GET /api/inbox/conversations/{conversationId}/messages,
+// newest first and paginated like the conversations.
+func listInboxMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	_, tenantID, err := getUserIDAndTenantIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	limit, cursor, err := parseInboxPage(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	c, err := getConversation(r.Context(), db, tenantID, mux.Vars(r)["conversationId"])
+	if err != nil {
+		writeInboxError(w, "list messages", err)
+		return
+	}
+	query := "SELECT id, conversation_id, external_id, author_id, author_name, body, outbound, sent_at, read_at IS NOT NULL FROM inbox_messages WHERE conversation_id = $1"
+	args := []interface{}{c.ID}
+	if cursor != nil {
+		query += " AND (sent_at, id) < ($2, $3)"
+		args = append(args, cursor.At, cursor.ID)
+	}
+	args = append(args, limit+1)
+	query += fmt.Sprintf(" ORDER BY sent_at DESC, id DESC LIMIT $%d", len(args))
+
+	rows, err := db.QueryContext(r.Context(), query, args...)
+	if err != nil {
+		writeInboxError(w, "list messages", err)
+		return
+	}
+	defer rows.Close()
+	messages := []InboxMessage{}
+	for rows.Next() {
+		var m InboxMessage
+		if err := rows.Scan(&m.ID, &m.ConversationID, &m.ExternalID, &m.AuthorID, &m.AuthorName, &m.Body, &m.Outbound, &m.SentAt, &m.Read); err != nil {
+			writeInboxError(w, "list messages", err)
+			return
+		}
+		messages = append(messages, m)
+	}
+	if err := rows.Err(); err != nil {
+		writeInboxError(w, "list messages", err)
+		return
+	}
+	page := struct {
+		Messages   []InboxMessage `json:"messages"`
+		NextCursor string         `json:"nextCursor,omitempty"`
+	}{Messages: messages}
+	if len(messages) > limit {
+		page.Messages = messages[:limit]
+		last := page.Messages[limit-1]
+		page.NextCursor = inboxCursor{At: last.SentAt, ID: last.ID}.String()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+// markConversationHandler handles POST .../read, which marks every message of
+// a conversation read, and POST .../unread, which marks its latest received
+// message unread again. It answers with the updated conversation.
+func markConversationHandler(read bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, tenantID, err := getUserIDAndTenantIDFromContext(r.Context())
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		tx, err := db.BeginTx(r.Context(), nil)
+		if err != nil {
+			writeInboxError(w, "update conversation", err)
+			return
+		}
+		defer tx.Rollback()
+		c, err := getConversation(r.Context(), tx, tenantID, mux.Vars(r)["conversationId"])
+		if err != nil {
+			writeInboxError(w, "update conversation", err)
+			return
+		}
+		if read {
+			_, err = tx.ExecContext(r.Context(), "UPDATE inbox_messages SET read_at = now() WHERE conversation_id = $1 AND read_at IS NULL", c.ID)
+		} else {
+			_, err = tx.ExecContext(r.Context(), `
+				UPDATE inbox_messages SET read_at = NULL
+				WHERE id = (SELECT id FROM inbox_messages WHERE conversation_id = $1 AND NOT outbound ORDER BY sent_at DESC, id DESC LIMIT 1)`, c.ID)
+		}
+		if err == nil {
+			err = refreshConversation(r.Context(), tx, c.ID)
+		}
+		if err == nil {
+			c, err = getConversation(r.Context(), tx, tenantID, c.ID)
+		}
+		if err == nil {
+			err = tx.Commit()
+		}
+		if err != nil {
+			writeInboxError(w, "update conversation", err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(c)
+	}
+}
+
+// inboxWebhookHandler handles /webhooks/{platform}: GET answers the platform's
+// subscription check and POST stores the delivered items for every tenant
+// that connected the account. These routes are not under /api and carry no
+// user token; the platform's signature authenticates them.
+func inboxWebhookHandler(publishers *PublisherRegistry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		platform := mux.Vars(r)["platform"]
+		publisher, ok := publishers.Get(platform)
+		var hook InboxWebhook
+		if ok {
+			hook, ok = publisher.(InboxWebhook)
+		}
+		if !ok {
+			http.Error(w, "Unknown webhook", http.StatusNotFound)
+			return
+		}
+
+		if r.Method == http.MethodGet {
+			challenge, err := hook.WebhookChallenge(r.URL.Query())
+			if err != nil {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			w.Header().Set("Content-Type", "text/plain")
+			io.WriteString(w, challenge)
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		items, err := hook.ParseWebhook(r.Header, body)
+		if errors.Is(err, errWebhookUnverified) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for platformUserID, accountItems := range items {
+			tenants, err := inboxTenants(r.Context(), platform, platformUserID)
+			if err != nil {
+				writeInboxError(w, "store webhook", err)
+				return
+			}
+			for _, tenantID := range tenants {
+				if _, err := saveInboxItems(r.Context(), tenantID, platform, platformUserID, accountItems); err != nil {
+					writeInboxError(w, "store webhook", err)
+					return
+				}
+			}
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
@@ -14,23 +14,16 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/gorilla/mux"
 	_ "github.com/lib/pq"
-)
-
-// --- Configuration ---
-const (
-	JWT_SECRET = "supersecretjwtkeythatshouldbeverylongandrandom"
+	"shared/jwks"
+	"shared/revocation"
 )
 
 // --- Database Connection ---
 var db *sql.DB
 
 func initDB() {
-	connStr := os.Getenv("DATABASE_URL")
-	if connStr == "" {
-		log.Fatal("DATABASE_URL environment variable is not set")
-	}
 	var err error
-	db, err = sql.Open("postgres", connStr)
+	db, err = sql.Open("postgres", cfg.DatabaseURL)
 	if err != nil {
 		log.Fatalf("Failed to open database connection: %v", err)
 	}
@@ -38,26 +31,6 @@ func initDB() {
 		log.Fatalf("Failed to ping database: %v", err)
 	}
 	log.Println("Post Service successfully connected to PostgreSQL!")
-	createTables()
-}
-
-func createTables() {
-	postTableSQL := `
-	CREATE TABLE IF NOT EXISTS posts (
-		id TEXT PRIMARY KEY,
-		user_id TEXT NOT NULL,
-		tenant_id TEXT NOT NULL,
-		platform TEXT NOT NULL,
-		content TEXT NOT NULL,
-		media_url TEXT,
-		scheduled_at TIMESTAMP WITH TIME ZONE NOT NULL,
-		posted_at TIMESTAMP WITH TIME ZONE,
-		status TEXT NOT NULL
-	);`
-	if _, err := db.Exec(postTableSQL); err != nil {
-		log.Fatalf("Failed to create posts table: %v", err)
-	}
-	log.Println("Post Service tables created successfully.")
 }
 
 // --- Models ---
@@ -66,24 +39,64 @@ type Post struct {
 	UserID        string    `json:"userId"`
 	TenantID      string    `json:"tenantId"`
 	Platform      string    `json:"platform"`
+	PlatformUserID string   `json:"platformUserId"`
 	Content       string    `json:"content"`
+	// MediaID references the post's media in the library; see media.go.
+	MediaID       string    `json:"mediaId,omitempty"`
+	// MediaURL is only stored for posts created before the media library. For
+	// the others the publishing worker fills it in with a link to MediaID.
 	MediaURL      string    `json:"mediaUrl"`
+	Media         *MediaInfo `json:"media,omitempty"`
 	ScheduledAt   time.Time `json:"scheduledAt"`
 	PostedAt      *time.Time `json:"postedAt"`
 	Status        string    `json:"status"`
+	ExternalID    string    `json:"externalId,omitempty"`
+	LastError     string    `json:"lastError,omitempty"`
+	// RequiredApprovals and Approvals count sign-offs in the current review round.
+	RequiredApprovals int `json:"requiredApprovals"`
+	Approvals         int `json:"approvals"`
+	ApprovalRound     int `json:"-"`
+	// CampaignID is set on posts created for a campaign; see campaigns.go.
+	CampaignID string `json:"campaignId,omitempty"`
+	// Version changes whenever the post or its status does; it is the post's ETag.
+	Version   int       `json:"version"`
+	UpdatedAt time.Time `json:"updatedAt"`
 }
 
+// Post lifecycle states; see approvals.go for how a post moves between them.
+const (
+	statusDraft           = "draft"
+	statusPendingApproval = "pending_approval"
+	statusApproved        = "approved"
+	statusRejected        = "rejected"
+	statusScheduled  = "scheduled"
+	statusPublishing = "publishing"
+	statusPublished  = "published"
+	statusFailed     = "failed"
+	statusCancelled  = "cancelled"
+	// statusDeleted hides a post while keeping its history.
+	statusDeleted = "deleted"
+)
+
 type Claims struct {
 	UserID   string `json:"user_id"`
 	TenantID string `json:"tenant_id"`
+	// Role is the user's role in the tenant; see authz.go for what it allows.
+	Role string `json:"role"`
 	jwt.RegisteredClaims
 }
 
 // --- Database Operations ---
-func savePost(post Post) error {
-	_, err := db.Exec(
-		"INSERT INTO posts (id, user_id, tenant_id, platform, content, media_url, scheduled_at, status) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)",
-		post.ID, post.UserID, post.TenantID, post.Platform, post.Content, post.MediaURL, post.ScheduledAt, post.Status,
+// execer is satisfied by both *sql.DB and *sql.Tx.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+func savePost(ctx context.Context, q execer, post Post) error {
+	_, err := q.ExecContext(ctx,
+		"INSERT INTO posts (id, user_id, tenant_id, platform, platform_user_id, content, media_id, scheduled_at, status, campaign_id) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)",
+		post.ID, post.UserID, post.TenantID, post.Platform, post.PlatformUserID, post.Content, sql.NullString{String: post.MediaID, Valid: post.MediaID != ""}, post.ScheduledAt, post.Status,
+		sql.NullString{String: post.CampaignID, Valid: post.CampaignID != ""},
 	)
 	if err != nil {
 		return fmt.Errorf("failed to save post: %w", err)
@@ -91,8 +104,36 @@ func savePost(post Post) error {
 	return nil
 }
 
-func getPostsForUser(userID, tenantID string) ([]Post, error) {
-	rows, err := db.Query("SELECT id, user_id, tenant_id, platform, content, media_url, scheduled_at, posted_at, status FROM posts WHERE user_id = $1 AND tenant_id = $2 ORDER BY scheduled_at DESC", userID, tenantID)
+// postColumns selects everything scanPost reads from posts aliased as p. The
+// media info of library media comes from the library, that of older posts from
+// their media_info column.
+const postColumns = `p.id, p.user_id, p.tenant_id, p.platform, COALESCE(p.platform_user_id, ''), p.content, COALESCE(p.media_url, ''), p.scheduled_at, p.posted_at, p.status, COALESCE(p.external_id, ''), COALESCE(p.last_error, ''),
+	p.required_approvals, p.approval_round, (SELECT count(*) FROM post_approvals a WHERE a.post_id = p.id AND a.round = p.approval_round), p.version, p.updated_at, COALESCE(p.campaign_id, ''), COALESCE(p.media_id, ''),
+	COALESCE((SELECT json_build_object('kind', m.kind, 'width', m.width, 'height', m.height, 'durationSeconds', m.duration_seconds) FROM media m WHERE m.id = p.media_id), p.media_info::json)`
+
+func scanPost(row interface{ Scan(...interface{}) error }) (Post, error) {
+	var post Post
+	var postedAt sql.NullTime
+	var media []byte
+	err := row.Scan(&post.ID, &post.UserID, &post.TenantID, &post.Platform, &post.PlatformUserID, &post.Content, &post.MediaURL, &post.ScheduledAt, &postedAt, &post.Status, &post.ExternalID, &post.LastError,
+		&post.RequiredApprovals, &post.ApprovalRound, &post.Approvals, &post.Version, &post.UpdatedAt, &post.CampaignID, &post.MediaID, &media)
+	if err != nil {
+		return post, err
+	}
+	if postedAt.Valid {
+		post.PostedAt = &postedAt.Time
+	}
+	if media != nil {
+		if err := json.Unmarshal(media, &post.Media); err != nil {
+			return post, fmt.Errorf("failed to parse media info: %w", err)
+		}
+	}
+	return post, nil
+}
+
+// getPostsForTenant returns the posts of every member of a tenant, except deleted ones.
+func getPostsForTenant(tenantID string) ([]Post, error) {
+	rows, err := db.Query("SELECT "+postColumns+" FROM posts p WHERE p.tenant_id = $1 AND p.status <> $2 ORDER BY p.scheduled_at DESC", tenantID, statusDeleted)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get posts: %w", err)
 	}
@@ -100,23 +141,48 @@ func getPostsForUser(userID, tenantID string) ([]Post, error) {
 
 	var posts []Post
 	for rows.Next() {
-		var post Post
-		var postedAt sql.NullTime
-		if err := rows.Scan(&post.ID, &post.UserID, &post.TenantID, &post.Platform, &post.Content, &post.MediaURL, &post.ScheduledAt, &postedAt, &post.Status); err != nil {
+		post, err := scanPost(rows)
+		if err != nil {
 			return nil, fmt.Errorf("failed to scan post row: %w", err)
 		}
-		if postedAt.Valid {
-			post.PostedAt = &postedAt.Time
-		}
 		posts = append(posts, post)
 	}
 	return posts, nil
 }
 
 // --- Middleware ---
+
+// keySet verifies the platform JWTs presented to authMiddleware.
+var keySet jwks.KeySet
+
+func initKeySet() {
+	keySet = jwks.NewRemoteKeySet(cfg.JWKS.URL, cfg.JWKS.RefreshInterval)
+}
+
+func parseJWT(ctx context.Context, tokenString string, claims *Claims) (*jwt.Token, error) {
+	return jwks.Parse(ctx, keySet, cfg.JWTIssuer, tokenString, claims)
+}
+
+// revokedTokens holds the jtis of access tokens auth-service has revoked
+// before their expiry; authMiddleware rejects them.
+var revokedTokens *revocation.List
+
+func initRevocationList() {
+	revokedTokens = revocation.NewList()
+	poller := &revocation.Poller{
+		URL:      cfg.Revocation.URL,
+		Secret:   cfg.InternalAPISecret,
+		Interval: cfg.Revocation.PollInterval,
+		Client:   &http.Client{Timeout: 10 * time.Second},
+		List:     revokedTokens,
+	}
+	go poller.Run(context.Background())
+}
+
 type contextKey string
 const userIDKey contextKey = "userID"
 const tenantIDKey contextKey = "tenantID"
+const roleKey contextKey = "role"
 
 func authMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -127,15 +193,18 @@ func authMiddleware(next http.Handler) http.Handler {
 		}
 		tokenString := strings.Replace(authHeader, "Bearer ", "", 1)
 		claims := &Claims{}
-		token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-			return []byte(JWT_SECRET), nil
-		})
+		token, err := parseJWT(r.Context(), tokenString, claims)
 		if err != nil || !token.Valid {
 			http.Error(w, "Invalid or expired token", http.StatusUnauthorized)
 			return
 		}
+		if revokedTokens.Revoked(claims.ID) {
+			http.Error(w, "Token has been revoked", http.StatusUnauthorized)
+			return
+		}
 		ctx := context.WithValue(r.Context(), userIDKey, claims.UserID)
 		ctx = context.WithValue(ctx, tenantIDKey, claims.TenantID)
+		ctx = context.WithValue(ctx, roleKey, claims.Role)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
@@ -154,12 +223,12 @@ func getUserIDAndTenantIDFromContext(ctx context.Context) (string, string, error
 
 // --- Handlers ---
 func getScheduledPostsHandler(w http.ResponseWriter, r *http.Request) {
-	userID, tenantID, err := getUserIDAndTenantIDFromContext(r.Context())
+	_, tenantID, err := getUserIDAndTenantIDFromContext(r.Context())
 	if err != nil {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
-	posts, err := getPostsForUser(userID, tenantID)
+	posts, err := getPostsForTenant(tenantID)
 	if err != nil {
 		http.Error(w, "Failed to retrieve posts", http.StatusInternalServerError)
 		return
@@ -179,30 +248,50 @@ func createPostHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	if newPost.MediaURL != "" {
+		http.Error(w, "mediaUrl is no longer accepted; upload the file to /api/media and send its mediaId", http.StatusBadRequest)
+		return
+	}
 	newPost.ID = fmt.Sprintf("post-%d", time.Now().UnixNano())
 	newPost.UserID = userID
 	newPost.TenantID = tenantID
-	newPost.Status = "scheduled"
-	if err := savePost(newPost); err != nil {
+	newPost.Status = statusDraft
+	newPost.RequiredApprovals, newPost.Approvals = 0, 0
+	newPost.Version, newPost.UpdatedAt = 1, time.Now()
+	newPost.CampaignID = ""
+	newPost.Media = nil
+	if err := attachMedia(r.Context(), &newPost); err != nil {
+		log.Printf("Failed to look up media for a new post: %v", err)
+		http.Error(w, "Failed to save post", http.StatusInternalServerError)
+		return
+	}
+	if v := validatePost(newPost); !v.Valid {
+		writeValidationError(w, v)
+		return
+	}
+	if err := savePost(r.Context(), db, newPost); err != nil {
 		http.Error(w, "Failed to save post", http.StatusInternalServerError)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", postETag(newPost))
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(newPost)
 }
 
-// --- Main function ---
-func main() {
-	initDB()
-	defer db.Close()
+// --- Routes ---
+
+// newRouter builds the service's HTTP routes. Everything under /api passes
+// authMiddleware and authorize.
+func newRouter(publishers *PublisherRegistry) *mux.Router {
 	router := mux.NewRouter()
 
 	router.Use(func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			w.Header().Set("Access-Control-Allow-Origin", "http://localhost:3000")
-			w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE")
-			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Access-Control-Allow-Headers, Authorization, X-Requested-With")
+			w.Header().Set("Access-Control-Allow-Origin", cfg.CORSAllowedOrigin)
+			w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, PATCH, DELETE")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Access-Control-Allow-Headers, Authorization, X-Requested-With, If-Match, Content-Range")
+			w.Header().Set("Access-Control-Expose-Headers", "ETag")
 			w.Header().Set("Access-Control-Allow-Credentials", "true")
 			if r.Method == "OPTIONS" {
 				w.WriteHeader(http.StatusOK)
@@ -212,12 +301,97 @@ func main() {
 		})
 	})
 
+	if local, ok := mediaStore.(*LocalStorage); ok {
+		router.HandleFunc("/media/files/{key:.+}", local.serveFile).Methods("GET", "HEAD")
+	}
+	router.HandleFunc("/webhooks/{platform}", inboxWebhookHandler(publishers)).Methods("GET", "POST")
+
 	apiRouter := router.PathPrefix("/api").Subrouter()
 	apiRouter.Use(authMiddleware)
+	apiRouter.Use(authorize)
 
 	apiRouter.HandleFunc("/posts", getScheduledPostsHandler).Methods("GET")
 	apiRouter.HandleFunc("/posts", createPostHandler).Methods("POST")
-	
-	log.Println("Post Service is starting on port 8083...")
-	log.Fatal(http.ListenAndServe(":8083", router))
+	apiRouter.HandleFunc("/posts/validate", validatePostHandler).Methods("POST")
+	apiRouter.HandleFunc("/posts/{postId}", getPostHandler).Methods("GET")
+	apiRouter.HandleFunc("/posts/{postId}", replacePostHandler).Methods("PUT")
+	apiRouter.HandleFunc("/posts/{postId}", patchPostHandler).Methods("PATCH")
+	apiRouter.HandleFunc("/posts/{postId}", deletePostHandler).Methods("DELETE")
+	apiRouter.HandleFunc("/posts/{postId}/submit", submitPostHandler).Methods("POST")
+	apiRouter.HandleFunc("/posts/{postId}/approve", approvePostHandler).Methods("POST")
+	apiRouter.HandleFunc("/posts/{postId}/reject", rejectPostHandler).Methods("POST")
+	apiRouter.HandleFunc("/posts/{postId}/approvals", listApprovalsHandler).Methods("GET")
+	apiRouter.HandleFunc("/posts/{postId}/transitions", listTransitionsHandler).Methods("GET")
+	apiRouter.HandleFunc("/posts/{postId}/metrics", listPostMetricsHandler).Methods("GET")
+	apiRouter.HandleFunc("/campaigns", getCampaignsHandler).Methods("GET")
+	apiRouter.HandleFunc("/campaigns", createCampaignHandler).Methods("POST")
+	apiRouter.HandleFunc("/campaigns/{campaignId}", getCampaignHandler).Methods("GET")
+	apiRouter.HandleFunc("/campaigns/{campaignId}/submit", submitCampaignHandler).Methods("POST")
+	apiRouter.HandleFunc("/campaigns/{campaignId}/approve", approveCampaignHandler).Methods("POST")
+	apiRouter.HandleFunc("/campaigns/{campaignId}/reject", rejectCampaignHandler).Methods("POST")
+	apiRouter.HandleFunc("/media", listMediaHandler).Methods("GET")
+	apiRouter.HandleFunc("/media", uploadMediaHandler).Methods("POST")
+	apiRouter.HandleFunc("/media/uploads", createUploadHandler).Methods("POST")
+	apiRouter.HandleFunc("/media/uploads/{uploadId}", getUploadHandler).Methods("GET")
+	apiRouter.HandleFunc("/media/uploads/{uploadId}", uploadChunkHandler).Methods("PUT")
+	apiRouter.HandleFunc("/media/uploads/{uploadId}", deleteUploadHandler).Methods("DELETE")
+	apiRouter.HandleFunc("/media/{mediaId}", getMediaHandler).Methods("GET")
+	apiRouter.HandleFunc("/media/{mediaId}", deleteMediaHandler).Methods("DELETE")
+	apiRouter.HandleFunc("/media/{mediaId}/renditions", listRenditionsHandler).Methods("GET")
+	apiRouter.HandleFunc("/media/{mediaId}/renditions", retryRenditionsHandler).Methods("POST")
+	apiRouter.HandleFunc("/analytics", getAnalyticsHandler).Methods("GET")
+	apiRouter.HandleFunc("/reports", listReportsHandler).Methods("GET")
+	apiRouter.HandleFunc("/reports", createReportHandler).Methods("POST")
+	apiRouter.HandleFunc("/reports/{reportId}", getReportHandler).Methods("GET")
+	apiRouter.HandleFunc("/report-subscriptions", listSubscriptionsHandler).Methods("GET")
+	apiRouter.HandleFunc("/report-subscriptions", createSubscriptionHandler).Methods("POST")
+	apiRouter.HandleFunc("/report-subscriptions/{subscriptionId}", getSubscriptionHandler).Methods("GET")
+	apiRouter.HandleFunc("/report-subscriptions/{subscriptionId}", updateSubscriptionHandler).Methods("PUT")
+	apiRouter.HandleFunc("/report-subscriptions/{subscriptionId}", deleteSubscriptionHandler).Methods("DELETE")
+	apiRouter.HandleFunc("/report-subscriptions/{subscriptionId}/deliveries", listDeliveriesHandler).Methods("GET")
+	apiRouter.HandleFunc("/report-subscriptions/{subscriptionId}/deliveries/{deliveryId}/retry", retryDeliveryHandler).Methods("POST")
+	apiRouter.HandleFunc("/inbox/conversations", listConversationsHandler).Methods("GET")
+	apiRouter.HandleFunc("/inbox/conversations/{conversationId}", getConversationHandler).Methods("GET")
+	apiRouter.HandleFunc("/inbox/conversations/{conversationId}/messages", listInboxMessagesHandler).Methods("GET")
+	apiRouter.HandleFunc("/inbox/conversations/{conversationId}/read", markConversationHandler(true)).Methods("POST")
+	apiRouter.HandleFunc("/inbox/conversations/{conversationId}/unread", markConversationHandler(false)).Methods("POST")
+	apiRouter.HandleFunc("/settings", getTenantSettingsHandler).Methods("GET")
+	apiRouter.HandleFunc("/settings", updateTenantSettingsHandler).Methods("PUT")
+	return router
+}
+
+// --- Main function ---
+func main() {
+	initConfig()
+	initDB()
+	defer db.Close()
+
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		runMigrateCommand(os.Args[2:])
+		return
+	}
+	autoMigrate()
+	initKeySet()
+	initRevocationList()
+	initMediaLibrary()
+	startRenditionWorkers()
+	startReportWorkers()
+
+	accounts := newAccountServiceClientFromConfig()
+	accountDirectory = accounts
+	publishers := newPublisherRegistryFromConfig()
+	scheduler := newScheduler(publishers, accounts)
+	go scheduler.Run(context.Background())
+	startReportScheduler()
+	if cfg.Analytics.Enabled {
+		go newMetricsCollector(publishers, accounts, accounts).Run(context.Background())
+	}
+	if cfg.Inbox.Enabled {
+		go newInboxSyncer(publishers, accounts, accounts).Run(context.Background())
+	}
+
+	router := newRouter(publishers)
+
+	log.Printf("Post Service is starting on port %d...", cfg.Port)
+	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", cfg.Port), router))
 }
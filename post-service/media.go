@@ -0,0 +1,776 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// --- Media Library ---
+//
+// Each tenant has a library of uploaded images and videos, and posts and
+// campaigns reference its media by ID instead of carrying arbitrary URLs.
+// Small files are uploaded in one multipart request to POST /api/media. Large
+// ones go through a resumable upload: POST /api/media/uploads announces the
+// file, its bytes follow in any number of PUT requests with a Content-Range,
+// and after an interruption GET tells the client where to carry on. Uploads
+// are staged in media.upload_dir until their last byte arrives.
+//
+// Complete files are ingested: their type is sniffed and they are measured
+// (see probe.go), checksummed, and stored in mediaStore (see storage.go). A
+// file the tenant already has is not stored again; the existing media is
+// returned instead, with 200 rather than 201. New media is then queued for its
+// platform renditions (see renditions.go).
+
+const (
+	// uploadChunkLimit bounds the body of one PUT to a resumable upload.
+	uploadChunkLimit = 64 << 20
+	// uploadLifetime is how long an unfinished resumable upload is kept.
+	uploadLifetime = 24 * time.Hour
+	// mediaPreviewTTL is how long the links in API responses stay valid.
+	mediaPreviewTTL = time.Hour
+)
+
+var (
+	errMediaNotFound    = errors.New("media not found")
+	errMediaInUse       = errors.New("media is used by posts that have not been published")
+	errUnsupportedMedia = errors.New("unsupported media")
+	errUploadNotFound   = errors.New("upload not found")
+	errUploadOffset     = errors.New("chunk does not start where the upload left off")
+)
+
+// Media is a file in a tenant's media library.
+type Media struct {
+	ID              string    `json:"id"`
+	TenantID        string    `json:"tenantId"`
+	UserID          string    `json:"userId"`
+	Filename        string    `json:"filename"`
+	ContentType     string    `json:"contentType"`
+	Kind            string    `json:"kind"`
+	SizeBytes       int64     `json:"sizeBytes"`
+	Width           int       `json:"width,omitempty"`
+	Height          int       `json:"height,omitempty"`
+	DurationSeconds float64   `json:"durationSeconds,omitempty"`
+	SHA256          string    `json:"sha256"`
+	CreatedAt       time.Time `json:"createdAt"`
+	// URL and ThumbnailURL are short-lived download links, filled in for responses.
+	URL          string `json:"url,omitempty"`
+	ThumbnailURL string `json:"thumbnailUrl,omitempty"`
+	storageKey   string
+	thumbnailKey string
+}
+
+// info returns what content validation needs to know about the media.
+func (m Media) info() *MediaInfo {
+	return &MediaInfo{Kind: m.Kind, Width: m.Width, Height: m.Height, DurationSeconds: m.DurationSeconds}
+}
+
+// MediaUpload is a resumable upload in progress.
+type MediaUpload struct {
+	ID            string    `json:"id"`
+	Filename      string    `json:"filename"`
+	SizeBytes     int64     `json:"sizeBytes"`
+	ReceivedBytes int64     `json:"receivedBytes"`
+	ExpiresAt     time.Time `json:"expiresAt"`
+}
+
+func initMediaLibrary() {
+	store, err := newStorageFromConfig()
+	if err != nil {
+		log.Fatalf("Failed to set up media storage: %v", err)
+	}
+	if err := os.MkdirAll(cfg.Media.UploadDir, 0o750); err != nil {
+		log.Fatalf("Failed to create upload directory: %v", err)
+	}
+	mediaStore = store
+	go runUploadJanitor(context.Background(), time.Hour)
+}
+
+// --- Database Operations ---
+
+// queryRower is satisfied by both *sql.DB and *sql.Tx.
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+const mediaColumns = `id, tenant_id, user_id, filename, content_type, kind, size_bytes, COALESCE(width, 0), COALESCE(height, 0), COALESCE(duration_seconds, 0), sha256, storage_key, created_at,
+	COALESCE((SELECT r.storage_key FROM media_renditions r WHERE r.media_id = media.id AND r.preset = 'thumbnail' AND r.status = 'ready'), '')`
+
+func scanMedia(row interface{ Scan(...interface{}) error }) (Media, error) {
+	var m Media
+	err := row.Scan(&m.ID, &m.TenantID, &m.UserID, &m.Filename, &m.ContentType, &m.Kind, &m.SizeBytes, &m.Width, &m.Height, &m.DurationSeconds, &m.SHA256, &m.storageKey, &m.CreatedAt, &m.thumbnailKey)
+	return m, err
+}
+
+// getMedia returns one of a tenant's media that has not been deleted.
+func getMedia(ctx context.Context, q queryRower, tenantID, mediaID string) (Media, error) {
+	m, err := scanMedia(q.QueryRowContext(ctx, "SELECT "+mediaColumns+" FROM media WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL", mediaID, tenantID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Media{}, errMediaNotFound
+	}
+	if err != nil {
+		return Media{}, fmt.Errorf("failed to get media: %w", err)
+	}
+	return m, nil
+}
+
+func getMediaByChecksum(ctx context.Context, tenantID, checksum string) (Media, error) {
+	m, err := scanMedia(db.QueryRowContext(ctx, "SELECT "+mediaColumns+" FROM media WHERE tenant_id = $1 AND sha256 = $2 AND deleted_at IS NULL", tenantID, checksum))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Media{}, errMediaNotFound
+	}
+	if err != nil {
+		return Media{}, fmt.Errorf("failed to get media: %w", err)
+	}
+	return m, nil
+}
+
+// attachMedia fills in post.Media from the library media the post references.
+// It leaves it nil if the tenant has no such media, which validatePost reports.
+func attachMedia(ctx context.Context, post *Post) error {
+	if post.MediaID == "" {
+		return nil
+	}
+	m, err := getMedia(ctx, db, post.TenantID, post.MediaID)
+	if errors.Is(err, errMediaNotFound) {
+		post.Media = nil
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	post.Media = m.info()
+	return nil
+}
+
+// mediaDownloadURL returns the link a publisher hands to platform: to the
+// media's rendition for the platform if it is ready, else to the original.
+func mediaDownloadURL(ctx context.Context, tenantID, mediaID, platform string) (string, error) {
+	m, err := getMedia(ctx, db, tenantID, mediaID)
+	if err != nil {
+		return "", err
+	}
+	key := m.storageKey
+	if _, ok := findPreset(strings.ToLower(platform), m.Kind); ok && cfg.Renditions.Enabled {
+		rendition, err := renditionKey(ctx, m.ID, strings.ToLower(platform))
+		if err != nil {
+			return "", err
+		}
+		if rendition != "" {
+			key = rendition
+		} else {
+			log.Printf("Media %s has no %s rendition yet; publishing the original", m.ID, platform)
+		}
+	}
+	return mediaStore.URL(key, cfg.Media.URLTTL)
+}
+
+// withURLs fills in the download links of m.
+func (m Media) withURLs() (Media, error) {
+	var err error
+	if m.URL, err = mediaStore.URL(m.storageKey, mediaPreviewTTL); err == nil && m.thumbnailKey != "" {
+		m.ThumbnailURL, err = mediaStore.URL(m.thumbnailKey, mediaPreviewTTL)
+	}
+	return m, err
+}
+
+// ingestMedia adds the file at path to a tenant's library. It reports false if
+// the tenant already had the file, in which case the existing media is returned.
+func ingestMedia(ctx context.Context, tenantID, userID, filename, path string) (Media, bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Media{}, false, fmt.Errorf("failed to open upload: %w", err)
+	}
+	defer f.Close()
+	hash := sha256.New()
+	size, err := io.Copy(hash, f)
+	if err != nil {
+		return Media{}, false, fmt.Errorf("failed to read upload: %w", err)
+	}
+	if size == 0 {
+		return Media{}, false, fmt.Errorf("%w: the file is empty", errUnsupportedMedia)
+	}
+	checksum := hex.EncodeToString(hash.Sum(nil))
+	if existing, err := getMediaByChecksum(ctx, tenantID, checksum); !errors.Is(err, errMediaNotFound) {
+		return existing, false, err
+	}
+
+	head := make([]byte, 512)
+	n, err := f.ReadAt(head, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return Media{}, false, fmt.Errorf("failed to read upload: %w", err)
+	}
+	contentType := sniffContentType(head[:n])
+	mediaType, ok := mediaTypes[contentType]
+	if !ok {
+		return Media{}, false, fmt.Errorf("%w: %s files are not accepted", errUnsupportedMedia, contentType)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return Media{}, false, fmt.Errorf("failed to read upload: %w", err)
+	}
+	info, err := probeMedia(f, size, contentType)
+	if err != nil {
+		return Media{}, false, fmt.Errorf("%w: %v", errUnsupportedMedia, err)
+	}
+
+	m := Media{
+		ID:              fmt.Sprintf("media-%d", time.Now().UnixNano()),
+		TenantID:        tenantID,
+		UserID:          userID,
+		Filename:        cleanFilename(filename),
+		ContentType:     contentType,
+		Kind:            mediaType.kind,
+		SizeBytes:       size,
+		Width:           info.Width,
+		Height:          info.Height,
+		DurationSeconds: info.DurationSeconds,
+		SHA256:          checksum,
+		CreatedAt:       time.Now(),
+		storageKey:      tenantID + "/" + checksum + mediaType.ext,
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return Media{}, false, fmt.Errorf("failed to read upload: %w", err)
+	}
+	if err := mediaStore.Put(ctx, m.storageKey, f, size, contentType); err != nil {
+		return Media{}, false, err
+	}
+	res, err := db.ExecContext(ctx, `
+		INSERT INTO media (id, tenant_id, user_id, filename, content_type, kind, size_bytes, width, height, duration_seconds, sha256, storage_key, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, NULLIF($8, 0), NULLIF($9, 0), NULLIF($10, 0), $11, $12, $13)
+		ON CONFLICT (tenant_id, sha256) WHERE deleted_at IS NULL DO NOTHING`,
+		m.ID, m.TenantID, m.UserID, m.Filename, m.ContentType, m.Kind, m.SizeBytes, m.Width, m.Height, m.DurationSeconds, m.SHA256, m.storageKey, m.CreatedAt,
+	)
+	if err != nil {
+		return Media{}, false, fmt.Errorf("failed to save media: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		// The same file was uploaded concurrently and won; it stored the same object.
+		existing, err := getMediaByChecksum(ctx, tenantID, checksum)
+		return existing, false, err
+	}
+	if err := enqueueRenditions(ctx, m); err != nil {
+		log.Printf("Failed to queue renditions of media %s: %v", m.ID, err)
+	}
+	return m, true, nil
+}
+
+// cleanFilename keeps the base name of a client-supplied file name.
+func cleanFilename(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+	if name == "." || name == "/" {
+		return "upload"
+	}
+	return name
+}
+
+// deleteMedia removes media from the library and its files from storage. The
+// row is kept for the published posts that reference it.
+func deleteMedia(ctx context.Context, tenantID, mediaID string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var key string
+	err = tx.QueryRowContext(ctx, "SELECT storage_key FROM media WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL FOR UPDATE", mediaID, tenantID).Scan(&key)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errMediaNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get media: %w", err)
+	}
+	var inUse bool
+	err = tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM posts WHERE media_id = $1 AND status NOT IN ($2, $3))", mediaID, statusPublished, statusDeleted).Scan(&inUse)
+	if err != nil {
+		return fmt.Errorf("failed to check media use: %w", err)
+	}
+	if inUse {
+		return errMediaInUse
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE media SET deleted_at = now() WHERE id = $1", mediaID); err != nil {
+		return fmt.Errorf("failed to delete media: %w", err)
+	}
+	renditions, err := getRenditions(ctx, mediaID)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	keys := []string{key}
+	for _, r := range renditions {
+		if r.storageKey != "" {
+			keys = append(keys, r.storageKey)
+		}
+	}
+	for _, key := range keys {
+		if err := mediaStore.Delete(ctx, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// --- Resumable Uploads ---
+
+func uploadPath(uploadID string) string {
+	return filepath.Join(cfg.Media.UploadDir, uploadID+".part")
+}
+
+func getUpload(ctx context.Context, q queryRower, tenantID, uploadID string, lock bool) (MediaUpload, error) {
+	query := "SELECT id, filename, size_bytes, received_bytes, expires_at FROM media_uploads WHERE id = $1 AND tenant_id = $2 AND expires_at > now()"
+	if lock {
+		query += " FOR UPDATE"
+	}
+	var u MediaUpload
+	err := q.QueryRowContext(ctx, query, uploadID, tenantID).Scan(&u.ID, &u.Filename, &u.SizeBytes, &u.ReceivedBytes, &u.ExpiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return MediaUpload{}, errUploadNotFound
+	}
+	if err != nil {
+		return MediaUpload{}, fmt.Errorf("failed to get upload: %w", err)
+	}
+	return u, nil
+}
+
+func removeUpload(ctx context.Context, uploadID string) error {
+	if _, err := db.ExecContext(ctx, "DELETE FROM media_uploads WHERE id = $1", uploadID); err != nil {
+		return fmt.Errorf("failed to remove upload: %w", err)
+	}
+	if err := os.Remove(uploadPath(uploadID)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove staged upload: %w", err)
+	}
+	return nil
+}
+
+// receiveChunk copies a chunk of length bytes from body into a temporary file
+// and returns it, positioned at its start.
+func receiveChunk(body io.Reader, length int64) (*os.File, error) {
+	f, err := os.CreateTemp(cfg.Media.UploadDir, "chunk-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create chunk file: %w", err)
+	}
+	written, err := io.Copy(f, io.LimitReader(body, length))
+	if err == nil && written != length {
+		err = fmt.Errorf("chunk ended after %d of %d bytes", written, length)
+	}
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return f, nil
+}
+
+// appendChunk writes the received chunk starting at offset to a locked upload
+// and returns how many bytes the upload has received.
+func appendChunk(ctx context.Context, tx *sql.Tx, u MediaUpload, offset, length int64, body io.Reader) (int64, error) {
+	if offset != u.ReceivedBytes {
+		return 0, errUploadOffset
+	}
+	f, err := os.OpenFile(uploadPath(u.ID), os.O_WRONLY|os.O_CREATE, 0o640)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open staged upload: %w", err)
+	}
+	defer f.Close()
+	// Drop anything a previously interrupted chunk left behind.
+	if err := f.Truncate(offset); err != nil {
+		return 0, fmt.Errorf("failed to truncate staged upload: %w", err)
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return 0, fmt.Errorf("failed to seek staged upload: %w", err)
+	}
+	written, err := io.Copy(f, io.LimitReader(body, length))
+	if err == nil && written != length {
+		err = fmt.Errorf("chunk ended after %d of %d bytes", written, length)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to write chunk: %w", err)
+	}
+	received := offset + length
+	if _, err := tx.ExecContext(ctx, "UPDATE media_uploads SET received_bytes = $2 WHERE id = $1", u.ID, received); err != nil {
+		return 0, fmt.Errorf("failed to record chunk: %w", err)
+	}
+	return received, nil
+}
+
+// parseContentRange parses "bytes first-last/total".
+func parseContentRange(header string) (first, last, total int64, err error) {
+	spec, ok := strings.CutPrefix(header, "bytes ")
+	rangePart, totalPart, ok2 := strings.Cut(spec, "/")
+	firstPart, lastPart, ok3 := strings.Cut(rangePart, "-")
+	if !ok || !ok2 || !ok3 {
+		return 0, 0, 0, fmt.Errorf("Content-Range must look like \"bytes 0-1048575/5242880\"")
+	}
+	if first, err = strconv.ParseInt(firstPart, 10, 64); err == nil {
+		if last, err = strconv.ParseInt(lastPart, 10, 64); err == nil {
+			total, err = strconv.ParseInt(totalPart, 10, 64)
+		}
+	}
+	if err != nil || first < 0 || last < first || last >= total {
+		return 0, 0, 0, fmt.Errorf("invalid Content-Range %q", header)
+	}
+	return first, last, total, nil
+}
+
+// runUploadJanitor removes expired resumable uploads every interval.
+func runUploadJanitor(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		rows, err := db.QueryContext(ctx, "SELECT id FROM media_uploads WHERE expires_at <= now()")
+		if err != nil {
+			log.Printf("Failed to list expired uploads: %v", err)
+		} else {
+			var expired []string
+			for rows.Next() {
+				var id string
+				if rows.Scan(&id) == nil {
+					expired = append(expired, id)
+				}
+			}
+			rows.Close()
+			for _, id := range expired {
+				if err := removeUpload(ctx, id); err != nil {
+					log.Printf("Failed to remove expired upload %s: %v", id, err)
+				}
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// --- Handlers ---
+
+func writeMediaError(w http.ResponseWriter, action string, err error) {
+	switch {
+	case errors.Is(err, errMediaNotFound):
+		http.Error(w, "Media not found", http.StatusNotFound)
+	case errors.Is(err, errUploadNotFound):
+		http.Error(w, "Upload not found or expired", http.StatusNotFound)
+	case errors.Is(err, errMediaInUse):
+		http.Error(w, "The media is used by posts that have not been published yet", http.StatusConflict)
+	case errors.Is(err, errUnsupportedMedia):
+		http.Error(w, fmt.Sprintf("Cannot %s: %v", action, err), http.StatusUnsupportedMediaType)
+	default:
+		log.Printf("Failed to %s: %v", action, err)
+		http.Error(w, "Failed to "+action, http.StatusInternalServerError)
+	}
+}
+
+// writeMedia answers with media and a fresh link to it: 201 if it was just
+// added to the library, 200 otherwise.
+func writeMedia(w http.ResponseWriter, m Media, created bool) {
+	m, err := m.withURLs()
+	if err != nil {
+		writeMediaError(w, "link media", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if created {
+		w.WriteHeader(http.StatusCreated)
+	}
+	json.NewEncoder(w).Encode(m)
+}
+
+func writeUpload(w http.ResponseWriter, status int, u MediaUpload) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(u)
+}
+
+func listMediaHandler(w http.ResponseWriter, r *http.Request) {
+	_, tenantID, err := getUserIDAndTenantIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	rows, err := db.QueryContext(r.Context(), "SELECT "+mediaColumns+" FROM media WHERE tenant_id = $1 AND deleted_at IS NULL ORDER BY created_at DESC", tenantID)
+	if err != nil {
+		writeMediaError(w, "list media", err)
+		return
+	}
+	defer rows.Close()
+	library := []Media{}
+	for rows.Next() {
+		m, err := scanMedia(rows)
+		if err == nil {
+			m, err = m.withURLs()
+		}
+		if err != nil {
+			writeMediaError(w, "list media", err)
+			return
+		}
+		library = append(library, m)
+	}
+	if err := rows.Err(); err != nil {
+		writeMediaError(w, "list media", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(library)
+}
+
+func getMediaHandler(w http.ResponseWriter, r *http.Request) {
+	_, tenantID, err := getUserIDAndTenantIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	m, err := getMedia(r.Context(), db, tenantID, mux.Vars(r)["mediaId"])
+	if err != nil {
+		writeMediaError(w, "get media", err)
+		return
+	}
+	writeMedia(w, m, false)
+}
+
+func deleteMediaHandler(w http.ResponseWriter, r *http.Request) {
+	_, tenantID, err := getUserIDAndTenantIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if err := deleteMedia(r.Context(), tenantID, mux.Vars(r)["mediaId"]); err != nil {
+		writeMediaError(w, "delete media", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// uploadMediaHandler adds the "file" part of a multipart request to the library.
+func uploadMediaHandler(w http.ResponseWriter, r *http.Request) {
+	userID, tenantID, err := getUserIDAndTenantIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, int64(cfg.Media.MaxUploadBytes)+1<<20)
+	parts, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, "Expected a multipart/form-data request", http.StatusBadRequest)
+		return
+	}
+	for {
+		part, err := parts.NextPart()
+		if errors.Is(err, io.EOF) {
+			http.Error(w, `The request has no "file" part`, http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Invalid multipart body", http.StatusBadRequest)
+			return
+		}
+		if part.FormName() != "file" {
+			continue
+		}
+		tmp, err := os.CreateTemp(cfg.Media.UploadDir, "multipart-*")
+		if err != nil {
+			writeMediaError(w, "upload media", err)
+			return
+		}
+		defer os.Remove(tmp.Name())
+		// The body limit leaves room for the multipart framing; the file itself
+		// may not exceed MaxUploadBytes.
+		written, err := io.Copy(tmp, io.LimitReader(part, int64(cfg.Media.MaxUploadBytes)+1))
+		tmp.Close()
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) || written > int64(cfg.Media.MaxUploadBytes) {
+			http.Error(w, fmt.Sprintf("Files can be at most %d bytes", cfg.Media.MaxUploadBytes), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to receive the file", http.StatusBadRequest)
+			return
+		}
+		m, created, err := ingestMedia(r.Context(), tenantID, userID, part.FileName(), tmp.Name())
+		if err != nil {
+			writeMediaError(w, "upload media", err)
+			return
+		}
+		writeMedia(w, m, created)
+		return
+	}
+}
+
+// createUploadHandler starts a resumable upload.
+func createUploadHandler(w http.ResponseWriter, r *http.Request) {
+	userID, tenantID, err := getUserIDAndTenantIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var req struct {
+		Filename  string `json:"filename"`
+		SizeBytes int64  `json:"sizeBytes"`
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, 1<<16)
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.SizeBytes <= 0 || req.SizeBytes > int64(cfg.Media.MaxUploadBytes) {
+		http.Error(w, fmt.Sprintf("sizeBytes must be between 1 and %d", cfg.Media.MaxUploadBytes), http.StatusBadRequest)
+		return
+	}
+	u := MediaUpload{
+		ID:        fmt.Sprintf("upload-%d", time.Now().UnixNano()),
+		Filename:  cleanFilename(req.Filename),
+		SizeBytes: req.SizeBytes,
+		ExpiresAt: time.Now().Add(uploadLifetime),
+	}
+	_, err = db.ExecContext(r.Context(),
+		"INSERT INTO media_uploads (id, tenant_id, user_id, filename, size_bytes, expires_at) VALUES ($1, $2, $3, $4, $5, $6)",
+		u.ID, tenantID, userID, u.Filename, u.SizeBytes, u.ExpiresAt,
+	)
+	if err != nil {
+		writeMediaError(w, "start upload", err)
+		return
+	}
+	writeUpload(w, http.StatusCreated, u)
+}
+
+// getUploadHandler reports how much of an upload has arrived, so it can be resumed.
+func getUploadHandler(w http.ResponseWriter, r *http.Request) {
+	_, tenantID, err := getUserIDAndTenantIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	u, err := getUpload(r.Context(), db, tenantID, mux.Vars(r)["uploadId"], false)
+	if err != nil {
+		writeMediaError(w, "get upload", err)
+		return
+	}
+	writeUpload(w, http.StatusOK, u)
+}
+
+// uploadChunkHandler appends the chunk in the body to an upload. A chunk that
+// does not start where the upload left off is refused with 409 and the
+// upload's state. The last chunk completes the upload and answers with the
+// media; sending it again retries a completion that failed. The chunk is
+// received into a file of its own before the upload is locked, so a slow
+// client does not hold the lock.
+func uploadChunkHandler(w http.ResponseWriter, r *http.Request) {
+	userID, tenantID, err := getUserIDAndTenantIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	first, last, total, err := parseContentRange(r.Header.Get("Content-Range"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if last-first+1 > uploadChunkLimit {
+		http.Error(w, fmt.Sprintf("Chunks can be at most %d bytes", uploadChunkLimit), http.StatusRequestEntityTooLarge)
+		return
+	}
+	length := last - first + 1
+	r.Body = http.MaxBytesReader(w, r.Body, length)
+
+	ctx := r.Context()
+	uploadID := mux.Vars(r)["uploadId"]
+	u, err := getUpload(ctx, db, tenantID, uploadID, false)
+	if err != nil {
+		writeMediaError(w, "upload chunk", err)
+		return
+	}
+	if total != u.SizeBytes {
+		http.Error(w, fmt.Sprintf("The upload is %d bytes, not %d", u.SizeBytes, total), http.StatusBadRequest)
+		return
+	}
+	if u.ReceivedBytes < u.SizeBytes {
+		if first != u.ReceivedBytes {
+			writeUpload(w, http.StatusConflict, u)
+			return
+		}
+		chunk, err := receiveChunk(r.Body, length)
+		if err != nil {
+			http.Error(w, "Failed to receive the chunk: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer chunk.Close()
+		defer os.Remove(chunk.Name())
+
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			writeMediaError(w, "upload chunk", err)
+			return
+		}
+		defer tx.Rollback()
+		if u, err = getUpload(ctx, tx, tenantID, uploadID, true); err != nil {
+			writeMediaError(w, "upload chunk", err)
+			return
+		}
+		received, err := appendChunk(ctx, tx, u, first, length, chunk)
+		if errors.Is(err, errUploadOffset) {
+			writeUpload(w, http.StatusConflict, u)
+			return
+		}
+		if err == nil {
+			err = tx.Commit()
+		}
+		if err != nil {
+			writeMediaError(w, "upload chunk", err)
+			return
+		}
+		u.ReceivedBytes = received
+		if received < u.SizeBytes {
+			writeUpload(w, http.StatusOK, u)
+			return
+		}
+	}
+	// Otherwise every byte had arrived but ingesting the file failed; retry it.
+
+	m, created, err := ingestMedia(ctx, tenantID, userID, u.Filename, uploadPath(u.ID))
+	if err == nil || errors.Is(err, errUnsupportedMedia) {
+		if err := removeUpload(ctx, u.ID); err != nil {
+			log.Printf("Failed to clean up upload %s: %v", u.ID, err)
+		}
+	}
+	if err != nil {
+		writeMediaError(w, "upload media", err)
+		return
+	}
+	writeMedia(w, m, created)
+}
+
+// deleteUploadHandler abandons an upload.
+func deleteUploadHandler(w http.ResponseWriter, r *http.Request) {
+	_, tenantID, err := getUserIDAndTenantIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	u, err := getUpload(r.Context(), db, tenantID, mux.Vars(r)["uploadId"], false)
+	if err == nil {
+		err = removeUpload(r.Context(), u.ID)
+	}
+	if err != nil {
+		writeMediaError(w, "cancel upload", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+
+	"shared/migrate"
+)
+
+// --- Schema Migrations ---
+//
+// Migrations live in migrations/ as NNNN_name.up.sql / NNNN_name.down.sql pairs
+// and are embedded into the binary; the shared migrate package applies them.
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+func newMigrator() *migrate.Migrator {
+	files, err := fs.Sub(migrationFiles, "migrations")
+	if err != nil {
+		panic(err)
+	}
+	return migrate.New("post-service", db, files)
+}
+
+// autoMigrate applies pending migrations at startup unless auto_migrate is off.
+func autoMigrate() {
+	newMigrator().Auto(cfg.AutoMigrate)
+}
+
+// runMigrateCommand implements `<service> migrate [up | down [N] | status]`.
+func runMigrateCommand(args []string) {
+	newMigrator().RunCommand(args)
+}
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS posts (
+	id TEXT PRIMARY KEY,
+	user_id TEXT NOT NULL,
+	tenant_id TEXT NOT NULL,
+	platform TEXT NOT NULL,
+	content TEXT NOT NULL,
+	media_url TEXT,
+	scheduled_at TIMESTAMP WITH TIME ZONE NOT NULL,
+	posted_at TIMESTAMP WITH TIME ZONE,
+	status TEXT NOT NULL
+);
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS posts_due_idx;
+ALTER TABLE posts DROP COLUMN IF EXISTS platform_user_id;
+ALTER TABLE posts DROP COLUMN IF EXISTS last_error;
+ALTER TABLE posts DROP COLUMN IF EXISTS external_id;
+ALTER TABLE posts DROP COLUMN IF EXISTS claimed_at;
@@ -0,0 +1,6 @@
+-- Databases created before migrations existed may already have these columns.
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS claimed_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS external_id TEXT;
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS last_error TEXT;
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS platform_user_id TEXT;
+CREATE INDEX IF NOT EXISTS posts_due_idx ON posts (scheduled_at) WHERE status = 'scheduled';
@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS post_transitions;
+DROP TABLE IF EXISTS post_approvals;
+DROP TABLE IF EXISTS tenant_settings;
+ALTER TABLE posts DROP COLUMN IF EXISTS approved_at;
+ALTER TABLE posts DROP COLUMN IF EXISTS required_approvals;
+ALTER TABLE posts DROP COLUMN IF EXISTS approval_round;
@@ -0,0 +1,35 @@
+-- Posts are now drafted and approved before the publishing worker may claim them.
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS approval_round INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS required_approvals INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS approved_at TIMESTAMP WITH TIME ZONE;
+-- Posts scheduled before the workflow existed count as approved.
+UPDATE posts SET approved_at = now() WHERE approved_at IS NULL;
+
+CREATE TABLE IF NOT EXISTS tenant_settings (
+	tenant_id TEXT PRIMARY KEY,
+	required_approvals INTEGER NOT NULL DEFAULT 0 CHECK (required_approvals BETWEEN 0 AND 10),
+	updated_by TEXT,
+	updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS post_approvals (
+	post_id TEXT NOT NULL REFERENCES posts(id),
+	round INTEGER NOT NULL,
+	user_id TEXT NOT NULL,
+	comment TEXT,
+	created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+	PRIMARY KEY (post_id, round, user_id)
+);
+
+-- post_transitions records every status change. actor_id is NULL for changes
+-- made by the publishing worker.
+CREATE TABLE IF NOT EXISTS post_transitions (
+	id BIGSERIAL PRIMARY KEY,
+	post_id TEXT NOT NULL REFERENCES posts(id),
+	from_status TEXT NOT NULL,
+	to_status TEXT NOT NULL,
+	actor_id TEXT,
+	comment TEXT,
+	created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS post_transitions_post_idx ON post_transitions (post_id, created_at);
@@ -0,0 +1,2 @@
+ALTER TABLE posts DROP COLUMN IF EXISTS updated_at;
+ALTER TABLE posts DROP COLUMN IF EXISTS version;
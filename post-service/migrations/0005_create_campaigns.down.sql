@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS posts_campaign_idx;
+ALTER TABLE posts DROP COLUMN IF EXISTS campaign_id;
+DROP TABLE IF EXISTS campaign_targets;
+DROP TABLE IF EXISTS campaigns;
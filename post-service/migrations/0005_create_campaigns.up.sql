@@ -0,0 +1,28 @@
+-- A campaign is one composed post fanned out to several connected accounts.
+CREATE TABLE IF NOT EXISTS campaigns (
+	id TEXT PRIMARY KEY,
+	tenant_id TEXT NOT NULL,
+	user_id TEXT NOT NULL,
+	name TEXT NOT NULL,
+	content TEXT NOT NULL,
+	media_url TEXT,
+	hashtags TEXT[] NOT NULL DEFAULT '{}',
+	scheduled_at TIMESTAMP WITH TIME ZONE NOT NULL,
+	created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS campaigns_tenant_idx ON campaigns (tenant_id, created_at);
+
+-- campaign_targets holds each account's overrides; NULL means "use the campaign's".
+CREATE TABLE IF NOT EXISTS campaign_targets (
+	campaign_id TEXT NOT NULL REFERENCES campaigns(id),
+	platform_user_id TEXT NOT NULL,
+	platform TEXT NOT NULL,
+	caption TEXT,
+	media_url TEXT,
+	hashtags TEXT[],
+	post_id TEXT NOT NULL UNIQUE REFERENCES posts(id),
+	PRIMARY KEY (campaign_id, platform_user_id)
+);
+
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS campaign_id TEXT REFERENCES campaigns(id);
+CREATE INDEX IF NOT EXISTS posts_campaign_idx ON posts (campaign_id) WHERE campaign_id IS NOT NULL;
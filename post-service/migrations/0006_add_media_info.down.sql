@@ -0,0 +1,3 @@
+ALTER TABLE campaign_targets DROP COLUMN IF EXISTS media_info;
+ALTER TABLE campaigns DROP COLUMN IF EXISTS media_info;
+ALTER TABLE posts DROP COLUMN IF EXISTS media_info;
@@ -0,0 +1,4 @@
+-- media_info holds the dimensions and duration of a post's media, used by content validation.
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS media_info JSONB;
+ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS media_info JSONB;
+ALTER TABLE campaign_targets ADD COLUMN IF NOT EXISTS media_info JSONB;
@@ -0,0 +1,5 @@
+ALTER TABLE campaign_targets DROP COLUMN IF EXISTS media_id;
+ALTER TABLE campaigns DROP COLUMN IF EXISTS media_id;
+ALTER TABLE posts DROP COLUMN IF EXISTS media_id;
+DROP TABLE IF EXISTS media_uploads;
+DROP TABLE IF EXISTS media;
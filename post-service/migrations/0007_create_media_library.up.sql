@@ -0,0 +1,41 @@
+-- media is each tenant's library of uploaded files. Rows are kept when media is
+-- deleted so published posts still show what they carried.
+CREATE TABLE IF NOT EXISTS media (
+	id TEXT PRIMARY KEY,
+	tenant_id TEXT NOT NULL,
+	user_id TEXT NOT NULL,
+	filename TEXT NOT NULL,
+	content_type TEXT NOT NULL,
+	kind TEXT NOT NULL,
+	size_bytes BIGINT NOT NULL,
+	width INTEGER,
+	height INTEGER,
+	duration_seconds DOUBLE PRECISION,
+	sha256 TEXT NOT NULL,
+	storage_key TEXT NOT NULL,
+	created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+	deleted_at TIMESTAMP WITH TIME ZONE
+);
+-- A file uploaded twice to the same tenant is stored once.
+CREATE UNIQUE INDEX IF NOT EXISTS media_tenant_sha256_idx ON media (tenant_id, sha256) WHERE deleted_at IS NULL;
+CREATE INDEX IF NOT EXISTS media_tenant_idx ON media (tenant_id, created_at);
+
+-- media_uploads tracks resumable uploads; the bytes received so far are staged on disk.
+CREATE TABLE IF NOT EXISTS media_uploads (
+	id TEXT PRIMARY KEY,
+	tenant_id TEXT NOT NULL,
+	user_id TEXT NOT NULL,
+	filename TEXT NOT NULL,
+	size_bytes BIGINT NOT NULL,
+	received_bytes BIGINT NOT NULL DEFAULT 0,
+	created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+	expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+-- Posts and campaigns now reference library media instead of arbitrary URLs.
+-- The media_url and media_info columns of posts, campaigns and campaign_targets
+-- remain for rows created before; a URL cannot be turned into a library file
+-- without downloading it, so they are not migrated.
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS media_id TEXT REFERENCES media(id);
+ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS media_id TEXT REFERENCES media(id);
+ALTER TABLE campaign_targets ADD COLUMN IF NOT EXISTS media_id TEXT REFERENCES media(id);
@@ -0,0 +1,22 @@
+-- media_renditions holds the platform-specific versions of library media. Each
+-- row is also the job that produces it: the rendition worker claims queued rows.
+CREATE TABLE IF NOT EXISTS media_renditions (
+	id TEXT PRIMARY KEY,
+	media_id TEXT NOT NULL REFERENCES media(id),
+	preset TEXT NOT NULL,
+	status TEXT NOT NULL DEFAULT 'queued',
+	progress DOUBLE PRECISION NOT NULL DEFAULT 0,
+	error TEXT,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	content_type TEXT,
+	size_bytes BIGINT,
+	width INTEGER,
+	height INTEGER,
+	duration_seconds DOUBLE PRECISION,
+	storage_key TEXT,
+	claimed_at TIMESTAMP WITH TIME ZONE,
+	created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+	updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+	UNIQUE (media_id, preset)
+);
+CREATE INDEX IF NOT EXISTS media_renditions_queue_idx ON media_renditions (created_at) WHERE status = 'queued';
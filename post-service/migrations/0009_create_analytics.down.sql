@@ -0,0 +1,3 @@
+ALTER TABLE posts DROP COLUMN IF EXISTS metrics_collected_at;
+DROP TABLE IF EXISTS account_metrics;
+DROP TABLE IF EXISTS post_metrics;
@@ -0,0 +1,32 @@
+-- post_metrics is a time series of the lifetime totals the platforms report
+-- for published posts: one row per post and day, replaced by later collections
+-- on the same day.
+CREATE TABLE IF NOT EXISTS post_metrics (
+	post_id TEXT NOT NULL REFERENCES posts(id),
+	day DATE NOT NULL,
+	tenant_id TEXT NOT NULL,
+	platform TEXT NOT NULL,
+	platform_user_id TEXT NOT NULL,
+	impressions BIGINT NOT NULL DEFAULT 0,
+	reach BIGINT NOT NULL DEFAULT 0,
+	likes BIGINT NOT NULL DEFAULT 0,
+	comments BIGINT NOT NULL DEFAULT 0,
+	shares BIGINT NOT NULL DEFAULT 0,
+	collected_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+	PRIMARY KEY (post_id, day)
+);
+CREATE INDEX IF NOT EXISTS post_metrics_tenant_day_idx ON post_metrics (tenant_id, day);
+
+-- account_metrics is the same for connected accounts.
+CREATE TABLE IF NOT EXISTS account_metrics (
+	tenant_id TEXT NOT NULL,
+	platform_user_id TEXT NOT NULL,
+	day DATE NOT NULL,
+	platform TEXT NOT NULL,
+	followers BIGINT NOT NULL DEFAULT 0,
+	collected_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+	PRIMARY KEY (tenant_id, platform_user_id, day)
+);
+
+-- metrics_collected_at is when the collector last claimed a published post.
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS metrics_collected_at TIMESTAMP WITH TIME ZONE;
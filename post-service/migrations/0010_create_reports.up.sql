@@ -0,0 +1,20 @@
+-- reports are analytics reports generated for a tenant. Each row is also the
+-- job that renders it: the report worker claims queued rows.
+CREATE TABLE IF NOT EXISTS reports (
+	id TEXT PRIMARY KEY,
+	tenant_id TEXT NOT NULL,
+	user_id TEXT NOT NULL,
+	format TEXT NOT NULL,
+	from_day DATE NOT NULL,
+	to_day DATE NOT NULL,
+	status TEXT NOT NULL DEFAULT 'queued',
+	error TEXT,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	storage_key TEXT,
+	size_bytes BIGINT,
+	claimed_at TIMESTAMP WITH TIME ZONE,
+	created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+	updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS reports_tenant_idx ON reports (tenant_id, created_at);
+CREATE INDEX IF NOT EXISTS reports_queue_idx ON reports (created_at) WHERE status = 'queued';
@@ -0,0 +1,43 @@
+-- report_subscriptions render a report on a cron schedule and deliver it.
+CREATE TABLE IF NOT EXISTS report_subscriptions (
+	id TEXT PRIMARY KEY,
+	tenant_id TEXT NOT NULL,
+	user_id TEXT NOT NULL,
+	name TEXT NOT NULL,
+	schedule TEXT NOT NULL,
+	timezone TEXT NOT NULL,
+	format TEXT NOT NULL,
+	range_days INTEGER NOT NULL,
+	method TEXT NOT NULL,
+	recipients TEXT[] NOT NULL DEFAULT '{}',
+	webhook_url TEXT,
+	webhook_secret TEXT,
+	enabled BOOLEAN NOT NULL DEFAULT true,
+	next_run_at TIMESTAMP WITH TIME ZONE NOT NULL,
+	last_run_at TIMESTAMP WITH TIME ZONE,
+	created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+	updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+	deleted_at TIMESTAMP WITH TIME ZONE
+);
+CREATE INDEX IF NOT EXISTS report_subscriptions_due_idx ON report_subscriptions (next_run_at) WHERE enabled AND deleted_at IS NULL;
+
+-- report_deliveries is the delivery history: one row per scheduled run, which
+-- is also the job that delivers the run's report once it is rendered. The
+-- history outlives the reports, which expire.
+CREATE TABLE IF NOT EXISTS report_deliveries (
+	id TEXT PRIMARY KEY,
+	subscription_id TEXT NOT NULL REFERENCES report_subscriptions(id),
+	report_id TEXT REFERENCES reports(id) ON DELETE SET NULL,
+	from_day DATE NOT NULL,
+	to_day DATE NOT NULL,
+	status TEXT NOT NULL DEFAULT 'pending',
+	attempts INTEGER NOT NULL DEFAULT 0,
+	last_error TEXT,
+	next_attempt_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+	claimed_at TIMESTAMP WITH TIME ZONE,
+	delivered_at TIMESTAMP WITH TIME ZONE,
+	created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+	updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS report_deliveries_subscription_idx ON report_deliveries (subscription_id, created_at);
+CREATE INDEX IF NOT EXISTS report_deliveries_pending_idx ON report_deliveries (next_attempt_at) WHERE status = 'pending';
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS inbox_messages;
+DROP TABLE IF EXISTS conversations;
+DROP TABLE IF EXISTS inbox_accounts;
@@ -0,0 +1,52 @@
+-- inbox_accounts are the connected accounts the inbox syncs, with how far
+-- each sync got. Platform webhooks find the tenants of an account here.
+CREATE TABLE IF NOT EXISTS inbox_accounts (
+	tenant_id TEXT NOT NULL,
+	platform TEXT NOT NULL,
+	platform_user_id TEXT NOT NULL,
+	synced_until TIMESTAMP WITH TIME ZONE,
+	claimed_at TIMESTAMP WITH TIME ZONE,
+	last_error TEXT,
+	created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+	updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+	PRIMARY KEY (tenant_id, platform, platform_user_id)
+);
+CREATE INDEX IF NOT EXISTS inbox_accounts_platform_user_idx ON inbox_accounts (platform, platform_user_id);
+
+-- conversations group inbox messages: the comments on one post, one post
+-- mentioning an account, or the direct messages with one person.
+CREATE TABLE IF NOT EXISTS conversations (
+	id TEXT PRIMARY KEY,
+	tenant_id TEXT NOT NULL,
+	platform TEXT NOT NULL,
+	platform_user_id TEXT NOT NULL,
+	kind TEXT NOT NULL,
+	external_id TEXT NOT NULL,
+	post_id TEXT REFERENCES posts(id) ON DELETE SET NULL,
+	participant_id TEXT NOT NULL DEFAULT '',
+	participant_name TEXT NOT NULL DEFAULT '',
+	preview TEXT NOT NULL DEFAULT '',
+	last_message_at TIMESTAMP WITH TIME ZONE NOT NULL,
+	unread_count INTEGER NOT NULL DEFAULT 0,
+	created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+	updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+	UNIQUE (tenant_id, platform, platform_user_id, kind, external_id)
+);
+CREATE INDEX IF NOT EXISTS conversations_tenant_idx ON conversations (tenant_id, last_message_at DESC, id DESC);
+
+-- inbox_messages are the comments, mentions and direct messages themselves.
+-- Messages the account sent itself are stored read.
+CREATE TABLE IF NOT EXISTS inbox_messages (
+	id TEXT PRIMARY KEY,
+	conversation_id TEXT NOT NULL REFERENCES conversations(id) ON DELETE CASCADE,
+	external_id TEXT NOT NULL,
+	author_id TEXT NOT NULL DEFAULT '',
+	author_name TEXT NOT NULL DEFAULT '',
+	body TEXT NOT NULL,
+	outbound BOOLEAN NOT NULL DEFAULT false,
+	sent_at TIMESTAMP WITH TIME ZONE NOT NULL,
+	read_at TIMESTAMP WITH TIME ZONE,
+	created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+	UNIQUE (conversation_id, external_id)
+);
+CREATE INDEX IF NOT EXISTS inbox_messages_conversation_idx ON inbox_messages (conversation_id, sent_at DESC, id DESC);
@@ -0,0 +1,2 @@
+ALTER TABLE posts DROP COLUMN IF EXISTS submitted_by;
+ALTER TABLE posts DROP COLUMN IF EXISTS last_edited_by;
@@ -0,0 +1,4 @@
+-- Members who changed a post's wording or sent it for review may not approve
+-- it, just like its author.
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS last_edited_by TEXT;
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS submitted_by TEXT;
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// --- Post Editing ---
+//
+// Posts can be read, edited, cancelled and deleted one at a time. Writes use
+// optimistic concurrency: responses carry the post's version as its ETag, and
+// PUT, PATCH and DELETE must send it back in If-Match. A write without If-Match
+// is refused with 428 and one with a stale ETag with 412, so two editors never
+// silently overwrite each other. Once the publishing worker has claimed a post
+// it can no longer be changed.
+//
+// Changing what a submitted post says or where it goes sends it back to draft,
+// because its approval no longer covers it. Moving only its scheduled time
+// keeps its status.
+
+var (
+	errPostChanged = errors.New("post was changed concurrently")
+	errPostLocked  = errors.New("post is being published or was published")
+)
+
+// postChanges is the body of PUT and PATCH. PATCH leaves nil fields unchanged.
+type postChanges struct {
+	Platform       *string `json:"platform"`
+	PlatformUserID *string `json:"platformUserId"`
+	Content        *string `json:"content"`
+	// MediaID names library media; "" removes the post's media.
+	MediaID     *string    `json:"mediaId"`
+	ScheduledAt *time.Time `json:"scheduledAt"`
+	// Status can only be set to "cancelled", with PATCH and on its own.
+	Status *string `json:"status"`
+}
+
+// apply copies the changes into post and reports whether anything other than
+// the scheduled time changed.
+func (c postChanges) apply(post *Post) bool {
+	changed := false
+	for _, f := range []struct {
+		dst *string
+		src *string
+	}{
+		{&post.Platform, c.Platform},
+		{&post.PlatformUserID, c.PlatformUserID},
+		{&post.Content, c.Content},
+	} {
+		if f.src != nil && *f.src != *f.dst {
+			*f.dst = *f.src
+			changed = true
+		}
+	}
+	// Setting the media also replaces the media URL of an older post.
+	if c.MediaID != nil && (*c.MediaID != post.MediaID || post.MediaURL != "") {
+		post.MediaID, post.MediaURL, post.Media = *c.MediaID, "", nil
+		changed = true
+	}
+	if c.ScheduledAt != nil {
+		post.ScheduledAt = *c.ScheduledAt
+	}
+	return changed
+}
+
+func postETag(post Post) string {
+	return fmt.Sprintf(`"%d"`, post.Version)
+}
+
+// etagMatches reports whether an If-Match header value names the post's current version.
+func etagMatches(ifMatch string, post Post) bool {
+	etag := postETag(post)
+	for _, candidate := range strings.Split(ifMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// editRequest is postRequest for writes, which must carry If-Match and may not
+// touch a post the publishing worker has claimed.
+func editRequest(w http.ResponseWriter, r *http.Request, action string) (*sql.Tx, Post, string) {
+	if r.Header.Get("If-Match") == "" {
+		http.Error(w, "If-Match header required; send the post's ETag", http.StatusPreconditionRequired)
+		return nil, Post{}, ""
+	}
+	tx, post, userID := postRequest(w, r, action)
+	if tx != nil && (post.Status == statusPublishing || post.Status == statusPublished) {
+		tx.Rollback()
+		writePostError(w, action, errPostLocked)
+		return nil, Post{}, ""
+	}
+	return tx, post, userID
+}
+
+func decodePostChanges(w http.ResponseWriter, r *http.Request) (postChanges, bool) {
+	var c postChanges
+	r.Body = http.MaxBytesReader(w, r.Body, 1<<20)
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return postChanges{}, false
+	}
+	return c, true
+}
+
+// editPost applies changes to the post named in the URL.
+func editPost(w http.ResponseWriter, r *http.Request, c postChanges) {
+	tx, post, userID := editRequest(w, r, "edit post")
+	if tx == nil {
+		return
+	}
+	defer tx.Rollback()
+	ctx := r.Context()
+	changed := c.apply(&post)
+	if post.Media == nil {
+		if err := attachMedia(ctx, &post); err != nil {
+			writePostError(w, "edit post", err)
+			return
+		}
+	}
+	if v := validatePost(post); !v.Valid {
+		writeValidationError(w, v)
+		return
+	}
+	var err error
+	if changed && post.Status != statusDraft && post.Status != statusRejected {
+		err = transitionPost(ctx, tx, &post, statusDraft, userID, "Edited after submission")
+	}
+	if err == nil {
+		err = tx.QueryRowContext(ctx, `
+			UPDATE posts SET platform = $2, platform_user_id = $3, content = $4, media_id = $5, scheduled_at = $6,
+				media_url = CASE WHEN $7 THEN media_url END, media_info = CASE WHEN $7 THEN media_info END,
+				last_edited_by = CASE WHEN $8 THEN $9 ELSE last_edited_by END, version = version + 1, updated_at = now()
+			WHERE id = $1
+			RETURNING version, updated_at`,
+			post.ID, post.Platform, post.PlatformUserID, post.Content, sql.NullString{String: post.MediaID, Valid: post.MediaID != ""}, post.ScheduledAt, post.MediaURL != "", changed, userID,
+		).Scan(&post.Version, &post.UpdatedAt)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		writePostError(w, "edit post", err)
+		return
+	}
+	writePost(w, post)
+}
+
+// --- Handlers ---
+
+func getPostHandler(w http.ResponseWriter, r *http.Request) {
+	_, tenantID, err := getUserIDAndTenantIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	row := db.QueryRowContext(r.Context(), "SELECT "+postColumns+" FROM posts p WHERE p.id = $1 AND p.tenant_id = $2 AND p.status <> $3",
+		mux.Vars(r)["postId"], tenantID, statusDeleted)
+	post, err := scanPost(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = errPostNotFound
+	}
+	if err != nil {
+		writePostError(w, "get post", err)
+		return
+	}
+	writePost(w, post)
+}
+
+// replacePostHandler replaces every editable field of a post.
+func replacePostHandler(w http.ResponseWriter, r *http.Request) {
+	c, ok := decodePostChanges(w, r)
+	if !ok {
+		return
+	}
+	if c.Status != nil {
+		http.Error(w, "Use PATCH to change a post's status", http.StatusBadRequest)
+		return
+	}
+	if c.Platform == nil || c.PlatformUserID == nil || c.Content == nil || c.ScheduledAt == nil {
+		http.Error(w, "platform, platformUserId, content and scheduledAt are required", http.StatusBadRequest)
+		return
+	}
+	if c.MediaID == nil {
+		c.MediaID = new(string)
+	}
+	editPost(w, r, c)
+}
+
+// patchPostHandler changes some fields of a post, or cancels it.
+func patchPostHandler(w http.ResponseWriter, r *http.Request) {
+	c, ok := decodePostChanges(w, r)
+	if !ok {
+		return
+	}
+	if c.Status == nil {
+		editPost(w, r, c)
+		return
+	}
+	otherChanges := c.Platform != nil || c.PlatformUserID != nil || c.Content != nil || c.MediaID != nil || c.ScheduledAt != nil
+	if *c.Status != statusCancelled || otherChanges {
+		http.Error(w, `status can only be set to "cancelled", without other changes`, http.StatusBadRequest)
+		return
+	}
+	tx, post, userID := editRequest(w, r, "cancel post")
+	if tx == nil {
+		return
+	}
+	defer tx.Rollback()
+	err := transitionPost(r.Context(), tx, &post, statusCancelled, userID, "")
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		writePostError(w, "cancel post", err)
+		return
+	}
+	writePost(w, post)
+}
+
+// deletePostHandler hides a post. Its row and history are kept.
+func deletePostHandler(w http.ResponseWriter, r *http.Request) {
+	tx, post, userID := editRequest(w, r, "delete post")
+	if tx == nil {
+		return
+	}
+	defer tx.Rollback()
+	err := transitionPost(r.Context(), tx, &post, statusDeleted, userID, "")
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		writePostError(w, "delete post", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
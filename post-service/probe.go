@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// --- Media Inspection ---
+//
+// Files are inspected when they enter the media library. The content type is
+// sniffed from the bytes rather than taken from the client, and images and
+// MP4/QuickTime videos are measured so content validation can check aspect
+// ratios and durations. WebM videos are accepted but not measured; validation
+// then only warns about the rules it could not check.
+
+// mediaTypes lists the accepted content types with their kind and file extension.
+var mediaTypes = map[string]struct{ kind, ext string }{
+	"image/jpeg":      {mediaImage, ".jpg"},
+	"image/png":       {mediaImage, ".png"},
+	"image/gif":       {mediaImage, ".gif"},
+	"image/webp":      {mediaImage, ".webp"},
+	"video/mp4":       {mediaVideo, ".mp4"},
+	"video/quicktime": {mediaVideo, ".mov"},
+	"video/webm":      {mediaVideo, ".webm"},
+}
+
+// sniffContentType identifies a file from its first bytes. ISO media files are
+// told apart by their brand, which http.DetectContentType does not look at.
+func sniffContentType(head []byte) string {
+	if len(head) >= 12 && string(head[4:8]) == "ftyp" {
+		switch string(head[8:12]) {
+		case "qt  ":
+			return "video/quicktime"
+		case "heic", "heix", "mif1", "msf1":
+			return "image/heic"
+		case "avif", "avis":
+			return "image/avif"
+		default:
+			return "video/mp4"
+		}
+	}
+	contentType, _, _ := strings.Cut(http.DetectContentType(head), ";")
+	return contentType
+}
+
+// probeMedia measures the media in r, which holds size bytes of contentType.
+func probeMedia(r io.ReadSeeker, size int64, contentType string) (MediaInfo, error) {
+	info := MediaInfo{Kind: mediaTypes[contentType].kind}
+	var err error
+	switch contentType {
+	case "image/jpeg", "image/png", "image/gif":
+		var config image.Config
+		if config, _, err = image.DecodeConfig(r); err == nil {
+			info.Width, info.Height = config.Width, config.Height
+		}
+	case "image/webp":
+		info.Width, info.Height, err = probeWebP(r)
+	case "video/mp4", "video/quicktime":
+		err = probeISOMedia(r, size, &info)
+	}
+	if err != nil {
+		return MediaInfo{}, fmt.Errorf("failed to read %s: %w", contentType, err)
+	}
+	return info, nil
+}
+
+// probeWebP reads the canvas size from the first chunk of a WebP file.
+func probeWebP(r io.Reader) (int, int, error) {
+	head := make([]byte, 30)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return 0, 0, err
+	}
+	data := head[20:]
+	switch string(head[12:16]) {
+	case "VP8 ":
+		if data[3] != 0x9d || data[4] != 0x01 || data[5] != 0x2a {
+			return 0, 0, errors.New("bad VP8 start code")
+		}
+		return int(binary.LittleEndian.Uint16(data[6:]) & 0x3fff), int(binary.LittleEndian.Uint16(data[8:]) & 0x3fff), nil
+	case "VP8L":
+		if data[0] != 0x2f {
+			return 0, 0, errors.New("bad VP8L signature")
+		}
+		bits := binary.LittleEndian.Uint32(data[1:])
+		return int(bits&0x3fff) + 1, int(bits>>14&0x3fff) + 1, nil
+	case "VP8X":
+		width := int(data[4]) | int(data[5])<<8 | int(data[6])<<16
+		height := int(data[7]) | int(data[8])<<8 | int(data[9])<<16
+		return width + 1, height + 1, nil
+	}
+	return 0, 0, errors.New("unknown WebP chunk")
+}
+
+// isoBox is a box of an ISO base media file: its type and where its payload lies.
+type isoBox struct {
+	typ         string
+	start, size int64
+}
+
+// walkBoxes calls fn for each box between start and end.
+func walkBoxes(r io.ReadSeeker, start, end int64, fn func(isoBox) error) error {
+	header := make([]byte, 16)
+	for offset := start; offset+8 <= end; {
+		if _, err := r.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(r, header[:8]); err != nil {
+			return err
+		}
+		size, headerSize := int64(binary.BigEndian.Uint32(header)), int64(8)
+		switch size {
+		case 0:
+			size = end - offset
+		case 1:
+			if _, err := io.ReadFull(r, header[8:]); err != nil {
+				return err
+			}
+			size, headerSize = int64(binary.BigEndian.Uint64(header[8:])), 16
+		}
+		if size < headerSize || offset+size > end {
+			return fmt.Errorf("malformed %q box", header[4:8])
+		}
+		if err := fn(isoBox{typ: string(header[4:8]), start: offset + headerSize, size: size - headerSize}); err != nil {
+			return err
+		}
+		offset += size
+	}
+	return nil
+}
+
+// readBox reads up to n bytes of a box's payload.
+func readBox(r io.ReadSeeker, box isoBox, n int64) ([]byte, error) {
+	if box.size < n {
+		n = box.size
+	}
+	if _, err := r.Seek(box.start, io.SeekStart); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, n)
+	_, err := io.ReadFull(r, buf)
+	return buf, err
+}
+
+// probeISOMedia reads the duration from the movie header and the dimensions
+// from the first visual track's header, turned as the track's matrix says.
+func probeISOMedia(r io.ReadSeeker, size int64, info *MediaInfo) error {
+	foundMovie := false
+	err := walkBoxes(r, 0, size, func(moov isoBox) error {
+		if moov.typ != "moov" {
+			return nil
+		}
+		foundMovie = true
+		return walkBoxes(r, moov.start, moov.start+moov.size, func(box isoBox) error {
+			switch box.typ {
+			case "mvhd":
+				b, err := readBox(r, box, 32)
+				if err != nil {
+					return err
+				}
+				var timescale, duration uint64
+				if len(b) >= 32 && b[0] == 1 {
+					timescale, duration = uint64(binary.BigEndian.Uint32(b[20:])), binary.BigEndian.Uint64(b[24:])
+				} else if len(b) >= 20 {
+					timescale, duration = uint64(binary.BigEndian.Uint32(b[12:])), uint64(binary.BigEndian.Uint32(b[16:]))
+				}
+				if timescale > 0 {
+					info.DurationSeconds = float64(duration) / float64(timescale)
+				}
+			case "trak":
+				if info.Width > 0 {
+					return nil
+				}
+				return walkBoxes(r, box.start, box.start+box.size, func(tkhd isoBox) error {
+					if tkhd.typ != "tkhd" {
+						return nil
+					}
+					b, err := readBox(r, tkhd, 96)
+					if err != nil || len(b) == 0 {
+						return err
+					}
+					// The matrix and the 16.16 fixed-point size end the box;
+					// version 1 headers are 12 bytes longer.
+					offset := 40
+					if b[0] == 1 {
+						offset = 52
+					}
+					if len(b) < offset+44 {
+						return nil
+					}
+					matrix, dims := b[offset:offset+36], b[offset+36:]
+					width, height := int(binary.BigEndian.Uint32(dims)>>16), int(binary.BigEndian.Uint32(dims[4:])>>16)
+					if binary.BigEndian.Uint32(matrix) == 0 && binary.BigEndian.Uint32(matrix[16:]) == 0 {
+						// Rotated by 90 or 270 degrees.
+						width, height = height, width
+					}
+					info.Width, info.Height = width, height
+					return nil
+				})
+			}
+			return nil
+		})
+	})
+	if err == nil && !foundMovie {
+		err = errors.New("no movie header")
+	}
+	return err
+}
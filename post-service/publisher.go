@@ -0,0 +1,334 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// --- Publishers ---
+
+// Default API endpoints for the platform adapters. Each can be overridden in the
+// configuration so tests can point the adapters at an httptest server.
+const (
+	defaultMetaGraphBaseURL = "https://graph.facebook.com/v19.0"
+	defaultTikTokBaseURL    = "https://open.tiktokapis.com"
+	defaultSnapchatBaseURL  = "https://businessapi.snapchat.com"
+)
+
+// Publish states reported by Publisher.Status.
+const (
+	publishStateProcessing = "processing"
+	publishStatePublished  = "published"
+	publishStateFailed     = "failed"
+	publishStateDeleted    = "deleted"
+)
+
+// errPublisherUnsupported is returned when a platform has no API for an operation.
+var errPublisherUnsupported = errors.New("operation not supported by platform")
+
+// PlatformAccount identifies the connected account a publisher acts on behalf of.
+type PlatformAccount struct {
+	PlatformUserID string
+	AccessToken    string
+}
+
+// PublishStatus is the platform's view of a previously published post.
+type PublishStatus struct {
+	State  string `json:"state"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// Publisher sends posts to one social platform.
+type Publisher interface {
+	// Publish creates the post on the platform and returns the platform's ID for it.
+	Publish(ctx context.Context, account PlatformAccount, post Post) (string, error)
+	// Delete removes a published post from the platform.
+	Delete(ctx context.Context, account PlatformAccount, externalID string) error
+	// Status fetches the platform's current state for a published post.
+	Status(ctx context.Context, account PlatformAccount, externalID string) (PublishStatus, error)
+}
+
+// PublisherRegistry maps platform names to their Publisher. Lookups are
+// case-insensitive so "TikTok" and "tiktok" resolve to the same adapter.
+type PublisherRegistry struct {
+	mu         sync.RWMutex
+	publishers map[string]Publisher
+}
+
+func newPublisherRegistry() *PublisherRegistry {
+	return &PublisherRegistry{publishers: make(map[string]Publisher)}
+}
+
+// Register installs p as the publisher for platform, replacing any previous one.
+func (r *PublisherRegistry) Register(platform string, p Publisher) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.publishers[strings.ToLower(platform)] = p
+}
+
+// Get returns the publisher registered for platform.
+func (r *PublisherRegistry) Get(platform string) (Publisher, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	p, ok := r.publishers[strings.ToLower(platform)]
+	return p, ok
+}
+
+// newPublisherRegistryFromConfig registers the real platform adapters, or a fake
+// for every platform when publisher_mode is "fake" (useful for local development).
+func newPublisherRegistryFromConfig() *PublisherRegistry {
+	registry := newPublisherRegistry()
+	if cfg.PublisherMode == "fake" {
+		log.Println("publisher_mode is fake: posts will be recorded, not sent to any platform.")
+		fake := newFakePublisher()
+		for _, platform := range []string{"Meta", "TikTok", "Snapchat"} {
+			registry.Register(platform, fake)
+		}
+		return registry
+	}
+	client := &http.Client{Timeout: 60 * time.Second}
+	meta := newMetaPublisher(cfg.Meta.APIBaseURL, client)
+	meta.appSecret, meta.verifyToken = cfg.Inbox.MetaAppSecret, cfg.Inbox.MetaVerifyToken
+	registry.Register("Meta", meta)
+	registry.Register("TikTok", newTikTokPublisher(cfg.TikTok.APIBaseURL, client))
+	registry.Register("Snapchat", newSnapchatPublisher(cfg.Snapchat.APIBaseURL, client))
+	return registry
+}
+
+// --- Platform HTTP helpers ---
+
+// platformError is a non-2xx response from a platform API.
+type platformError struct {
+	Platform   string
+	StatusCode int
+	Body       string
+}
+
+func (e *platformError) Error() string {
+	return fmt.Sprintf("%s API returned status %d: %s", e.Platform, e.StatusCode, e.Body)
+}
+
+// doJSON sends a request with an optional JSON body and decodes a JSON response into out.
+func doJSON(ctx context.Context, client *http.Client, platform, method, url string, header http.Header, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to encode %s request: %w", platform, err)
+		}
+		reader = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, reader)
+	if err != nil {
+		return fmt.Errorf("failed to create %s request: %w", platform, err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return doRequest(client, platform, req, out)
+}
+
+// doRequest executes req and decodes a JSON response into out.
+func doRequest(client *http.Client, platform string, req *http.Request, out interface{}) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call %s API: %w", platform, err)
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("failed to read %s response: %w", platform, err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return &platformError{Platform: platform, StatusCode: resp.StatusCode, Body: string(respBody)}
+	}
+	if out == nil || len(respBody) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(respBody, out); err != nil {
+		return fmt.Errorf("failed to parse %s response: %w", platform, err)
+	}
+	return nil
+}
+
+// isVideoURL guesses from the file extension whether a media URL points at a video.
+func isVideoURL(mediaURL string) bool {
+	path := strings.ToLower(strings.SplitN(mediaURL, "?", 2)[0])
+	for _, ext := range []string{".mp4", ".mov", ".m4v", ".webm"} {
+		if strings.HasSuffix(path, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// --- Fake Publisher ---
+
+// FakeCall is one call recorded by FakePublisher.
+type FakeCall struct {
+	Method     string
+	Account    PlatformAccount
+	Post       Post
+	ExternalID string
+}
+
+// FakePublisher is an in-memory Publisher that records every call. Set Err to
+// make subsequent calls fail. Its metrics grow steadily from the time a post
+// was published, at a rate derived from the post's ID, and its inbox fills
+// with made-up comments and messages.
+type FakePublisher struct {
+	mu          sync.Mutex
+	calls       []FakeCall
+	status      map[string]PublishStatus
+	publishedAt map[string]time.Time
+	nextID      int
+	Err         error
+}
+
+func newFakePublisher() *FakePublisher {
+	return &FakePublisher{status: make(map[string]PublishStatus), publishedAt: make(map[string]time.Time)}
+}
+
+func (f *FakePublisher) Publish(ctx context.Context, account PlatformAccount, post Post) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, FakeCall{Method: "Publish", Account: account, Post: post})
+	if f.Err != nil {
+		return "", f.Err
+	}
+	f.nextID++
+	externalID := fmt.Sprintf("fake-%d", f.nextID)
+	f.calls[len(f.calls)-1].ExternalID = externalID
+	f.status[externalID] = PublishStatus{State: publishStatePublished}
+	f.publishedAt[externalID] = time.Now()
+	return externalID, nil
+}
+
+func (f *FakePublisher) Delete(ctx context.Context, account PlatformAccount, externalID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, FakeCall{Method: "Delete", Account: account, ExternalID: externalID})
+	if f.Err != nil {
+		return f.Err
+	}
+	if _, ok := f.status[externalID]; !ok {
+		return fmt.Errorf("fake post %s not found", externalID)
+	}
+	f.status[externalID] = PublishStatus{State: publishStateDeleted}
+	return nil
+}
+
+func (f *FakePublisher) Status(ctx context.Context, account PlatformAccount, externalID string) (PublishStatus, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, FakeCall{Method: "Status", Account: account, ExternalID: externalID})
+	if f.Err != nil {
+		return PublishStatus{}, f.Err
+	}
+	status, ok := f.status[externalID]
+	if !ok {
+		return PublishStatus{}, fmt.Errorf("fake post %s not found", externalID)
+	}
+	return status, nil
+}
+
+func (f *FakePublisher) PostMetrics(ctx context.Context, account PlatformAccount, externalID string) (PostMetrics, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, FakeCall{Method: "PostMetrics", Account: account, ExternalID: externalID})
+	if f.Err != nil {
+		return PostMetrics{}, f.Err
+	}
+	publishedAt, ok := f.publishedAt[externalID]
+	if !ok || f.status[externalID].State != publishStatePublished {
+		return PostMetrics{}, fmt.Errorf("fake post %s not found", externalID)
+	}
+	seed := fakeSeed(externalID)
+	impressions := int64(time.Since(publishedAt).Hours() * float64(50+seed%200))
+	likes := impressions / int64(15+seed%10)
+	return PostMetrics{Impressions: impressions, Reach: impressions * 6 / 10, Likes: likes, Comments: likes / 8, Shares: likes / 12}, nil
+}
+
+func (f *FakePublisher) AccountMetrics(ctx context.Context, account PlatformAccount) (AccountMetrics, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, FakeCall{Method: "AccountMetrics", Account: account})
+	if f.Err != nil {
+		return AccountMetrics{}, f.Err
+	}
+	return AccountMetrics{Followers: int64(1000+fakeSeed(account.PlatformUserID)%5000) + int64(25*len(f.publishedAt))}, nil
+}
+
+// fakeFans are the people who comment on and message fake accounts.
+var fakeFans = []string{"Alex Rivera", "Sam Chen", "Jordan Lee", "Taylor Brooks", "Morgan Patel"}
+
+// fakeReplies are what they write.
+var fakeReplies = []string{"Love this! 😍", "Where can I buy this?", "So good 🔥", "Is this available in other colours?", "Great post, thanks for sharing.", "Can you DM me the details?"}
+
+// Inbox makes up a comment on every post the account published every half
+// hour after publishing, up to ten per post, and a direct message from one of
+// the fans every three hours.
+func (f *FakePublisher) Inbox(ctx context.Context, account PlatformAccount, since time.Time) ([]InboxItem, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, FakeCall{Method: "Inbox", Account: account})
+	if f.Err != nil {
+		return nil, f.Err
+	}
+	now := time.Now()
+	var items []InboxItem
+	for _, call := range f.calls {
+		if call.Method != "Publish" || call.ExternalID == "" || call.Account.PlatformUserID != account.PlatformUserID {
+			continue
+		}
+		seed := fakeSeed(call.ExternalID)
+		for i := 1; i <= 10; i++ {
+			sent := f.publishedAt[call.ExternalID].Add(time.Duration(i) * 30 * time.Minute)
+			if sent.After(now) {
+				break
+			}
+			if !sent.After(since) {
+				continue
+			}
+			fan := fakeFans[(int(seed)+i)%len(fakeFans)]
+			items = append(items, InboxItem{Kind: inboxComment, ThreadID: call.ExternalID, ExternalID: fmt.Sprintf("%s-comment-%d", call.ExternalID, i),
+				AuthorID: "fan-" + strconv.Itoa((int(seed)+i)%len(fakeFans)), AuthorName: fan, Text: fakeReplies[(int(seed)+i)%len(fakeReplies)], SentAt: sent})
+		}
+	}
+	for sent := now.Truncate(3 * time.Hour); sent.After(since); sent = sent.Add(-3 * time.Hour) {
+		n := int(sent.Unix() / int64(3*time.Hour/time.Second))
+		fan := n % len(fakeFans)
+		items = append(items, InboxItem{Kind: inboxMessage, ThreadID: "fan-" + strconv.Itoa(fan), ParticipantID: "fan-" + strconv.Itoa(fan), ParticipantName: fakeFans[fan],
+			ExternalID: fmt.Sprintf("%s-dm-%d", account.PlatformUserID, n), AuthorID: "fan-" + strconv.Itoa(fan), AuthorName: fakeFans[fan],
+			Text: fakeReplies[n%len(fakeReplies)], SentAt: sent})
+	}
+	return items, nil
+}
+
+// fakeSeed derives a stable number from id.
+func fakeSeed(id string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	return h.Sum32()
+}
+
+// Calls returns a copy of every call recorded so far.
+func (f *FakePublisher) Calls() []FakeCall {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]FakeCall(nil), f.calls...)
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// metaPublisher publishes to a Facebook Page through the Meta Graph API. The
+// account's PlatformUserID is the Page ID and AccessToken a Page access token.
+// appSecret and verifyToken authenticate the Page webhooks of the inbox.
+type metaPublisher struct {
+	baseURL     string
+	client      *http.Client
+	appSecret   string
+	verifyToken string
+}
+
+func newMetaPublisher(baseURL string, client *http.Client) *metaPublisher {
+	return &metaPublisher{baseURL: strings.TrimRight(baseURL, "/"), client: client}
+}
+
+// header authenticates a Graph API call with the Page token. The token is never
+// put in the URL, which transport errors quote and the pollers log.
+func (m *metaPublisher) header(account PlatformAccount) http.Header {
+	h := http.Header{}
+	h.Set("Authorization", "Bearer "+account.AccessToken)
+	return h
+}
+
+func (m *metaPublisher) Publish(ctx context.Context, account PlatformAccount, post Post) (string, error) {
+	if account.PlatformUserID == "" {
+		return "", fmt.Errorf("meta: post has no target page")
+	}
+	form := url.Values{}
+	var edge string
+	switch {
+	case post.MediaURL == "":
+		edge = "feed"
+		form.Set("message", post.Content)
+	case isVideoURL(post.MediaURL):
+		edge = "videos"
+		form.Set("file_url", post.MediaURL)
+		form.Set("description", post.Content)
+	default:
+		edge = "photos"
+		form.Set("url", post.MediaURL)
+		form.Set("caption", post.Content)
+	}
+	endpoint := fmt.Sprintf("%s/%s/%s", m.baseURL, url.PathEscape(account.PlatformUserID), edge)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("failed to create Meta request: %w", err)
+	}
+	req.Header = m.header(account)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	var result struct {
+		ID     string `json:"id"`
+		PostID string `json:"post_id"`
+	}
+	if err := doRequest(m.client, "Meta", req, &result); err != nil {
+		return "", err
+	}
+	// Photo uploads return both the photo ID and the feed story ID; the story is the post.
+	if result.PostID != "" {
+		return result.PostID, nil
+	}
+	if result.ID == "" {
+		return "", fmt.Errorf("meta: response did not include a post ID")
+	}
+	return result.ID, nil
+}
+
+func (m *metaPublisher) Delete(ctx context.Context, account PlatformAccount, externalID string) error {
+	endpoint := fmt.Sprintf("%s/%s", m.baseURL, url.PathEscape(externalID))
+	var result struct {
+		Success bool `json:"success"`
+	}
+	if err := doJSON(ctx, m.client, "Meta", http.MethodDelete, endpoint, m.header(account), nil, &result); err != nil {
+		return err
+	}
+	if !result.Success {
+		return fmt.Errorf("meta: delete of %s was not acknowledged", externalID)
+	}
+	return nil
+}
+
+func (m *metaPublisher) Status(ctx context.Context, account PlatformAccount, externalID string) (PublishStatus, error) {
+	endpoint := fmt.Sprintf("%s/%s?fields=id,is_published", m.baseURL, url.PathEscape(externalID))
+	var result struct {
+		ID          string `json:"id"`
+		IsPublished *bool  `json:"is_published"`
+	}
+	if err := doJSON(ctx, m.client, "Meta", http.MethodGet, endpoint, m.header(account), nil, &result); err != nil {
+		if pe, ok := err.(*platformError); ok && pe.StatusCode == http.StatusNotFound {
+			return PublishStatus{State: publishStateDeleted}, nil
+		}
+		return PublishStatus{}, err
+	}
+	if result.IsPublished != nil && !*result.IsPublished {
+		return PublishStatus{State: publishStateProcessing}, nil
+	}
+	return PublishStatus{State: publishStatePublished}, nil
+}
+
+// PostMetrics reads a Page post's reaction, comment and share counts and its
+// lifetime impressions, total and unique.
+func (m *metaPublisher) PostMetrics(ctx context.Context, account PlatformAccount, externalID string) (PostMetrics, error) {
+	q := url.Values{}
+	q.Set("fields", "shares,reactions.summary(total_count).limit(0),comments.summary(total_count).limit(0),insights.metric(post_impressions,post_impressions_unique)")
+	endpoint := fmt.Sprintf("%s/%s?%s", m.baseURL, url.PathEscape(externalID), q.Encode())
+	type summary struct {
+		Summary struct {
+			TotalCount int64 `json:"total_count"`
+		} `json:"summary"`
+	}
+	var result struct {
+		Shares struct {
+			Count int64 `json:"count"`
+		} `json:"shares"`
+		Reactions summary `json:"reactions"`
+		Comments  summary `json:"comments"`
+		Insights  struct {
+			Data []struct {
+				Name   string `json:"name"`
+				Values []struct {
+					Value int64 `json:"value"`
+				} `json:"values"`
+			} `json:"data"`
+		} `json:"insights"`
+	}
+	if err := doJSON(ctx, m.client, "Meta", http.MethodGet, endpoint, m.header(account), nil, &result); err != nil {
+		return PostMetrics{}, err
+	}
+	metrics := PostMetrics{Likes: result.Reactions.Summary.TotalCount, Comments: result.Comments.Summary.TotalCount, Shares: result.Shares.Count}
+	for _, insight := range result.Insights.Data {
+		if len(insight.Values) == 0 {
+			continue
+		}
+		switch insight.Name {
+		case "post_impressions":
+			metrics.Impressions = insight.Values[0].Value
+		case "post_impressions_unique":
+			metrics.Reach = insight.Values[0].Value
+		}
+	}
+	return metrics, nil
+}
+
+func (m *metaPublisher) AccountMetrics(ctx context.Context, account PlatformAccount) (AccountMetrics, error) {
+	endpoint := fmt.Sprintf("%s/%s?fields=followers_count", m.baseURL, url.PathEscape(account.PlatformUserID))
+	var result struct {
+		FollowersCount int64 `json:"followers_count"`
+	}
+	if err := doJSON(ctx, m.client, "Meta", http.MethodGet, endpoint, m.header(account), nil, &result); err != nil {
+		return AccountMetrics{}, err
+	}
+	return AccountMetrics{Followers: result.FollowersCount}, nil
+}
+
+// metaTimeLayout is how the Graph API writes times.
+const metaTimeLayout = "2006-01-02T15:04:05-0700"
+
+// metaUser is the from or participant of a Graph API object.
+type metaUser struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// metaMessage is a comment, post or message as the Graph API lists it.
+type metaMessage struct {
+	ID          string   `json:"id"`
+	Message     string   `json:"message"`
+	From        metaUser `json:"from"`
+	CreatedTime string   `json:"created_time"`
+}
+
+func (m metaMessage) sentAt() time.Time {
+	t, err := time.Parse(metaTimeLayout, m.CreatedTime)
+	if err != nil {
+		return time.Now()
+	}
+	return t
+}
+
+// Inbox reads the comments on the Page's recent posts, the posts the Page is
+// tagged in and its Messenger conversations.
+func (m *metaPublisher) Inbox(ctx context.Context, account PlatformAccount, since time.Time) ([]InboxItem, error) {
+	page := account.PlatformUserID
+	get := func(edge string, q url.Values, out interface{}) error {
+		endpoint := fmt.Sprintf("%s/%s/%s?%s", m.baseURL, url.PathEscape(page), edge, q.Encode())
+		return doJSON(ctx, m.client, "Meta", http.MethodGet, endpoint, m.header(account), nil, out)
+	}
+	var items []InboxItem
+
+	var feed struct {
+		Data []struct {
+			ID       string `json:"id"`
+			Comments struct {
+				Data []metaMessage `json:"data"`
+			} `json:"comments"`
+		} `json:"data"`
+	}
+	err := get("feed", url.Values{
+		"fields": {"id,comments.order(reverse_chronological).limit(100){id,message,from,created_time}"},
+		"limit":  {"50"},
+	}, &feed)
+	if err != nil {
+		return nil, err
+	}
+	for _, post := range feed.Data {
+		for _, c := range post.Comments.Data {
+			if sent := c.sentAt(); sent.After(since) {
+				items = append(items, InboxItem{Kind: inboxComment, ThreadID: post.ID, ExternalID: c.ID, AuthorID: c.From.ID, AuthorName: c.From.Name,
+					Text: c.Message, SentAt: sent, Outbound: c.From.ID == page})
+			}
+		}
+	}
+
+	var tagged struct {
+		Data []metaMessage `json:"data"`
+	}
+	err = get("tagged", url.Values{
+		"fields": {"id,message,from,created_time"},
+		"since":  {strconv.FormatInt(since.Unix(), 10)},
+		"limit":  {"50"},
+	}, &tagged)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range tagged.Data {
+		items = append(items, InboxItem{Kind: inboxMention, ThreadID: t.ID, ParticipantID: t.From.ID, ParticipantName: t.From.Name, ExternalID: t.ID,
+			AuthorID: t.From.ID, AuthorName: t.From.Name, Text: t.Message, SentAt: t.sentAt()})
+	}
+
+	var conversations struct {
+		Data []struct {
+			UpdatedTime  string `json:"updated_time"`
+			Participants struct {
+				Data []metaUser `json:"data"`
+			} `json:"participants"`
+			Messages struct {
+				Data []metaMessage `json:"data"`
+			} `json:"messages"`
+		} `json:"data"`
+	}
+	err = get("conversations", url.Values{
+		"fields":   {"updated_time,participants,messages.limit(50){id,message,from,created_time}"},
+		"platform": {"messenger"},
+		"limit":    {"25"},
+	}, &conversations)
+	if err != nil {
+		return nil, err
+	}
+	for _, conv := range conversations.Data {
+		// Direct messages are threaded by the other party, which is also how
+		// webhooks identify them.
+		var other metaUser
+		for _, p := range conv.Participants.Data {
+			if p.ID != page {
+				other = p
+			}
+		}
+		for _, msg := range conv.Messages.Data {
+			if sent := msg.sentAt(); sent.After(since) {
+				items = append(items, InboxItem{Kind: inboxMessage, ThreadID: other.ID, ParticipantID: other.ID, ParticipantName: other.Name, ExternalID: msg.ID,
+					AuthorID: msg.From.ID, AuthorName: msg.From.Name, Text: msg.Message, SentAt: sent, Outbound: msg.From.ID == page})
+			}
+		}
+	}
+	return items, nil
+}
+
+// WebhookChallenge answers Meta's subscription check, which echoes
+// hub.challenge when hub.verify_token is the configured verify token.
+func (m *metaPublisher) WebhookChallenge(q url.Values) (string, error) {
+	if m.verifyToken == "" || q.Get("hub.mode") != "subscribe" || !hmac.Equal([]byte(q.Get("hub.verify_token")), []byte(m.verifyToken)) {
+		return "", errWebhookUnverified
+	}
+	return q.Get("hub.challenge"), nil
+}
+
+// ParseWebhook reads the comments (feed changes) and Messenger messages of a
+// Page webhook delivery, signed in X-Hub-Signature-256 with the app secret.
+func (m *metaPublisher) ParseWebhook(header http.Header, body []byte) (map[string][]InboxItem, error) {
+	if m.appSecret == "" {
+		return nil, errWebhookUnverified
+	}
+	mac := hmac.New(sha256.New, []byte(m.appSecret))
+	mac.Write(body)
+	if !hmac.Equal([]byte(header.Get("X-Hub-Signature-256")), []byte("sha256="+hex.EncodeToString(mac.Sum(nil)))) {
+		return nil, errWebhookUnverified
+	}
+	var payload struct {
+		Entry []struct {
+			ID      string `json:"id"`
+			Changes []struct {
+				Field string `json:"field"`
+				Value struct {
+					Item        string   `json:"item"`
+					Verb        string   `json:"verb"`
+					CommentID   string   `json:"comment_id"`
+					PostID      string   `json:"post_id"`
+					Message     string   `json:"message"`
+					From        metaUser `json:"from"`
+					CreatedTime int64    `json:"created_time"`
+				} `json:"value"`
+			} `json:"changes"`
+			Messaging []struct {
+				Sender    metaUser `json:"sender"`
+				Recipient metaUser `json:"recipient"`
+				Timestamp int64    `json:"timestamp"`
+				Message   *struct {
+					Mid    string `json:"mid"`
+					Text   string `json:"text"`
+					IsEcho bool   `json:"is_echo"`
+				} `json:"message"`
+			} `json:"messaging"`
+		} `json:"entry"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, fmt.Errorf("meta: invalid webhook payload: %w", err)
+	}
+	items := make(map[string][]InboxItem)
+	for _, entry := range payload.Entry {
+		page := entry.ID
+		for _, change := range entry.Changes {
+			v := change.Value
+			if change.Field != "feed" || v.Item != "comment" || (v.Verb != "add" && v.Verb != "edited") {
+				continue
+			}
+			sent := time.Now()
+			if v.CreatedTime > 0 {
+				sent = time.Unix(v.CreatedTime, 0)
+			}
+			items[page] = append(items[page], InboxItem{Kind: inboxComment, ThreadID: v.PostID, ExternalID: v.CommentID, AuthorID: v.From.ID, AuthorName: v.From.Name,
+				Text: v.Message, SentAt: sent, Outbound: v.From.ID == page})
+		}
+		for _, event := range entry.Messaging {
+			if event.Message == nil {
+				continue
+			}
+			outbound := event.Message.IsEcho || event.Sender.ID == page
+			other := event.Sender
+			if outbound {
+				other = event.Recipient
+			}
+			text := event.Message.Text
+			if text == "" {
+				text = "(attachment)"
+			}
+			items[page] = append(items[page], InboxItem{Kind: inboxMessage, ThreadID: other.ID, ParticipantID: other.ID, ExternalID: event.Message.Mid,
+				AuthorID: event.Sender.ID, Text: text, SentAt: time.UnixMilli(event.Timestamp), Outbound: outbound})
+		}
+	}
+	return items, nil
+}
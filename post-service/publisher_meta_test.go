@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newMetaTestServer serves handler and returns a metaPublisher pointed at it.
+func newMetaTestServer(t *testing.T, handler http.HandlerFunc) *metaPublisher {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	return newMetaPublisher(srv.URL, srv.Client())
+}
+
+func TestMetaPublishChoosesEdgeByMedia(t *testing.T) {
+	tests := []struct {
+		name     string
+		post     Post
+		wantPath string
+		wantForm url.Values
+		response string
+		wantID   string
+	}{
+		{
+			name:     "text",
+			post:     Post{Content: "Hello"},
+			wantPath: "/page-1/feed",
+			wantForm: url.Values{"message": {"Hello"}},
+			response: `{"id":"page-1_42"}`,
+			wantID:   "page-1_42",
+		},
+		{
+			name:     "photo",
+			post:     Post{Content: "Look", MediaURL: "https://cdn.example.com/a.jpg"},
+			wantPath: "/page-1/photos",
+			wantForm: url.Values{"url": {"https://cdn.example.com/a.jpg"}, "caption": {"Look"}},
+			response: `{"id":"photo-7","post_id":"page-1_43"}`,
+			wantID:   "page-1_43",
+		},
+		{
+			name:     "video",
+			post:     Post{Content: "Watch", MediaURL: "https://cdn.example.com/a.mp4"},
+			wantPath: "/page-1/videos",
+			wantForm: url.Values{"file_url": {"https://cdn.example.com/a.mp4"}, "description": {"Watch"}},
+			response: `{"id":"video-9"}`,
+			wantID:   "video-9",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			meta := newMetaTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodPost || r.URL.Path != tt.wantPath {
+					t.Errorf("request %s %s; want POST %s", r.Method, r.URL.Path, tt.wantPath)
+				}
+				if err := r.ParseForm(); err != nil {
+					t.Fatalf("ParseForm: %v", err)
+				}
+				if got := r.Header.Get("Authorization"); got != "Bearer page-token" {
+					t.Errorf("Authorization = %q; want the Page token", got)
+				}
+				for key, want := range tt.wantForm {
+					if got := r.PostForm.Get(key); got != want[0] {
+						t.Errorf("form %s = %q; want %q", key, got, want[0])
+					}
+				}
+				w.Write([]byte(tt.response))
+			})
+			id, err := meta.Publish(context.Background(), PlatformAccount{PlatformUserID: "page-1", AccessToken: "page-token"}, tt.post)
+			if err != nil {
+				t.Fatalf("Publish: %v", err)
+			}
+			if id != tt.wantID {
+				t.Errorf("Publish returned %q; want %q", id, tt.wantID)
+			}
+		})
+	}
+}
+
+func TestMetaPublishReportsPlatformErrors(t *testing.T) {
+	meta := newMetaTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"error":{"message":"(#200) The user hasn't authorized the application"}}`))
+	})
+	_, err := meta.Publish(context.Background(), PlatformAccount{PlatformUserID: "page-1", AccessToken: "t"}, Post{Content: "x"})
+	var pe *platformError
+	if !errors.As(err, &pe) || pe.StatusCode != http.StatusForbidden {
+		t.Fatalf("Publish error = %v; want a 403 platformError", err)
+	}
+	if _, err := meta.Publish(context.Background(), PlatformAccount{}, Post{Content: "x"}); err == nil {
+		t.Error("Publish without a Page succeeded")
+	}
+}
+
+func TestMetaStatus(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		want   string
+	}{
+		{"published", http.StatusOK, `{"id":"p","is_published":true}`, publishStatePublished},
+		{"scheduled", http.StatusOK, `{"id":"p","is_published":false}`, publishStateProcessing},
+		{"gone", http.StatusNotFound, `{"error":{"message":"not found"}}`, publishStateDeleted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			meta := newMetaTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			})
+			got, err := meta.Status(context.Background(), PlatformAccount{AccessToken: "t"}, "p")
+			if err != nil {
+				t.Fatalf("Status: %v", err)
+			}
+			if got.State != tt.want {
+				t.Errorf("Status = %q; want %q", got.State, tt.want)
+			}
+		})
+	}
+}
+
+func TestMetaKeepsTokenOutOfURLs(t *testing.T) {
+	meta := newMetaTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.RawQuery, "secret-token") {
+			t.Errorf("%s %s carries the token in its URL", r.Method, r.URL.Path)
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer secret-token" {
+			t.Errorf("%s %s: Authorization = %q", r.Method, r.URL.Path, got)
+		}
+		w.Write([]byte(`{"success":true,"data":[]}`))
+	})
+	account := PlatformAccount{PlatformUserID: "page-1", AccessToken: "secret-token"}
+	ctx := context.Background()
+	if err := meta.Delete(ctx, account, "p"); err != nil {
+		t.Errorf("Delete: %v", err)
+	}
+	if _, err := meta.Status(ctx, account, "p"); err != nil {
+		t.Errorf("Status: %v", err)
+	}
+	if _, err := meta.PostMetrics(ctx, account, "p"); err != nil {
+		t.Errorf("PostMetrics: %v", err)
+	}
+	if _, err := meta.AccountMetrics(ctx, account); err != nil {
+		t.Errorf("AccountMetrics: %v", err)
+	}
+	if _, err := meta.Inbox(ctx, account, time.Now().Add(-time.Hour)); err != nil {
+		t.Errorf("Inbox: %v", err)
+	}
+}
+
+func TestMetaParseWebhookVerifiesSignature(t *testing.T) {
+	meta := newMetaPublisher("http://unused", http.DefaultClient)
+	meta.appSecret = "app-secret"
+	body := []byte(`{"entry":[{"id":"page-1","changes":[{"field":"feed","value":{"item":"comment","verb":"add","comment_id":"c1","post_id":"page-1_42","message":"Nice","from":{"id":"fan-1","name":"Fan"},"created_time":1700000000}}],
+		"messaging":[{"sender":{"id":"fan-2"},"recipient":{"id":"page-1"},"timestamp":1700000000000,"message":{"mid":"m1","text":"Hi"}}]}]}`)
+	mac := hmac.New(sha256.New, []byte("app-secret"))
+	mac.Write(body)
+	header := http.Header{"X-Hub-Signature-256": {"sha256=" + hex.EncodeToString(mac.Sum(nil))}}
+
+	items, err := meta.ParseWebhook(header, body)
+	if err != nil {
+		t.Fatalf("ParseWebhook: %v", err)
+	}
+	got := items["page-1"]
+	if len(got) != 2 {
+		t.Fatalf("got %d items; want a comment and a message", len(got))
+	}
+	if got[0].Kind != inboxComment || got[0].ThreadID != "page-1_42" || got[0].Text != "Nice" {
+		t.Errorf("comment parsed as %+v", got[0])
+	}
+	if got[1].Kind != inboxMessage || got[1].ThreadID != "fan-2" || got[1].Outbound {
+		t.Errorf("message parsed as %+v", got[1])
+	}
+
+	header.Set("X-Hub-Signature-256", "sha256=00")
+	if _, err := meta.ParseWebhook(header, body); !errors.Is(err, errWebhookUnverified) {
+		t.Errorf("ParseWebhook with a bad signature = %v; want errWebhookUnverified", err)
+	}
+}
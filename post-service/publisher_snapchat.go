@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// snapchatPublisher posts Stories to a Snapchat Public Profile. The account's
+// PlatformUserID is the public profile ID.
+type snapchatPublisher struct {
+	baseURL string
+	client  *http.Client
+}
+
+func newSnapchatPublisher(baseURL string, client *http.Client) *snapchatPublisher {
+	return &snapchatPublisher{baseURL: strings.TrimRight(baseURL, "/"), client: client}
+}
+
+// snapchatStory is the story object returned by the Public Profile API.
+type snapchatStory struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	Reason string `json:"failure_reason"`
+}
+
+func (s *snapchatPublisher) header(account PlatformAccount) http.Header {
+	h := http.Header{}
+	h.Set("Authorization", "Bearer "+account.AccessToken)
+	return h
+}
+
+func (s *snapchatPublisher) Publish(ctx context.Context, account PlatformAccount, post Post) (string, error) {
+	if account.PlatformUserID == "" {
+		return "", fmt.Errorf("snapchat: post has no target profile")
+	}
+	if post.MediaURL == "" {
+		return "", fmt.Errorf("snapchat: stories require an image or video")
+	}
+	mediaType := "IMAGE"
+	if isVideoURL(post.MediaURL) {
+		mediaType = "VIDEO"
+	}
+	body := map[string]string{
+		"media_url":  post.MediaURL,
+		"media_type": mediaType,
+		"caption":    post.Content,
+	}
+	endpoint := fmt.Sprintf("%s/v1/public_profiles/%s/stories", s.baseURL, url.PathEscape(account.PlatformUserID))
+	var result struct {
+		Story snapchatStory `json:"story"`
+	}
+	if err := doJSON(ctx, s.client, "Snapchat", http.MethodPost, endpoint, s.header(account), body, &result); err != nil {
+		return "", err
+	}
+	if result.Story.ID == "" {
+		return "", fmt.Errorf("snapchat: response did not include a story ID")
+	}
+	return result.Story.ID, nil
+}
+
+func (s *snapchatPublisher) Delete(ctx context.Context, account PlatformAccount, externalID string) error {
+	endpoint := fmt.Sprintf("%s/v1/stories/%s", s.baseURL, url.PathEscape(externalID))
+	return doJSON(ctx, s.client, "Snapchat", http.MethodDelete, endpoint, s.header(account), nil, nil)
+}
+
+func (s *snapchatPublisher) Status(ctx context.Context, account PlatformAccount, externalID string) (PublishStatus, error) {
+	endpoint := fmt.Sprintf("%s/v1/stories/%s", s.baseURL, url.PathEscape(externalID))
+	var result struct {
+		Story snapchatStory `json:"story"`
+	}
+	if err := doJSON(ctx, s.client, "Snapchat", http.MethodGet, endpoint, s.header(account), nil, &result); err != nil {
+		if pe, ok := err.(*platformError); ok && pe.StatusCode == http.StatusNotFound {
+			return PublishStatus{State: publishStateDeleted}, nil
+		}
+		return PublishStatus{}, err
+	}
+	switch result.Story.Status {
+	case "ACTIVE", "PUBLISHED":
+		return PublishStatus{State: publishStatePublished}, nil
+	case "FAILED", "REJECTED":
+		return PublishStatus{State: publishStateFailed, Detail: result.Story.Reason}, nil
+	case "DELETED", "EXPIRED":
+		return PublishStatus{State: publishStateDeleted, Detail: result.Story.Status}, nil
+	default:
+		return PublishStatus{State: publishStateProcessing, Detail: result.Story.Status}, nil
+	}
+}
+
+// PostMetrics reads a Story's view counts, replies and shares. Snapchat has no
+// likes, so Likes stays zero.
+func (s *snapchatPublisher) PostMetrics(ctx context.Context, account PlatformAccount, externalID string) (PostMetrics, error) {
+	endpoint := fmt.Sprintf("%s/v1/stories/%s/stats", s.baseURL, url.PathEscape(externalID))
+	var result struct {
+		Stats struct {
+			Views         int64 `json:"views"`
+			UniqueViewers int64 `json:"unique_viewers"`
+			Replies       int64 `json:"replies"`
+			Shares        int64 `json:"shares"`
+		} `json:"stats"`
+	}
+	if err := doJSON(ctx, s.client, "Snapchat", http.MethodGet, endpoint, s.header(account), nil, &result); err != nil {
+		return PostMetrics{}, err
+	}
+	return PostMetrics{Impressions: result.Stats.Views, Reach: result.Stats.UniqueViewers, Comments: result.Stats.Replies, Shares: result.Stats.Shares}, nil
+}
+
+func (s *snapchatPublisher) AccountMetrics(ctx context.Context, account PlatformAccount) (AccountMetrics, error) {
+	endpoint := fmt.Sprintf("%s/v1/public_profiles/%s", s.baseURL, url.PathEscape(account.PlatformUserID))
+	var result struct {
+		PublicProfile struct {
+			SubscriberCount int64 `json:"subscriber_count"`
+		} `json:"public_profile"`
+	}
+	if err := doJSON(ctx, s.client, "Snapchat", http.MethodGet, endpoint, s.header(account), nil, &result); err != nil {
+		return AccountMetrics{}, err
+	}
+	return AccountMetrics{Followers: result.PublicProfile.SubscriberCount}, nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newSnapchatTestServer serves handler and returns a snapchatPublisher pointed at it.
+func newSnapchatTestServer(t *testing.T, handler http.HandlerFunc) *snapchatPublisher {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	return newSnapchatPublisher(srv.URL, srv.Client())
+}
+
+func TestSnapchatPublishPostsStoryToProfile(t *testing.T) {
+	snapchat := newSnapchatTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/v1/public_profiles/profile-1/stories" {
+			t.Errorf("request %s %s; want POST to the profile's stories", r.Method, r.URL.Path)
+		}
+		var body map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Fatalf("decode body: %v", err)
+		}
+		if body["media_type"] != "VIDEO" || body["caption"] != "Story" {
+			t.Errorf("body = %v", body)
+		}
+		w.Write([]byte(`{"story":{"id":"story-1","status":"PENDING"}}`))
+	})
+	account := PlatformAccount{PlatformUserID: "profile-1", AccessToken: "t"}
+	id, err := snapchat.Publish(context.Background(), account, Post{Content: "Story", MediaURL: "https://cdn.example.com/a.mp4"})
+	if err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	if id != "story-1" {
+		t.Errorf("Publish returned %q; want story-1", id)
+	}
+	if _, err := snapchat.Publish(context.Background(), account, Post{Content: "text only"}); err == nil {
+		t.Error("Publish without media succeeded")
+	}
+	if _, err := snapchat.Publish(context.Background(), PlatformAccount{}, Post{MediaURL: "https://cdn.example.com/a.jpg"}); err == nil {
+		t.Error("Publish without a profile succeeded")
+	}
+}
+
+func TestSnapchatStatus(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		want   string
+	}{
+		{"active", http.StatusOK, `{"story":{"id":"s","status":"ACTIVE"}}`, publishStatePublished},
+		{"rejected", http.StatusOK, `{"story":{"id":"s","status":"REJECTED","failure_reason":"policy"}}`, publishStateFailed},
+		{"expired", http.StatusOK, `{"story":{"id":"s","status":"EXPIRED"}}`, publishStateDeleted},
+		{"pending", http.StatusOK, `{"story":{"id":"s","status":"PENDING"}}`, publishStateProcessing},
+		{"gone", http.StatusNotFound, `{}`, publishStateDeleted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			snapchat := newSnapchatTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			})
+			got, err := snapchat.Status(context.Background(), PlatformAccount{}, "s")
+			if err != nil {
+				t.Fatalf("Status: %v", err)
+			}
+			if got.State != tt.want {
+				t.Errorf("Status = %q; want %q", got.State, tt.want)
+			}
+		})
+	}
+}
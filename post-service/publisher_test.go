@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestPublisherRegistryIsCaseInsensitive(t *testing.T) {
+	registry := newPublisherRegistry()
+	fake := newFakePublisher()
+	registry.Register("TikTok", fake)
+
+	for _, platform := range []string{"TikTok", "tiktok", "TIKTOK"} {
+		if p, ok := registry.Get(platform); !ok || p != fake {
+			t.Errorf("Get(%q) = %v, %v; want the registered fake", platform, p, ok)
+		}
+	}
+	if _, ok := registry.Get("Meta"); ok {
+		t.Error("Get(\"Meta\") found a publisher that was never registered")
+	}
+}
+
+func TestFakePublisherLifecycle(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakePublisher()
+	account := PlatformAccount{PlatformUserID: "page-1", AccessToken: "token"}
+
+	id, err := fake.Publish(ctx, account, Post{ID: "post-1", Content: "Hello"})
+	if err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	status, err := fake.Status(ctx, account, id)
+	if err != nil || status.State != publishStatePublished {
+		t.Fatalf("Status after Publish = %+v, %v; want published", status, err)
+	}
+	if err := fake.Delete(ctx, account, id); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if status, _ := fake.Status(ctx, account, id); status.State != publishStateDeleted {
+		t.Errorf("Status after Delete = %+v; want deleted", status)
+	}
+	if err := fake.Delete(ctx, account, "fake-404"); err == nil {
+		t.Error("Delete of an unknown post succeeded")
+	}
+
+	calls := fake.Calls()
+	methods := make([]string, len(calls))
+	for i, call := range calls {
+		methods[i] = call.Method
+	}
+	want := []string{"Publish", "Status", "Delete", "Status", "Delete"}
+	if len(methods) != len(want) {
+		t.Fatalf("recorded calls %v; want %v", methods, want)
+	}
+	for i := range want {
+		if methods[i] != want[i] {
+			t.Fatalf("recorded calls %v; want %v", methods, want)
+		}
+	}
+	if calls[0].Post.Content != "Hello" || calls[0].ExternalID != id || calls[0].Account != account {
+		t.Errorf("Publish call recorded as %+v", calls[0])
+	}
+}
+
+func TestFakePublisherErr(t *testing.T) {
+	fake := newFakePublisher()
+	fake.Err = errors.New("platform is down")
+	if _, err := fake.Publish(context.Background(), PlatformAccount{}, Post{}); !errors.Is(err, fake.Err) {
+		t.Errorf("Publish error = %v; want %v", err, fake.Err)
+	}
+	if n := len(fake.Calls()); n != 1 {
+		t.Errorf("recorded %d calls; want the failed call to be recorded too", n)
+	}
+}
+
+func TestIsVideoURL(t *testing.T) {
+	tests := []struct {
+		url  string
+		want bool
+	}{
+		{"https://cdn.example.com/clip.mp4", true},
+		{"https://cdn.example.com/clip.MOV?sig=abc", true},
+		{"https://cdn.example.com/photo.jpg", false},
+		{"https://cdn.example.com/photo.png?name=clip.mp4", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := isVideoURL(tt.url); got != tt.want {
+			t.Errorf("isVideoURL(%q) = %v; want %v", tt.url, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// tiktokPublisher publishes videos through the TikTok Content Posting API. TikTok
+// pulls the video from the post's MediaURL and processes it asynchronously, so the
+// ID returned by Publish is a publish_id whose progress is reported by Status.
+type tiktokPublisher struct {
+	baseURL string
+	client  *http.Client
+}
+
+func newTikTokPublisher(baseURL string, client *http.Client) *tiktokPublisher {
+	return &tiktokPublisher{baseURL: strings.TrimRight(baseURL, "/"), client: client}
+}
+
+// tiktokError is the error envelope included in every TikTok API response.
+type tiktokError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	LogID   string `json:"log_id"`
+}
+
+func (e tiktokError) err() error {
+	if e.Code == "" || e.Code == "ok" {
+		return nil
+	}
+	return fmt.Errorf("tiktok: %s: %s (log_id %s)", e.Code, e.Message, e.LogID)
+}
+
+func (t *tiktokPublisher) header(account PlatformAccount) http.Header {
+	h := http.Header{}
+	h.Set("Authorization", "Bearer "+account.AccessToken)
+	return h
+}
+
+func (t *tiktokPublisher) Publish(ctx context.Context, account PlatformAccount, post Post) (string, error) {
+	if post.MediaURL == "" {
+		return "", fmt.Errorf("tiktok: posts require a video")
+	}
+	body := map[string]interface{}{
+		"post_info": map[string]interface{}{
+			"title":         post.Content,
+			"privacy_level": "PUBLIC_TO_EVERYONE",
+		},
+		"source_info": map[string]interface{}{
+			"source":    "PULL_FROM_URL",
+			"video_url": post.MediaURL,
+		},
+	}
+	var result struct {
+		Data struct {
+			PublishID string `json:"publish_id"`
+		} `json:"data"`
+		Error tiktokError `json:"error"`
+	}
+	if err := doJSON(ctx, t.client, "TikTok", http.MethodPost, t.baseURL+"/v2/post/publish/video/init/", t.header(account), body, &result); err != nil {
+		return "", err
+	}
+	if err := result.Error.err(); err != nil {
+		return "", err
+	}
+	if result.Data.PublishID == "" {
+		return "", fmt.Errorf("tiktok: response did not include a publish_id")
+	}
+	return result.Data.PublishID, nil
+}
+
+// Delete is not offered by the Content Posting API; videos must be removed in the app.
+func (t *tiktokPublisher) Delete(ctx context.Context, account PlatformAccount, externalID string) error {
+	return errPublisherUnsupported
+}
+
+// tiktokPublishStatus is the progress of a publish_id.
+type tiktokPublishStatus struct {
+	Status     string `json:"status"`
+	FailReason string `json:"fail_reason"`
+	// PostIDs holds the IDs of the public video once it is published.
+	PostIDs []int64 `json:"publicaly_available_post_id"`
+}
+
+func (t *tiktokPublisher) fetchStatus(ctx context.Context, account PlatformAccount, publishID string) (tiktokPublishStatus, error) {
+	body := map[string]string{"publish_id": publishID}
+	var result struct {
+		Data  tiktokPublishStatus `json:"data"`
+		Error tiktokError         `json:"error"`
+	}
+	if err := doJSON(ctx, t.client, "TikTok", http.MethodPost, t.baseURL+"/v2/post/publish/status/fetch/", t.header(account), body, &result); err != nil {
+		return tiktokPublishStatus{}, err
+	}
+	return result.Data, result.Error.err()
+}
+
+func (t *tiktokPublisher) Status(ctx context.Context, account PlatformAccount, externalID string) (PublishStatus, error) {
+	status, err := t.fetchStatus(ctx, account, externalID)
+	if err != nil {
+		return PublishStatus{}, err
+	}
+	switch status.Status {
+	case "PUBLISH_COMPLETE":
+		return PublishStatus{State: publishStatePublished}, nil
+	case "FAILED":
+		return PublishStatus{State: publishStateFailed, Detail: status.FailReason}, nil
+	default:
+		return PublishStatus{State: publishStateProcessing, Detail: status.Status}, nil
+	}
+}
+
+// PostMetrics looks up the public video of a publish_id and reads its counts.
+// TikTok reports views but not reach, so Reach stays zero.
+func (t *tiktokPublisher) PostMetrics(ctx context.Context, account PlatformAccount, externalID string) (PostMetrics, error) {
+	status, err := t.fetchStatus(ctx, account, externalID)
+	if err != nil {
+		return PostMetrics{}, err
+	}
+	if len(status.PostIDs) == 0 {
+		return PostMetrics{}, errMetricsUnavailable
+	}
+	body := map[string]interface{}{
+		"filters": map[string]interface{}{"video_ids": []string{strconv.FormatInt(status.PostIDs[0], 10)}},
+	}
+	var result struct {
+		Data struct {
+			Videos []struct {
+				ViewCount    int64 `json:"view_count"`
+				LikeCount    int64 `json:"like_count"`
+				CommentCount int64 `json:"comment_count"`
+				ShareCount   int64 `json:"share_count"`
+			} `json:"videos"`
+		} `json:"data"`
+		Error tiktokError `json:"error"`
+	}
+	endpoint := t.baseURL + "/v2/video/query/?fields=id,view_count,like_count,comment_count,share_count"
+	if err := doJSON(ctx, t.client, "TikTok", http.MethodPost, endpoint, t.header(account), body, &result); err != nil {
+		return PostMetrics{}, err
+	}
+	if err := result.Error.err(); err != nil {
+		return PostMetrics{}, err
+	}
+	if len(result.Data.Videos) == 0 {
+		return PostMetrics{}, errMetricsUnavailable
+	}
+	v := result.Data.Videos[0]
+	return PostMetrics{Impressions: v.ViewCount, Likes: v.LikeCount, Comments: v.CommentCount, Shares: v.ShareCount}, nil
+}
+
+func (t *tiktokPublisher) AccountMetrics(ctx context.Context, account PlatformAccount) (AccountMetrics, error) {
+	var result struct {
+		Data struct {
+			User struct {
+				FollowerCount int64 `json:"follower_count"`
+			} `json:"user"`
+		} `json:"data"`
+		Error tiktokError `json:"error"`
+	}
+	if err := doJSON(ctx, t.client, "TikTok", http.MethodGet, t.baseURL+"/v2/user/info/?fields=follower_count", t.header(account), nil, &result); err != nil {
+		return AccountMetrics{}, err
+	}
+	if err := result.Error.err(); err != nil {
+		return AccountMetrics{}, err
+	}
+	return AccountMetrics{Followers: result.Data.User.FollowerCount}, nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newTikTokTestServer serves handler and returns a tiktokPublisher pointed at it.
+func newTikTokTestServer(t *testing.T, handler http.HandlerFunc) *tiktokPublisher {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	return newTikTokPublisher(srv.URL, srv.Client())
+}
+
+func TestTikTokPublishPullsVideoFromURL(t *testing.T) {
+	tiktok := newTikTokTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v2/post/publish/video/init/" {
+			t.Errorf("request to %s; want the video init endpoint", r.URL.Path)
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer user-token" {
+			t.Errorf("Authorization = %q", got)
+		}
+		var body struct {
+			SourceInfo struct {
+				Source   string `json:"source"`
+				VideoURL string `json:"video_url"`
+			} `json:"source_info"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Fatalf("decode body: %v", err)
+		}
+		if body.SourceInfo.Source != "PULL_FROM_URL" || body.SourceInfo.VideoURL != "https://cdn.example.com/a.mp4" {
+			t.Errorf("source_info = %+v", body.SourceInfo)
+		}
+		w.Write([]byte(`{"data":{"publish_id":"v_pub_1"},"error":{"code":"ok"}}`))
+	})
+	id, err := tiktok.Publish(context.Background(), PlatformAccount{AccessToken: "user-token"}, Post{Content: "Clip", MediaURL: "https://cdn.example.com/a.mp4"})
+	if err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	if id != "v_pub_1" {
+		t.Errorf("Publish returned %q; want v_pub_1", id)
+	}
+}
+
+func TestTikTokPublishReportsErrorEnvelope(t *testing.T) {
+	tiktok := newTikTokTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"data":{},"error":{"code":"spam_risk_too_many_posts","message":"slow down","log_id":"L1"}}`))
+	})
+	_, err := tiktok.Publish(context.Background(), PlatformAccount{}, Post{MediaURL: "https://cdn.example.com/a.mp4"})
+	if err == nil {
+		t.Fatal("Publish succeeded despite an error envelope")
+	}
+	if _, err := tiktok.Publish(context.Background(), PlatformAccount{}, Post{Content: "text only"}); err == nil {
+		t.Error("Publish without a video succeeded")
+	}
+	if err := tiktok.Delete(context.Background(), PlatformAccount{}, "v_pub_1"); !errors.Is(err, errPublisherUnsupported) {
+		t.Errorf("Delete = %v; want errPublisherUnsupported", err)
+	}
+}
+
+func TestTikTokStatus(t *testing.T) {
+	tests := []struct {
+		status string
+		want   PublishStatus
+	}{
+		{"PUBLISH_COMPLETE", PublishStatus{State: publishStatePublished}},
+		{"FAILED", PublishStatus{State: publishStateFailed, Detail: "file_format_check_failed"}},
+		{"PROCESSING_DOWNLOAD", PublishStatus{State: publishStateProcessing, Detail: "PROCESSING_DOWNLOAD"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.status, func(t *testing.T) {
+			tiktok := newTikTokTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"data":  map[string]string{"status": tt.status, "fail_reason": "file_format_check_failed"},
+					"error": map[string]string{"code": "ok"},
+				})
+			})
+			got, err := tiktok.Status(context.Background(), PlatformAccount{}, "v_pub_1")
+			if err != nil {
+				t.Fatalf("Status: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Status = %+v; want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,542 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// --- Media Renditions ---
+//
+// The platforms are particular about the media they accept, so every file
+// that enters the library is turned into a rendition per platform, and a
+// thumbnail, by a local ffmpeg binary. A rendition is cropped to the aspect
+// ratios its platform accepts, scaled down to its maximum width and
+// re-encoded, as H.264/AAC MP4 for video and JPEG for images. When a post is
+// published the worker hands the platform the rendition made for it, or the
+// original if there is none.
+//
+// Each rendition row is also the job that makes it. The rendition worker
+// claims queued jobs with FOR UPDATE SKIP LOCKED, so every replica can run
+// one, and records how far ffmpeg has got so clients can show progress.
+
+// Rendition job states.
+const (
+	renditionQueued     = "queued"
+	renditionProcessing = "processing"
+	renditionReady      = "ready"
+	renditionFailed     = "failed"
+)
+
+// thumbnailPreset names the thumbnail rendition of every media.
+const thumbnailPreset = "thumbnail"
+
+// renditionMaxAttempts is how often a job interrupted by a dying replica is retried.
+const renditionMaxAttempts = 3
+
+// renditionReleaseGrace is how much longer than the job timeout a claim is
+// honoured before the job counts as abandoned, so a replica that is still
+// storing its result does not see the job handed to another.
+const renditionReleaseGrace = 5 * time.Minute
+
+// RenditionPreset describes one rendition. Presets are named after the
+// platform they are for, so the publisher can find them by platform.
+type RenditionPreset struct {
+	Name string
+	Kind string
+	// Media whose width divided by height falls outside these bounds is
+	// center-cropped to the nearest one. Zero bounds keep the aspect ratio.
+	MinAspectRatio float64
+	MaxAspectRatio float64
+	MaxWidth       int
+	// MaxRateKbps caps the video bitrate.
+	MaxRateKbps int
+	// MaxBytes is the platform's size limit; larger renditions fail.
+	MaxBytes int64
+}
+
+// renditionPresets follow the platforms' published media specifications.
+// TikTok and Snapchat are full-screen vertical; Instagram feed images may be
+// between 4:5 and 1.91:1 and its videos between 9:16 and 1.91:1.
+var renditionPresets = []RenditionPreset{
+	{Name: "meta", Kind: mediaImage, MinAspectRatio: 4.0 / 5.0, MaxAspectRatio: 1.91, MaxWidth: 1440, MaxBytes: 8 << 20},
+	{Name: "meta", Kind: mediaVideo, MinAspectRatio: 9.0 / 16.0, MaxAspectRatio: 1.91, MaxWidth: 1080, MaxRateKbps: 8000, MaxBytes: 1 << 30},
+	{Name: "tiktok", Kind: mediaVideo, MinAspectRatio: 9.0 / 16.0, MaxAspectRatio: 9.0 / 16.0, MaxWidth: 1080, MaxRateKbps: 10000, MaxBytes: 4 << 30},
+	{Name: "snapchat", Kind: mediaImage, MinAspectRatio: 9.0 / 16.0, MaxAspectRatio: 9.0 / 16.0, MaxWidth: 1080, MaxBytes: 5 << 20},
+	{Name: "snapchat", Kind: mediaVideo, MinAspectRatio: 9.0 / 16.0, MaxAspectRatio: 9.0 / 16.0, MaxWidth: 1080, MaxRateKbps: 8000, MaxBytes: 1 << 30},
+	{Name: thumbnailPreset, Kind: mediaImage, MaxWidth: 540},
+	{Name: thumbnailPreset, Kind: mediaVideo, MaxWidth: 540},
+}
+
+func findPreset(name, kind string) (RenditionPreset, bool) {
+	for _, p := range renditionPresets {
+		if p.Name == name && p.Kind == kind {
+			return p, true
+		}
+	}
+	return RenditionPreset{}, false
+}
+
+// Rendition is one rendition of a media and the state of the job making it.
+type Rendition struct {
+	ID              string    `json:"id"`
+	MediaID         string    `json:"mediaId"`
+	Preset          string    `json:"preset"`
+	Status          string    `json:"status"`
+	Progress        float64   `json:"progress"`
+	Error           string    `json:"error,omitempty"`
+	ContentType     string    `json:"contentType,omitempty"`
+	SizeBytes       int64     `json:"sizeBytes,omitempty"`
+	Width           int       `json:"width,omitempty"`
+	Height          int       `json:"height,omitempty"`
+	DurationSeconds float64   `json:"durationSeconds,omitempty"`
+	UpdatedAt       time.Time `json:"updatedAt"`
+	// URL is a short-lived download link, filled in for ready renditions.
+	URL        string `json:"url,omitempty"`
+	storageKey string
+}
+
+// --- Database Operations ---
+
+// enqueueRenditions queues the jobs for every preset that applies to m and
+// has no job yet, and requeues failed ones.
+func enqueueRenditions(ctx context.Context, m Media) error {
+	if !cfg.Renditions.Enabled {
+		return nil
+	}
+	for i, p := range renditionPresets {
+		if p.Kind != m.Kind {
+			continue
+		}
+		_, err := db.ExecContext(ctx, `
+			INSERT INTO media_renditions (id, media_id, preset) VALUES ($1, $2, $3)
+			ON CONFLICT (media_id, preset) DO UPDATE SET status = $4, progress = 0, error = NULL, attempts = 0, updated_at = now()
+			WHERE media_renditions.status = $5`,
+			fmt.Sprintf("rendition-%d-%d", time.Now().UnixNano(), i), m.ID, p.Name, renditionQueued, renditionFailed,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to queue rendition: %w", err)
+		}
+	}
+	return nil
+}
+
+const renditionColumns = "id, media_id, preset, status, progress, COALESCE(error, ''), COALESCE(content_type, ''), COALESCE(size_bytes, 0), COALESCE(width, 0), COALESCE(height, 0), COALESCE(duration_seconds, 0), COALESCE(storage_key, ''), updated_at"
+
+func scanRendition(row interface{ Scan(...interface{}) error }) (Rendition, error) {
+	var r Rendition
+	err := row.Scan(&r.ID, &r.MediaID, &r.Preset, &r.Status, &r.Progress, &r.Error, &r.ContentType, &r.SizeBytes, &r.Width, &r.Height, &r.DurationSeconds, &r.storageKey, &r.UpdatedAt)
+	return r, err
+}
+
+func getRenditions(ctx context.Context, mediaID string) ([]Rendition, error) {
+	rows, err := db.QueryContext(ctx, "SELECT "+renditionColumns+" FROM media_renditions WHERE media_id = $1 ORDER BY preset", mediaID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get renditions: %w", err)
+	}
+	defer rows.Close()
+	renditions := []Rendition{}
+	for rows.Next() {
+		r, err := scanRendition(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan rendition row: %w", err)
+		}
+		renditions = append(renditions, r)
+	}
+	return renditions, rows.Err()
+}
+
+// renditionKey returns the storage key of a ready rendition, or "" if there is none.
+func renditionKey(ctx context.Context, mediaID, preset string) (string, error) {
+	var key string
+	err := db.QueryRowContext(ctx, "SELECT storage_key FROM media_renditions WHERE media_id = $1 AND preset = $2 AND status = $3",
+		mediaID, preset, renditionReady).Scan(&key)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get rendition: %w", err)
+	}
+	return key, nil
+}
+
+// renditionJob is a claimed job with what the worker needs to know about its media.
+type renditionJob struct {
+	Rendition
+	tenantID        string
+	checksum        string
+	kind            string
+	sourceKey       string
+	durationSeconds float64
+	// attempt identifies the claim; results of an earlier claim are dropped.
+	attempt int
+}
+
+// claimRendition claims the oldest queued job of media that still exists.
+func claimRendition(ctx context.Context) (renditionJob, bool, error) {
+	var job renditionJob
+	err := db.QueryRowContext(ctx, `
+		WITH claimed AS (
+			UPDATE media_renditions SET status = $1, progress = 0, attempts = attempts + 1, claimed_at = now(), updated_at = now()
+			WHERE id = (
+				SELECT r.id FROM media_renditions r JOIN media m ON m.id = r.media_id
+				WHERE r.status = $2 AND m.deleted_at IS NULL
+				ORDER BY r.created_at
+				LIMIT 1
+				FOR UPDATE OF r SKIP LOCKED
+			)
+			RETURNING id, media_id, preset, attempts
+		)
+		SELECT c.id, c.media_id, c.preset, c.attempts, m.tenant_id, m.sha256, m.kind, m.storage_key, COALESCE(m.duration_seconds, 0)
+		FROM claimed c JOIN media m ON m.id = c.media_id`,
+		renditionProcessing, renditionQueued,
+	).Scan(&job.ID, &job.MediaID, &job.Preset, &job.attempt, &job.tenantID, &job.checksum, &job.kind, &job.sourceKey, &job.durationSeconds)
+	if errors.Is(err, sql.ErrNoRows) {
+		return job, false, nil
+	}
+	if err != nil {
+		return job, false, fmt.Errorf("failed to claim rendition: %w", err)
+	}
+	return job, true, nil
+}
+
+// releaseStaleRenditions requeues jobs whose replica stopped working on them,
+// and fails those that were interrupted too often.
+func releaseStaleRenditions(ctx context.Context, maxAge time.Duration) error {
+	_, err := db.ExecContext(ctx, `
+		UPDATE media_renditions
+		SET status = CASE WHEN attempts < $3 THEN $2 ELSE $4 END,
+			error = CASE WHEN attempts < $3 THEN NULL ELSE 'rendering was interrupted too often' END,
+			progress = 0, updated_at = now()
+		WHERE status = $1 AND claimed_at < $5`,
+		renditionProcessing, renditionQueued, renditionMaxAttempts, renditionFailed, time.Now().Add(-maxAge),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to release stale renditions: %w", err)
+	}
+	return nil
+}
+
+func updateRenditionProgress(ctx context.Context, id string, progress float64) {
+	if _, err := db.ExecContext(ctx, "UPDATE media_renditions SET progress = $2, updated_at = now() WHERE id = $1 AND status = $3", id, progress, renditionProcessing); err != nil {
+		log.Printf("Failed to record progress of rendition %s: %v", id, err)
+	}
+}
+
+// finishRendition records the result of job. It is dropped if the job's claim
+// was released meanwhile; the job then belongs to another attempt.
+func finishRendition(ctx context.Context, job renditionJob, r Rendition) error {
+	res, err := db.ExecContext(ctx, `
+		UPDATE media_renditions SET status = $2, progress = 1, error = NULL, content_type = $3, size_bytes = $4, width = NULLIF($5, 0), height = NULLIF($6, 0),
+			duration_seconds = NULLIF($7, 0), storage_key = $8, updated_at = now()
+		WHERE id = $1 AND status = $9 AND attempts = $10`,
+		job.ID, renditionReady, r.ContentType, r.SizeBytes, r.Width, r.Height, r.DurationSeconds, r.storageKey, renditionProcessing, job.attempt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record rendition: %w", err)
+	}
+	return checkRenditionClaim(res, job)
+}
+
+// failRendition records why job failed, unless its claim was released meanwhile.
+func failRendition(ctx context.Context, job renditionJob, cause error) error {
+	res, err := db.ExecContext(ctx,
+		"UPDATE media_renditions SET status = $2, error = $3, updated_at = now() WHERE id = $1 AND status = $4 AND attempts = $5",
+		job.ID, renditionFailed, cause.Error(), renditionProcessing, job.attempt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record rendition failure: %w", err)
+	}
+	return checkRenditionClaim(res, job)
+}
+
+func checkRenditionClaim(res sql.Result, job renditionJob) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to record rendition: %w", err)
+	}
+	if n == 0 {
+		return fmt.Errorf("rendition %s was released before attempt %d finished; its result was dropped", job.ID, job.attempt)
+	}
+	return nil
+}
+
+// --- Rendition Worker ---
+
+// RenditionWorker claims rendition jobs and runs ffmpeg for them.
+type RenditionWorker struct {
+	ffmpeg   string
+	interval time.Duration
+	timeout  time.Duration
+}
+
+func startRenditionWorkers() {
+	if !cfg.Renditions.Enabled {
+		log.Println("Media renditions are disabled.")
+		return
+	}
+	if _, err := exec.LookPath(cfg.Renditions.FFmpegPath); err != nil {
+		log.Printf("ffmpeg not found at %q; media renditions stay queued until it is installed", cfg.Renditions.FFmpegPath)
+		return
+	}
+	for i := 0; i < cfg.Renditions.Workers; i++ {
+		w := &RenditionWorker{ffmpeg: cfg.Renditions.FFmpegPath, interval: cfg.Renditions.PollInterval, timeout: cfg.Renditions.JobTimeout}
+		go w.Run(context.Background())
+	}
+	log.Printf("Rendition worker started (%d worker(s))", cfg.Renditions.Workers)
+}
+
+// Run works through queued jobs until ctx is cancelled.
+func (w *RenditionWorker) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		if err := releaseStaleRenditions(ctx, w.timeout+renditionReleaseGrace); err != nil {
+			log.Print(err)
+		}
+		for ctx.Err() == nil {
+			job, ok, err := claimRendition(ctx)
+			if err != nil {
+				log.Print(err)
+			}
+			if !ok {
+				break
+			}
+			w.process(ctx, job)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (w *RenditionWorker) process(ctx context.Context, job renditionJob) {
+	r, err := w.render(ctx, job)
+	if err == nil {
+		err = finishRendition(ctx, job, r)
+	} else {
+		log.Printf("Failed to render %s of media %s: %v", job.Preset, job.MediaID, err)
+		err = failRendition(ctx, job, err)
+	}
+	if err != nil {
+		log.Print(err)
+	}
+}
+
+// render makes the rendition of a job and stores it.
+func (w *RenditionWorker) render(ctx context.Context, job renditionJob) (Rendition, error) {
+	preset, ok := findPreset(job.Preset, job.kind)
+	if !ok {
+		return Rendition{}, fmt.Errorf("no %s preset for %s media", job.Preset, job.kind)
+	}
+	ctx, cancel := context.WithTimeout(ctx, w.timeout)
+	defer cancel()
+	dir, err := os.MkdirTemp(cfg.Media.UploadDir, "rendition-*")
+	if err != nil {
+		return Rendition{}, fmt.Errorf("failed to create work directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	source := filepath.Join(dir, "source"+filepath.Ext(job.sourceKey))
+	if err := copyObject(ctx, job.sourceKey, source); err != nil {
+		return Rendition{}, err
+	}
+	r := job.Rendition
+	r.ContentType, r.storageKey = "image/jpeg", job.tenantID+"/renditions/"+job.checksum+"-"+preset.Name+".jpg"
+	if job.kind == mediaVideo && preset.Name != thumbnailPreset {
+		r.ContentType, r.storageKey = "video/mp4", strings.TrimSuffix(r.storageKey, ".jpg")+".mp4"
+	}
+	output := filepath.Join(dir, "output"+filepath.Ext(r.storageKey))
+	if err := w.ffmpegRun(ctx, job, ffmpegArgs(preset, job, source, output)); err != nil {
+		return Rendition{}, err
+	}
+
+	f, err := os.Open(output)
+	if err != nil {
+		return Rendition{}, fmt.Errorf("failed to open rendition: %w", err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return Rendition{}, fmt.Errorf("failed to open rendition: %w", err)
+	}
+	r.SizeBytes = info.Size()
+	if preset.MaxBytes > 0 && r.SizeBytes > preset.MaxBytes {
+		return Rendition{}, fmt.Errorf("the rendition is %d bytes but %s accepts at most %d", r.SizeBytes, preset.Name, preset.MaxBytes)
+	}
+	measured, err := probeMedia(f, r.SizeBytes, r.ContentType)
+	if err != nil {
+		return Rendition{}, err
+	}
+	r.Width, r.Height, r.DurationSeconds = measured.Width, measured.Height, measured.DurationSeconds
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return Rendition{}, fmt.Errorf("failed to read rendition: %w", err)
+	}
+	if err := mediaStore.Put(ctx, r.storageKey, f, r.SizeBytes, r.ContentType); err != nil {
+		return Rendition{}, err
+	}
+	return r, nil
+}
+
+// copyObject downloads a stored object to a local file for ffmpeg to read.
+func copyObject(ctx context.Context, key, path string) error {
+	src, err := mediaStore.Open(ctx, key)
+	if err != nil {
+		return fmt.Errorf("failed to open media: %w", err)
+	}
+	defer src.Close()
+	dst, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create work file: %w", err)
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return fmt.Errorf("failed to download media: %w", err)
+	}
+	return dst.Close()
+}
+
+// ffmpegArgs builds the command line that renders source to output.
+func ffmpegArgs(preset RenditionPreset, job renditionJob, source, output string) []string {
+	var filters []string
+	if preset.MinAspectRatio > 0 {
+		filters = append(filters, fmt.Sprintf(`crop=w=min(iw\,trunc(ih*%.6f/2)*2):h=min(ih\,trunc(iw/%.6f/2)*2)`, preset.MaxAspectRatio, preset.MinAspectRatio))
+	}
+	filters = append(filters, fmt.Sprintf(`scale=w=trunc(min(iw\,%d)/2)*2:h=-2`, preset.MaxWidth))
+	args := []string{"-hide_banner", "-nostdin", "-y"}
+	if job.kind == mediaVideo && preset.Name == thumbnailPreset {
+		// A frame a second in is more telling than the first, often black, one.
+		args = append(args, "-ss", strconv.FormatFloat(min(1, job.durationSeconds/2), 'f', 3, 64))
+	}
+	args = append(args, "-i", source, "-vf", strings.Join(filters, ","))
+	if job.kind == mediaImage || preset.Name == thumbnailPreset {
+		return append(args, "-frames:v", "1", "-q:v", "3", output)
+	}
+	return append(args,
+		"-c:v", "libx264", "-profile:v", "high", "-pix_fmt", "yuv420p", "-preset", "medium", "-crf", "23",
+		"-maxrate", fmt.Sprintf("%dk", preset.MaxRateKbps), "-bufsize", fmt.Sprintf("%dk", 2*preset.MaxRateKbps),
+		"-c:a", "aac", "-b:a", "128k", "-ar", "44100",
+		"-movflags", "+faststart", "-progress", "pipe:1", "-nostats",
+		output,
+	)
+}
+
+// ffmpegRun runs ffmpeg, recording its progress through the media's duration.
+func (w *RenditionWorker) ffmpegRun(ctx context.Context, job renditionJob, args []string) error {
+	cmd := exec.CommandContext(ctx, w.ffmpeg, args...)
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start ffmpeg: %w", err)
+	}
+	lastUpdate := time.Now()
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		// -progress reports the encoded position as out_time_us (out_time_ms
+		// in older versions, despite its name also in microseconds).
+		key, value, _ := strings.Cut(scanner.Text(), "=")
+		if (key != "out_time_us" && key != "out_time_ms") || job.durationSeconds <= 0 || time.Since(lastUpdate) < 2*time.Second {
+			continue
+		}
+		if us, err := strconv.ParseInt(value, 10, 64); err == nil {
+			updateRenditionProgress(ctx, job.ID, min(0.99, float64(us)/1e6/job.durationSeconds))
+			lastUpdate = time.Now()
+		}
+	}
+	if err := cmd.Wait(); err != nil {
+		detail := strings.TrimSpace(stderr.String())
+		if len(detail) > 500 {
+			detail = detail[len(detail)-500:]
+		}
+		return fmt.Errorf("ffmpeg failed: %v: %s", err, detail)
+	}
+	return nil
+}
+
+// --- Handlers ---
+
+// withRenditionURLs fills in links to the ready renditions.
+func withRenditionURLs(renditions []Rendition) ([]Rendition, error) {
+	for i := range renditions {
+		if renditions[i].Status != renditionReady {
+			continue
+		}
+		url, err := mediaStore.URL(renditions[i].storageKey, mediaPreviewTTL)
+		if err != nil {
+			return nil, err
+		}
+		renditions[i].URL = url
+	}
+	return renditions, nil
+}
+
+func writeRenditions(w http.ResponseWriter, r *http.Request, status int, mediaID string) {
+	renditions, err := getRenditions(r.Context(), mediaID)
+	if err == nil {
+		renditions, err = withRenditionURLs(renditions)
+	}
+	if err != nil {
+		writeMediaError(w, "list renditions", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(renditions)
+}
+
+// listRenditionsHandler lists a media's renditions and the progress of their jobs.
+func listRenditionsHandler(w http.ResponseWriter, r *http.Request) {
+	_, tenantID, err := getUserIDAndTenantIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	m, err := getMedia(r.Context(), db, tenantID, mux.Vars(r)["mediaId"])
+	if err != nil {
+		writeMediaError(w, "list renditions", err)
+		return
+	}
+	writeRenditions(w, r, http.StatusOK, m.ID)
+}
+
+// retryRenditionsHandler queues the renditions a media is missing and those that failed.
+func retryRenditionsHandler(w http.ResponseWriter, r *http.Request) {
+	_, tenantID, err := getUserIDAndTenantIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !cfg.Renditions.Enabled {
+		http.Error(w, "Media renditions are disabled", http.StatusConflict)
+		return
+	}
+	m, err := getMedia(r.Context(), db, tenantID, mux.Vars(r)["mediaId"])
+	if err == nil {
+		err = enqueueRenditions(r.Context(), m)
+	}
+	if err != nil {
+		writeMediaError(w, "queue renditions", err)
+		return
+	}
+	writeRenditions(w, r, http.StatusAccepted, m.ID)
+}
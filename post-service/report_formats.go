@@ -0,0 +1,370 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// --- Report Formats ---
+//
+// Reports are a title followed by tables (see ReportData.tables). The
+// renderers below write them without third-party libraries: CSV with the
+// standard library, XLSX as the minimal set of SpreadsheetML parts Excel,
+// LibreOffice and Google Sheets open, with a sheet per table, and PDF as
+// landscape A4 pages set in the standard Helvetica font, which every reader
+// has, so no font needs embedding. That font only covers Latin-1; other
+// characters are printed as "?" in PDFs.
+
+// Post captions end up in report cells. Spreadsheet apps run text starting
+// with one of these characters as a formula, so such cells are written as text:
+// with a leading apostrophe in CSV, with the quote prefix style in XLSX.
+const formulaPrefixes = "=+-@\t\r"
+
+func looksLikeFormula(s string) bool {
+	return s != "" && strings.ContainsRune(formulaPrefixes, rune(s[0]))
+}
+
+// --- CSV ---
+
+// renderCSV writes the title, then each table under its own title, separated by empty lines.
+func renderCSV(w io.Writer, title string, tables []reportTable) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{title})
+	for _, t := range tables {
+		cw.Write([]string{})
+		cw.Write([]string{t.Title})
+		cw.Write(t.Header)
+		for _, row := range t.Rows {
+			record := make([]string, len(row))
+			for i, cell := range row {
+				record[i] = formatCell(cell)
+				if _, ok := cell.(string); ok && looksLikeFormula(record[i]) {
+					record[i] = "'" + record[i]
+				}
+			}
+			cw.Write(record)
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// --- XLSX ---
+
+// Cell styles defined by xlsxStyles.
+const (
+	xlsxStyleBold    = 1
+	xlsxStylePercent = 2
+	xlsxStyleText    = 3
+)
+
+const xlsxStyles = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+<numFmts count="1"><numFmt numFmtId="164" formatCode="0.0"/></numFmts>
+<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts>
+<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>
+<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>
+<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>
+<cellXfs count="4"><xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/><xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/><xf numFmtId="164" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/><xf numFmtId="49" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1" quotePrefix="1"/></cellXfs>
+</styleSheet>`
+
+// renderXLSX writes a workbook with a sheet per table. Each sheet starts with
+// the report's title, and numbers are stored as numbers so they can be summed.
+func renderXLSX(w io.Writer, title string, tables []reportTable) error {
+	var sheets, sheetRels, overrides strings.Builder
+	parts := map[string]string{"xl/styles.xml": xlsxStyles}
+	for i, t := range tables {
+		n := i + 1
+		fmt.Fprintf(&sheets, `<sheet name="%s" sheetId="%d" r:id="rId%d"/>`, xmlText(xlsxSheetName(t.Title)), n, n)
+		fmt.Fprintf(&sheetRels, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet%d.xml"/>`, n, n)
+		fmt.Fprintf(&overrides, `<Override PartName="/xl/worksheets/sheet%d.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>`, n)
+		parts[fmt.Sprintf("xl/worksheets/sheet%d.xml", n)] = xlsxSheet(title, t)
+	}
+	fmt.Fprintf(&sheetRels, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>`, len(tables)+1)
+
+	parts["[Content_Types].xml"] = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"><Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/><Default Extension="xml" ContentType="application/xml"/><Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/><Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>` + overrides.String() + `</Types>`
+	parts["_rels/.rels"] = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/></Relationships>`
+	parts["xl/workbook.xml"] = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets>` + sheets.String() + `</sheets></workbook>`
+	parts["xl/_rels/workbook.xml.rels"] = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` + sheetRels.String() + `</Relationships>`
+
+	zw := zip.NewWriter(w)
+	// The content types part comes first, as some readers expect.
+	names := []string{"[Content_Types].xml", "_rels/.rels", "xl/workbook.xml", "xl/_rels/workbook.xml.rels", "xl/styles.xml"}
+	for i := range tables {
+		names = append(names, fmt.Sprintf("xl/worksheets/sheet%d.xml", i+1))
+	}
+	for _, name := range names {
+		f, err := zw.Create(name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(f, parts[name]); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// xlsxSheet renders a table as a worksheet: the title, an empty row, the
+// header and the rows.
+func xlsxSheet(title string, t reportTable) string {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><cols>`)
+	for i, width := range columnWidths(t, 60) {
+		fmt.Fprintf(&b, `<col min="%d" max="%d" width="%d" customWidth="1"/>`, i+1, i+1, width+2)
+	}
+	b.WriteString(`</cols><sheetData>`)
+	row := func(n int, cells []interface{}, style int) {
+		fmt.Fprintf(&b, `<row r="%d">`, n)
+		for i, cell := range cells {
+			ref := xlsxColumn(i) + strconv.Itoa(n)
+			s := style
+			switch v := cell.(type) {
+			case nil:
+				continue
+			case string:
+				if s == 0 && looksLikeFormula(v) {
+					s = xlsxStyleText
+				}
+				fmt.Fprintf(&b, `<c r="%s" t="inlineStr" s="%d"><is><t xml:space="preserve">%s</t></is></c>`, ref, s, xmlText(v))
+			case float64:
+				if s == 0 {
+					s = xlsxStylePercent
+				}
+				fmt.Fprintf(&b, `<c r="%s" s="%d"><v>%s</v></c>`, ref, s, strconv.FormatFloat(v, 'f', -1, 64))
+			default:
+				fmt.Fprintf(&b, `<c r="%s" s="%d"><v>%v</v></c>`, ref, s, v)
+			}
+		}
+		b.WriteString(`</row>`)
+	}
+	row(1, []interface{}{title + ": " + t.Title}, xlsxStyleBold)
+	header := make([]interface{}, len(t.Header))
+	for i, h := range t.Header {
+		header[i] = h
+	}
+	row(3, header, xlsxStyleBold)
+	for i, cells := range t.Rows {
+		row(i+4, cells, 0)
+	}
+	b.WriteString(`</sheetData></worksheet>`)
+	return b.String()
+}
+
+// xlsxColumn returns the letters of the zero-based column i: A, B, ..., Z, AA, ...
+func xlsxColumn(i int) string {
+	name := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = string(rune('A'+(i-1)%26)) + name
+	}
+	return name
+}
+
+// xlsxSheetName makes a table title a valid sheet name.
+func xlsxSheetName(title string) string {
+	name := strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`[]:*?/\`, r) {
+			return '-'
+		}
+		return r
+	}, title)
+	if utf8.RuneCountInString(name) > 31 {
+		name = string([]rune(name)[:31])
+	}
+	return name
+}
+
+// xmlText escapes s for XML text and attributes, replacing characters XML cannot hold.
+func xmlText(s string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// columnWidths returns each column's width in characters: its longest cell, at most limit.
+func columnWidths(t reportTable, limit int) []int {
+	widths := make([]int, len(t.Header))
+	measure := func(i int, s string) {
+		if n := utf8.RuneCountInString(s); i < len(widths) && n > widths[i] {
+			widths[i] = min(n, limit)
+		}
+	}
+	for i, h := range t.Header {
+		measure(i, h)
+	}
+	for _, row := range t.Rows {
+		for i, cell := range row {
+			measure(i, formatCell(cell))
+		}
+	}
+	return widths
+}
+
+// --- PDF ---
+
+// Page geometry in points: landscape A4 with 40pt margins.
+const (
+	pdfPageWidth  = 842
+	pdfPageHeight = 595
+	pdfMargin     = 40
+	pdfFontSize   = 8
+	pdfLineHeight = 13
+)
+
+// pdfWriter lays out text on pages. Font F1 is Helvetica and F2 Helvetica-Bold.
+type pdfWriter struct {
+	pages []*bytes.Buffer
+	y     float64
+}
+
+func (p *pdfWriter) newPage() {
+	p.pages = append(p.pages, &bytes.Buffer{})
+	p.y = pdfPageHeight - pdfMargin
+}
+
+// ensure starts a new page unless height points are left on this one.
+func (p *pdfWriter) ensure(height float64) {
+	if len(p.pages) == 0 || p.y-height < pdfMargin {
+		p.newPage()
+	}
+}
+
+// text prints s with its baseline at (x, p.y).
+func (p *pdfWriter) text(x float64, s string, bold bool, size float64) {
+	font := "F1"
+	if bold {
+		font = "F2"
+	}
+	fmt.Fprintf(p.pages[len(p.pages)-1], "BT /%s %g Tf %g %g Td (%s) Tj ET\n", font, size, x, p.y, pdfString(s))
+}
+
+// renderPDF writes the title and each table, breaking pages as needed and
+// repeating a table's header on every page it spans. Cells that do not fit
+// their column are cut short.
+func renderPDF(w io.Writer, title string, tables []reportTable) error {
+	p := &pdfWriter{}
+	p.ensure(0)
+	p.y -= 16
+	p.text(pdfMargin, title, true, 16)
+	p.y -= 12
+
+	usable := float64(pdfPageWidth - 2*pdfMargin)
+	for _, t := range tables {
+		widths := columnWidths(t, 50)
+		total := 0
+		for _, width := range widths {
+			total += width + 2
+		}
+		xs := make([]float64, len(widths)+1)
+		xs[0] = pdfMargin
+		for i, width := range widths {
+			xs[i+1] = xs[i] + usable*float64(width+2)/float64(total)
+		}
+		// Helvetica averages about half an em per character.
+		fits := func(i int) int { return int((xs[i+1] - xs[i] - 4) / (pdfFontSize * 0.5)) }
+		line := func(cells []string, bold bool) {
+			for i, cell := range cells {
+				if i < len(widths) {
+					p.text(xs[i], truncateRunes(cell, fits(i)), bold, pdfFontSize)
+				}
+			}
+		}
+
+		p.ensure(2*pdfLineHeight + 20)
+		p.y -= 20
+		p.text(pdfMargin, t.Title, true, 12)
+		p.y -= pdfLineHeight
+		line(t.Header, true)
+		for _, row := range t.Rows {
+			if p.y-pdfLineHeight < pdfMargin {
+				p.newPage()
+				line(t.Header, true)
+			}
+			p.y -= pdfLineHeight
+			cells := make([]string, len(row))
+			for i, cell := range row {
+				cells[i] = formatCell(cell)
+			}
+			line(cells, false)
+		}
+	}
+
+	// Objects: 1 catalog, 2 page tree, 3 and 4 fonts, then a page and its
+	// content stream for every page.
+	var objects []string
+	kids := make([]string, len(p.pages))
+	for i := range p.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 5+2*i)
+	}
+	objects = append(objects,
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(p.pages)),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>",
+	)
+	for i, content := range p.pages {
+		objects = append(objects,
+			fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>", pdfPageWidth, pdfPageHeight, 6+2*i),
+			fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", content.Len(), content.String()),
+		)
+	}
+
+	var out bytes.Buffer
+	out.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	offsets := make([]int, len(objects))
+	for i, object := range objects {
+		offsets[i] = out.Len()
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	_, err := out.WriteTo(w)
+	return err
+}
+
+// pdfString encodes s as the body of a PDF string in WinAnsiEncoding, which
+// matches Latin-1 for the characters kept.
+func pdfString(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r >= 0x20 && r < 0x7f:
+			b.WriteRune(r)
+		case r >= 0xa0 && r <= 0xff:
+			b.WriteByte(byte(r))
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
+
+// truncateRunes cuts s to at most n characters, marking the cut with "...".
+func truncateRunes(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	if n <= 3 {
+		return string([]rune(s)[:max(n, 0)])
+	}
+	return string([]rune(s)[:n-3]) + "..."
+}
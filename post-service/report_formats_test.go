@@ -0,0 +1,65 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"io"
+	"strings"
+	"testing"
+)
+
+var formulaTables = []reportTable{{
+	Title:  "Top posts",
+	Header: []string{"Rank", "Content"},
+	Rows: [][]interface{}{
+		{int64(1), "=HYPERLINK(\"http://evil.example\",\"click\")"},
+		{int64(-2), "@SUM(A1:A2)"},
+		{int64(3), "plain caption"},
+	},
+}}
+
+func TestRenderCSVEscapesFormulas(t *testing.T) {
+	var buf bytes.Buffer
+	if err := renderCSV(&buf, "Report", formulaTables); err != nil {
+		t.Fatal(err)
+	}
+	r := csv.NewReader(&buf)
+	r.FieldsPerRecord = -1
+	records, err := r.ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := records[len(records)-3:]
+	want := [][]string{
+		{"1", "'=HYPERLINK(\"http://evil.example\",\"click\")"},
+		{"-2", "'@SUM(A1:A2)"},
+		{"3", "plain caption"},
+	}
+	for i := range want {
+		if strings.Join(rows[i], "|") != strings.Join(want[i], "|") {
+			t.Errorf("row %d = %q; want %q", i, rows[i], want[i])
+		}
+	}
+}
+
+func TestRenderXLSXMarksFormulasAsText(t *testing.T) {
+	var buf bytes.Buffer
+	if err := renderXLSX(&buf, "Report", formulaTables); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := zr.Open("xl/worksheets/sheet1.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sheet, _ := io.ReadAll(f)
+	for _, cell := range []string{`<c r="B4" t="inlineStr" s="3">`, `<c r="B5" t="inlineStr" s="3">`, `<c r="B6" t="inlineStr" s="0">`, `<c r="A5" s="0"><v>-2</v>`} {
+		if !strings.Contains(string(sheet), cell) {
+			t.Errorf("sheet lacks %s:\n%s", cell, sheet)
+		}
+	}
+}
@@ -0,0 +1,660 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// --- Reports ---
+//
+// A report summarizes a tenant's analytics over a date range: totals and
+// follower growth per platform, and the posts that gained the most engagement.
+// Reports are rendered as CSV, XLSX or PDF by a background worker, because a
+// busy tenant's report can take longer than an HTTP request should. Each row of
+// reports is also the job that renders it; the report worker claims queued
+// jobs with FOR UPDATE SKIP LOCKED, so every replica can run one. Finished
+// reports are kept in media storage, next to the media library, and handed out
+// as expiring download links until cfg.Reports.Retention has passed.
+
+// Report job states.
+const (
+	reportQueued     = "queued"
+	reportProcessing = "processing"
+	reportReady      = "ready"
+	reportFailed     = "failed"
+)
+
+const (
+	// reportMaxAttempts is how often a job interrupted by a dying replica is retried.
+	reportMaxAttempts = 3
+	// reportLinkTTL is how long the download links in API responses stay valid.
+	reportLinkTTL = time.Hour
+	// reportTopPosts is how many posts a report ranks.
+	reportTopPosts = 10
+)
+
+var errReportNotFound = errors.New("report not found")
+
+// reportFormats lists the formats reports are rendered in.
+var reportFormats = map[string]struct {
+	contentType string
+	ext         string
+	render      func(w io.Writer, title string, tables []reportTable) error
+}{
+	"csv":  {"text/csv", ".csv", renderCSV},
+	"xlsx": {"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", ".xlsx", renderXLSX},
+	"pdf":  {"application/pdf", ".pdf", renderPDF},
+}
+
+// Report is a requested report and the state of the job rendering it.
+type Report struct {
+	ID        string    `json:"id"`
+	Format    string    `json:"format"`
+	From      string    `json:"from"`
+	To        string    `json:"to"`
+	Status    string    `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	SizeBytes int64     `json:"sizeBytes,omitempty"`
+	CreatedBy string    `json:"createdBy"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	// DownloadURL is a short-lived link, filled in for ready reports.
+	DownloadURL string `json:"downloadUrl,omitempty"`
+	tenantID    string
+	storageKey  string
+}
+
+// ReportData is what a report shows.
+type ReportData struct {
+	From      time.Time
+	To        time.Time
+	Platforms []PlatformSummary
+	TopPosts  []TopPost
+}
+
+// PlatformSummary is a platform's part of a report. Metrics are what the
+// tenant's posts gained in the range; followers are counted on its first and
+// last day.
+type PlatformSummary struct {
+	Platform       string
+	Posts          int64
+	FollowersStart int64
+	FollowersEnd   int64
+	PostMetrics
+}
+
+// TopPost is one of the posts that gained the most engagement in a report's range.
+type TopPost struct {
+	PostID         string
+	Platform       string
+	PlatformUserID string
+	Content        string
+	PostedAt       time.Time
+	PostMetrics
+}
+
+// Engagement is the number of likes, comments and shares.
+func (m PostMetrics) Engagement() int64 {
+	return m.Likes + m.Comments + m.Shares
+}
+
+// --- Database Operations ---
+
+const reportColumns = "id, tenant_id, user_id, format, from_day, to_day, status, COALESCE(error, ''), COALESCE(size_bytes, 0), COALESCE(storage_key, ''), created_at, updated_at"
+
+func scanReport(row interface{ Scan(...interface{}) error }) (Report, error) {
+	var r Report
+	var from, to time.Time
+	err := row.Scan(&r.ID, &r.tenantID, &r.CreatedBy, &r.Format, &from, &to, &r.Status, &r.Error, &r.SizeBytes, &r.storageKey, &r.CreatedAt, &r.UpdatedAt)
+	r.From, r.To = from.Format("2006-01-02"), to.Format("2006-01-02")
+	return r, err
+}
+
+func getReport(ctx context.Context, tenantID, id string) (Report, error) {
+	r, err := scanReport(db.QueryRowContext(ctx, "SELECT "+reportColumns+" FROM reports WHERE id = $1 AND tenant_id = $2", id, tenantID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return r, errReportNotFound
+	}
+	if err != nil {
+		return r, fmt.Errorf("failed to get report: %w", err)
+	}
+	return r, nil
+}
+
+// claimReport claims the oldest queued report.
+func claimReport(ctx context.Context) (Report, bool, error) {
+	r, err := scanReport(db.QueryRowContext(ctx, `
+		UPDATE reports SET status = $1, attempts = attempts + 1, claimed_at = now(), updated_at = now()
+		WHERE id = (
+			SELECT id FROM reports WHERE status = $2 ORDER BY created_at LIMIT 1 FOR UPDATE SKIP LOCKED
+		)
+		RETURNING `+reportColumns,
+		reportProcessing, reportQueued,
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return r, false, nil
+	}
+	if err != nil {
+		return r, false, fmt.Errorf("failed to claim report: %w", err)
+	}
+	return r, true, nil
+}
+
+// releaseStaleReports requeues reports whose replica stopped working on them,
+// and fails those that were interrupted too often.
+func releaseStaleReports(ctx context.Context, maxAge time.Duration) error {
+	_, err := db.ExecContext(ctx, `
+		UPDATE reports
+		SET status = CASE WHEN attempts < $3 THEN $2 ELSE $4 END,
+			error = CASE WHEN attempts < $3 THEN NULL ELSE 'rendering was interrupted too often' END,
+			updated_at = now()
+		WHERE status = $1 AND claimed_at < $5`,
+		reportProcessing, reportQueued, reportMaxAttempts, reportFailed, time.Now().Add(-maxAge),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to release stale reports: %w", err)
+	}
+	return nil
+}
+
+func finishReport(ctx context.Context, id, storageKey string, size int64) error {
+	_, err := db.ExecContext(ctx, "UPDATE reports SET status = $2, error = NULL, storage_key = $3, size_bytes = $4, updated_at = now() WHERE id = $1",
+		id, reportReady, storageKey, size)
+	if err != nil {
+		return fmt.Errorf("failed to record report: %w", err)
+	}
+	return nil
+}
+
+func failReport(ctx context.Context, id string, cause error) error {
+	if _, err := db.ExecContext(ctx, "UPDATE reports SET status = $2, error = $3, updated_at = now() WHERE id = $1", id, reportFailed, cause.Error()); err != nil {
+		return fmt.Errorf("failed to record report failure: %w", err)
+	}
+	return nil
+}
+
+// postGainsCTE defines gains: what each of the tenant's posts ($1) gained on
+// each day it was collected on, up to day $3.
+const postGainsCTE = `gains AS (
+	SELECT post_id, day, lower(platform) AS platform,
+		impressions - COALESCE(LAG(impressions) OVER w, 0) AS impressions,
+		reach - COALESCE(LAG(reach) OVER w, 0) AS reach,
+		likes - COALESCE(LAG(likes) OVER w, 0) AS likes,
+		comments - COALESCE(LAG(comments) OVER w, 0) AS comments,
+		shares - COALESCE(LAG(shares) OVER w, 0) AS shares
+	FROM post_metrics
+	WHERE tenant_id = $1 AND day <= $3
+	WINDOW w AS (PARTITION BY post_id ORDER BY day)
+)`
+
+// loadReportData gathers what the report r shows.
+func loadReportData(ctx context.Context, r Report) (ReportData, error) {
+	var data ReportData
+	var err error
+	if data.From, err = time.Parse("2006-01-02", r.From); err == nil {
+		data.To, err = time.Parse("2006-01-02", r.To)
+	}
+	if err != nil {
+		return data, fmt.Errorf("invalid report range: %w", err)
+	}
+	summaries := make(map[string]*PlatformSummary)
+	summary := func(platform string) *PlatformSummary {
+		label := platformLabel(platform)
+		if summaries[label] == nil {
+			summaries[label] = &PlatformSummary{Platform: label}
+		}
+		return summaries[label]
+	}
+	for _, platform := range analyticsPlatforms {
+		summary(platform)
+	}
+	args := []interface{}{r.tenantID, r.From, r.To}
+
+	rows, err := db.QueryContext(ctx, `WITH `+postGainsCTE+`
+		SELECT platform, sum(impressions), sum(reach), sum(likes), sum(comments), sum(shares) FROM gains WHERE day >= $2 GROUP BY platform`, args...)
+	if err != nil {
+		return data, fmt.Errorf("failed to total post metrics: %w", err)
+	}
+	for rows.Next() {
+		var platform string
+		var m PostMetrics
+		if err := rows.Scan(&platform, &m.Impressions, &m.Reach, &m.Likes, &m.Comments, &m.Shares); err != nil {
+			rows.Close()
+			return data, fmt.Errorf("failed to scan post metrics: %w", err)
+		}
+		summary(platform).PostMetrics = m
+	}
+	rows.Close()
+
+	// Posted_at is a timestamp; the range includes all of its last day.
+	rows, err = db.QueryContext(ctx, `
+		SELECT lower(platform), count(*) FROM posts
+		WHERE tenant_id = $1 AND status = $4 AND posted_at >= $2::date AND posted_at < $3::date + 1
+		GROUP BY 1`, append(args, statusPublished)...)
+	if err != nil {
+		return data, fmt.Errorf("failed to count published posts: %w", err)
+	}
+	for rows.Next() {
+		var platform string
+		var posts int64
+		if err := rows.Scan(&platform, &posts); err != nil {
+			rows.Close()
+			return data, fmt.Errorf("failed to scan post count: %w", err)
+		}
+		summary(platform).Posts = posts
+	}
+	rows.Close()
+
+	// An account's starting count is its last one on or before the first day,
+	// or its first one in the range if it was connected later.
+	rows, err = db.QueryContext(ctx, `
+		WITH accounts AS (
+			SELECT platform_user_id, min(lower(platform)) AS platform FROM account_metrics WHERE tenant_id = $1 AND day <= $3 GROUP BY 1
+		)
+		SELECT a.platform,
+			sum(COALESCE(
+				(SELECT followers FROM account_metrics m WHERE m.tenant_id = $1 AND m.platform_user_id = a.platform_user_id AND m.day <= $2 ORDER BY m.day DESC LIMIT 1),
+				(SELECT followers FROM account_metrics m WHERE m.tenant_id = $1 AND m.platform_user_id = a.platform_user_id AND m.day <= $3 ORDER BY m.day LIMIT 1))),
+			sum((SELECT followers FROM account_metrics m WHERE m.tenant_id = $1 AND m.platform_user_id = a.platform_user_id AND m.day <= $3 ORDER BY m.day DESC LIMIT 1))
+		FROM accounts a GROUP BY 1`, args...)
+	if err != nil {
+		return data, fmt.Errorf("failed to total followers: %w", err)
+	}
+	for rows.Next() {
+		var platform string
+		var start, end int64
+		if err := rows.Scan(&platform, &start, &end); err != nil {
+			rows.Close()
+			return data, fmt.Errorf("failed to scan followers: %w", err)
+		}
+		s := summary(platform)
+		s.FollowersStart, s.FollowersEnd = start, end
+	}
+	rows.Close()
+
+	rows, err = db.QueryContext(ctx, `WITH `+postGainsCTE+`
+		SELECT p.id, p.platform, COALESCE(p.platform_user_id, ''), p.content, p.posted_at,
+			sum(g.impressions), sum(g.reach), sum(g.likes), sum(g.comments), sum(g.shares)
+		FROM gains g JOIN posts p ON p.id = g.post_id
+		WHERE g.day >= $2
+		GROUP BY p.id
+		ORDER BY sum(g.likes + g.comments + g.shares) DESC, sum(g.impressions) DESC, p.id
+		LIMIT $4`, append(args, reportTopPosts)...)
+	if err != nil {
+		return data, fmt.Errorf("failed to rank posts: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var p TopPost
+		var postedAt sql.NullTime
+		if err := rows.Scan(&p.PostID, &p.Platform, &p.PlatformUserID, &p.Content, &postedAt,
+			&p.Impressions, &p.Reach, &p.Likes, &p.Comments, &p.Shares); err != nil {
+			return data, fmt.Errorf("failed to scan top post: %w", err)
+		}
+		p.Platform, p.PostedAt = platformLabel(p.Platform), postedAt.Time
+		data.TopPosts = append(data.TopPosts, p)
+	}
+	if err := rows.Err(); err != nil {
+		return data, fmt.Errorf("failed to rank posts: %w", err)
+	}
+
+	for _, platform := range analyticsPlatforms {
+		data.Platforms = append(data.Platforms, *summaries[platform])
+		delete(summaries, platform)
+	}
+	for _, s := range summaries {
+		data.Platforms = append(data.Platforms, *s)
+	}
+	return data, nil
+}
+
+// --- Rendering ---
+
+// reportTable is a titled table of a report. Cells are strings, int64s or
+// float64s; a nil cell is left empty.
+type reportTable struct {
+	Title  string
+	Header []string
+	Rows   [][]interface{}
+}
+
+// title names a report after its range.
+func (d ReportData) title() string {
+	return fmt.Sprintf("Analytics report %s to %s", d.From.Format("2006-01-02"), d.To.Format("2006-01-02"))
+}
+
+// tables lays out a report's data the same way for every format.
+func (d ReportData) tables() []reportTable {
+	platforms := reportTable{
+		Title:  "Totals by platform",
+		Header: []string{"Platform", "Posts published", "Impressions", "Reach", "Likes", "Comments", "Shares", "Engagement", "Followers", "Follower growth", "Growth %"},
+	}
+	row := func(s PlatformSummary) []interface{} {
+		var growth interface{}
+		if s.FollowersStart > 0 {
+			growth = float64(s.FollowersEnd-s.FollowersStart) * 100 / float64(s.FollowersStart)
+		}
+		return []interface{}{
+			s.Platform, s.Posts, s.Impressions, s.Reach, s.Likes, s.Comments, s.Shares, s.Engagement(),
+			s.FollowersEnd, s.FollowersEnd - s.FollowersStart, growth,
+		}
+	}
+	total := PlatformSummary{Platform: "All platforms"}
+	for _, s := range d.Platforms {
+		platforms.Rows = append(platforms.Rows, row(s))
+		total.Posts += s.Posts
+		total.Impressions += s.Impressions
+		total.Reach += s.Reach
+		total.Likes += s.Likes
+		total.Comments += s.Comments
+		total.Shares += s.Shares
+		total.FollowersStart += s.FollowersStart
+		total.FollowersEnd += s.FollowersEnd
+	}
+	platforms.Rows = append(platforms.Rows, row(total))
+
+	top := reportTable{
+		Title:  fmt.Sprintf("Top %d posts by engagement", reportTopPosts),
+		Header: []string{"Rank", "Platform", "Account", "Published", "Content", "Impressions", "Reach", "Likes", "Comments", "Shares", "Engagement"},
+	}
+	for i, p := range d.TopPosts {
+		top.Rows = append(top.Rows, []interface{}{
+			int64(i + 1), p.Platform, p.PlatformUserID, p.PostedAt.Format("2006-01-02"), strings.Join(strings.Fields(p.Content), " "),
+			p.Impressions, p.Reach, p.Likes, p.Comments, p.Shares, p.Engagement(),
+		})
+	}
+	return []reportTable{platforms, top}
+}
+
+// formatCell renders a cell as text; percentages are the only floats.
+func formatCell(cell interface{}) string {
+	switch v := cell.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return fmt.Sprintf("%.1f", v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// --- Report Worker ---
+
+// ReportWorker claims report jobs and renders them.
+type ReportWorker struct {
+	interval time.Duration
+	timeout  time.Duration
+}
+
+func startReportWorkers() {
+	for i := 0; i < cfg.Reports.Workers; i++ {
+		w := &ReportWorker{interval: cfg.Reports.PollInterval, timeout: cfg.Reports.JobTimeout}
+		go w.Run(context.Background())
+	}
+	go runReportJanitor(context.Background(), time.Hour)
+	log.Printf("Report worker started (%d worker(s))", cfg.Reports.Workers)
+}
+
+// Run works through queued jobs until ctx is cancelled.
+func (w *ReportWorker) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		if err := releaseStaleReports(ctx, w.timeout); err != nil {
+			log.Print(err)
+		}
+		for ctx.Err() == nil {
+			r, ok, err := claimReport(ctx)
+			if err != nil {
+				log.Print(err)
+			}
+			if !ok {
+				break
+			}
+			w.process(ctx, r)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (w *ReportWorker) process(ctx context.Context, r Report) {
+	key, size, err := w.render(ctx, r)
+	if err == nil {
+		err = finishReport(ctx, r.ID, key, size)
+	} else {
+		log.Printf("Failed to render report %s: %v", r.ID, err)
+		err = failReport(ctx, r.ID, err)
+	}
+	if err != nil {
+		log.Print(err)
+	}
+}
+
+// render renders a report and stores it, returning its storage key and size.
+func (w *ReportWorker) render(ctx context.Context, r Report) (string, int64, error) {
+	format, ok := reportFormats[r.Format]
+	if !ok {
+		return "", 0, fmt.Errorf("unknown report format %q", r.Format)
+	}
+	ctx, cancel := context.WithTimeout(ctx, w.timeout)
+	defer cancel()
+	data, err := loadReportData(ctx, r)
+	if err != nil {
+		return "", 0, err
+	}
+	var buf bytes.Buffer
+	if err := format.render(&buf, data.title(), data.tables()); err != nil {
+		return "", 0, fmt.Errorf("failed to render %s: %w", r.Format, err)
+	}
+	key := fmt.Sprintf("%s/reports/%s%s", r.tenantID, r.ID, format.ext)
+	if err := mediaStore.Put(ctx, key, bytes.NewReader(buf.Bytes()), int64(buf.Len()), format.contentType); err != nil {
+		return "", 0, fmt.Errorf("failed to store report: %w", err)
+	}
+	return key, int64(buf.Len()), nil
+}
+
+// deleteReport deletes an expired report. Deliveries still waiting for it
+// fail with it, since claimDelivery never picks up a delivery without its
+// report.
+func deleteReport(ctx context.Context, id string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE report_deliveries SET status = $2, last_error = 'the report expired before it was delivered', updated_at = now()
+		WHERE report_id = $1 AND status IN ($3, $4)`,
+		id, deliveryFailed, deliveryPending, deliveryDelivering,
+	); err != nil {
+		return fmt.Errorf("failed to fail deliveries: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM reports WHERE id = $1", id); err != nil {
+		return fmt.Errorf("failed to delete report: %w", err)
+	}
+	return tx.Commit()
+}
+
+// runReportJanitor deletes reports older than cfg.Reports.Retention every interval.
+func runReportJanitor(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		rows, err := db.QueryContext(ctx, "SELECT id, COALESCE(storage_key, '') FROM reports WHERE created_at < $1 AND status <> $2",
+			time.Now().Add(-cfg.Reports.Retention), reportProcessing)
+		if err != nil {
+			log.Printf("Failed to list expired reports: %v", err)
+		} else {
+			expired := make(map[string]string)
+			for rows.Next() {
+				var id, key string
+				if rows.Scan(&id, &key) == nil {
+					expired[id] = key
+				}
+			}
+			rows.Close()
+			for id, key := range expired {
+				if key != "" {
+					if err := mediaStore.Delete(ctx, key); err != nil {
+						log.Printf("Failed to delete expired report %s: %v", id, err)
+						continue
+					}
+				}
+				if err := deleteReport(ctx, id); err != nil {
+					log.Printf("Failed to delete expired report %s: %v", id, err)
+				}
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// --- Handlers ---
+
+func writeReportError(w http.ResponseWriter, action string, err error) {
+	if errors.Is(err, errReportNotFound) {
+		http.Error(w, "Report not found", http.StatusNotFound)
+		return
+	}
+	log.Printf("Failed to %s: %v", action, err)
+	http.Error(w, "Failed to "+action, http.StatusInternalServerError)
+}
+
+// withDownloadURL fills in a link to a ready report.
+func (r Report) withDownloadURL() (Report, error) {
+	if r.Status != reportReady || r.storageKey == "" {
+		return r, nil
+	}
+	var err error
+	r.DownloadURL, err = mediaStore.URL(r.storageKey, reportLinkTTL)
+	return r, err
+}
+
+func writeReport(w http.ResponseWriter, status int, r Report) {
+	r, err := r.withDownloadURL()
+	if err != nil {
+		writeReportError(w, "link report", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(r)
+}
+
+// createReportHandler handles POST /api/reports: {"format": "csv|xlsx|pdf",
+// "from": "2006-01-02", "to": "2006-01-02"}. It queues the report and answers
+// 202; poll GET /api/reports/{reportId} until it is ready.
+func createReportHandler(w http.ResponseWriter, r *http.Request) {
+	userID, tenantID, err := getUserIDAndTenantIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var req struct {
+		Format string `json:"format"`
+		From   string `json:"from"`
+		To     string `json:"to"`
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, 1<<16)
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req.Format = strings.ToLower(req.Format)
+	if _, ok := reportFormats[req.Format]; !ok {
+		http.Error(w, "format must be \"csv\", \"xlsx\" or \"pdf\"", http.StatusBadRequest)
+		return
+	}
+	from, errFrom := time.Parse("2006-01-02", req.From)
+	to, errTo := time.Parse("2006-01-02", req.To)
+	switch {
+	case errFrom != nil || errTo != nil:
+		http.Error(w, "from and to must be dates like 2006-01-02", http.StatusBadRequest)
+		return
+	case to.Before(from):
+		http.Error(w, "from must not be after to", http.StatusBadRequest)
+		return
+	case int(to.Sub(from).Hours()/24)+1 > maxAnalyticsDays:
+		http.Error(w, fmt.Sprintf("the date range may span at most %d days", maxAnalyticsDays), http.StatusBadRequest)
+		return
+	}
+
+	report, err := scanReport(db.QueryRowContext(r.Context(), `
+		INSERT INTO reports (id, tenant_id, user_id, format, from_day, to_day, status) VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING `+reportColumns,
+		fmt.Sprintf("report-%d", time.Now().UnixNano()), tenantID, userID, req.Format, req.From, req.To, reportQueued,
+	))
+	if err != nil {
+		writeReportError(w, "create report", err)
+		return
+	}
+	writeReport(w, http.StatusAccepted, report)
+}
+
+// listReportsHandler lists the tenant's reports, newest first.
+func listReportsHandler(w http.ResponseWriter, r *http.Request) {
+	_, tenantID, err := getUserIDAndTenantIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	rows, err := db.QueryContext(r.Context(), "SELECT "+reportColumns+" FROM reports WHERE tenant_id = $1 ORDER BY created_at DESC LIMIT 100", tenantID)
+	if err != nil {
+		writeReportError(w, "list reports", err)
+		return
+	}
+	defer rows.Close()
+	reports := []Report{}
+	for rows.Next() {
+		report, err := scanReport(rows)
+		if err == nil {
+			report, err = report.withDownloadURL()
+		}
+		if err != nil {
+			writeReportError(w, "list reports", err)
+			return
+		}
+		reports = append(reports, report)
+	}
+	if err := rows.Err(); err != nil {
+		writeReportError(w, "list reports", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reports)
+}
+
+func getReportHandler(w http.ResponseWriter, r *http.Request) {
+	_, tenantID, err := getUserIDAndTenantIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	report, err := getReport(r.Context(), tenantID, mux.Vars(r)["reportId"])
+	if err != nil {
+		writeReportError(w, "get report", err)
+		return
+	}
+	writeReport(w, http.StatusOK, report)
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// --- Publishing Worker ---
+const (
+	schedulerPollInterval = 15 * time.Second
+	// publishTimeout bounds the publishing of a single post, from locating its
+	// media to the platform's answer.
+	publishTimeout = 2 * time.Minute
+	// staleClaimAfter is how long a post may sit in "publishing" before we assume
+	// the replica that claimed it died. Such posts are failed, never retried, so a
+	// post that may already be live on the platform is not published twice.
+	// Posts are claimed one at a time, so a live claim is never older than
+	// publishTimeout; this must stay well above it.
+	staleClaimAfter = 15 * time.Minute
+	// processingCheckInterval is how often a post the platform is still
+	// processing is checked again, and processingTimeout how long it may take
+	// before it is failed.
+	processingCheckInterval = time.Minute
+	processingTimeout       = 24 * time.Hour
+	processingBatchSize     = 20
+)
+
+// Scheduler periodically claims due posts and hands them to the matching publisher.
+// Several replicas may run a Scheduler against the same database: posts are claimed
+// with FOR UPDATE SKIP LOCKED and moved to "publishing" in the same statement, so
+// each post is handed to exactly one worker. A worker claims the next post only
+// when it has finished the previous one, so no claim waits behind other posts.
+//
+// Some platforms (TikTok) accept a post and process it asynchronously. Such a
+// post stays "publishing" with its external ID recorded, and the worker asks
+// the platform's Status until the post is live or has failed.
+type Scheduler struct {
+	publishers *PublisherRegistry
+	tokens     TokenSource
+	interval   time.Duration
+}
+
+func newScheduler(publishers *PublisherRegistry, tokens TokenSource) *Scheduler {
+	return &Scheduler{
+		publishers: publishers,
+		tokens:     tokens,
+		interval:   schedulerPollInterval,
+	}
+}
+
+// Run polls for due posts until ctx is cancelled.
+func (s *Scheduler) Run(ctx context.Context) {
+	log.Printf("Publishing worker started (interval %s)", s.interval)
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		s.tick(ctx)
+		select {
+		case <-ctx.Done():
+			log.Println("Publishing worker stopped.")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Scheduler) tick(ctx context.Context) {
+	if n, err := failStaleClaims(ctx, staleClaimAfter); err != nil {
+		log.Printf("Failed to release stale claims: %v", err)
+	} else if n > 0 {
+		log.Printf("Marked %d interrupted post(s) as failed", n)
+	}
+	s.checkProcessing(ctx)
+	for ctx.Err() == nil {
+		post, ok, err := claimDuePost(ctx)
+		if err != nil {
+			log.Printf("Failed to claim a due post: %v", err)
+			return
+		}
+		if !ok {
+			return
+		}
+		s.publish(ctx, post)
+	}
+}
+
+func (s *Scheduler) publish(ctx context.Context, post Post) {
+	publisher, ok := s.publishers.Get(post.Platform)
+	if !ok {
+		s.finish(ctx, post, "", fmt.Errorf("no publisher registered for platform %q", post.Platform))
+		return
+	}
+	if post.PlatformUserID == "" {
+		s.finish(ctx, post, "", fmt.Errorf("post has no target account"))
+		return
+	}
+	publishCtx, cancel := context.WithTimeout(ctx, publishTimeout)
+	defer cancel()
+	if post.MediaID != "" {
+		// The platform fetches the media itself, from a link that outlives the call.
+		mediaURL, err := mediaDownloadURL(publishCtx, post.TenantID, post.MediaID, post.Platform)
+		if err != nil {
+			s.finish(ctx, post, "", fmt.Errorf("failed to locate media: %w", err))
+			return
+		}
+		post.MediaURL = mediaURL
+	}
+	accessToken, err := s.tokens.AccessToken(publishCtx, post.TenantID, post.PlatformUserID)
+	if err != nil {
+		s.finish(ctx, post, "", fmt.Errorf("failed to obtain access token: %w", err))
+		return
+	}
+	account := PlatformAccount{PlatformUserID: post.PlatformUserID, AccessToken: accessToken}
+	externalID, err := publisher.Publish(publishCtx, account, post)
+	if err != nil {
+		s.finish(ctx, post, "", err)
+		return
+	}
+	status, err := publisher.Status(publishCtx, account, externalID)
+	if err != nil {
+		log.Printf("Failed to check the status of post %s on %s: %v", post.ID, post.Platform, err)
+	}
+	if err != nil || status.State == publishStateDeleted {
+		// The post was accepted, and a platform may not list it right away; its
+		// state is checked again later.
+		status = PublishStatus{State: publishStateProcessing}
+	}
+	s.settle(ctx, post, externalID, status)
+}
+
+// settle records what the platform reports about a post it has accepted.
+func (s *Scheduler) settle(ctx context.Context, post Post, externalID string, status PublishStatus) {
+	switch status.State {
+	case publishStateFailed, publishStateDeleted:
+		reason := "the platform did not publish the post"
+		if status.Detail != "" {
+			reason += ": " + status.Detail
+		}
+		s.finish(ctx, post, "", errors.New(reason))
+	case publishStateProcessing:
+		if err := markPostProcessing(ctx, post.ID, externalID); err != nil {
+			log.Printf("Post %s was accepted by %s as %s but could not be updated: %v", post.ID, post.Platform, externalID, err)
+		}
+	default:
+		s.finish(ctx, post, externalID, nil)
+	}
+}
+
+// checkProcessing asks the platforms about posts they are still processing.
+func (s *Scheduler) checkProcessing(ctx context.Context) {
+	posts, err := claimProcessingPosts(ctx, processingCheckInterval, processingBatchSize)
+	if err != nil {
+		log.Printf("Failed to claim processing posts: %v", err)
+		return
+	}
+	for _, post := range posts {
+		if time.Since(post.UpdatedAt) > processingTimeout {
+			s.finish(ctx, post, "", fmt.Errorf("the platform did not finish processing the post within %s", processingTimeout))
+			continue
+		}
+		publisher, ok := s.publishers.Get(post.Platform)
+		if !ok {
+			continue
+		}
+		checkCtx, cancel := context.WithTimeout(ctx, publishTimeout)
+		status, err := s.status(checkCtx, publisher, post)
+		cancel()
+		if err != nil {
+			log.Printf("Failed to check the status of post %s on %s: %v", post.ID, post.Platform, err)
+			continue
+		}
+		if status.State != publishStateProcessing {
+			s.settle(ctx, post, post.ExternalID, status)
+		}
+	}
+}
+
+func (s *Scheduler) status(ctx context.Context, publisher Publisher, post Post) (PublishStatus, error) {
+	accessToken, err := s.tokens.AccessToken(ctx, post.TenantID, post.PlatformUserID)
+	if err != nil {
+		return PublishStatus{}, fmt.Errorf("failed to obtain access token: %w", err)
+	}
+	return publisher.Status(ctx, PlatformAccount{PlatformUserID: post.PlatformUserID, AccessToken: accessToken}, post.ExternalID)
+}
+
+func (s *Scheduler) finish(ctx context.Context, post Post, externalID string, publishErr error) {
+	if publishErr != nil {
+		log.Printf("Failed to publish post %s to %s: %v", post.ID, post.Platform, publishErr)
+		if err := markPostFailed(ctx, post.ID, publishErr.Error()); err != nil {
+			log.Printf("Failed to record failure for post %s: %v", post.ID, err)
+		}
+		return
+	}
+	if err := markPostPublished(ctx, post.ID, externalID); err != nil {
+		log.Printf("Post %s was published as %s but could not be updated: %v", post.ID, externalID, err)
+		return
+	}
+	log.Printf("Published post %s to %s as %s", post.ID, post.Platform, externalID)
+}
+
+// --- Database Operations ---
+
+// claimDuePost atomically moves the most overdue post from "scheduled" to
+// "publishing" and returns it, or false if no post is due. Rows locked by
+// another replica are skipped, and so are posts that were never approved.
+func claimDuePost(ctx context.Context) (Post, bool, error) {
+	var post Post
+	err := db.QueryRowContext(ctx, `
+		WITH claimed AS (
+			UPDATE posts SET status = $1, claimed_at = now(), external_id = NULL, last_error = NULL, version = version + 1, updated_at = now()
+			WHERE id IN (
+				SELECT id FROM posts
+				WHERE status = $2 AND approved_at IS NOT NULL AND scheduled_at <= now()
+				ORDER BY scheduled_at
+				LIMIT 1
+				FOR UPDATE SKIP LOCKED
+			)
+			RETURNING id, user_id, tenant_id, platform, COALESCE(platform_user_id, '') AS platform_user_id, content, COALESCE(media_url, '') AS media_url, COALESCE(media_id, '') AS media_id, scheduled_at, status
+		), logged AS (
+			INSERT INTO post_transitions (post_id, from_status, to_status) SELECT id, $2, $1 FROM claimed
+		)
+		SELECT id, user_id, tenant_id, platform, platform_user_id, content, media_url, media_id, scheduled_at, status FROM claimed`,
+		statusPublishing, statusScheduled,
+	).Scan(&post.ID, &post.UserID, &post.TenantID, &post.Platform, &post.PlatformUserID, &post.Content, &post.MediaURL, &post.MediaID, &post.ScheduledAt, &post.Status)
+	if err == sql.ErrNoRows {
+		return Post{}, false, nil
+	}
+	if err != nil {
+		return Post{}, false, fmt.Errorf("failed to claim post: %w", err)
+	}
+	return post, true, nil
+}
+
+// markPostProcessing records the external ID of a post the platform has
+// accepted but not yet published. The post stays claimed.
+func markPostProcessing(ctx context.Context, postID, externalID string) error {
+	res, err := db.ExecContext(ctx,
+		"UPDATE posts SET external_id = $2, claimed_at = now(), version = version + 1, updated_at = now() WHERE id = $1 AND status = $3",
+		postID, externalID, statusPublishing,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update post: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("post %s is no longer claimed", postID)
+	}
+	return nil
+}
+
+// claimProcessingPosts returns up to limit posts the platform is processing
+// that were last checked more than interval ago, and marks them checked now so
+// other replicas skip them. UpdatedAt of the returned posts is when the
+// platform accepted them.
+func claimProcessingPosts(ctx context.Context, interval time.Duration, limit int) ([]Post, error) {
+	rows, err := db.QueryContext(ctx, `
+		UPDATE posts SET claimed_at = now()
+		WHERE id IN (
+			SELECT id FROM posts
+			WHERE status = $1 AND external_id IS NOT NULL AND claimed_at < $2
+			ORDER BY claimed_at
+			LIMIT $3
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, tenant_id, platform, COALESCE(platform_user_id, ''), external_id, updated_at`,
+		statusPublishing, time.Now().Add(-interval), limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim processing posts: %w", err)
+	}
+	defer rows.Close()
+	var posts []Post
+	for rows.Next() {
+		var post Post
+		if err := rows.Scan(&post.ID, &post.TenantID, &post.Platform, &post.PlatformUserID, &post.ExternalID, &post.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan processing post: %w", err)
+		}
+		posts = append(posts, post)
+	}
+	return posts, rows.Err()
+}
+
+func markPostPublished(ctx context.Context, postID, externalID string) error {
+	return finishPost(ctx, postID, statusPublished, sql.NullString{String: externalID, Valid: externalID != ""}, sql.NullString{})
+}
+
+func markPostFailed(ctx context.Context, postID, reason string) error {
+	return finishPost(ctx, postID, statusFailed, sql.NullString{}, sql.NullString{String: reason, Valid: true})
+}
+
+// finishPost moves a claimed post to its final state and records the transition.
+// The status guard makes the update a no-op if the claim was already released as
+// stale.
+func finishPost(ctx context.Context, postID, status string, externalID, lastError sql.NullString) error {
+	var postedAt interface{}
+	if status == statusPublished {
+		postedAt = time.Now()
+	}
+	res, err := db.ExecContext(ctx, `
+		WITH finished AS (
+			UPDATE posts SET status = $2, posted_at = $3, external_id = $4, last_error = $5, version = version + 1, updated_at = now() WHERE id = $1 AND status = $6 RETURNING id
+		)
+		INSERT INTO post_transitions (post_id, from_status, to_status, comment) SELECT id, $6, $2, $5 FROM finished`,
+		postID, status, postedAt, externalID, lastError, statusPublishing,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update post status: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("post %s is no longer claimed", postID)
+	}
+	return nil
+}
+
+// failStaleClaims fails posts whose publishing claim is older than maxAge. Posts
+// the platform has accepted and is processing are left to checkProcessing.
+func failStaleClaims(ctx context.Context, maxAge time.Duration) (int64, error) {
+	res, err := db.ExecContext(ctx, `
+		WITH failed AS (
+			UPDATE posts SET status = $1, last_error = $2, version = version + 1, updated_at = now() WHERE status = $3 AND external_id IS NULL AND claimed_at < $4 RETURNING id
+		)
+		INSERT INTO post_transitions (post_id, from_status, to_status, comment) SELECT id, $3, $1, $2 FROM failed`,
+		statusFailed, "publishing was interrupted; check the platform before retrying", statusPublishing, time.Now().Add(-maxAge),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to fail stale claims: %w", err)
+	}
+	return res.RowsAffected()
+}
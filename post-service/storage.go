@@ -0,0 +1,355 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// --- Media Storage ---
+//
+// The media library keeps its files in a Storage, chosen by media.storage: a
+// directory on local disk, or a bucket on an S3-compatible service such as AWS
+// S3 or MinIO. The platforms fetch media themselves when a post is published,
+// so a Storage also hands out links to its objects that expire after a while.
+
+var errObjectNotFound = errors.New("object not found")
+
+// Storage keeps media files under keys such as "tenant/checksum.mp4".
+type Storage interface {
+	// Put stores size bytes read from r under key, replacing any existing object.
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Open reads the object stored under key.
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the object stored under key. A missing object is not an error.
+	Delete(ctx context.Context, key string) error
+	// URL returns a link the object can be downloaded from until ttl has passed.
+	URL(key string, ttl time.Duration) (string, error)
+}
+
+var mediaStore Storage
+
+func newStorageFromConfig() (Storage, error) {
+	if cfg.Media.Storage == "s3" {
+		return newS3Storage(cfg.Media.S3)
+	}
+	return newLocalStorage(cfg.Media.LocalDir, strings.TrimRight(cfg.Media.PublicURL, "/")+"/media/files", []byte(cfg.InternalAPISecret))
+}
+
+// --- Local Storage ---
+
+// LocalStorage keeps objects as files below a directory. Its links point at
+// serveFile and are signed, so only holders of a link can download the file.
+type LocalStorage struct {
+	dir     string
+	baseURL string
+	secret  []byte
+}
+
+func newLocalStorage(dir, baseURL string, secret []byte) (*LocalStorage, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create media directory: %w", err)
+	}
+	return &LocalStorage{dir: dir, baseURL: baseURL, secret: secret}, nil
+}
+
+// path maps key to a file, refusing keys that would escape the directory.
+func (s *LocalStorage) path(key string) (string, error) {
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "" || segment == "." || segment == ".." || strings.ContainsRune(segment, '\\') {
+			return "", fmt.Errorf("invalid storage key %q", key)
+		}
+	}
+	return filepath.Join(s.dir, filepath.FromSlash(key)), nil
+}
+
+func (s *LocalStorage) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return fmt.Errorf("failed to create media directory: %w", err)
+	}
+	// Write to a temporary file first so readers never see a partial object.
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".put-*")
+	if err != nil {
+		return fmt.Errorf("failed to create media file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write media file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write media file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to store media file: %w", err)
+	}
+	return nil
+}
+
+func (s *LocalStorage) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, errObjectNotFound
+	}
+	return f, err
+}
+
+func (s *LocalStorage) Delete(ctx context.Context, key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete media file: %w", err)
+	}
+	return nil
+}
+
+func (s *LocalStorage) URL(key string, ttl time.Duration) (string, error) {
+	if _, err := s.path(key); err != nil {
+		return "", err
+	}
+	expires := strconv.FormatInt(time.Now().Add(ttl).Unix(), 10)
+	segments := strings.Split(key, "/")
+	for i := range segments {
+		segments[i] = url.PathEscape(segments[i])
+	}
+	q := url.Values{"expires": {expires}, "signature": {s.sign(key, expires)}}
+	return s.baseURL + "/" + strings.Join(segments, "/") + "?" + q.Encode(), nil
+}
+
+func (s *LocalStorage) sign(key, expires string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	fmt.Fprintf(mac, "media-file\n%s\n%s", key, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// serveFile serves GET /media/files/{key} to holders of a link made by URL.
+func (s *LocalStorage) serveFile(w http.ResponseWriter, r *http.Request) {
+	key := mux.Vars(r)["key"]
+	expires := r.URL.Query().Get("expires")
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	signature := r.URL.Query().Get("signature")
+	if err != nil || time.Now().Unix() > unix || subtle.ConstantTimeCompare([]byte(signature), []byte(s.sign(key, expires))) != 1 {
+		http.Error(w, "Link is invalid or has expired", http.StatusForbidden)
+		return
+	}
+	path, err := s.path(key)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	http.ServeContent(w, r, filepath.Base(path), info.ModTime(), f)
+}
+
+// --- S3 Storage ---
+
+// s3MaxPresignTTL is the longest validity S3 accepts for a presigned link.
+const s3MaxPresignTTL = 7 * 24 * time.Hour
+
+// S3Storage keeps objects in a bucket of an S3-compatible service. Requests
+// are signed with AWS Signature Version 4.
+type S3Storage struct {
+	endpoint  *url.URL
+	region    string
+	bucket    string
+	accessKey string
+	secretKey string
+	pathStyle bool
+	client    *http.Client
+}
+
+func newS3Storage(c S3Config) (*S3Storage, error) {
+	endpoint, err := url.Parse(strings.TrimRight(c.Endpoint, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid S3 endpoint: %w", err)
+	}
+	return &S3Storage{
+		endpoint:  endpoint,
+		region:    c.Region,
+		bucket:    c.Bucket,
+		accessKey: c.AccessKeyID,
+		secretKey: c.SecretAccessKey,
+		pathStyle: c.PathStyle,
+		client:    &http.Client{Timeout: 30 * time.Minute},
+	}, nil
+}
+
+// object returns the host and escaped path of the object stored under key.
+func (s *S3Storage) object(key string) (host, path string) {
+	host, path = s.endpoint.Host, s.endpoint.Path
+	if s.pathStyle {
+		path += "/" + s.bucket
+	} else {
+		host = s.bucket + "." + host
+	}
+	return host, path + "/" + s3Escape(key, false)
+}
+
+func (s *S3Storage) do(ctx context.Context, method, key string, body io.Reader, size int64, contentType string) (*http.Response, error) {
+	host, path := s.object(key)
+	req, err := http.NewRequestWithContext(ctx, method, s.endpoint.Scheme+"://"+host+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+		req.Header.Set("Content-Type", contentType)
+	}
+	now := time.Now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", "UNSIGNED-PAYLOAD")
+	const signedHeaders = "host;x-amz-content-sha256;x-amz-date"
+	canonical := strings.Join([]string{
+		method, path, "",
+		"host:" + host, "x-amz-content-sha256:UNSIGNED-PAYLOAD", "x-amz-date:" + amzDate, "",
+		signedHeaders, "UNSIGNED-PAYLOAD",
+	}, "\n")
+	scope, signature := s.signature(now, canonical)
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", s.accessKey, scope, signedHeaders, signature))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, errObjectNotFound
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("S3 %s %s returned %d: %s", method, key, resp.StatusCode, strings.TrimSpace(string(detail)))
+	}
+	return resp, nil
+}
+
+// signature signs a canonical request made at t and returns its credential scope and signature.
+func (s *S3Storage) signature(t time.Time, canonical string) (string, string) {
+	date := t.Format("20060102")
+	scope := date + "/" + s.region + "/s3/aws4_request"
+	hash := sha256.Sum256([]byte(canonical))
+	stringToSign := "AWS4-HMAC-SHA256\n" + t.Format("20060102T150405Z") + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+	key := []byte("AWS4" + s.secretKey)
+	for _, part := range []string{date, s.region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	return scope, hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3Escape percent-encodes everything but unreserved characters, and '/' unless escapeSlash is set.
+func s3Escape(s string, escapeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' || c == '/' && !escapeSlash {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func (s *S3Storage) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	resp, err := s.do(ctx, http.MethodPut, key, r, size, contentType)
+	if err != nil {
+		return fmt.Errorf("failed to upload media object: %w", err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *S3Storage) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := s.do(ctx, http.MethodGet, key, nil, 0, "")
+	if errors.Is(err, errObjectNotFound) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to download media object: %w", err)
+	}
+	return resp.Body, nil
+}
+
+func (s *S3Storage) Delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, http.MethodDelete, key, nil, 0, "")
+	if errors.Is(err, errObjectNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete media object: %w", err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// URL returns a presigned GET link.
+func (s *S3Storage) URL(key string, ttl time.Duration) (string, error) {
+	if ttl > s3MaxPresignTTL {
+		ttl = s3MaxPresignTTL
+	}
+	host, path := s.object(key)
+	now := time.Now().UTC()
+	scope := now.Format("20060102") + "/" + s.region + "/s3/aws4_request"
+	params := map[string]string{
+		"X-Amz-Algorithm":     "AWS4-HMAC-SHA256",
+		"X-Amz-Credential":    s.accessKey + "/" + scope,
+		"X-Amz-Date":          now.Format("20060102T150405Z"),
+		"X-Amz-Expires":       strconv.Itoa(int(ttl.Seconds())),
+		"X-Amz-SignedHeaders": "host",
+	}
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = s3Escape(name, true) + "=" + s3Escape(params[name], true)
+	}
+	query := strings.Join(pairs, "&")
+	canonical := strings.Join([]string{"GET", path, query, "host:" + host, "", "host", "UNSIGNED-PAYLOAD"}, "\n")
+	_, signature := s.signature(now, canonical)
+	return s.endpoint.Scheme + "://" + host + path + "?" + query + "&X-Amz-Signature=" + signature, nil
+}
@@ -0,0 +1,786 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+	"github.com/robfig/cron/v3"
+)
+
+// --- Report Subscriptions ---
+//
+// A report subscription renders a report on a cron schedule, in the
+// subscription's timezone, and delivers it by email, webhook or to a file
+// (see delivery.go). Each run covers the range_days whole days before the day
+// it runs on. The scheduler claims due subscriptions with FOR UPDATE SKIP
+// LOCKED, queues a report for the report worker and records a delivery, which
+// is both the history entry and the job that sends the report once it is
+// rendered. Failed deliveries are retried with backoff and can be retried by
+// hand once they have given up.
+
+// Delivery states.
+const (
+	deliveryPending    = "pending"
+	deliveryDelivering = "delivering"
+	deliveryDelivered  = "delivered"
+	deliveryFailed     = "failed"
+)
+
+const (
+	// subscriptionPollInterval is how often the scheduler looks for due
+	// subscriptions and deliverable reports.
+	subscriptionPollInterval = 30 * time.Second
+	// deliveryTimeout bounds a single delivery attempt.
+	deliveryTimeout = 2 * time.Minute
+	// deliveryLinkTTL is how long the download links sent to recipients stay valid.
+	deliveryLinkTTL = 72 * time.Hour
+	// maxRecipients is how many addresses an email subscription may have.
+	maxRecipients = 20
+)
+
+// deliveryBackoff is how long to wait after each failed attempt before the
+// next one; a delivery fails for good after len(deliveryBackoff)+1 attempts.
+var deliveryBackoff = []time.Duration{time.Minute, 5 * time.Minute, 30 * time.Minute, 2 * time.Hour}
+
+var (
+	errSubscriptionNotFound = errors.New("report subscription not found")
+	errDeliveryNotFound     = errors.New("report delivery not found")
+)
+
+// ReportSubscription is a report rendered and delivered on a schedule.
+type ReportSubscription struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// Schedule is a standard five-field cron expression, evaluated in Timezone.
+	Schedule   string     `json:"schedule"`
+	Timezone   string     `json:"timezone"`
+	Format     string     `json:"format"`
+	RangeDays  int        `json:"rangeDays"`
+	Method     string     `json:"method"`
+	Recipients []string   `json:"recipients"`
+	WebhookURL string     `json:"webhookUrl,omitempty"`
+	Enabled    bool       `json:"enabled"`
+	NextRunAt  time.Time  `json:"nextRunAt"`
+	LastRunAt  *time.Time `json:"lastRunAt,omitempty"`
+	CreatedBy  string     `json:"createdBy"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	UpdatedAt  time.Time  `json:"updatedAt"`
+	// WebhookSecret signs webhook deliveries. It is only returned when the
+	// subscription is created.
+	WebhookSecret string `json:"webhookSecret,omitempty"`
+	tenantID      string
+}
+
+// Delivery is one scheduled run of a subscription.
+type Delivery struct {
+	ID             string     `json:"id"`
+	SubscriptionID string     `json:"subscriptionId"`
+	ReportID       string     `json:"reportId,omitempty"`
+	From           string     `json:"from"`
+	To             string     `json:"to"`
+	Status         string     `json:"status"`
+	Attempts       int        `json:"attempts"`
+	LastError      string     `json:"lastError,omitempty"`
+	NextAttemptAt  *time.Time `json:"nextAttemptAt,omitempty"`
+	DeliveredAt    *time.Time `json:"deliveredAt,omitempty"`
+	CreatedAt      time.Time  `json:"createdAt"`
+}
+
+// subscriptionRequest is the body of creating or replacing a subscription.
+type subscriptionRequest struct {
+	Name       string   `json:"name"`
+	Schedule   string   `json:"schedule"`
+	Timezone   string   `json:"timezone"`
+	Format     string   `json:"format"`
+	RangeDays  int      `json:"rangeDays"`
+	Method     string   `json:"method"`
+	Recipients []string `json:"recipients"`
+	WebhookURL string   `json:"webhookUrl"`
+	Enabled    *bool    `json:"enabled"`
+}
+
+// validate normalizes the request and returns the parsed schedule and timezone.
+func (req *subscriptionRequest) validate(ctx context.Context) (cron.Schedule, *time.Location, error) {
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > 100 {
+		return nil, nil, errors.New("name is required and may be at most 100 characters")
+	}
+	// The timezone is a field of its own, so a TZ= prefix is not accepted.
+	req.Schedule = strings.TrimSpace(req.Schedule)
+	schedule, err := cron.ParseStandard(req.Schedule)
+	if err != nil || strings.Contains(req.Schedule, "TZ=") {
+		return nil, nil, errors.New("schedule must be a five-field cron expression like \"0 8 * * 1\"")
+	}
+	if req.Timezone == "" {
+		req.Timezone = "UTC"
+	}
+	loc, err := time.LoadLocation(req.Timezone)
+	if err != nil || req.Timezone == "Local" {
+		return nil, nil, fmt.Errorf("unknown timezone %q", req.Timezone)
+	}
+	req.Format = strings.ToLower(req.Format)
+	if _, ok := reportFormats[req.Format]; !ok {
+		return nil, nil, errors.New("format must be \"csv\", \"xlsx\" or \"pdf\"")
+	}
+	if req.RangeDays == 0 {
+		req.RangeDays = 7
+	}
+	if req.RangeDays < 1 || req.RangeDays > maxAnalyticsDays {
+		return nil, nil, fmt.Errorf("rangeDays must be between 1 and %d", maxAnalyticsDays)
+	}
+	req.Method = strings.ToLower(req.Method)
+	if _, ok := deliverers[req.Method]; !ok {
+		methods := make([]string, 0, len(deliverers))
+		for method := range deliverers {
+			methods = append(methods, fmt.Sprintf("%q", method))
+		}
+		sort.Strings(methods)
+		return nil, nil, fmt.Errorf("method must be one of %s", strings.Join(methods, ", "))
+	}
+	recipients := []string{}
+	for _, rcpt := range req.Recipients {
+		if rcpt = strings.TrimSpace(rcpt); rcpt != "" {
+			recipients = append(recipients, rcpt)
+		}
+	}
+	req.Recipients = recipients
+	switch req.Method {
+	case "email":
+		if len(req.Recipients) == 0 || len(req.Recipients) > maxRecipients {
+			return nil, nil, fmt.Errorf("email subscriptions need between 1 and %d recipients", maxRecipients)
+		}
+		for i, rcpt := range req.Recipients {
+			addr, err := mail.ParseAddress(rcpt)
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid recipient %q", rcpt)
+			}
+			req.Recipients[i] = addr.Address
+		}
+		req.WebhookURL = ""
+	case "webhook":
+		u, err := url.Parse(req.WebhookURL)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return nil, nil, errors.New("webhookUrl must be an http or https URL")
+		}
+		// Deliveries check the address again on every connection; this only
+		// reports an internal host early.
+		if _, err := resolveWebhookHost(ctx, u.Hostname()); err != nil {
+			if errors.Is(err, errWebhookAddressNotAllowed) {
+				return nil, nil, errors.New("webhookUrl must point to a public address")
+			}
+			return nil, nil, errors.New("webhookUrl host could not be resolved")
+		}
+		req.Recipients = []string{}
+	default:
+		req.Recipients = []string{}
+		req.WebhookURL = ""
+	}
+	return schedule, loc, nil
+}
+
+// newWebhookSecret makes the secret webhook deliveries are signed with.
+func newWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate webhook secret: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// nextRun is the first time after the given one that schedule fires in loc.
+func nextRun(schedule cron.Schedule, loc *time.Location, after time.Time) time.Time {
+	return schedule.Next(after.In(loc)).UTC()
+}
+
+// reportRange is the range of a run at the given time: the rangeDays whole
+// days before that day in loc.
+func reportRange(at time.Time, loc *time.Location, rangeDays int) (from, to string) {
+	local := at.In(loc)
+	today := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, time.UTC)
+	last := today.AddDate(0, 0, -1)
+	return last.AddDate(0, 0, 1-rangeDays).Format("2006-01-02"), last.Format("2006-01-02")
+}
+
+// --- Database Operations ---
+
+const subscriptionColumns = "id, tenant_id, user_id, name, schedule, timezone, format, range_days, method, recipients, COALESCE(webhook_url, ''), enabled, next_run_at, last_run_at, created_at, updated_at"
+
+func scanSubscription(row interface{ Scan(...interface{}) error }) (ReportSubscription, error) {
+	var s ReportSubscription
+	var lastRun sql.NullTime
+	err := row.Scan(&s.ID, &s.tenantID, &s.CreatedBy, &s.Name, &s.Schedule, &s.Timezone, &s.Format, &s.RangeDays, &s.Method,
+		(*pq.StringArray)(&s.Recipients), &s.WebhookURL, &s.Enabled, &s.NextRunAt, &lastRun, &s.CreatedAt, &s.UpdatedAt)
+	if lastRun.Valid {
+		s.LastRunAt = &lastRun.Time
+	}
+	if s.Recipients == nil {
+		s.Recipients = []string{}
+	}
+	return s, err
+}
+
+func getSubscription(ctx context.Context, q queryRower, tenantID, id string, lock bool) (ReportSubscription, error) {
+	query := "SELECT " + subscriptionColumns + " FROM report_subscriptions WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL"
+	if lock {
+		query += " FOR UPDATE"
+	}
+	s, err := scanSubscription(q.QueryRowContext(ctx, query, id, tenantID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return s, errSubscriptionNotFound
+	}
+	if err != nil {
+		return s, fmt.Errorf("failed to get report subscription: %w", err)
+	}
+	return s, nil
+}
+
+const deliveryColumns = "id, subscription_id, COALESCE(report_id, ''), from_day, to_day, status, attempts, COALESCE(last_error, ''), next_attempt_at, delivered_at, created_at"
+
+func scanDelivery(row interface{ Scan(...interface{}) error }) (Delivery, error) {
+	var d Delivery
+	var from, to, nextAttempt time.Time
+	var delivered sql.NullTime
+	err := row.Scan(&d.ID, &d.SubscriptionID, &d.ReportID, &from, &to, &d.Status, &d.Attempts, &d.LastError, &nextAttempt, &delivered, &d.CreatedAt)
+	d.From, d.To = from.Format("2006-01-02"), to.Format("2006-01-02")
+	if d.Status == deliveryPending {
+		d.NextAttemptAt = &nextAttempt
+	}
+	if delivered.Valid {
+		d.DeliveredAt = &delivered.Time
+	}
+	return d, err
+}
+
+// runDueSubscription starts the run of one due subscription: it queues the
+// report, records the delivery and moves the subscription to its next run.
+// It reports whether there was a due subscription.
+func runDueSubscription(ctx context.Context) (bool, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	s, err := scanSubscription(tx.QueryRowContext(ctx, `
+		SELECT `+subscriptionColumns+` FROM report_subscriptions
+		WHERE enabled AND deleted_at IS NULL AND next_run_at <= now()
+		ORDER BY next_run_at LIMIT 1 FOR UPDATE SKIP LOCKED`))
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to claim report subscription: %w", err)
+	}
+
+	now := time.Now()
+	schedule, errSchedule := cron.ParseStandard(s.Schedule)
+	loc, errLoc := time.LoadLocation(s.Timezone)
+	if errSchedule != nil || errLoc != nil {
+		// Subscriptions are validated when saved; this only happens if the
+		// cron parser or the timezone database changed underneath them.
+		log.Printf("Disabling report subscription %s with invalid schedule %q in %q", s.ID, s.Schedule, s.Timezone)
+		if _, err := tx.ExecContext(ctx, "UPDATE report_subscriptions SET enabled = false, updated_at = now() WHERE id = $1", s.ID); err != nil {
+			return false, fmt.Errorf("failed to disable report subscription: %w", err)
+		}
+		return true, tx.Commit()
+	}
+
+	from, to := reportRange(now, loc, s.RangeDays)
+	reportID := fmt.Sprintf("report-%d", now.UnixNano())
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO reports (id, tenant_id, user_id, format, from_day, to_day, status) VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		reportID, s.tenantID, s.CreatedBy, s.Format, from, to, reportQueued,
+	); err != nil {
+		return false, fmt.Errorf("failed to queue report: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO report_deliveries (id, subscription_id, report_id, from_day, to_day, status) VALUES ($1, $2, $3, $4, $5, $6)`,
+		fmt.Sprintf("delivery-%d", now.UnixNano()), s.ID, reportID, from, to, deliveryPending,
+	); err != nil {
+		return false, fmt.Errorf("failed to record delivery: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE report_subscriptions SET next_run_at = $2, last_run_at = now() WHERE id = $1",
+		s.ID, nextRun(schedule, loc, now)); err != nil {
+		return false, fmt.Errorf("failed to schedule report subscription: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit report subscription run: %w", err)
+	}
+	return true, nil
+}
+
+// claimDelivery claims the pending delivery that has waited longest for a
+// report that has finished rendering, successfully or not.
+func claimDelivery(ctx context.Context) (Delivery, bool, error) {
+	d, err := scanDelivery(db.QueryRowContext(ctx, `
+		UPDATE report_deliveries SET status = $1, attempts = attempts + 1, claimed_at = now(), updated_at = now()
+		WHERE id = (
+			SELECT d.id FROM report_deliveries d JOIN reports r ON r.id = d.report_id
+			WHERE d.status = $2 AND d.next_attempt_at <= now() AND r.status IN ($3, $4)
+			ORDER BY d.next_attempt_at LIMIT 1 FOR UPDATE OF d SKIP LOCKED
+		)
+		RETURNING `+deliveryColumns,
+		deliveryDelivering, deliveryPending, reportReady, reportFailed,
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return d, false, nil
+	}
+	if err != nil {
+		return d, false, fmt.Errorf("failed to claim delivery: %w", err)
+	}
+	return d, true, nil
+}
+
+// releaseStaleDeliveries puts deliveries whose replica stopped working on them
+// back in line.
+func releaseStaleDeliveries(ctx context.Context, maxAge time.Duration) error {
+	_, err := db.ExecContext(ctx, "UPDATE report_deliveries SET status = $2, next_attempt_at = now(), updated_at = now() WHERE status = $1 AND claimed_at < $3",
+		deliveryDelivering, deliveryPending, time.Now().Add(-maxAge))
+	if err != nil {
+		return fmt.Errorf("failed to release stale deliveries: %w", err)
+	}
+	return nil
+}
+
+// finishDelivery records the outcome of an attempt: delivered, retried after
+// a backoff, or failed for good once the attempts are used up or retry is false.
+// A delivery that was failed meanwhile, because its report expired, stays failed.
+func finishDelivery(ctx context.Context, d Delivery, cause error, retry bool) error {
+	var err error
+	switch {
+	case cause == nil:
+		_, err = db.ExecContext(ctx, "UPDATE report_deliveries SET status = $2, last_error = NULL, delivered_at = now(), updated_at = now() WHERE id = $1 AND status = $3",
+			d.ID, deliveryDelivered, deliveryDelivering)
+	case retry && d.Attempts <= len(deliveryBackoff):
+		_, err = db.ExecContext(ctx, "UPDATE report_deliveries SET status = $2, last_error = $3, next_attempt_at = $4, updated_at = now() WHERE id = $1 AND status = $5",
+			d.ID, deliveryPending, cause.Error(), time.Now().Add(deliveryBackoff[d.Attempts-1]), deliveryDelivering)
+	default:
+		_, err = db.ExecContext(ctx, "UPDATE report_deliveries SET status = $2, last_error = $3, updated_at = now() WHERE id = $1 AND status = $4",
+			d.ID, deliveryFailed, cause.Error(), deliveryDelivering)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to record delivery: %w", err)
+	}
+	return nil
+}
+
+// --- Scheduler ---
+
+// ReportScheduler starts due subscription runs and delivers their reports.
+type ReportScheduler struct {
+	interval time.Duration
+}
+
+func startReportScheduler() {
+	initReportDelivery()
+	s := &ReportScheduler{interval: subscriptionPollInterval}
+	go s.Run(context.Background())
+	log.Print("Report scheduler started")
+}
+
+// Run starts runs and delivers reports until ctx is cancelled.
+func (s *ReportScheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		for ctx.Err() == nil {
+			ok, err := runDueSubscription(ctx)
+			if err != nil {
+				log.Print(err)
+			}
+			if !ok {
+				break
+			}
+		}
+		if err := releaseStaleDeliveries(ctx, 2*deliveryTimeout); err != nil {
+			log.Print(err)
+		}
+		for ctx.Err() == nil {
+			d, ok, err := claimDelivery(ctx)
+			if err != nil {
+				log.Print(err)
+			}
+			if !ok {
+				break
+			}
+			s.deliver(ctx, d)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *ReportScheduler) deliver(ctx context.Context, d Delivery) {
+	retry, err := s.attempt(ctx, d)
+	if err != nil {
+		log.Printf("Failed to deliver report %s (attempt %d): %v", d.ReportID, d.Attempts, err)
+	}
+	if err := finishDelivery(ctx, d, err, retry); err != nil {
+		log.Print(err)
+	}
+}
+
+// attempt sends a delivery's report. On failure it also reports whether
+// another attempt might succeed.
+func (s *ReportScheduler) attempt(ctx context.Context, d Delivery) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, deliveryTimeout)
+	defer cancel()
+
+	sub, err := scanSubscription(db.QueryRowContext(ctx, "SELECT "+subscriptionColumns+" FROM report_subscriptions WHERE id = $1 AND deleted_at IS NULL", d.SubscriptionID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, errors.New("the subscription was deleted")
+	}
+	if err == nil {
+		err = db.QueryRowContext(ctx, "SELECT COALESCE(webhook_secret, '') FROM report_subscriptions WHERE id = $1", sub.ID).Scan(&sub.WebhookSecret)
+	}
+	if err != nil {
+		return true, fmt.Errorf("failed to load subscription: %w", err)
+	}
+	deliverer, ok := deliverers[sub.Method]
+	if !ok {
+		return false, fmt.Errorf("delivery method %q is not configured", sub.Method)
+	}
+	report, err := getReport(ctx, sub.tenantID, d.ReportID)
+	if err != nil {
+		return true, err
+	}
+	if report.Status == reportFailed {
+		return false, fmt.Errorf("the report could not be rendered: %s", report.Error)
+	}
+
+	format := reportFormats[report.Format]
+	obj, err := mediaStore.Open(ctx, report.storageKey)
+	if err != nil {
+		return true, fmt.Errorf("failed to open report: %w", err)
+	}
+	content, err := io.ReadAll(obj)
+	obj.Close()
+	if err != nil {
+		return true, fmt.Errorf("failed to read report: %w", err)
+	}
+	link, err := mediaStore.URL(report.storageKey, deliveryLinkTTL)
+	if err != nil {
+		return true, fmt.Errorf("failed to link report: %w", err)
+	}
+	return true, deliverer.Deliver(ctx, ReportDelivery{
+		DeliveryID:   d.ID,
+		Subscription: sub,
+		Report:       report,
+		Filename:     fmt.Sprintf("report-%s-%s%s", report.From, report.To, format.ext),
+		ContentType:  format.contentType,
+		Content:      content,
+		DownloadURL:  link,
+		ExpiresAt:    time.Now().Add(deliveryLinkTTL),
+	})
+}
+
+// --- Handlers ---
+
+func writeSubscriptionError(w http.ResponseWriter, action string, err error) {
+	switch {
+	case errors.Is(err, errSubscriptionNotFound):
+		http.Error(w, "Report subscription not found", http.StatusNotFound)
+	case errors.Is(err, errDeliveryNotFound):
+		http.Error(w, "Delivery not found", http.StatusNotFound)
+	default:
+		log.Printf("Failed to %s: %v", action, err)
+		http.Error(w, "Failed to "+action, http.StatusInternalServerError)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func decodeSubscriptionRequest(w http.ResponseWriter, r *http.Request) (subscriptionRequest, cron.Schedule, *time.Location, bool) {
+	var req subscriptionRequest
+	r.Body = http.MaxBytesReader(w, r.Body, 1<<16)
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return req, nil, nil, false
+	}
+	schedule, loc, err := req.validate(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return req, nil, nil, false
+	}
+	return req, schedule, loc, true
+}
+
+// listSubscriptionsHandler lists the tenant's report subscriptions by name.
+func listSubscriptionsHandler(w http.ResponseWriter, r *http.Request) {
+	_, tenantID, err := getUserIDAndTenantIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	rows, err := db.QueryContext(r.Context(), "SELECT "+subscriptionColumns+" FROM report_subscriptions WHERE tenant_id = $1 AND deleted_at IS NULL ORDER BY name, created_at", tenantID)
+	if err != nil {
+		writeSubscriptionError(w, "list report subscriptions", err)
+		return
+	}
+	defer rows.Close()
+	subs := []ReportSubscription{}
+	for rows.Next() {
+		s, err := scanSubscription(rows)
+		if err != nil {
+			writeSubscriptionError(w, "list report subscriptions", err)
+			return
+		}
+		subs = append(subs, s)
+	}
+	if err := rows.Err(); err != nil {
+		writeSubscriptionError(w, "list report subscriptions", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, subs)
+}
+
+// createSubscriptionHandler handles POST /api/report-subscriptions. Webhook
+// subscriptions get a signing secret, which is only part of this response.
+func createSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	userID, tenantID, err := getUserIDAndTenantIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	req, schedule, loc, ok := decodeSubscriptionRequest(w, r)
+	if !ok {
+		return
+	}
+	var secret string
+	if req.Method == "webhook" {
+		if secret, err = newWebhookSecret(); err != nil {
+			writeSubscriptionError(w, "create report subscription", err)
+			return
+		}
+	}
+	enabled := req.Enabled == nil || *req.Enabled
+	s, err := scanSubscription(db.QueryRowContext(r.Context(), `
+		INSERT INTO report_subscriptions (id, tenant_id, user_id, name, schedule, timezone, format, range_days, method, recipients, webhook_url, webhook_secret, enabled, next_run_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+		RETURNING `+subscriptionColumns,
+		fmt.Sprintf("subscription-%d", time.Now().UnixNano()), tenantID, userID, req.Name, req.Schedule, req.Timezone, req.Format, req.RangeDays, req.Method,
+		pq.StringArray(req.Recipients), sql.NullString{String: req.WebhookURL, Valid: req.WebhookURL != ""}, sql.NullString{String: secret, Valid: secret != ""},
+		enabled, nextRun(schedule, loc, time.Now()),
+	))
+	if err != nil {
+		writeSubscriptionError(w, "create report subscription", err)
+		return
+	}
+	s.WebhookSecret = secret
+	writeJSON(w, http.StatusCreated, s)
+}
+
+func getSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	_, tenantID, err := getUserIDAndTenantIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	s, err := getSubscription(r.Context(), db, tenantID, mux.Vars(r)["subscriptionId"], false)
+	if err != nil {
+		writeSubscriptionError(w, "get report subscription", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, s)
+}
+
+// updateSubscriptionHandler handles PUT /api/report-subscriptions/{subscriptionId},
+// which replaces the subscription and reschedules its next run. A webhook
+// subscription keeps its secret; one switched to webhook delivery gets a new
+// one, returned in the response.
+func updateSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	_, tenantID, err := getUserIDAndTenantIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	req, schedule, loc, ok := decodeSubscriptionRequest(w, r)
+	if !ok {
+		return
+	}
+	tx, err := db.BeginTx(r.Context(), nil)
+	if err != nil {
+		writeSubscriptionError(w, "update report subscription", err)
+		return
+	}
+	defer tx.Rollback()
+	current, err := getSubscription(r.Context(), tx, tenantID, mux.Vars(r)["subscriptionId"], true)
+	if err != nil {
+		writeSubscriptionError(w, "update report subscription", err)
+		return
+	}
+	var secret string
+	if req.Method == "webhook" && current.Method != "webhook" {
+		if secret, err = newWebhookSecret(); err != nil {
+			writeSubscriptionError(w, "update report subscription", err)
+			return
+		}
+	}
+	enabled := current.Enabled
+	if req.Enabled != nil {
+		enabled = *req.Enabled
+	}
+	s, err := scanSubscription(tx.QueryRowContext(r.Context(), `
+		UPDATE report_subscriptions
+		SET name = $2, schedule = $3, timezone = $4, format = $5, range_days = $6, method = $7, recipients = $8, webhook_url = $9,
+			webhook_secret = CASE WHEN $7 <> 'webhook' THEN NULL WHEN $10 <> '' THEN $10 ELSE webhook_secret END,
+			enabled = $11, next_run_at = $12, updated_at = now()
+		WHERE id = $1
+		RETURNING `+subscriptionColumns,
+		current.ID, req.Name, req.Schedule, req.Timezone, req.Format, req.RangeDays, req.Method,
+		pq.StringArray(req.Recipients), sql.NullString{String: req.WebhookURL, Valid: req.WebhookURL != ""}, secret,
+		enabled, nextRun(schedule, loc, time.Now()),
+	))
+	if err != nil {
+		writeSubscriptionError(w, "update report subscription", err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeSubscriptionError(w, "update report subscription", err)
+		return
+	}
+	s.WebhookSecret = secret
+	writeJSON(w, http.StatusOK, s)
+}
+
+// deleteSubscriptionHandler stops a subscription. Its delivery history is
+// kept; pending deliveries fail.
+func deleteSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	_, tenantID, err := getUserIDAndTenantIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	res, err := db.ExecContext(r.Context(), "UPDATE report_subscriptions SET deleted_at = now(), enabled = false, updated_at = now() WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL",
+		mux.Vars(r)["subscriptionId"], tenantID)
+	if err != nil {
+		writeSubscriptionError(w, "delete report subscription", err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		writeSubscriptionError(w, "delete report subscription", errSubscriptionNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// listDeliveriesHandler lists a subscription's latest deliveries, newest first.
+func listDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	_, tenantID, err := getUserIDAndTenantIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	s, err := getSubscription(r.Context(), db, tenantID, mux.Vars(r)["subscriptionId"], false)
+	if err != nil {
+		writeSubscriptionError(w, "list deliveries", err)
+		return
+	}
+	rows, err := db.QueryContext(r.Context(), "SELECT "+deliveryColumns+" FROM report_deliveries WHERE subscription_id = $1 ORDER BY created_at DESC LIMIT 100", s.ID)
+	if err != nil {
+		writeSubscriptionError(w, "list deliveries", err)
+		return
+	}
+	defer rows.Close()
+	deliveries := []Delivery{}
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			writeSubscriptionError(w, "list deliveries", err)
+			return
+		}
+		deliveries = append(deliveries, d)
+	}
+	if err := rows.Err(); err != nil {
+		writeSubscriptionError(w, "list deliveries", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, deliveries)
+}
+
+// retryDeliveryHandler handles POST .../deliveries/{deliveryId}/retry: a
+// failed delivery is attempted again with a fresh set of retries, as long as
+// its report has not expired.
+func retryDeliveryHandler(w http.ResponseWriter, r *http.Request) {
+	_, tenantID, err := getUserIDAndTenantIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	vars := mux.Vars(r)
+	s, err := getSubscription(r.Context(), db, tenantID, vars["subscriptionId"], false)
+	if err != nil {
+		writeSubscriptionError(w, "retry delivery", err)
+		return
+	}
+	d, err := scanDelivery(db.QueryRowContext(r.Context(), "SELECT "+deliveryColumns+" FROM report_deliveries WHERE id = $1 AND subscription_id = $2", vars["deliveryId"], s.ID))
+	if errors.Is(err, sql.ErrNoRows) {
+		err = errDeliveryNotFound
+	}
+	if err != nil {
+		writeSubscriptionError(w, "retry delivery", err)
+		return
+	}
+	switch {
+	case d.Status != deliveryFailed:
+		http.Error(w, "Only failed deliveries can be retried", http.StatusConflict)
+		return
+	case d.ReportID == "":
+		http.Error(w, "The report of this delivery has expired", http.StatusConflict)
+		return
+	}
+	tx, err := db.BeginTx(r.Context(), nil)
+	if err != nil {
+		writeSubscriptionError(w, "retry delivery", err)
+		return
+	}
+	defer tx.Rollback()
+	// A report that failed to render is rendered again.
+	if _, err := tx.ExecContext(r.Context(), "UPDATE reports SET status = $3, attempts = 0, error = NULL, updated_at = now() WHERE id = $1 AND status = $2",
+		d.ReportID, reportFailed, reportQueued); err != nil {
+		writeSubscriptionError(w, "retry delivery", err)
+		return
+	}
+	d, err = scanDelivery(tx.QueryRowContext(r.Context(), `
+		UPDATE report_deliveries SET status = $3, attempts = 0, next_attempt_at = now(), updated_at = now()
+		WHERE id = $1 AND status = $2 AND report_id IS NOT NULL
+		RETURNING `+deliveryColumns,
+		d.ID, deliveryFailed, deliveryPending,
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Only failed deliveries can be retried", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		writeSubscriptionError(w, "retry delivery", err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeSubscriptionError(w, "retry delivery", err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, d)
+}
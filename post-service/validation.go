@@ -0,0 +1,329 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// --- Content Validation ---
+//
+// Each platform has rules a post must meet to be published: how long the
+// caption may be, how many hashtags and mentions it may carry, whether media is
+// required and which kinds, the aspect ratio and length of that media, and what
+// happens to links. validatePost checks a post against its platform's rules
+// when it is created or edited, so problems surface as field-level 422s rather
+// than as failures at publish time. POST /api/posts/validate runs the same
+// checks without saving anything, for live feedback while composing.
+//
+// Library media is measured when it is uploaded (see probe.go). When its
+// dimensions or duration are unknown, as for WebM videos and posts created with
+// a media URL, those rules are reported as warnings, not errors.
+
+// Media kinds. Library media knows its kind; for a media URL it is guessed by isVideoURL.
+const (
+	mediaImage = "image"
+	mediaVideo = "video"
+)
+
+// Link behaviours in captions.
+const (
+	linksAllowed      = "allowed"       // links are clickable
+	linksNotClickable = "not_clickable" // links are shown as plain text
+	linksForbidden    = "forbidden"     // captions with links are rejected
+)
+
+// MediaInfo describes a post's media for validation.
+type MediaInfo struct {
+	Kind            string  `json:"kind,omitempty"`
+	Width           int     `json:"width,omitempty"`
+	Height          int     `json:"height,omitempty"`
+	DurationSeconds float64 `json:"durationSeconds,omitempty"`
+}
+
+// PlatformRules are one platform's constraints on a post. Zero limits mean no limit.
+type PlatformRules struct {
+	MaxCaptionLength int
+	MaxHashtags      int
+	MaxMentions      int
+	RequiresMedia    bool
+	MediaKinds       []string
+	// MinAspectRatio and MaxAspectRatio bound width divided by height.
+	MinAspectRatio   float64
+	MaxAspectRatio   float64
+	MinVideoDuration time.Duration
+	MaxVideoDuration time.Duration
+	Links            string
+}
+
+// platformRules holds each platform's rules. Meta uses Instagram's hashtag and
+// mention limits so a post fits either Meta app.
+var platformRules = map[string]PlatformRules{
+	"meta": {
+		MaxCaptionLength: 63206,
+		MaxHashtags:      30,
+		MaxMentions:      20,
+		MediaKinds:       []string{mediaImage, mediaVideo},
+		MinAspectRatio:   9.0 / 16.0,
+		MaxAspectRatio:   1.91,
+		MinVideoDuration: time.Second,
+		MaxVideoDuration: 240 * time.Minute,
+		Links:            linksAllowed,
+	},
+	"tiktok": {
+		MaxCaptionLength: 2200,
+		RequiresMedia:    true,
+		MediaKinds:       []string{mediaVideo},
+		MinAspectRatio:   9.0 / 16.0,
+		MaxAspectRatio:   16.0 / 9.0,
+		MinVideoDuration: 3 * time.Second,
+		MaxVideoDuration: 10 * time.Minute,
+		Links:            linksNotClickable,
+	},
+	"snapchat": {
+		MaxCaptionLength: 250,
+		RequiresMedia:    true,
+		MediaKinds:       []string{mediaImage, mediaVideo},
+		MinAspectRatio:   9.0 / 16.0,
+		MaxAspectRatio:   9.0 / 16.0,
+		MinVideoDuration: time.Second,
+		MaxVideoDuration: time.Minute,
+		Links:            linksForbidden,
+	},
+}
+
+// aspectRatioTolerance absorbs rounding in dimensions such as 1080x1921.
+const aspectRatioTolerance = 0.01
+
+var (
+	hashtagRE = regexp.MustCompile(`(?:^|\s)#[\pL\pN_]+`)
+	mentionRE = regexp.MustCompile(`(?:^|\s)@[\pL\pN_.]+`)
+	linkRE    = regexp.MustCompile(`(?i)\bhttps?://\S+|\bwww\.\S+`)
+)
+
+// FieldError is one problem with one field of a post.
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// ValidationResult lists a post's errors, which block saving it, and warnings, which do not.
+type ValidationResult struct {
+	Valid    bool         `json:"valid"`
+	Errors   []FieldError `json:"errors"`
+	Warnings []FieldError `json:"warnings"`
+}
+
+func (v *ValidationResult) fail(field, code, format string, args ...interface{}) {
+	v.Errors = append(v.Errors, FieldError{Field: field, Code: code, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *ValidationResult) warn(field, code, format string, args ...interface{}) {
+	v.Warnings = append(v.Warnings, FieldError{Field: field, Code: code, Message: fmt.Sprintf(format, args...)})
+}
+
+// prefix qualifies every field name, e.g. for the posts of a campaign.
+func (v ValidationResult) prefix(p string) ValidationResult {
+	for i := range v.Errors {
+		v.Errors[i].Field = p + v.Errors[i].Field
+	}
+	for i := range v.Warnings {
+		v.Warnings[i].Field = p + v.Warnings[i].Field
+	}
+	return v
+}
+
+// validatePost checks post against the rules of its platform.
+func validatePost(post Post) ValidationResult {
+	v := ValidationResult{Errors: []FieldError{}, Warnings: []FieldError{}}
+	if post.PlatformUserID == "" {
+		v.fail("platformUserId", "required", "Choose the account to post to.")
+	}
+	if post.ScheduledAt.IsZero() {
+		v.fail("scheduledAt", "required", "Choose when to publish the post.")
+	}
+	rules, ok := platformRules[strings.ToLower(post.Platform)]
+	if !ok {
+		v.fail("platform", "unsupported", "Platform %q is not supported.", post.Platform)
+		return v
+	}
+	name := post.Platform
+	if strings.TrimSpace(post.Content) == "" && post.MediaID == "" && post.MediaURL == "" {
+		v.fail("content", "required", "Write a caption or add media.")
+	}
+	if n := utf8.RuneCountInString(post.Content); rules.MaxCaptionLength > 0 && n > rules.MaxCaptionLength {
+		v.fail("content", "too_long", "%s captions can be at most %d characters; this one has %d.", name, rules.MaxCaptionLength, n)
+	}
+	if n := len(hashtagRE.FindAllString(post.Content, -1)); rules.MaxHashtags > 0 && n > rules.MaxHashtags {
+		v.fail("content", "too_many_hashtags", "%s allows at most %d hashtags; this caption has %d.", name, rules.MaxHashtags, n)
+	}
+	if n := len(mentionRE.FindAllString(post.Content, -1)); rules.MaxMentions > 0 && n > rules.MaxMentions {
+		v.fail("content", "too_many_mentions", "%s allows at most %d mentions; this caption has %d.", name, rules.MaxMentions, n)
+	}
+	if linkRE.MatchString(post.Content) {
+		switch rules.Links {
+		case linksForbidden:
+			v.fail("content", "links_forbidden", "%s does not accept links in captions.", name)
+		case linksNotClickable:
+			v.warn("content", "links_not_clickable", "%s shows links in captions as plain text.", name)
+		}
+	}
+	validateMedia(&v, name, rules, post)
+	v.Valid = len(v.Errors) == 0
+	return v
+}
+
+// validateMedia checks a post's library media, or the media URL of a post
+// created before the library. post.Media must have been filled in by attachMedia.
+func validateMedia(v *ValidationResult, name string, rules PlatformRules, post Post) {
+	media := MediaInfo{}
+	if post.Media != nil {
+		media = *post.Media
+	}
+	field := "mediaId"
+	switch {
+	case post.MediaID != "":
+		if post.Media == nil {
+			v.fail(field, "not_found", "The media was not found in the media library.")
+			return
+		}
+	case post.MediaURL != "":
+		field = "mediaUrl"
+		if u, err := url.Parse(post.MediaURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			v.fail(field, "invalid", "The media URL must be an http or https URL.")
+			return
+		}
+		media.Kind = mediaImage
+		if isVideoURL(post.MediaURL) {
+			media.Kind = mediaVideo
+		}
+	default:
+		if rules.RequiresMedia {
+			v.fail(field, "required", "%s posts need %s.", name, describeKinds(rules.MediaKinds))
+		}
+		return
+	}
+	kind := media.Kind
+	if !containsString(rules.MediaKinds, kind) {
+		v.fail(field, "unsupported_media", "%s posts need %s; this media is %s.", name, describeKinds(rules.MediaKinds), mediaNouns[kind])
+		return
+	}
+
+	if media.Width > 0 && media.Height > 0 {
+		ratio := float64(media.Width) / float64(media.Height)
+		preset, cropped := findPreset(strings.ToLower(post.Platform), kind)
+		cropped = cropped && post.MediaID != "" && cfg.Renditions.Enabled
+		switch {
+		case cropped:
+			// The platform gets the rendition, which is cropped to the preset's
+			// bounds even where the platform would accept more; see renditions.go.
+			if !ratioWithin(ratio, preset.MinAspectRatio, preset.MaxAspectRatio) {
+				v.warn("media", "aspect_ratio_cropped", "%s media is cropped to an aspect ratio %s; this media is %dx%d.", name, describeRatios(preset.MinAspectRatio, preset.MaxAspectRatio), media.Width, media.Height)
+			}
+		case !ratioWithin(ratio, rules.MinAspectRatio, rules.MaxAspectRatio):
+			v.fail("media", "aspect_ratio", "%s needs an aspect ratio %s; this media is %dx%d.", name, describeRatios(rules.MinAspectRatio, rules.MaxAspectRatio), media.Width, media.Height)
+		}
+	} else if rules.MinAspectRatio > 0 {
+		v.warn("media", "dimensions_unknown", "The media's dimensions are unknown, so its aspect ratio was not checked.")
+	}
+	if kind != mediaVideo {
+		return
+	}
+	if media.DurationSeconds > 0 {
+		duration := time.Duration(media.DurationSeconds * float64(time.Second))
+		if rules.MinVideoDuration > 0 && duration < rules.MinVideoDuration {
+			v.fail("media", "video_too_short", "%s videos must be at least %s long; this one is %s.", name, rules.MinVideoDuration, duration.Round(time.Millisecond))
+		}
+		if rules.MaxVideoDuration > 0 && duration > rules.MaxVideoDuration {
+			v.fail("media", "video_too_long", "%s videos can be at most %s long; this one is %s.", name, rules.MaxVideoDuration, duration.Round(time.Second))
+		}
+	} else if rules.MinVideoDuration > 0 || rules.MaxVideoDuration > 0 {
+		v.warn("media", "duration_unknown", "The video's length is unknown, so it was not checked.")
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+var mediaNouns = map[string]string{mediaImage: "an image", mediaVideo: "a video"}
+
+func describeKinds(kinds []string) string {
+	nouns := make([]string, len(kinds))
+	for i, kind := range kinds {
+		nouns[i] = mediaNouns[kind]
+	}
+	return strings.Join(nouns, " or ")
+}
+
+// ratioWithin reports whether ratio lies between min and max, give or take
+// aspectRatioTolerance. Zero bounds admit every ratio.
+func ratioWithin(ratio, min, max float64) bool {
+	if min == 0 && max == 0 {
+		return true
+	}
+	return ratio >= min-aspectRatioTolerance && ratio <= max+aspectRatioTolerance
+}
+
+// describeRatios renders an aspect ratio range such as "between 9:16 and 1.91:1".
+func describeRatios(min, max float64) string {
+	format := func(r float64) string {
+		for _, common := range [][2]int{{9, 16}, {4, 5}, {1, 1}, {16, 9}} {
+			if math.Abs(r-float64(common[0])/float64(common[1])) < 0.001 {
+				return fmt.Sprintf("%d:%d", common[0], common[1])
+			}
+		}
+		return fmt.Sprintf("%.2f:1", r)
+	}
+	if min == max {
+		return "of " + format(min)
+	}
+	return fmt.Sprintf("between %s and %s", format(min), format(max))
+}
+
+// writeValidationError answers with the errors that block saving a post.
+func writeValidationError(w http.ResponseWriter, v ValidationResult) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	json.NewEncoder(w).Encode(struct {
+		Error   string `json:"error"`
+		Message string `json:"message"`
+		ValidationResult
+	}{"validation_failed", "The post does not meet the platform's requirements.", v})
+}
+
+// validatePostHandler validates a post without saving it.
+func validatePostHandler(w http.ResponseWriter, r *http.Request) {
+	_, tenantID, err := getUserIDAndTenantIDFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var post Post
+	r.Body = http.MaxBytesReader(w, r.Body, 1<<20)
+	if err := json.NewDecoder(r.Body).Decode(&post); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	post.TenantID, post.Media = tenantID, nil
+	if err := attachMedia(r.Context(), &post); err != nil {
+		log.Printf("Failed to look up media to validate a post: %v", err)
+		http.Error(w, "Failed to validate post", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(validatePost(post))
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestValidateAspectRatio(t *testing.T) {
+	tests := []struct {
+		name       string
+		platform   string
+		media      MediaInfo
+		library    bool
+		renditions bool
+		wantError  bool
+		wantWarn   bool
+	}{
+		{"tiktok vertical", "tiktok", MediaInfo{Kind: mediaVideo, Width: 1080, Height: 1920}, true, true, false, false},
+		{"tiktok landscape rendition", "tiktok", MediaInfo{Kind: mediaVideo, Width: 1920, Height: 1080}, true, true, false, true},
+		{"tiktok landscape original", "tiktok", MediaInfo{Kind: mediaVideo, Width: 1920, Height: 1080}, true, false, false, false},
+		{"tiktok too wide original", "tiktok", MediaInfo{Kind: mediaVideo, Width: 3000, Height: 1000}, true, false, true, false},
+		{"meta tall image rendition", "meta", MediaInfo{Kind: mediaImage, Width: 1080, Height: 1920}, true, true, false, true},
+		{"meta portrait image rendition", "meta", MediaInfo{Kind: mediaImage, Width: 1080, Height: 1350}, true, true, false, false},
+		{"meta tall video rendition", "meta", MediaInfo{Kind: mediaVideo, Width: 1080, Height: 1920, DurationSeconds: 10}, true, true, false, false},
+		{"meta tall image by URL", "meta", MediaInfo{Kind: mediaImage, Width: 1080, Height: 1920}, false, true, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg = &Config{Renditions: RenditionConfig{Enabled: tt.renditions}}
+			media := tt.media
+			if media.DurationSeconds == 0 && media.Kind == mediaVideo {
+				media.DurationSeconds = 30
+			}
+			post := Post{Platform: tt.platform, PlatformUserID: "1", ScheduledAt: time.Now(), Media: &media}
+			if tt.library {
+				post.MediaID = "media-1"
+			} else {
+				post.MediaURL = "https://example.com/image.jpg"
+			}
+			v := validatePost(post)
+			if got := hasCode(v.Errors, "aspect_ratio"); got != tt.wantError {
+				t.Errorf("aspect_ratio error = %v; want %v (%+v)", got, tt.wantError, v.Errors)
+			}
+			if got := hasCode(v.Warnings, "aspect_ratio_cropped"); got != tt.wantWarn {
+				t.Errorf("aspect_ratio_cropped warning = %v; want %v (%+v)", got, tt.wantWarn, v.Warnings)
+			}
+		})
+	}
+}
+
+func hasCode(errs []FieldError, code string) bool {
+	for _, e := range errs {
+		if e.Code == code {
+			return true
+		}
+	}
+	return false
+}
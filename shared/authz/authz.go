@@ -0,0 +1,98 @@
+// Package authz decides which tenant roles may call which API routes.
+//
+// Access tokens carry the caller's role in their tenant. A role grants a set of
+// permissions and every API route requires one of them: the middleware returned
+// by Authorize looks up the matched route in the service's route table and
+// refuses the request with a structured 403 if the role lacks it. Routes
+// missing from the table are refused too, so a new route cannot go live
+// without a decision about who may call it.
+package authz
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// Permission is one action a role may be granted.
+type Permission string
+
+const (
+	AccountsRead   Permission = "accounts:read"
+	AccountsManage Permission = "accounts:manage"
+	PostsRead      Permission = "posts:read"
+	PostsWrite     Permission = "posts:write"
+	PostsApprove   Permission = "posts:approve"
+	MediaRead      Permission = "media:read"
+	MediaWrite     Permission = "media:write"
+	AnalyticsRead  Permission = "analytics:read"
+	ReportsManage  Permission = "reports:manage"
+	InboxRead      Permission = "inbox:read"
+	InboxManage    Permission = "inbox:manage"
+	SettingsManage Permission = "settings:manage"
+)
+
+// RolePermissions lists what each tenant role may do.
+var RolePermissions = map[string][]Permission{
+	"owner":  {AccountsRead, AccountsManage, PostsRead, PostsWrite, PostsApprove, MediaRead, MediaWrite, AnalyticsRead, ReportsManage, InboxRead, InboxManage, SettingsManage},
+	"admin":  {AccountsRead, AccountsManage, PostsRead, PostsWrite, PostsApprove, MediaRead, MediaWrite, AnalyticsRead, ReportsManage, InboxRead, InboxManage, SettingsManage},
+	"editor": {AccountsRead, PostsRead, PostsWrite, MediaRead, MediaWrite, AnalyticsRead, ReportsManage, InboxRead, InboxManage},
+	"viewer": {AccountsRead, PostsRead, MediaRead, AnalyticsRead, InboxRead},
+}
+
+// RoleHas reports whether role grants p.
+func RoleHas(role string, p Permission) bool {
+	for _, granted := range RolePermissions[role] {
+		if granted == p {
+			return true
+		}
+	}
+	return false
+}
+
+// ForbiddenResponse is the body of every 403 from the API.
+type ForbiddenResponse struct {
+	Error              string     `json:"error"`
+	Message            string     `json:"message"`
+	Role               string     `json:"role,omitempty"`
+	RequiredPermission Permission `json:"requiredPermission,omitempty"`
+}
+
+func WriteForbidden(w http.ResponseWriter, body ForbiddenResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusForbidden)
+	json.NewEncoder(w).Encode(body)
+}
+
+// Authorize returns a middleware that admits requests whose role, as reported
+// by roleOf, grants the permission routes maps the matched route to. routes is
+// keyed by "METHOD /path/template".
+func Authorize(routes map[string]Permission, roleOf func(*http.Request) string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			role := roleOf(r)
+			var template string
+			if route := mux.CurrentRoute(r); route != nil {
+				template, _ = route.GetPathTemplate()
+			}
+			required, ok := routes[r.Method+" "+template]
+			if !ok {
+				log.Printf("No permission is mapped to %s %s; refusing", r.Method, template)
+				WriteForbidden(w, ForbiddenResponse{Error: "forbidden", Message: "This endpoint is not available.", Role: role})
+				return
+			}
+			if !RoleHas(role, required) {
+				WriteForbidden(w, ForbiddenResponse{
+					Error:              "forbidden",
+					Message:            "Your role in this workspace does not allow this action.",
+					Role:               role,
+					RequiredPermission: required,
+				})
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,154 @@
+// Package config loads a service's settings into a tagged struct.
+//
+// Settings come from, in increasing precedence: the fields' `default` tags, the
+// YAML file named by CONFIG_FILE (optional), and environment variables. Each
+// field names its YAML key with a `yaml` tag and its environment variable with
+// an `env` tag; the env tags of nested structs are joined with "_". Fields
+// tagged `required:"true"` must end up non-zero, and `secret:"true"` fields are
+// masked by Log and must not be left at a placeholder value.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// placeholderMarkers are fragments of the sample values shipped in docs and
+// earlier versions of the services; a secret containing one was never filled in.
+var placeholderMarkers = []string{"your_", "your-", "changeme", "change-me", "supersecret", "placeholder", "replace"}
+
+// IsPlaceholder reports whether value looks like a sample value that was never replaced.
+func IsPlaceholder(value string) bool {
+	lower := strings.ToLower(value)
+	for _, marker := range placeholderMarkers {
+		if strings.Contains(lower, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// Load fills target (a pointer to a struct) from defaults, CONFIG_FILE and
+// the environment, then checks `required` and `secret` fields.
+func Load(target interface{}) error {
+	root := reflect.ValueOf(target).Elem()
+	if err := walk(root, "", "", func(f field) error {
+		if def := f.tag.Get("default"); def != "" && f.value.IsZero() {
+			return setValue(f.value, def)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read config file: %w", err)
+		}
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(target); err != nil {
+			return fmt.Errorf("failed to parse config file %s: %w", path, err)
+		}
+	}
+	var errs []error
+	walk(root, "", "", func(f field) error {
+		if raw, ok := os.LookupEnv(f.env); ok && f.env != "" {
+			if err := setValue(f.value, raw); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", f.env, err))
+			}
+		}
+		if f.tag.Get("required") == "true" && f.value.IsZero() {
+			errs = append(errs, fmt.Errorf("%s (%s) is required", f.name, f.env))
+		}
+		if f.tag.Get("secret") == "true" && f.value.Kind() == reflect.String && IsPlaceholder(f.value.String()) {
+			errs = append(errs, fmt.Errorf("%s (%s) is still a placeholder value", f.name, f.env))
+		}
+		return nil
+	})
+	return errors.Join(errs...)
+}
+
+type field struct {
+	name  string
+	env   string
+	tag   reflect.StructTag
+	value reflect.Value
+}
+
+// walk calls fn for every leaf field, with its dotted YAML name and its
+// environment variable name (nested env tags are joined with "_").
+func walk(v reflect.Value, namePrefix, envPrefix string, fn func(field) error) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		name := namePrefix + sf.Tag.Get("yaml")
+		env := sf.Tag.Get("env")
+		if envPrefix != "" && env != "" {
+			env = envPrefix + "_" + env
+		}
+		fv := v.Field(i)
+		if fv.Kind() == reflect.Struct && fv.Type() != reflect.TypeOf(time.Duration(0)) {
+			if err := walk(fv, name+".", env, fn); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := fn(field{name: name, env: env, tag: sf.Tag, value: fv}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func setValue(v reflect.Value, raw string) error {
+	switch {
+	case v.Type() == reflect.TypeOf(time.Duration(0)):
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+	case v.Kind() == reflect.String:
+		v.SetString(raw)
+	case v.Kind() == reflect.Int:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(n))
+	case v.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	default:
+		return fmt.Errorf("unsupported config type %s", v.Type())
+	}
+	return nil
+}
+
+// Log prints the effective configuration of target with secrets masked.
+func Log(target interface{}) {
+	var b strings.Builder
+	b.WriteString("Effective configuration:")
+	walk(reflect.ValueOf(target).Elem(), "", "", func(f field) error {
+		value := fmt.Sprint(f.value.Interface())
+		if f.tag.Get("secret") == "true" && value != "" {
+			value = "[redacted]"
+		}
+		fmt.Fprintf(&b, "\n  %s: %s", f.name, value)
+		return nil
+	})
+	log.Println(b.String())
+}
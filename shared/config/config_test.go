@@ -0,0 +1,69 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testConfig struct {
+	Port        int    `yaml:"port" env:"PORT" default:"8080"`
+	DatabaseURL string `yaml:"database_url" env:"DATABASE_URL" required:"true" secret:"true"`
+	Jobs        struct {
+		Enabled  bool          `yaml:"enabled" env:"ENABLED" default:"true"`
+		Interval time.Duration `yaml:"interval" env:"INTERVAL" default:"5m"`
+	} `yaml:"jobs" env:"JOBS"`
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("port: 9000\njobs:\n  interval: 1h\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CONFIG_FILE", path)
+	t.Setenv("DATABASE_URL", "postgres://db")
+	t.Setenv("JOBS_INTERVAL", "30s")
+
+	var c testConfig
+	if err := Load(&c); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if c.Port != 9000 {
+		t.Errorf("Port = %d; want the file's 9000", c.Port)
+	}
+	if !c.Jobs.Enabled {
+		t.Error("Jobs.Enabled = false; want the default true")
+	}
+	if c.Jobs.Interval != 30*time.Second {
+		t.Errorf("Jobs.Interval = %s; want the environment's 30s", c.Jobs.Interval)
+	}
+	if c.DatabaseURL != "postgres://db" {
+		t.Errorf("DatabaseURL = %q", c.DatabaseURL)
+	}
+}
+
+func TestLoadReportsEveryProblem(t *testing.T) {
+	t.Setenv("CONFIG_FILE", "")
+	t.Setenv("PORT", "eighty")
+	t.Setenv("DATABASE_URL", "postgres://your_user:changeme@db")
+
+	var c testConfig
+	err := Load(&c)
+	if err == nil {
+		t.Fatal("Load succeeded")
+	}
+	for _, want := range []string{"PORT", "database_url (DATABASE_URL) is still a placeholder value"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Load error %q does not mention %q", err, want)
+		}
+	}
+
+	os.Unsetenv("DATABASE_URL")
+	t.Setenv("PORT", "80")
+	c = testConfig{}
+	if err := Load(&c); err == nil || !strings.Contains(err.Error(), "database_url (DATABASE_URL) is required") {
+		t.Errorf("Load error = %v; want the missing database_url reported", err)
+	}
+}
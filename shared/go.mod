@@ -0,0 +1,9 @@
+module shared
+
+go 1.22.3
+
+require (
+	github.com/golang-jwt/jwt/v5 v5.3.0
+	github.com/gorilla/mux v1.8.1
+	gopkg.in/yaml.v3 v3.0.1
+)
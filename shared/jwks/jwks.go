@@ -0,0 +1,240 @@
+// Package jwks verifies platform JWTs against the keys auth-service publishes.
+//
+// Platform JWTs are signed by auth-service with an asymmetric key and carry the
+// key's ID in the "kid" header. The matching public keys are fetched from
+// auth-service's JWKS endpoint and cached; an unknown kid triggers an early
+// refetch so a freshly rotated key is picked up without a restart.
+package jwks
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Algorithms are the only signing methods accepted from auth-service.
+var Algorithms = []string{"RS256", "EdDSA"}
+
+// ErrUnknownKeyID is returned for tokens signed with a key the set does not know.
+var ErrUnknownKeyID = errors.New("unknown signing key")
+
+// KeySet resolves the public key a token was signed with.
+type KeySet interface {
+	Key(ctx context.Context, kid string) (crypto.PublicKey, error)
+}
+
+// Parse verifies tokenString against keys and issuer and decodes it into claims.
+func Parse(ctx context.Context, keys KeySet, issuer, tokenString string, claims jwt.Claims) (*jwt.Token, error) {
+	return jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		if kid == "" {
+			return nil, errors.New("token has no kid header")
+		}
+		return keys.Key(ctx, kid)
+	}, jwt.WithValidMethods(Algorithms), jwt.WithIssuer(issuer), jwt.WithExpirationRequired())
+}
+
+// StaticKeySet is a fixed, in-process KeySet, e.g. for tests signing their own tokens.
+type StaticKeySet map[string]crypto.PublicKey
+
+func (s StaticKeySet) Key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	key, ok := s[kid]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownKeyID, kid)
+	}
+	return key, nil
+}
+
+// RemoteKeySet caches the keys published at a JWKS URL.
+//
+// Fetches happen outside the lock and at most one at a time: concurrent
+// callers that need the keys wait for the same fetch, and callers holding a
+// cached but stale key keep using it while the set refreshes in the
+// background. After a failed fetch the next attempt is delayed with an
+// exponential backoff, so an unreachable auth-service is not asked again on
+// every request.
+type RemoteKeySet struct {
+	url             string
+	client          *http.Client
+	refreshInterval time.Duration
+	// minRefetch bounds how often an unknown kid may trigger a fetch, and is
+	// the first delay after a failed fetch.
+	minRefetch time.Duration
+	// maxBackoff caps the delay between failed fetches.
+	maxBackoff time.Duration
+
+	mu        sync.Mutex
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+	// nextFetch is the earliest time another fetch may start.
+	nextFetch time.Time
+	failures  int
+	inflight  *keyFetch
+}
+
+// keyFetch is a fetch in progress; done is closed when it has finished.
+type keyFetch struct {
+	done chan struct{}
+	err  error
+}
+
+func NewRemoteKeySet(url string, refreshInterval time.Duration) *RemoteKeySet {
+	return &RemoteKeySet{
+		url:             url,
+		client:          &http.Client{Timeout: 10 * time.Second},
+		refreshInterval: refreshInterval,
+		minRefetch:      30 * time.Second,
+		maxBackoff:      5 * time.Minute,
+	}
+}
+
+func (s *RemoteKeySet) Key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	s.mu.Lock()
+	key, ok := s.keys[kid]
+	now := time.Now()
+	stale := !ok || now.Sub(s.fetchedAt) >= s.refreshInterval
+	if !stale || (s.inflight == nil && now.Before(s.nextFetch)) {
+		s.mu.Unlock()
+		if !ok {
+			return nil, fmt.Errorf("%w %q", ErrUnknownKeyID, kid)
+		}
+		return key, nil
+	}
+	f := s.inflight
+	if f == nil {
+		f = &keyFetch{done: make(chan struct{})}
+		s.inflight = f
+		go s.refresh(f)
+	}
+	s.mu.Unlock()
+
+	if ok {
+		// Keep serving the cached key while the set refreshes.
+		return key, nil
+	}
+	select {
+	case <-f.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if f.err != nil {
+		return nil, f.err
+	}
+	s.mu.Lock()
+	key, ok = s.keys[kid]
+	s.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownKeyID, kid)
+	}
+	return key, nil
+}
+
+// refresh runs f and records its outcome. It is not tied to any one caller's
+// context, since every caller waiting on f shares its result.
+func (s *RemoteKeySet) refresh(f *keyFetch) {
+	keys, err := s.fetch(context.Background())
+	s.mu.Lock()
+	now := time.Now()
+	if err != nil {
+		// Keep serving the cached keys if auth-service is briefly unreachable.
+		s.failures++
+		backoff := s.maxBackoff
+		if s.failures < 16 && s.minRefetch<<(s.failures-1) < backoff {
+			backoff = s.minRefetch << (s.failures - 1)
+		}
+		s.nextFetch = now.Add(backoff)
+		log.Printf("Failed to refresh JWKS from %s (attempt %d, retrying in %s): %v", s.url, s.failures, backoff, err)
+	} else {
+		s.keys = keys
+		s.fetchedAt = now
+		s.failures = 0
+		s.nextFetch = now.Add(s.minRefetch)
+	}
+	f.err = err
+	s.inflight = nil
+	s.mu.Unlock()
+	close(f.done)
+}
+
+func (s *RemoteKeySet) fetch(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create JWKS request: %w", err)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("JWKS endpoint returned status %d", resp.StatusCode)
+	}
+	var doc struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("failed to parse JWKS: %w", err)
+	}
+	keys := make(map[string]crypto.PublicKey, len(doc.Keys))
+	for _, jwk := range doc.Keys {
+		key, err := jwk.publicKey()
+		if err != nil {
+			log.Printf("Skipping JWKS key %q: %v", jwk.Kid, err)
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+	return keys, nil
+}
+
+// jsonWebKey is one entry of a JWKS document (RFC 7517).
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	if k.Use != "" && k.Use != "sig" {
+		return nil, fmt.Errorf("key use %q is not sig", k.Use)
+	}
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modulus: %w", err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("invalid exponent")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
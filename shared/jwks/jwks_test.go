@@ -0,0 +1,242 @@
+package jwks
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const testIssuer = "test-issuer"
+
+func signToken(t *testing.T, method jwt.SigningMethod, kid string, key crypto.PrivateKey, issuer string) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, jwt.RegisteredClaims{
+		Issuer:    issuer,
+		Subject:   "user-1",
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+	})
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	return signed
+}
+
+func TestParseWithStaticKeySet(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := StaticKeySet{"ed-1": pub}
+	ctx := context.Background()
+
+	claims := &jwt.RegisteredClaims{}
+	token, err := Parse(ctx, keys, testIssuer, signToken(t, jwt.SigningMethodEdDSA, "ed-1", priv, testIssuer), claims)
+	if err != nil || !token.Valid {
+		t.Fatalf("Parse of a valid token = %v", err)
+	}
+	if claims.Subject != "user-1" {
+		t.Errorf("Subject = %q; want user-1", claims.Subject)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"unknown kid", signToken(t, jwt.SigningMethodEdDSA, "ed-2", priv, testIssuer)},
+		{"wrong issuer", signToken(t, jwt.SigningMethodEdDSA, "ed-1", priv, "someone-else")},
+		{"symmetric algorithm", signToken(t, jwt.SigningMethodHS256, "ed-1", []byte("secret"), testIssuer)},
+		{"no kid", func() string {
+			token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, jwt.RegisteredClaims{Issuer: testIssuer, ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute))})
+			signed, _ := token.SignedString(priv)
+			return signed
+		}()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Parse(ctx, keys, testIssuer, tt.token, &jwt.RegisteredClaims{}); err == nil {
+				t.Error("Parse succeeded")
+			}
+		})
+	}
+}
+
+// jwksServer publishes keys as a JWKS document and counts the requests it
+// serves. While fail is set it answers 503.
+type jwksServer struct {
+	*httptest.Server
+	mu    sync.Mutex
+	keys  map[string]crypto.PublicKey
+	hits  atomic.Int32
+	fail  atomic.Bool
+	block chan struct{}
+}
+
+func newJWKSServer(t *testing.T, keys map[string]crypto.PublicKey) *jwksServer {
+	s := &jwksServer{keys: keys}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.hits.Add(1)
+		if s.block != nil {
+			<-s.block
+		}
+		if s.fail.Load() {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		var doc struct {
+			Keys []jsonWebKey `json:"keys"`
+		}
+		for kid, key := range s.keys {
+			switch k := key.(type) {
+			case ed25519.PublicKey:
+				doc.Keys = append(doc.Keys, jsonWebKey{Kty: "OKP", Kid: kid, Use: "sig", Crv: "Ed25519", X: base64.RawURLEncoding.EncodeToString(k)})
+			case *rsa.PublicKey:
+				doc.Keys = append(doc.Keys, jsonWebKey{Kty: "RSA", Kid: kid, Use: "sig",
+					N: base64.RawURLEncoding.EncodeToString(k.N.Bytes()), E: base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes())})
+			}
+		}
+		json.NewEncoder(w).Encode(doc)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *jwksServer) setKey(kid string, key crypto.PublicKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[kid] = key
+}
+
+func TestRemoteKeySetFetchesAndRotates(t *testing.T) {
+	edPub, _, _ := ed25519.GenerateKey(rand.Reader)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := newJWKSServer(t, map[string]crypto.PublicKey{"ed-1": edPub})
+	set := NewRemoteKeySet(srv.URL, time.Hour)
+	set.minRefetch = 20 * time.Millisecond
+	ctx := context.Background()
+
+	key, err := set.Key(ctx, "ed-1")
+	if err != nil {
+		t.Fatalf("Key: %v", err)
+	}
+	if !edPub.Equal(key) {
+		t.Error("Key returned a different Ed25519 key")
+	}
+	if _, err := set.Key(ctx, "ed-1"); err != nil || srv.hits.Load() != 1 {
+		t.Errorf("cached lookup = %v after %d fetches; want no refetch", err, srv.hits.Load())
+	}
+
+	// A rotated-in key is unknown until minRefetch has passed since the last fetch.
+	srv.setKey("rsa-2", &rsaKey.PublicKey)
+	if _, err := set.Key(ctx, "rsa-2"); !errors.Is(err, ErrUnknownKeyID) {
+		t.Errorf("Key right after a fetch = %v; want ErrUnknownKeyID", err)
+	}
+	time.Sleep(30 * time.Millisecond)
+	key, err = set.Key(ctx, "rsa-2")
+	if err != nil {
+		t.Fatalf("Key after rotation: %v", err)
+	}
+	if !rsaKey.PublicKey.Equal(key) {
+		t.Error("Key returned a different RSA key")
+	}
+}
+
+func TestRemoteKeySetSharesOneFetch(t *testing.T) {
+	edPub, _, _ := ed25519.GenerateKey(rand.Reader)
+	srv := newJWKSServer(t, map[string]crypto.PublicKey{"ed-1": edPub})
+	srv.block = make(chan struct{})
+	set := NewRemoteKeySet(srv.URL, time.Hour)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := set.Key(context.Background(), "ed-1")
+			errs <- err
+		}()
+	}
+	// Let every caller reach the fetch before it completes.
+	time.Sleep(50 * time.Millisecond)
+	close(srv.block)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("Key: %v", err)
+		}
+	}
+	if n := srv.hits.Load(); n != 1 {
+		t.Errorf("JWKS fetched %d times; want concurrent callers to share one fetch", n)
+	}
+}
+
+func TestRemoteKeySetBacksOffAfterFailures(t *testing.T) {
+	edPub, _, _ := ed25519.GenerateKey(rand.Reader)
+	srv := newJWKSServer(t, map[string]crypto.PublicKey{"ed-1": edPub})
+	set := NewRemoteKeySet(srv.URL, 10*time.Millisecond)
+	set.minRefetch = 40 * time.Millisecond
+	ctx := context.Background()
+
+	if _, err := set.Key(ctx, "ed-1"); err != nil {
+		t.Fatalf("Key: %v", err)
+	}
+	srv.fail.Store(true)
+	time.Sleep(50 * time.Millisecond)
+
+	// The stale key is still served, without waiting, while the refresh fails.
+	if _, err := set.Key(ctx, "ed-1"); err != nil {
+		t.Fatalf("Key with a stale cache = %v; want the cached key", err)
+	}
+	waitForHits(t, srv, 2)
+	if _, err := set.Key(ctx, "unknown"); err == nil {
+		t.Fatal("Key of an unknown kid succeeded")
+	}
+	for i := 0; i < 10; i++ {
+		set.Key(ctx, "unknown")
+		set.Key(ctx, "ed-1")
+	}
+	if n := srv.hits.Load(); n != 2 {
+		t.Errorf("JWKS fetched %d times during the backoff; want 2", n)
+	}
+
+	// Once the backoff has passed the next caller tries again.
+	time.Sleep(50 * time.Millisecond)
+	srv.fail.Store(false)
+	if _, err := set.Key(ctx, "ed-1"); err != nil {
+		t.Fatalf("Key: %v", err)
+	}
+	waitForHits(t, srv, 3)
+}
+
+func waitForHits(t *testing.T, srv *jwksServer, want int32) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for srv.hits.Load() < want {
+		if time.Now().After(deadline) {
+			t.Fatalf("JWKS fetched %d times; want %d", srv.hits.Load(), want)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,283 @@
+// Package migrate applies the versioned SQL migrations of a service.
+//
+// Migrations are NNNN_name.up.sql / NNNN_name.down.sql pairs, usually embedded
+// into the service binary. Applied versions are recorded per service in
+// schema_migrations, and every run holds a Postgres advisory lock so replicas
+// starting together apply each migration exactly once.
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+var fileRE = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+type migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Migrator applies the migrations of one service to its database.
+type Migrator struct {
+	service string
+	db      *sql.DB
+	files   fs.FS
+}
+
+// New returns a Migrator for service whose migration files are at the root of files.
+func New(service string, db *sql.DB, files fs.FS) *Migrator {
+	return &Migrator{service: service, db: db, files: files}
+}
+
+// load parses the migration files, ordered by version.
+func (m *Migrator) load() ([]migration, error) {
+	entries, err := fs.ReadDir(m.files, ".")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %w", err)
+	}
+	byVersion := make(map[int]*migration)
+	for _, entry := range entries {
+		match := fileRE.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("unexpected migration file name %q", entry.Name())
+		}
+		version, _ := strconv.Atoi(match[1])
+		body, err := fs.ReadFile(m.files, entry.Name())
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", entry.Name(), err)
+		}
+		mig, ok := byVersion[version]
+		if !ok {
+			mig = &migration{Version: version, Name: match[2]}
+			byVersion[version] = mig
+		} else if mig.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has conflicting names %q and %q", version, mig.Name, match[2])
+		}
+		if match[3] == "up" {
+			mig.Up = string(body)
+		} else {
+			mig.Down = string(body)
+		}
+	}
+	migrations := make([]migration, 0, len(byVersion))
+	for _, mig := range byVersion {
+		if mig.Up == "" {
+			return nil, fmt.Errorf("migration %04d_%s has no up file", mig.Version, mig.Name)
+		}
+		migrations = append(migrations, *mig)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// withLock runs fn on a dedicated connection holding the service's advisory
+// lock, after making sure schema_migrations exists.
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to acquire connection: %w", err)
+	}
+	defer conn.Close()
+
+	h := fnv.New64a()
+	h.Write([]byte("schema_migrations:" + m.service))
+	lockKey := int64(h.Sum64())
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", lockKey); err != nil {
+		return fmt.Errorf("failed to take migration lock: %w", err)
+	}
+	defer conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", lockKey)
+
+	if _, err := conn.ExecContext(ctx, `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		service TEXT NOT NULL,
+		version INTEGER NOT NULL,
+		name TEXT NOT NULL,
+		applied_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+		PRIMARY KEY (service, version)
+	);`); err != nil {
+		return fmt.Errorf("failed to create schema_migrations table: %w", err)
+	}
+	return fn(conn)
+}
+
+func (m *Migrator) applied(ctx context.Context, conn *sql.Conn) (map[int]time.Time, error) {
+	rows, err := conn.QueryContext(ctx, "SELECT version, applied_at FROM schema_migrations WHERE service = $1", m.service)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema_migrations: %w", err)
+	}
+	defer rows.Close()
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, fmt.Errorf("failed to scan schema_migrations: %w", err)
+		}
+		applied[version] = at
+	}
+	return applied, rows.Err()
+}
+
+// Up applies every pending migration in order, each in its own transaction,
+// and returns how many it applied.
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	migrations, err := m.load()
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	err = m.withLock(ctx, func(conn *sql.Conn) error {
+		applied, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, mig := range migrations {
+			if _, ok := applied[mig.Version]; ok {
+				continue
+			}
+			if err := run(ctx, conn, mig.Up, func(tx *sql.Tx) error {
+				_, err := tx.ExecContext(ctx, "INSERT INTO schema_migrations (service, version, name) VALUES ($1, $2, $3)", m.service, mig.Version, mig.Name)
+				return err
+			}); err != nil {
+				return fmt.Errorf("migration %04d_%s failed: %w", mig.Version, mig.Name, err)
+			}
+			log.Printf("Applied migration %04d_%s", mig.Version, mig.Name)
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+// Down reverts the latest steps applied migrations and returns how many it reverted.
+func (m *Migrator) Down(ctx context.Context, steps int) (int, error) {
+	migrations, err := m.load()
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	err = m.withLock(ctx, func(conn *sql.Conn) error {
+		applied, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for i := len(migrations) - 1; i >= 0 && count < steps; i-- {
+			mig := migrations[i]
+			if _, ok := applied[mig.Version]; !ok {
+				continue
+			}
+			if mig.Down == "" {
+				return fmt.Errorf("migration %04d_%s has no down file", mig.Version, mig.Name)
+			}
+			if err := run(ctx, conn, mig.Down, func(tx *sql.Tx) error {
+				_, err := tx.ExecContext(ctx, "DELETE FROM schema_migrations WHERE service = $1 AND version = $2", m.service, mig.Version)
+				return err
+			}); err != nil {
+				return fmt.Errorf("reverting migration %04d_%s failed: %w", mig.Version, mig.Name, err)
+			}
+			log.Printf("Reverted migration %04d_%s", mig.Version, mig.Name)
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+func run(ctx context.Context, conn *sql.Conn, script string, record func(tx *sql.Tx) error) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		return err
+	}
+	if err := record(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// PrintStatus lists every known migration and whether it is applied.
+func (m *Migrator) PrintStatus(ctx context.Context) error {
+	migrations, err := m.load()
+	if err != nil {
+		return err
+	}
+	return m.withLock(ctx, func(conn *sql.Conn) error {
+		applied, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, mig := range migrations {
+			state := "pending"
+			if at, ok := applied[mig.Version]; ok {
+				state = "applied " + at.Format(time.RFC3339)
+			}
+			fmt.Printf("%04d_%-40s %s\n", mig.Version, mig.Name, state)
+		}
+		return nil
+	})
+}
+
+// Auto applies pending migrations at startup unless enabled (the service's
+// auto_migrate setting) is false, in which case they are expected to have been
+// run with the migrate subcommand. It exits the process if migrating fails.
+func (m *Migrator) Auto(enabled bool) {
+	if !enabled {
+		log.Println("AUTO_MIGRATE=false: skipping schema migrations.")
+		return
+	}
+	n, err := m.Up(context.Background())
+	if err != nil {
+		log.Fatalf("Failed to migrate database: %v", err)
+	}
+	log.Printf("Database schema is up to date (%d migration(s) applied).", n)
+}
+
+// RunCommand implements `<service> migrate [up | down [N] | status]`.
+func (m *Migrator) RunCommand(args []string) {
+	ctx := context.Background()
+	cmd := "up"
+	if len(args) > 0 {
+		cmd = args[0]
+	}
+	switch cmd {
+	case "up":
+		n, err := m.Up(ctx)
+		if err != nil {
+			log.Fatalf("Migration failed: %v", err)
+		}
+		log.Printf("Applied %d migration(s).", n)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			var err error
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				log.Fatalf("Invalid step count %q", args[1])
+			}
+		}
+		n, err := m.Down(ctx, steps)
+		if err != nil {
+			log.Fatalf("Migration failed: %v", err)
+		}
+		log.Printf("Reverted %d migration(s).", n)
+	case "status":
+		if err := m.PrintStatus(ctx); err != nil {
+			log.Fatalf("Failed to read migration status: %v", err)
+		}
+	default:
+		log.Fatalf("Unknown migrate command %q (expected up, down [N] or status)", cmd)
+	}
+}
@@ -0,0 +1,58 @@
+package migrate
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoadOrdersAndPairsFiles(t *testing.T) {
+	files := fstest.MapFS{
+		"0010_add_index.up.sql":    {Data: []byte("CREATE INDEX i ON t (c);")},
+		"0002_create_t.up.sql":     {Data: []byte("CREATE TABLE t (c INT);")},
+		"0002_create_t.down.sql":   {Data: []byte("DROP TABLE t;")},
+		"0010_add_index.down.sql":  {Data: []byte("DROP INDEX i;")},
+		"0011_no_down_file.up.sql": {Data: []byte("SELECT 1;")},
+	}
+	migrations, err := New("test-service", nil, files).load()
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if len(migrations) != 3 {
+		t.Fatalf("loaded %d migrations; want 3", len(migrations))
+	}
+	for i, want := range []struct {
+		version int
+		name    string
+	}{{2, "create_t"}, {10, "add_index"}, {11, "no_down_file"}} {
+		if migrations[i].Version != want.version || migrations[i].Name != want.name {
+			t.Errorf("migration %d = %04d_%s; want %04d_%s", i, migrations[i].Version, migrations[i].Name, want.version, want.name)
+		}
+	}
+	if migrations[0].Up != "CREATE TABLE t (c INT);" || migrations[0].Down != "DROP TABLE t;" {
+		t.Errorf("0002 paired as %+v", migrations[0])
+	}
+	if migrations[2].Down != "" {
+		t.Errorf("0011 has down script %q; want none", migrations[2].Down)
+	}
+}
+
+func TestLoadRejectsInvalidSets(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   fstest.MapFS
+		wantErr string
+	}{
+		{"bad name", fstest.MapFS{"create_t.sql": {}}, "unexpected migration file name"},
+		{"conflicting names", fstest.MapFS{"0001_a.up.sql": {}, "0001_b.down.sql": {}}, "conflicting names"},
+		{"down without up", fstest.MapFS{"0001_a.down.sql": {}}, "has no up file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New("test-service", nil, tt.files).load()
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("load error = %v; want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
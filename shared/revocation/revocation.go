@@ -0,0 +1,120 @@
+// Package revocation keeps a denylist of revoked access tokens in sync with
+// auth-service.
+//
+// Access JWTs are short-lived, but a session revoked by logout or refresh-token
+// reuse must stop working immediately. auth-service publishes the jtis of
+// revoked, unexpired access tokens on an internal endpoint; a Poller copies
+// them into a List that the services' auth middleware consults.
+package revocation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// internalTokenHeader carries the shared secret of service-to-service calls.
+const internalTokenHeader = "X-Internal-Token"
+
+// List is an in-memory set of revoked jtis with their expiry.
+type List struct {
+	mu   sync.RWMutex
+	jtis map[string]time.Time
+}
+
+func NewList() *List {
+	return &List{jtis: make(map[string]time.Time)}
+}
+
+// Revoked reports whether the token with this jti has been revoked.
+func (l *List) Revoked(jti string) bool {
+	if jti == "" {
+		return false
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	_, ok := l.jtis[jti]
+	return ok
+}
+
+// Add denylists jti until expiresAt.
+func (l *List) Add(jti string, expiresAt time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.jtis[jti] = expiresAt
+}
+
+// prune forgets jtis whose tokens have expired anyway.
+func (l *List) prune(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for jti, exp := range l.jtis {
+		if now.After(exp) {
+			delete(l.jtis, jti)
+		}
+	}
+}
+
+// Poller keeps a List in sync with auth-service's revoked-tokens endpoint.
+type Poller struct {
+	URL      string
+	Secret   string
+	Interval time.Duration
+	Client   *http.Client
+	List     *List
+}
+
+// Run polls until ctx is cancelled.
+func (p *Poller) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.Interval)
+	defer ticker.Stop()
+	for {
+		if err := p.Poll(ctx); err != nil {
+			// Keep enforcing the last known list until auth-service is reachable again.
+			log.Printf("Failed to refresh revoked tokens: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Poll fetches the revoked tokens once and merges them into the list.
+func (p *Poller) Poll(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.URL, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set(internalTokenHeader, p.Secret)
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call auth service: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("auth service returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	var payload struct {
+		Revoked []struct {
+			JTI       string    `json:"jti"`
+			ExpiresAt time.Time `json:"expiresAt"`
+		} `json:"revoked"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+		return fmt.Errorf("failed to parse revoked tokens: %w", err)
+	}
+	for _, t := range payload.Revoked {
+		p.List.Add(t.JTI, t.ExpiresAt)
+	}
+	p.List.prune(time.Now())
+	return nil
+}
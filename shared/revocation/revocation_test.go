@@ -0,0 +1,48 @@
+package revocation
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPollMergesAndPrunes(t *testing.T) {
+	now := time.Now()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Internal-Token") != "secret" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"revoked": []map[string]interface{}{{"jti": "jti-1", "expiresAt": now.Add(time.Hour)}},
+		})
+	}))
+	defer srv.Close()
+
+	list := NewList()
+	list.Add("expired", now.Add(-time.Minute))
+	p := &Poller{URL: srv.URL, Secret: "secret", Interval: time.Minute, Client: srv.Client(), List: list}
+	if err := p.Poll(context.Background()); err != nil {
+		t.Fatalf("Poll: %v", err)
+	}
+	if !list.Revoked("jti-1") {
+		t.Error("jti-1 not revoked after Poll")
+	}
+	if list.Revoked("expired") {
+		t.Error("expired jti still listed after Poll")
+	}
+	if list.Revoked("") {
+		t.Error("empty jti reported as revoked")
+	}
+
+	p.Secret = "wrong"
+	if err := p.Poll(context.Background()); err == nil {
+		t.Error("Poll with a wrong secret succeeded")
+	}
+	if !list.Revoked("jti-1") {
+		t.Error("a failed Poll dropped the last known list")
+	}
+}